@@ -0,0 +1,291 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// List names
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// keyPrefix starts the Redis sets holding the entries added at runtime
+const keyPrefix = "acl:"
+
+// ErrUnknownList is returned for list names other than Allow and Deny
+var ErrUnknownList = errors.New("unknown access list")
+
+// Verdict is the outcome of checking an IP against the lists
+type Verdict int
+
+const (
+	// Unlisted IPs go through the rate limits
+	Unlisted Verdict = iota
+	// Allowed IPs are never rate limited
+	Allowed
+	// Denied IPs are always rejected
+	Denied
+)
+
+// Entries are the networks of a list, as configured and as added at runtime
+type Entries struct {
+	Static  []string `json:"static"`
+	Runtime []string `json:"runtime"`
+}
+
+// Lists holds the allowlist and denylist of IPs and CIDRs. Configured entries
+// are fixed; entries added at runtime are stored in Redis and picked up by
+// every instance within the sync interval. Checks only read memory
+type Lists struct {
+	client   *redis.Client
+	interval time.Duration
+	static   map[string][]*net.IPNet
+
+	mu      sync.RWMutex
+	runtime map[string][]*net.IPNet
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLists parses the configured entries. Without a client, runtime changes
+// only apply to this instance
+func NewLists(client *redis.Client, allow, deny []string, interval time.Duration) (*Lists, error) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	l := &Lists{
+		client:   client,
+		interval: interval,
+		static:   make(map[string][]*net.IPNet),
+		runtime:  make(map[string][]*net.IPNet),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for name, entries := range map[string][]string{Allow: allow, Deny: deny} {
+		for _, entry := range entries {
+			network, err := ParseNetwork(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry: %w", name, err)
+			}
+			l.static[name] = append(l.static[name], network)
+		}
+	}
+	return l, nil
+}
+
+// ParseNetwork parses an IP or a CIDR, a bare IP being a single-address network
+func ParseNetwork(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+	}
+	return network, nil
+}
+
+// Check returns the verdict for an IP. The denylist wins when both lists match
+func (l *Lists) Check(ip string) Verdict {
+	if l == nil {
+		return Unlisted
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return Unlisted
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	switch {
+	case contains(l.static[Deny], parsed) || contains(l.runtime[Deny], parsed):
+		return Denied
+	case contains(l.static[Allow], parsed) || contains(l.runtime[Allow], parsed):
+		return Allowed
+	}
+	return Unlisted
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds an IP or CIDR to a list at runtime
+func (l *Lists) Add(ctx context.Context, list, entry string) error {
+	network, err := l.validate(list, entry)
+	if err != nil {
+		return err
+	}
+
+	if l.client != nil {
+		if err := l.client.SAdd(ctx, keyPrefix+list, network.String()).Err(); err != nil {
+			return err
+		}
+		return l.Refresh(ctx)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !containsNetwork(l.runtime[list], network) {
+		l.runtime[list] = append(l.runtime[list], network)
+	}
+	return nil
+}
+
+// Remove removes an IP or CIDR added at runtime. Configured entries cannot be removed
+func (l *Lists) Remove(ctx context.Context, list, entry string) error {
+	network, err := l.validate(list, entry)
+	if err != nil {
+		return err
+	}
+
+	if l.client != nil {
+		if err := l.client.SRem(ctx, keyPrefix+list, network.String()).Err(); err != nil {
+			return err
+		}
+		return l.Refresh(ctx)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := l.runtime[list][:0]
+	for _, existing := range l.runtime[list] {
+		if existing.String() != network.String() {
+			kept = append(kept, existing)
+		}
+	}
+	l.runtime[list] = kept
+	return nil
+}
+
+// validate checks the list name and parses the entry
+func (l *Lists) validate(list, entry string) (*net.IPNet, error) {
+	if list != Allow && list != Deny {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownList, list)
+	}
+	return ParseNetwork(entry)
+}
+
+func containsNetwork(networks []*net.IPNet, network *net.IPNet) bool {
+	for _, existing := range networks {
+		if existing.String() == network.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns the entries of both lists keyed by list name
+func (l *Lists) Entries() map[string]Entries {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make(map[string]Entries, 2)
+	for _, list := range []string{Allow, Deny} {
+		entries[list] = Entries{
+			Static:  networkStrings(l.static[list]),
+			Runtime: networkStrings(l.runtime[list]),
+		}
+	}
+	return entries
+}
+
+func networkStrings(networks []*net.IPNet) []string {
+	values := make([]string, 0, len(networks))
+	for _, network := range networks {
+		values = append(values, network.String())
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Refresh loads the runtime entries from Redis
+func (l *Lists) Refresh(ctx context.Context) error {
+	if l.client == nil {
+		return nil
+	}
+
+	runtime := make(map[string][]*net.IPNet, 2)
+	for _, list := range []string{Allow, Deny} {
+		members, err := l.client.SMembers(ctx, keyPrefix+list).Result()
+		if err != nil {
+			return fmt.Errorf("failed to load %s list: %w", list, err)
+		}
+		for _, member := range members {
+			network, err := ParseNetwork(member)
+			if err != nil {
+				slog.Warn("Skipping invalid access list entry", "list", list, "error", err)
+				continue
+			}
+			runtime[list] = append(runtime[list], network)
+		}
+	}
+
+	l.mu.Lock()
+	l.runtime = runtime
+	l.mu.Unlock()
+	return nil
+}
+
+// Start loads the runtime entries and keeps them in sync until Stop
+func (l *Lists) Start(ctx context.Context) error {
+	err := l.Refresh(ctx)
+
+	go func() {
+		defer close(l.done)
+		if l.client == nil {
+			<-l.stop
+			return
+		}
+
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Refresh(context.Background()); err != nil {
+					slog.Error("Failed to sync access lists", "error", err)
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+
+	return err
+}
+
+// Stop ends the synchronization
+func (l *Lists) Stop() {
+	close(l.stop)
+	<-l.done
+}
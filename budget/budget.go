@@ -0,0 +1,235 @@
+package budget
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// Limiter holds the enforced policy, typically the rate limiter
+type Limiter interface {
+	Policy() config.Policy
+	SetPolicy(policy config.Policy)
+}
+
+// Status is the outcome of the last evaluated window
+type Status struct {
+	// Blocked is the number of rejected requests from legitimate clients
+	Blocked int `json:"blocked"`
+	// Succeeded is the number of successful requests from the same clients
+	Succeeded int `json:"succeeded"`
+	// Ratio is Blocked over all requests of legitimate clients
+	Ratio float64 `json:"ratio"`
+	// Factor multiplies the baseline limits, 1 when not relaxed
+	Factor      float64   `json:"factor"`
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+// outcome counts the requests of one client within the window
+type outcome struct {
+	blocked   int
+	succeeded int
+}
+
+// Relaxer watches how much legitimate traffic the limits reject and relaxes
+// them when it exceeds the error budget. A client is legitimate when it
+// authenticated with a configured token and eventually got a successful
+// response within the window; its rejected requests count against the budget.
+// Limits are scaled up by Step per window while over budget, up to MaxFactor
+// times the baseline, and scaled back down once the ratio falls under half the
+// budget. Relaxation is local to each instance
+type Relaxer struct {
+	limiter Limiter
+	events  *events.Bus
+	cfg     config.ErrorBudgetConfig
+
+	mu      sync.Mutex
+	clients map[string]*outcome
+	// baseline is the policy being relaxed, applied the one last set by the relaxer
+	baseline config.Policy
+	applied  *config.Policy
+	factor   float64
+	status   Status
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRelaxer creates a relaxer adjusting the limiter's policy and emitting
+// alerts on bus
+func NewRelaxer(limiter Limiter, bus *events.Bus, cfg config.ErrorBudgetConfig) *Relaxer {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.Step <= 1 {
+		cfg.Step = 1.5
+	}
+	if cfg.MaxFactor < 1 {
+		cfg.MaxFactor = 1
+	}
+
+	return &Relaxer{
+		limiter: limiter,
+		events:  bus,
+		cfg:     cfg,
+		clients: make(map[string]*outcome),
+		factor:  1,
+		status:  Status{Factor: 1},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Blocked records a rejected request of an authenticated client
+func (r *Relaxer) Blocked(client string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client(client).blocked++
+}
+
+// Succeeded records a successful response to an authenticated client
+func (r *Relaxer) Succeeded(client string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client(client).succeeded++
+}
+
+func (r *Relaxer) client(key string) *outcome {
+	o, ok := r.clients[key]
+	if !ok {
+		o = &outcome{}
+		r.clients[key] = o
+	}
+	return o
+}
+
+// Status returns the outcome of the last evaluated window
+func (r *Relaxer) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Evaluate closes the current window, relaxing or restoring the limits
+func (r *Relaxer) Evaluate() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := r.clients
+	r.clients = make(map[string]*outcome)
+
+	// Blocks of clients that never succeeded are not evidence of a bad limit
+	var blocked, succeeded int
+	for _, o := range clients {
+		if o.succeeded > 0 {
+			blocked += o.blocked
+			succeeded += o.succeeded
+		}
+	}
+
+	// Someone else changed the limits, relax from the new ones
+	current := r.limiter.Policy()
+	if r.applied == nil || !reflect.DeepEqual(current, *r.applied) {
+		r.baseline = current
+		r.applied = nil
+		r.factor = 1
+	}
+
+	var ratio float64
+	if total := blocked + succeeded; total > 0 {
+		ratio = float64(blocked) / float64(total)
+	}
+	metrics.ErrorBudgetBlockedRatio.Set(ratio)
+
+	if blocked+succeeded >= r.cfg.MinRequests {
+		switch {
+		case ratio > r.cfg.Budget && r.factor < r.cfg.MaxFactor:
+			r.scale(math.Min(r.factor*r.cfg.Step, r.cfg.MaxFactor), ratio, "relaxed")
+		case ratio > r.cfg.Budget:
+			r.alert(fmt.Sprintf("%.1f%% of legitimate requests blocked, over the %.1f%% budget with limits already at x%.2f",
+				ratio*100, r.cfg.Budget*100, r.factor))
+		case ratio <= r.cfg.Budget/2 && r.factor > 1:
+			r.scale(math.Max(r.factor/r.cfg.Step, 1), ratio, "restored")
+		}
+	}
+
+	r.status = Status{
+		Blocked:     blocked,
+		Succeeded:   succeeded,
+		Ratio:       ratio,
+		Factor:      r.factor,
+		EvaluatedAt: time.Now(),
+	}
+	return r.status
+}
+
+// scale applies the baseline limits multiplied by factor
+func (r *Relaxer) scale(factor, ratio float64, direction string) {
+	r.factor = factor
+	metrics.ErrorBudgetFactor.Set(factor)
+	metrics.ErrorBudgetAdjustments.WithLabelValues(direction).Inc()
+
+	policy := Scale(r.baseline, factor)
+	r.limiter.SetPolicy(policy)
+	r.applied = &policy
+
+	r.alert(fmt.Sprintf("limits %s to x%.2f of the baseline, %.1f%% of legitimate requests blocked (budget %.1f%%)",
+		direction, factor, ratio*100, r.cfg.Budget*100))
+}
+
+// alert logs and emits an error budget event
+func (r *Relaxer) alert(reason string) {
+	slog.Info("Error budget", "reason", reason)
+	if r.events != nil {
+		r.events.Emit(events.Event{
+			Type:   events.TypeRelaxed,
+			Key:    "policy",
+			Reason: reason,
+		})
+	}
+}
+
+// Scale multiplies the IP and token limits of a policy, rounding up. Block
+// times are kept
+func Scale(policy config.Policy, factor float64) config.Policy {
+	scaled := policy
+	scaled.IPLimit = int(math.Ceil(float64(policy.IPLimit) * factor))
+	scaled.TokenLimits = make(map[string]config.TokenLimit, len(policy.TokenLimits))
+	for token, limit := range policy.TokenLimits {
+		limit.Limit = int(math.Ceil(float64(limit.Limit) * factor))
+		scaled.TokenLimits[token] = limit
+	}
+	return scaled
+}
+
+// Start evaluates a window every configured interval until Stop
+func (r *Relaxer) Start() {
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.cfg.Window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Evaluate()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the evaluation, leaving the current limits in place
+func (r *Relaxer) Stop() {
+	close(r.stop)
+	<-r.done
+}
@@ -0,0 +1,158 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+const channel = "canary:blocks"
+
+// pollInterval is how often a probe re-checks a canary block until it is enforced
+const pollInterval = 5 * time.Millisecond
+
+// announcement tells the fleet that a canary key was just blocked
+type announcement struct {
+	Key string `json:"key"`
+	// BlockedAt is the Redis clock in milliseconds when the block was set
+	BlockedAt int64 `json:"blocked_at"`
+}
+
+// Prober measures block propagation delay: the leader blocks a canary key and
+// every instance measures how long its enforcement path takes to see the block.
+// Both ends are timed with the Redis clock so instance clock skew does not matter
+type Prober struct {
+	client  *redis.Client
+	storage strategy.StorageStrategy
+	slo     time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewProber creates a prober checking blocks through storage, which should be
+// the storage used by the rate limiter (including any local caching layer)
+func NewProber(client *redis.Client, storage strategy.StorageStrategy, slo time.Duration) *Prober {
+	if slo <= 0 {
+		slo = time.Second
+	}
+	metrics.BlockPropagationSLOSeconds.Set(slo.Seconds())
+
+	return &Prober{
+		client:  client,
+		storage: storage,
+		slo:     slo,
+	}
+}
+
+// Publish blocks a fresh canary key and announces it to the fleet. It is meant
+// to be scheduled as a leader-only job
+func (p *Prober) Publish(ctx context.Context) error {
+	now, err := p.client.Time(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read Redis time: %w", err)
+	}
+
+	key := fmt.Sprintf("canary:%d", now.UnixNano())
+	// Keep the canary blocked long enough for slow instances to report a missed SLO
+	if err := p.storage.SetBlocked(ctx, key, time.Now().Add(10*p.slo)); err != nil {
+		return fmt.Errorf("failed to block canary: %w", err)
+	}
+
+	data, err := json.Marshal(announcement{Key: key, BlockedAt: now.UnixMilli()})
+	if err != nil {
+		return err
+	}
+	return p.client.Publish(ctx, channel, data).Err()
+}
+
+// Start listens for canary announcements until Stop is called
+func (p *Prober) Start(ctx context.Context) {
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	pubsub := p.client.Subscribe(ctx, channel)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer pubsub.Close()
+
+		for {
+			select {
+			case msg, ok := <-pubsub.Channel():
+				if !ok {
+					return
+				}
+
+				var canary announcement
+				if err := json.Unmarshal([]byte(msg.Payload), &canary); err != nil {
+					continue
+				}
+
+				p.wg.Add(1)
+				go func() {
+					defer p.wg.Done()
+					p.probe(ctx, canary)
+				}()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops listening and waits for in-flight probes
+func (p *Prober) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+}
+
+// probe polls the canary until it is enforced and reports the delay against the SLO
+func (p *Prober) probe(ctx context.Context, canary announcement) {
+	timeout := time.NewTimer(10 * p.slo)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		blocked, _, err := p.storage.IsBlocked(ctx, canary.Key)
+		if err == nil && blocked {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			metrics.BlockPropagationSLO.WithLabelValues("missed").Inc()
+			slog.Warn("Canary block not enforced", "key", canary.Key, "within", 10*p.slo)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	now, err := p.client.Time(ctx).Result()
+	if err != nil {
+		slog.Error("Failed to read Redis time for canary", "key", canary.Key, "error", err)
+		return
+	}
+
+	delay := time.Duration(now.UnixMilli()-canary.BlockedAt) * time.Millisecond
+	metrics.BlockPropagation.Observe(delay.Seconds())
+	if delay > p.slo {
+		metrics.BlockPropagationSLO.WithLabelValues("missed").Inc()
+		slog.Warn("Canary block enforced above the SLO", "key", canary.Key, "delay", delay, "slo", p.slo)
+		return
+	}
+	metrics.BlockPropagationSLO.WithLabelValues("met").Inc()
+}
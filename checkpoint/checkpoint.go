@@ -0,0 +1,160 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Counter is a checkpointed counter value
+type Counter struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	// ExpiresAt is when the counter's period ends, zero if it never expires
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Snapshot is the set of counters saved by one checkpoint
+type Snapshot struct {
+	TakenAt  time.Time `json:"taken_at"`
+	Counters []Counter `json:"counters"`
+}
+
+// Store persists snapshots outside of Redis
+type Store interface {
+	// Save replaces the stored snapshot
+	Save(ctx context.Context, snapshot *Snapshot) error
+	// Load returns the stored snapshot, or nil if none was saved yet
+	Load(ctx context.Context) (*Snapshot, error)
+}
+
+// restoreScript raises a counter to the checkpointed value, never lowering it,
+// so requests counted since the checkpoint are kept
+var restoreScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0') or 0
+local count = tonumber(ARGV[1])
+if count <= current then
+	return 0
+end
+redis.call('SET', KEYS[1], count)
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// Checkpointer copies long-lived counters (e.g. daily quotas) to a durable store
+// and restores them, so a Redis flush or migration does not reset customers' usage
+type Checkpointer struct {
+	client   *redis.Client
+	store    Store
+	prefixes []string
+}
+
+// NewCheckpointer creates a checkpointer for the counters whose keys start with one of the prefixes
+func NewCheckpointer(client *redis.Client, store Store, prefixes ...string) *Checkpointer {
+	return &Checkpointer{
+		client:   client,
+		store:    store,
+		prefixes: prefixes,
+	}
+}
+
+// Checkpoint saves the current value and expiry of every matching counter
+func (c *Checkpointer) Checkpoint(ctx context.Context) error {
+	snapshot := &Snapshot{TakenAt: time.Now(), Counters: []Counter{}}
+
+	for _, prefix := range c.prefixes {
+		iter := c.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to scan %s counters: %w", prefix, err)
+		}
+
+		counters, err := c.read(ctx, keys)
+		if err != nil {
+			return err
+		}
+		snapshot.Counters = append(snapshot.Counters, counters...)
+	}
+
+	if err := c.store.Save(ctx, snapshot); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// read fetches the value and TTL of each key, skipping keys that are not counters
+func (c *Checkpointer) read(ctx context.Context, keys []string) ([]Counter, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := c.client.Pipeline()
+	values := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		values[i] = pipe.Get(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read counters: %w", err)
+	}
+
+	now := time.Now()
+	counters := make([]Counter, 0, len(keys))
+	for i, key := range keys {
+		count, err := strconv.ParseInt(values[i].Val(), 10, 64)
+		if err != nil {
+			// Expired since the scan or not a plain counter
+			continue
+		}
+
+		counter := Counter{Key: key, Count: count}
+		if ttl := ttls[i].Val(); ttl > 0 {
+			counter.ExpiresAt = now.Add(ttl)
+		}
+		counters = append(counters, counter)
+	}
+
+	return counters, nil
+}
+
+// Restore reconciles Redis with the last checkpoint, raising counters that are
+// lower than their checkpointed value. It returns the number of counters restored
+func (c *Checkpointer) Restore(ctx context.Context) (int, error) {
+	snapshot, err := c.store.Load(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	if snapshot == nil {
+		return 0, nil
+	}
+
+	now := time.Now()
+	restored := 0
+	for _, counter := range snapshot.Counters {
+		var expireAt int64
+		if !counter.ExpiresAt.IsZero() {
+			if !counter.ExpiresAt.After(now) {
+				// The counter's period is over
+				continue
+			}
+			expireAt = counter.ExpiresAt.UnixMilli()
+		}
+
+		changed, err := restoreScript.Run(ctx, c.client, []string{counter.Key}, counter.Count, expireAt).Int()
+		if err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", counter.Key, err)
+		}
+		restored += changed
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,232 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/awssig"
+)
+
+// OpenStore creates a store from a URI: file:///path/to/checkpoint.json or
+// s3://bucket/key (using the standard AWS_* credentials and region)
+func OpenStore(uri string) (Store, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint store %q: %w", uri, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewFileStore(u.Path), nil
+	case "s3":
+		return NewS3Store(u.Host, strings.TrimPrefix(u.Path, "/"), "")
+	default:
+		return nil, fmt.Errorf("unsupported checkpoint store %q", u.Scheme)
+	}
+}
+
+// FileStore keeps the snapshot in a local file, e.g. on a persistent volume
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store writing to the given path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save writes the snapshot atomically by renaming a temporary file
+func (f *FileStore) Save(ctx context.Context, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Load reads the snapshot, returning nil if the file does not exist
+func (f *FileStore) Load(ctx context.Context) (*Snapshot, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// S3Store keeps the snapshot as an S3 object
+type S3Store struct {
+	bucket string
+	key    string
+	client *http.Client
+	signer *awssig.Signer
+}
+
+// NewS3Store creates a store for an S3 object. An empty region falls back to AWS_REGION
+func NewS3Store(bucket, key, region string) (*S3Store, error) {
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("missing S3 bucket or key")
+	}
+
+	signer, err := awssig.FromEnv(region, "s3")
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		bucket: bucket,
+		key:    key,
+		client: &http.Client{Timeout: 30 * time.Second},
+		signer: signer,
+	}, nil
+}
+
+// Save uploads the snapshot, replacing the previous object
+func (s *S3Store) Save(ctx context.Context, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.error(resp)
+	}
+	return nil
+}
+
+// Load downloads the snapshot, returning nil if the object does not exist
+func (s *S3Store) Load(ctx context.Context) (*Snapshot, error) {
+	resp, err := s.do(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.error(resp)
+	}
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// do sends a signed request for the object
+func (s *S3Store) do(ctx context.Context, method string, body []byte) (*http.Response, error) {
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.signer.Region, s.key)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.signer.Sign(req, body, time.Now())
+
+	return s.client.Do(req)
+}
+
+func (s *S3Store) error(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3://%s/%s failed with status %d: %s", s.bucket, s.key, resp.StatusCode, message)
+}
+
+// SQLStore keeps the snapshot in a database table with the given name. The
+// caller provides the connection and its driver; the upsert uses the
+// PostgreSQL dialect:
+//
+//	CREATE TABLE rate_limit_checkpoints (
+//	    name       TEXT PRIMARY KEY,
+//	    data       TEXT NOT NULL,
+//	    updated_at TIMESTAMPTZ NOT NULL
+//	);
+type SQLStore struct {
+	db    *sql.DB
+	table string
+	name  string
+}
+
+// NewSQLStore creates a store writing the snapshot named name to table
+func NewSQLStore(db *sql.DB, table, name string) *SQLStore {
+	return &SQLStore{db: db, table: table, name: name}
+}
+
+// Save upserts the snapshot row
+func (s *SQLStore) Save(ctx context.Context, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO "+s.table+" (name, data, updated_at) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (name) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at",
+		s.name, string(data), snapshot.TakenAt)
+	return err
+}
+
+// Load reads the snapshot row, returning nil if it does not exist
+func (s *SQLStore) Load(ctx context.Context) (*Snapshot, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM "+s.table+" WHERE name = $1", s.name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/bypass"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+)
+
+// runBypass mints a signed token exempting requests from rate limiting
+func runBypass(args []string) error {
+	fs := flag.NewFlagSet("bypass", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", time.Hour, "how long the token is valid")
+	subject := fs.String("subject", "", "who the token is issued to, e.g. the integration team (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *subject == "" {
+		return fmt.Errorf("--subject is required")
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.RateLimit.Bypass.Secret == "" {
+		return fmt.Errorf("RATE_LIMIT_BYPASS_SECRET is not set")
+	}
+
+	signer := bypass.NewSigner(cfg.RateLimit.Bypass.Secret, cfg.RateLimit.Bypass.MaxTTL)
+	token, claims, err := signer.Mint(*subject, *ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Bypass token for %q, valid until %s\n", claims.Subject, claims.Expires().Format(time.RFC3339))
+	fmt.Println(token)
+	return nil
+}
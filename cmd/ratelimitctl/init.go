@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// initTemplates are rendered into the new project, named after the file they
+// produce with a .tmpl suffix ("env" becomes ".env")
+//
+//go:embed templates/init/*.tmpl
+var initTemplates embed.FS
+
+// initGoVersion is the Go version of the generated module
+const initGoVersion = "1.25"
+
+// projectName is accepted as service name in the generated files
+var projectName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// initProject is the data available to the templates
+type initProject struct {
+	Module    string
+	Name      string
+	Port      string
+	GoVersion string
+}
+
+// runInit scaffolds a minimal service embedding the rate limiter middleware
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	module := fs.String("module", "", "module path of the new service (default: the directory name)")
+	port := fs.String("port", "8080", "HTTP port of the service")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	project := initProject{
+		Module:    *module,
+		Name:      strings.ToLower(filepath.Base(absDir)),
+		Port:      *port,
+		GoVersion: initGoVersion,
+	}
+	if project.Module == "" {
+		project.Module = project.Name
+	}
+	if !projectName.MatchString(project.Name) {
+		return fmt.Errorf("directory name %q is not a valid service name, use lowercase letters, digits, - and _", project.Name)
+	}
+
+	files, err := renderInit(project)
+	if err != nil {
+		return err
+	}
+
+	if !*force {
+		for name := range files {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return fmt.Errorf("%s already exists, use --force to overwrite", filepath.Join(dir, name))
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("created %s\n", filepath.Join(dir, name))
+	}
+
+	fmt.Printf("\nNext steps:\n  cd %s\n  go mod tidy\n  docker compose up -d redis\n  go test ./...\n  go run .\n", dir)
+	return nil
+}
+
+// renderInit renders every template, keyed by the file name it produces
+func renderInit(project initProject) (map[string][]byte, error) {
+	names, err := fs.Glob(initTemplates, "templates/init/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		tmpl, err := template.ParseFS(initTemplates, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, project); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+
+		file := strings.TrimSuffix(path.Base(name), ".tmpl")
+		if file == "env" {
+			file = ".env"
+		}
+
+		content := out.Bytes()
+		if strings.HasSuffix(file, ".go") {
+			if content, err = format.Source(content); err != nil {
+				return nil, fmt.Errorf("generated %s does not parse: %w", file, err)
+			}
+		}
+		files[file] = content
+	}
+	return files, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a ratelimitctl subcommand
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{name: "whatif", description: "Replay an access log against a tier's limits", run: runWhatIf},
+	{name: "init", description: "Scaffold a service embedding the rate limiter", run: runInit},
+	{name: "bypass", description: "Mint a short-lived token exempting requests from rate limiting", run: runBypass},
+	{name: "migrate-storage", description: "Copy counters and blocks between storage backends", run: runMigrateStorage},
+	{name: "stress", description: "Check that concurrent requests never exceed the limit", run: runStress},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "ratelimitctl %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the available subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ratelimitctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.description)
+	}
+}
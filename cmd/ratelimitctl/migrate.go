@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/ratelimit"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Storage backends supported by migrate-storage
+const (
+	backendRedis          = "redis"
+	backendMemorySnapshot = "memory-snapshot"
+)
+
+// runMigrateStorage copies counters and blocks between storage backends
+func runMigrateStorage(args []string) error {
+	fs := flag.NewFlagSet("migrate-storage", flag.ContinueOnError)
+	from := fs.String("from", "", "source backend: redis or memory-snapshot")
+	to := fs.String("to", "", "destination backend: redis or memory-snapshot")
+	snapshot := fs.String("snapshot", "ratelimiter-snapshot.json", "memory-snapshot file")
+	rate := fs.Int("rate", 1000, "maximum records copied per second, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *from == "" || *to == "" {
+		return fmt.Errorf("--from and --to are required")
+	}
+	if *from == *to {
+		return fmt.Errorf("--from and --to must be different backends")
+	}
+	if *rate < 0 {
+		return fmt.Errorf("--rate must not be negative")
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	source, closeSource, err := openBackend(ctx, cfg, *from, *snapshot, true)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	destination, closeDestination, err := openBackend(ctx, cfg, *to, *snapshot, false)
+	if err != nil {
+		return err
+	}
+
+	copied, err := strategy.Copy(ctx, source, destination, *rate)
+	if err != nil {
+		closeDestination()
+		return fmt.Errorf("copied %d records before failing: %w", copied, err)
+	}
+	if err := closeDestination(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d counters and blocks from %s to %s\n", copied, *from, *to)
+	return nil
+}
+
+// openBackend opens a storage backend, loading a snapshot source and saving
+// a snapshot destination when closed
+func openBackend(ctx context.Context, cfg *config.Config, backend, snapshot string, source bool) (strategy.MigratableStorage, func() error, error) {
+	switch backend {
+	case backendRedis:
+		redisStrategy, err := ratelimit.NewRedis(cfg.Redis)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := redisStrategy.Ping(ctx); err != nil {
+			redisStrategy.Close()
+			return nil, nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		return redisStrategy, redisStrategy.Close, nil
+
+	case backendMemorySnapshot:
+		memory := strategy.NewMemoryStrategy()
+		if source {
+			if _, err := memory.LoadSnapshot(snapshot); err != nil {
+				return nil, nil, fmt.Errorf("failed to load snapshot: %w", err)
+			}
+			return memory, memory.Close, nil
+		}
+		return memory, func() error {
+			if err := memory.SaveSnapshot(snapshot); err != nil {
+				return fmt.Errorf("failed to save snapshot: %w", err)
+			}
+			return nil
+		}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q, expected redis or memory-snapshot", backend)
+	}
+}
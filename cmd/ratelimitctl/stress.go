@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/ratelimit"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// runStress hammers CheckRateLimit for a single client from many goroutines
+// and fails when more requests are admitted than the limit allows, guarding
+// the atomicity of the check. Build it with -race to also catch data races
+func runStress(args []string) error {
+	fs := flag.NewFlagSet("stress", flag.ContinueOnError)
+	backend := fs.String("backend", "memory", "storage backend: memory or redis")
+	goroutines := fs.Int("goroutines", 200, "concurrent clients")
+	requests := fs.Int("requests", 50, "requests per goroutine")
+	limit := fs.Int("limit", 100, "requests per window allowed to the client")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *goroutines <= 0 || *requests <= 0 || *limit <= 0 {
+		return fmt.Errorf("--goroutines, --requests and --limit must be positive")
+	}
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return err
+	}
+	// A long block makes every request after the first denial a denial too,
+	// so admissions can only come from windows started before it
+	cfg.RateLimit.IPLimit = *limit
+	cfg.RateLimit.IPBlockTime = time.Minute
+
+	ctx := context.Background()
+	var storage strategy.StorageStrategy
+	switch *backend {
+	case "memory":
+		storage = strategy.NewMemoryStrategy()
+	case backendRedis:
+		redisStrategy, err := ratelimit.NewRedis(cfg.Redis)
+		if err != nil {
+			return err
+		}
+		if err := redisStrategy.Ping(ctx); err != nil {
+			redisStrategy.Close()
+			return fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		storage = redisStrategy
+	default:
+		return fmt.Errorf("unknown backend %q, expected memory or redis", *backend)
+	}
+	defer storage.Close()
+
+	rateLimiter := limiter.NewRateLimiter(storage, cfg)
+	// A fresh client per run, so earlier runs against Redis do not interfere
+	ip := fmt.Sprintf("stress-%d", time.Now().UnixNano())
+	defer rateLimiter.ResetRateLimit(ctx, rateLimiter.Key("ip", ip))
+
+	var admitted, denied, failed atomic.Int64
+	var firstDenial atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range *goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range *requests {
+				result, err := rateLimiter.CheckRateLimit(ctx, ip, "")
+				switch {
+				case err != nil:
+					failed.Add(1)
+				case result.Allowed:
+					admitted.Add(1)
+				default:
+					denied.Add(1)
+					firstDenial.CompareAndSwap(0, int64(time.Since(start)))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Each window started before the first denial admits up to the limit
+	untilDenial := time.Duration(firstDenial.Load())
+	if untilDenial == 0 {
+		untilDenial = elapsed
+	}
+	bound := int64(*limit) * (int64(untilDenial/cfg.RateLimit.Window) + 1)
+
+	fmt.Printf("Backend:   %s\n", *backend)
+	fmt.Printf("Requests:  %d from %d goroutines in %s\n", *goroutines**requests, *goroutines, elapsed.Round(time.Millisecond))
+	fmt.Printf("Admitted:  %d (bound %d)\n", admitted.Load(), bound)
+	fmt.Printf("Denied:    %d\n", denied.Load())
+	fmt.Printf("Errors:    %d\n", failed.Load())
+
+	if failed.Load() > 0 {
+		return fmt.Errorf("%d checks failed", failed.Load())
+	}
+	if admitted.Load() > bound {
+		return fmt.Errorf("admitted %d requests, above the bound of %d", admitted.Load(), bound)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+)
+
+// clfTime is the timestamp layout of Common/Combined Log Format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// logEntry is a request read from an access log
+type logEntry struct {
+	customer string
+	time     time.Time
+	// path is the request path, empty when the log does not record it
+	path string
+}
+
+// customerUsage accumulates the simulated outcome for one customer
+type customerUsage struct {
+	Customer  string `json:"customer"`
+	Requests  int    `json:"requests"`
+	Throttled int    `json:"throttled"`
+
+	windowStart time.Time
+	windowCount int
+}
+
+// routeUsage accumulates the simulated outcome for one normalized route
+type routeUsage struct {
+	Route     string `json:"route"`
+	Requests  int    `json:"requests"`
+	Throttled int    `json:"throttled"`
+}
+
+// runWhatIf replays historical requests against a proposed tier's limits
+func runWhatIf(args []string) error {
+	fs := flag.NewFlagSet("whatif", flag.ContinueOnError)
+	tier := fs.String("tier", "", "tier whose limits are simulated (RATE_LIMIT_TIER_<NAME>_*)")
+	limit := fs.Int("limit", 0, "requests per window, overrides the tier limit")
+	window := fs.Duration("window", 0, "window duration, overrides the tier window")
+	logPath := fs.String("log", "", "access log to replay ('-' for stdin)")
+	format := fs.String("format", "clf", "log format: clf (Common/Combined) or json")
+	keyField := fs.String("key-field", "token", "json format: field identifying the customer, falling back to ip")
+	top := fs.Int("top", 10, "number of most throttled customers to report")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *logPath == "" {
+		return fmt.Errorf("--log is required")
+	}
+
+	tierLimit := config.TierLimit{Limit: *limit, Window: *window}
+	normalizer := routes.NewNormalizer(nil)
+	if *tier != "" {
+		cfg, err := loader.Load()
+		if err != nil {
+			return err
+		}
+		normalizer = routes.NewNormalizer(cfg.RateLimit.RouteTemplates)
+		configured, ok := cfg.RateLimit.Tiers[strings.ToUpper(*tier)]
+		if !ok {
+			return fmt.Errorf("tier %q is not configured", *tier)
+		}
+		if tierLimit.Limit == 0 {
+			tierLimit.Limit = configured.Limit
+		}
+		if tierLimit.Window == 0 {
+			tierLimit.Window = configured.Window
+		}
+	}
+	if tierLimit.Limit <= 0 {
+		return fmt.Errorf("a --tier or --limit is required")
+	}
+	if tierLimit.Window <= 0 {
+		tierLimit.Window = time.Second
+	}
+
+	var input io.Reader = os.Stdin
+	if *logPath != "-" {
+		file, err := os.Open(*logPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	usage, routeUsages, skipped, err := simulate(input, *format, *keyField, tierLimit, normalizer)
+	if err != nil {
+		return err
+	}
+
+	return printReport(os.Stdout, *tier, tierLimit, usage, routeUsages, skipped, *top, *asJSON)
+}
+
+// simulate replays the log with a fixed window counter per customer.
+// Entries are assumed to be in chronological order, as access logs are.
+// Throttled requests are also reported per route, normalized so that e.g.
+// "/users/1" and "/users/2" count as "/users/{id}"
+func simulate(input io.Reader, format, keyField string, limit config.TierLimit, normalizer *routes.Normalizer) (map[string]*customerUsage, map[string]*routeUsage, int, error) {
+	usage := make(map[string]*customerUsage)
+	routeUsages := make(map[string]*routeUsage)
+	skipped := 0
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, ok := parseEntry(scanner.Text(), format, keyField)
+		if !ok {
+			skipped++
+			continue
+		}
+
+		customer, exists := usage[entry.customer]
+		if !exists {
+			customer = &customerUsage{Customer: entry.customer}
+			usage[entry.customer] = customer
+		}
+
+		windowStart := entry.time.Truncate(limit.Window)
+		if !windowStart.Equal(customer.windowStart) {
+			customer.windowStart = windowStart
+			customer.windowCount = 0
+		}
+
+		var route *routeUsage
+		if entry.path != "" {
+			name := normalizer.NormalizePath(entry.path)
+			if route = routeUsages[name]; route == nil {
+				route = &routeUsage{Route: name}
+				routeUsages[name] = route
+			}
+			route.Requests++
+		}
+
+		customer.Requests++
+		customer.windowCount++
+		if customer.windowCount > limit.Limit {
+			customer.Throttled++
+			if route != nil {
+				route.Throttled++
+			}
+		}
+	}
+
+	return usage, routeUsages, skipped, scanner.Err()
+}
+
+// parseEntry extracts the customer and timestamp from a log line
+func parseEntry(line, format, keyField string) (logEntry, bool) {
+	if format == "json" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return logEntry{}, false
+		}
+
+		customer, _ := fields[keyField].(string)
+		if customer == "" {
+			customer, _ = fields["ip"].(string)
+		}
+		raw, _ := fields["time"].(string)
+		timestamp, err := time.Parse(time.RFC3339Nano, raw)
+		if customer == "" || err != nil {
+			return logEntry{}, false
+		}
+		path, _ := fields["path"].(string)
+		return logEntry{customer: customer, time: timestamp, path: path}, true
+	}
+
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326
+	ip, rest, found := strings.Cut(line, " ")
+	if !found {
+		return logEntry{}, false
+	}
+	start := strings.Index(rest, "[")
+	end := strings.Index(rest, "]")
+	if start < 0 || end < start {
+		return logEntry{}, false
+	}
+	timestamp, err := time.Parse(clfTime, rest[start+1:end])
+	if err != nil {
+		return logEntry{}, false
+	}
+
+	// The request line follows the timestamp: "METHOD /path?query PROTOCOL"
+	var path string
+	if _, request, found := strings.Cut(rest[end:], `"`); found {
+		request, _, _ = strings.Cut(request, `"`)
+		if fields := strings.Fields(request); len(fields) >= 2 {
+			path, _, _ = strings.Cut(fields[1], "?")
+		}
+	}
+	return logEntry{customer: ip, time: timestamp, path: path}, true
+}
+
+// printReport summarizes how many customers and requests would have been throttled
+func printReport(w io.Writer, tier string, limit config.TierLimit, usage map[string]*customerUsage, routeUsages map[string]*routeUsage, skipped, top int, asJSON bool) error {
+	customers := make([]*customerUsage, 0, len(usage))
+	totalRequests, throttledRequests, throttledCustomers := 0, 0, 0
+	for _, customer := range usage {
+		customers = append(customers, customer)
+		totalRequests += customer.Requests
+		throttledRequests += customer.Throttled
+		if customer.Throttled > 0 {
+			throttledCustomers++
+		}
+	}
+
+	sort.Slice(customers, func(i, j int) bool {
+		if customers[i].Throttled != customers[j].Throttled {
+			return customers[i].Throttled > customers[j].Throttled
+		}
+		return customers[i].Customer < customers[j].Customer
+	})
+
+	var worst []*customerUsage
+	for _, customer := range customers {
+		if len(worst) == top || customer.Throttled == 0 {
+			break
+		}
+		worst = append(worst, customer)
+	}
+
+	var worstRoutes []*routeUsage
+	for _, route := range routeUsages {
+		if route.Throttled > 0 {
+			worstRoutes = append(worstRoutes, route)
+		}
+	}
+	sort.Slice(worstRoutes, func(i, j int) bool {
+		if worstRoutes[i].Throttled != worstRoutes[j].Throttled {
+			return worstRoutes[i].Throttled > worstRoutes[j].Throttled
+		}
+		return worstRoutes[i].Route < worstRoutes[j].Route
+	})
+	if len(worstRoutes) > top {
+		worstRoutes = worstRoutes[:top]
+	}
+
+	if asJSON {
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"tier":                  tier,
+			"limit":                 limit.Limit,
+			"window":                limit.Window.String(),
+			"customers":             len(customers),
+			"throttled_customers":   throttledCustomers,
+			"requests":              totalRequests,
+			"throttled_requests":    throttledRequests,
+			"skipped_lines":         skipped,
+			"most_throttled":        worst,
+			"most_throttled_routes": worstRoutes,
+		})
+	}
+
+	if tier == "" {
+		tier = "custom"
+	}
+	fmt.Fprintf(w, "Tier:                %s (%d per %s)\n", tier, limit.Limit, limit.Window)
+	fmt.Fprintf(w, "Customers:           %d\n", len(customers))
+	fmt.Fprintf(w, "Throttled customers: %d (%.1f%%)\n", throttledCustomers, percent(throttledCustomers, len(customers)))
+	fmt.Fprintf(w, "Requests:            %d\n", totalRequests)
+	fmt.Fprintf(w, "Throttled requests:  %d (%.1f%%)\n", throttledRequests, percent(throttledRequests, totalRequests))
+	if skipped > 0 {
+		fmt.Fprintf(w, "Skipped lines:       %d\n", skipped)
+	}
+
+	if len(worst) > 0 {
+		fmt.Fprintln(w, "\nMost throttled customers:")
+		for _, customer := range worst {
+			fmt.Fprintf(w, "  %-40s %d/%d throttled\n", customer.Customer, customer.Throttled, customer.Requests)
+		}
+	}
+
+	if len(worstRoutes) > 0 {
+		fmt.Fprintln(w, "\nMost throttled routes:")
+		for _, route := range worstRoutes {
+			fmt.Fprintf(w, "  %-40s %d/%d throttled\n", route.Route, route.Throttled, route.Requests)
+		}
+	}
+
+	return nil
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -2,48 +2,247 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/acl"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/canary"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/checkpoint"
 	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
-	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/encryption"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/fleet"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/grpcserver"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/inflight"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/leader"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/lifecycle"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/logging"
 	ratelimitMiddleware "github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/offenders"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/policy"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/ratelimit"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/rls"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes/chiroutes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/siem"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/state"
 	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/waf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// version is set at build time via -ldflags "-X main.version=..."
+var version = "dev"
+
+// maxStateDumpSize bounds the state dumps accepted by the admin API
+const maxStateDumpSize = 256 << 20
+
+// Bounds of the faults injected through the admin API
+const (
+	defaultFaultDuration = 5 * time.Minute
+	maxFaultDuration     = time.Hour
+	maxFaultLatency      = 30 * time.Second
 )
 
 func main() {
+	// End-to-end check of a build or deployment, see runSmoke
+	if len(os.Args) > 1 && os.Args[1] == "smoke" {
+		if err := runSmoke(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "FAIL    ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	cfg, err := loader.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		fatal("Failed to configure logging", err)
+	}
+	if cfg.RateLimit.Preset != "" {
+		slog.Info("Using configuration preset", "preset", cfg.RateLimit.Preset)
 	}
 
-	// Initialize Redis strategy
-	redisStrategy := strategy.NewRedisStrategy(
-		cfg.Redis.Host,
-		cfg.Redis.Port,
-		cfg.Redis.Password,
-		cfg.Redis.DB,
-	)
-
-	// Test Redis connection
+	// Wire storage, rate limiter and middleware
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := redisStrategy.Ping(ctx); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	// Subsystems register their shutdown steps as they start
+	shutdown := lifecycle.New()
+
+	// The middleware is router agnostic, chi route patterns and request IDs are
+	// resolved here
+	components, err := ratelimit.Setup(ctx, cfg,
+		ratelimit.WithLifecycle(shutdown),
+		ratelimit.WithMiddlewareOptions(
+			ratelimitMiddleware.WithPatternResolver(chiroutes.Pattern),
+			ratelimitMiddleware.WithRequestIDResolver(func(r *http.Request) string {
+				return middleware.GetReqID(r.Context())
+			}),
+		),
+	)
+	if err != nil {
+		fatal("Failed to set up rate limiter", err)
+	}
+	slog.Info("Connected to Redis successfully")
+
+	redisStrategy := components.Redis
+	rateLimiter := components.Limiter
+	// Read-only instances serve reads of a replica and never write to it
+	readOnly := cfg.RateLimit.ReadOnly
+	if readOnly {
+		slog.Info("Running in read-only mode, requests are neither counted nor blocked")
+	}
+
+	// Track the worst offenders per policy
+	offenderTracker := offenders.NewTracker(redisStrategy.Client(), cfg.RateLimit.OffendersWindow)
+	if !readOnly {
+		rateLimiter.Events().Subscribe(offenderTracker)
+	}
+
+	// Stream block events to the SIEM
+	var siemSink *siem.Sink
+	if cfg.SIEM.Address != "" {
+		siemSink, err = siem.NewSink(cfg.SIEM.Address, version, cfg.SIEM.Events)
+		if err != nil {
+			fatal("Failed to configure SIEM export", err)
+		}
+		rateLimiter.Events().Subscribe(siemSink)
+		shutdown.OnShutdown("siem", lifecycle.PhaseSinks, func(context.Context) error {
+			siemSink.Close()
+			return nil
+		})
+	}
+
+	// Version applied limits so they can be rolled back across the fleet
+	policyHistory := policy.NewHistory(redisStrategy.Client(), rateLimiter, cfg.RateLimit.PolicyHistory.Size, cfg.RateLimit.PolicyHistory.SyncInterval)
+	keyring, err := encryption.ParseKeys(cfg.RateLimit.EncryptionKeys)
+	if err != nil {
+		fatal("Invalid encryption keys", err)
+	}
+	policyHistory.SetKeyring(keyring)
+	// Rollbacks are held to the same caps as runtime overrides
+	policyHistory.SetCaps(cfg.RateLimit.Caps)
+	if readOnly {
+		if err := policyHistory.Follow(ctx); err != nil {
+			slog.Error("Failed to load the active policy version, using configured limits", "error", err)
+		}
+	} else {
+		if err := policyHistory.Start(ctx, cfg.RateLimit.Policy()); err != nil {
+			slog.Error("Failed to reconcile policy history, using configured limits", "error", err)
+		}
+		// Seal versions stored before encryption was enabled or the key rotated
+		if rewritten, err := policyHistory.Reencrypt(ctx); err != nil {
+			slog.Error("Failed to re-encrypt policy history", "error", err)
+		} else if rewritten > 0 {
+			slog.Info("Re-encrypted policy versions", "versions", rewritten, "key", keyring.Primary())
+		}
+	}
+	shutdown.OnShutdown("policy history", lifecycle.PhaseJobs, func(context.Context) error {
+		policyHistory.Stop()
+		return nil
+	})
+
+	// Register this instance in the fleet
+	registry := fleet.NewRegistry(redisStrategy.Client(), version, fleet.Mode(cfg.RateLimit), cfg.Server.HeartbeatInterval)
+	if !readOnly {
+		registry.Start(ctx)
+		// Leave the fleet before the connection is closed
+		shutdown.OnShutdown("fleet", lifecycle.PhaseFleet, registry.Stop)
+	}
+
+	// Apply limit and token changes from the config file or SIGHUP without a restart
+	if !readOnly {
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		loader.Watch(watchCtx, func(reloaded *config.Config) {
+			previous := rateLimiter.Policy()
+			if err := policyHistory.Reload(watchCtx, reloaded.RateLimit.Policy()); err != nil {
+				slog.Error("Failed to apply reloaded limits", "error", err)
+				return
+			}
+			if changes := policy.Diff(previous, rateLimiter.Policy()); len(changes) > 0 {
+				slog.Info("Reloaded limits applied", "changes", changes)
+			}
+			// The fleet lists the mode of the configuration last loaded
+			registry.SetMode(fleet.Mode(reloaded.RateLimit))
+		})
+		shutdown.OnShutdown("config watch", lifecycle.PhaseJobs, func(context.Context) error {
+			stopWatching()
+			return nil
+		})
+	}
+
+	// Background jobs run only on the elected leader
+	elector := leader.NewElector(redisStrategy.Client(), "jobs", registry.ID(), cfg.Server.LeaderLeaseTTL)
+
+	// Export blocked IPs to edge WAFs
+	if syncer := newWAFSyncer(cfg.WAF, redisStrategy); syncer.Enabled() {
+		elector.Schedule("waf-sync", cfg.WAF.SyncInterval, func(ctx context.Context) error {
+			return syncer.Sync(ctx)
+		})
+	}
+
+	// Checkpoint long-lived counters so infra events do not reset daily usage
+	if cfg.RateLimit.Checkpoint.Store != "" && !readOnly {
+		store, err := checkpoint.OpenStore(cfg.RateLimit.Checkpoint.Store)
+		if err != nil {
+			fatal("Failed to open checkpoint store", err)
+		}
+		checkpointer := checkpoint.NewCheckpointer(redisStrategy.Client(), store, cfg.RateLimit.Checkpoint.Prefixes...)
+
+		restored, err := checkpointer.Restore(ctx)
+		if err != nil {
+			slog.Error("Failed to restore counters from checkpoint", "error", err)
+		} else if restored > 0 {
+			slog.Info("Restored counters from checkpoint", "counters", restored)
+		}
+
+		elector.Schedule("checkpoint", cfg.RateLimit.Checkpoint.Interval, checkpointer.Checkpoint)
 	}
-	log.Println("Connected to Redis successfully")
 
-	// Initialize rate limiter
-	rateLimiter := limiter.NewRateLimiter(redisStrategy, cfg)
+	// Measure how long blocks take to be enforced across the fleet
+	var prober *canary.Prober
+	if cfg.RateLimit.Canary.Interval > 0 {
+		prober = canary.NewProber(redisStrategy.Client(), components.Storage, cfg.RateLimit.Canary.SLO)
+		prober.Start(context.Background())
+		shutdown.OnShutdown("canary", lifecycle.PhaseJobs, func(context.Context) error {
+			prober.Stop()
+			return nil
+		})
+		elector.Schedule("canary", cfg.RateLimit.Canary.Interval, prober.Publish)
+	}
+
+	// Track concurrent requests per key to spot clients holding slow connections
+	var inFlight *inflight.Tracker
+	if cfg.RateLimit.InFlight.Interval > 0 && !readOnly {
+		inFlight = inflight.NewTracker(redisStrategy.Client(), registry.ID(), cfg.RateLimit.InFlight.Interval, cfg.RateLimit.InFlight.Top)
+		inFlight.Start(context.Background())
+		shutdown.OnShutdown("in-flight", lifecycle.PhaseJobs, inFlight.Stop)
+	}
+
+	// Leader jobs write to the storage
+	if !readOnly {
+		elector.Start(context.Background())
+		// Stop background jobs and hand over leadership
+		shutdown.OnShutdown("leader", lifecycle.PhaseJobs, elector.Stop)
+	}
 
 	// Setup Chi router
 	router := chi.NewRouter()
@@ -52,8 +251,17 @@ func main() {
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.RequestID)
+	// Only trusted proxies may set the headers RealIP reads the client address from
+	router.Use(components.TrustBoundary)
 	router.Use(middleware.RealIP)
 	router.Use(middleware.Timeout(60 * time.Second))
+	router.Use(registry.Middleware)
+	if readOnly {
+		router.Use(rejectAdminWrites)
+	} else {
+		router.Use(ratelimitMiddleware.HoneypotMiddleware(rateLimiter, cfg.RateLimit.Honeypot.Paths, cfg.RateLimit.Honeypot.BlockTime))
+		router.Use(ratelimitMiddleware.SlowClientMiddleware(rateLimiter, cfg.RateLimit.SlowClient))
+	}
 
 	// Health check endpoint (without rate limiting)
 	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -64,9 +272,18 @@ func main() {
 		})
 	})
 
+	// Prometheus metrics (without rate limiting), in the OpenMetrics format
+	// when the scraper asks for it
+	blockCollector := strategy.NewBlockCollector(redisStrategy, "ip", "token")
+	prometheus.MustRegister(blockCollector)
+	rateLimiter.Events().Subscribe(blockCollector)
+	router.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+
 	// Rate limit info endpoint
 	router.Route("/rate-limit", func(r chi.Router) {
-		r.Use(ratelimitMiddleware.RateLimitInfoMiddleware(rateLimiter))
+		r.Use(components.InfoMiddleware)
+		r.Use(ratelimitMiddleware.ETagMiddleware)
 		r.Get("/info", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -82,7 +299,12 @@ func main() {
 
 	// Protected endpoints
 	router.Route("/api", func(r chi.Router) {
-		r.Use(ratelimitMiddleware.RateLimitMiddleware(rateLimiter))
+		if inFlight != nil {
+			r.Use(ratelimitMiddleware.InFlightMiddleware(rateLimiter, inFlight, components.Options...))
+		}
+		r.Use(ratelimitMiddleware.BodySizeMiddleware(rateLimiter, cfg.RateLimit.MaxBodySize, cfg.RateLimit.Tiers, components.Options...))
+		r.Use(ratelimitMiddleware.SignatureMiddleware(components.Storage, cfg.RateLimit.Signing))
+		r.Use(components.Middleware)
 
 		r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
@@ -95,12 +317,18 @@ func main() {
 		})
 
 		r.Post("/data", func(w http.ResponseWriter, r *http.Request) {
-			var requestData map[string]interface{}
+			// Accepts a single object or a list of items
+			var requestData interface{}
 			if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+				status, message := http.StatusBadRequest, "Invalid JSON"
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					status, message = http.StatusRequestEntityTooLarge, "Request body too large"
+				}
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
+				w.WriteHeader(status)
 				json.NewEncoder(w).Encode(map[string]string{
-					"error": "Invalid JSON",
+					"error": message,
 				})
 				return
 			}
@@ -126,11 +354,30 @@ func main() {
 		})
 	})
 
+	// Admin endpoints require a bearer token or client certificate, and reject
+	// every request without configured credentials unless explicitly opened
+	adminAuth := ratelimitMiddleware.AdminAuthMiddleware(cfg.Admin)
+	switch {
+	case cfg.Admin.Enabled():
+	case cfg.Admin.AuthDisabled:
+		slog.Warn("Admin endpoints are open because ADMIN_AUTH_DISABLED is set, set ADMIN_WRITE_TOKENS or ADMIN_WRITE_CERT_SUBJECTS to protect them")
+	default:
+		slog.Warn("Admin endpoints are disabled, set ADMIN_WRITE_TOKENS or ADMIN_WRITE_CERT_SUBJECTS to use them")
+	}
+
+	// Operator changes to a key show up in its timeline
+	recordAction := func(r *http.Request, key, action, detail string) {
+		if components.Timeline != nil {
+			components.Timeline.RecordAction(key, action, ratelimitMiddleware.AdminIdentity(r), detail)
+		}
+	}
+
 	// Admin endpoints for testing
 	router.Route("/admin", func(r chi.Router) {
+		r.Use(adminAuth)
 		r.Post("/reset/{key}", func(w http.ResponseWriter, r *http.Request) {
 			key := chi.URLParam(r, "key")
-			if err := rateLimiter.ResetRateLimit(ctx, key); err != nil {
+			if err := rateLimiter.ResetRateLimit(r.Context(), key); err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(w).Encode(map[string]string{
@@ -138,6 +385,7 @@ func main() {
 				})
 				return
 			}
+			recordAction(r, key, "reset", "")
 
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -145,85 +393,1006 @@ func main() {
 				"key":     key,
 			})
 		})
+
+		r.Post("/grant", func(w http.ResponseWriter, r *http.Request) {
+			var request struct {
+				Key   string `json:"key"`
+				IP    string `json:"ip"`
+				Token string `json:"token"`
+				Units int    `json:"units"`
+				// Scope is "window" (default) or "day"
+				Scope string `json:"scope"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Units <= 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Expected a JSON body with key, ip or token and positive units",
+				})
+				return
+			}
+
+			key := request.Key
+			switch {
+			case request.Token != "":
+				key = rateLimiter.Key("token", request.Token)
+			case request.IP != "":
+				key = rateLimiter.Key("ip", request.IP)
+			}
+
+			now := time.Now()
+			var until time.Time
+			switch request.Scope {
+			case "", "window":
+				until = now.Add(rateLimiter.Policy().LimitWindow())
+			case "day":
+				until = now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			}
+			if key == "" || until.IsZero() {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Missing key or invalid scope",
+				})
+				return
+			}
+
+			total, err := rateLimiter.Grant(r.Context(), key, request.Units, until)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to grant units",
+				})
+				return
+			}
+			recordAction(r, key, "grant", fmt.Sprintf("%d units until %s", request.Units, until.Format(time.RFC3339)))
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":       "Units granted successfully",
+				"key":           key,
+				"granted":       request.Units,
+				"total_granted": total,
+				"expires_at":    until,
+			})
+		})
+
+		r.Get("/offenders", func(w http.ResponseWriter, r *http.Request) {
+			limit := 10
+			if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+				limit = value
+			}
+
+			policies := []string{"ip", "token", "blocked"}
+			if policy := r.URL.Query().Get("policy"); policy != "" {
+				policies = []string{policy}
+			}
+
+			result := make(map[string][]offenders.Offender, len(policies))
+			for _, policy := range policies {
+				top, err := offenderTracker.Top(r.Context(), policy, limit)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "Failed to list offenders",
+					})
+					return
+				}
+				result[policy] = top
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"window":    cfg.RateLimit.OffendersWindow.String(),
+				"offenders": result,
+			})
+		})
+
+		r.Get("/inflight", func(w http.ResponseWriter, r *http.Request) {
+			if inFlight == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "In-flight tracking is disabled",
+				})
+				return
+			}
+
+			limit := 10
+			if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+				limit = value
+			}
+
+			fleetTop, err := inFlight.Top(r.Context(), limit)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to list in-flight requests",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"fleet":    fleetTop,
+				"instance": registry.ID(),
+				"local":    inFlight.Local(limit),
+			})
+		})
+
+		r.Get("/error-budget", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if components.Relaxer == nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Error budget is disabled",
+				})
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"budget":      cfg.RateLimit.ErrorBudget.Budget,
+				"max_factor":  cfg.RateLimit.ErrorBudget.MaxFactor,
+				"last_window": components.Relaxer.Status(),
+				"policy":      rateLimiter.Policy(),
+			})
+		})
+
+		r.Get("/fleet", func(w http.ResponseWriter, r *http.Request) {
+			instances, err := registry.List(r.Context())
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to list fleet instances",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"instances": instances,
+				"count":     len(instances),
+			})
+		})
+
+		r.Get("/blocked", func(w http.ResponseWriter, r *http.Request) {
+			var cursor uint64
+			if value := r.URL.Query().Get("cursor"); value != "" {
+				parsed, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "Invalid cursor",
+					})
+					return
+				}
+				cursor = parsed
+			}
+			count := 100
+			if value, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && value > 0 && value <= 1000 {
+				count = value
+			}
+			kind := r.URL.Query().Get("type")
+
+			// Indexed kinds are paged through the key index, the cursor being an offset
+			var blocks []strategy.Block
+			var next uint64
+			var err error
+			if redisStrategy.Indexed(kind) {
+				var nextOffset int
+				blocks, nextOffset, err = redisStrategy.IndexedBlocks(r.Context(), kind, int(cursor), count)
+				next = uint64(nextOffset)
+			} else {
+				blocks, next, err = redisStrategy.ScanBlocked(r.Context(), kind, cursor, int64(count))
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to list blocked keys",
+				})
+				return
+			}
+
+			now := time.Now()
+			blocked := make([]map[string]interface{}, 0, len(blocks))
+			for _, block := range blocks {
+				blocked = append(blocked, map[string]interface{}{
+					"key":           block.Key,
+					"type":          block.Kind,
+					"ttl_seconds":   int(block.TTL.Round(time.Second).Seconds()),
+					"blocked_until": now.Add(block.TTL),
+				})
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"blocked":     blocked,
+				"next_cursor": next,
+			})
+		})
+
+		r.Get("/key-migration", func(w http.ResponseWriter, r *http.Request) {
+			report, ok := rateLimiter.KeyMigrationReport()
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "No key migration in progress",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+		})
+
+		r.Get("/limits", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rateLimiter.Policy())
+		})
+
+		// Limits changed at runtime are recorded as a policy version, so they
+		// reach every instance and can be rolled back
+		var limitsMu sync.Mutex
+		r.Put("/limits", func(w http.ResponseWriter, r *http.Request) {
+			var update policy.Update
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Expected a JSON body with ip_limit, ip_block_time or token_limits",
+				})
+				return
+			}
+
+			limitsMu.Lock()
+			defer limitsMu.Unlock()
+
+			current := rateLimiter.Policy()
+			updated, err := update.Apply(current)
+			if err == nil {
+				// Runtime overrides are held to the same caps as the configuration
+				err = cfg.RateLimit.Caps.CheckPolicy(updated)
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			version, err := policyHistory.Apply(r.Context(), updated, policy.SourceAPI)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to apply limits",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "Limits updated successfully",
+				"version": version,
+				"changes": policy.Diff(current, updated),
+			})
+		})
+
+		// Unlike the reset, lifting a block keeps the counter and usage history
+		unblock := func(w http.ResponseWriter, r *http.Request, key string) {
+			unblocked, err := rateLimiter.Unblock(r.Context(), key)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to unblock key",
+				})
+				return
+			}
+			if !unblocked {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Key is not blocked",
+				})
+				return
+			}
+
+			slog.Info("Key unblocked by operator", "key", key)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "Key unblocked successfully",
+				"key":     key,
+			})
+		}
+
+		// Any kind listed by GET /admin/blocked, the identifier canonicalized as in requests
+		r.Delete("/blocked/{type}/{key}", func(w http.ResponseWriter, r *http.Request) {
+			unblock(w, r, rateLimiter.Key(chi.URLParam(r, "type"), chi.URLParam(r, "key")))
+		})
+
+		// The exact key of the listing, for route, method and rule scoped keys
+		// that do not fit in a path segment
+		r.Delete("/blocked", func(w http.ResponseWriter, r *http.Request) {
+			key := r.URL.Query().Get("key")
+			if key == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "key is required",
+				})
+				return
+			}
+			unblock(w, r, key)
+		})
+
+		// The raw request log settles disputes about throttling with the exact
+		// time of each counted request
+		r.Get("/log/{type}/{key}", func(w http.ResponseWriter, r *http.Request) {
+			kind := chi.URLParam(r, "type")
+			if rateLimiter.AlgorithmName(kind) != config.AlgorithmSlidingLog {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Sliding log is not enabled for " + kind + " keys",
+				})
+				return
+			}
+
+			to := time.Now()
+			from := to.Add(-max(cfg.RateLimit.SlidingLogRetention, time.Hour))
+			for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+				value := r.URL.Query().Get(param)
+				if value == "" {
+					continue
+				}
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": param + " must be an RFC 3339 time",
+					})
+					return
+				}
+				*target = parsed
+			}
+			offset := 0
+			if value := r.URL.Query().Get("offset"); value != "" {
+				parsed, err := strconv.Atoi(value)
+				if err != nil || parsed < 0 {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "offset must be a non-negative integer",
+					})
+					return
+				}
+				offset = parsed
+			}
+			count := 100
+			if value, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && value > 0 && value <= 1000 {
+				count = value
+			}
+
+			key := rateLimiter.Key(kind, chi.URLParam(r, "key"))
+			entries, err := redisStrategy.ReadLog(r.Context(), key, from, to, offset, count)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to read request log",
+				})
+				return
+			}
+
+			response := map[string]interface{}{
+				"key":     key,
+				"from":    from.UTC(),
+				"to":      to.UTC(),
+				"entries": entries,
+			}
+			// A full page may be followed by more entries
+			if len(entries) == count {
+				response["next_offset"] = offset + count
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})
+
+		r.Get("/keys/{type}", func(w http.ResponseWriter, r *http.Request) {
+			kind := chi.URLParam(r, "type")
+			if !redisStrategy.Indexed(kind) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Keys of type " + kind + " are not indexed",
+				})
+				return
+			}
+
+			offset := 0
+			if value := r.URL.Query().Get("offset"); value != "" {
+				parsed, err := strconv.Atoi(value)
+				if err != nil || parsed < 0 {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "offset must be a non-negative integer",
+					})
+					return
+				}
+				offset = parsed
+			}
+			count := 100
+			if value, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && value > 0 && value <= 1000 {
+				count = value
+			}
+
+			active, err := redisStrategy.ActiveKeys(r.Context(), kind, offset, count)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to list active keys",
+				})
+				return
+			}
+
+			keys := make([]map[string]interface{}, 0, len(active))
+			for _, key := range active {
+				entry := map[string]interface{}{
+					"key":          key.Key,
+					"count":        key.Count,
+					"listed_until": key.ListedUntil,
+				}
+				if key.BlockTTL > 0 {
+					entry["blocked_until"] = time.Now().Add(key.BlockTTL)
+				}
+				keys = append(keys, entry)
+			}
+			response := map[string]interface{}{
+				"type": kind,
+				"keys": keys,
+			}
+			// A full page may be followed by more keys
+			if len(active) == count {
+				response["next_offset"] = offset + count
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})
+
+		r.Get("/history/{key}", func(w http.ResponseWriter, r *http.Request) {
+			if components.Timeline == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Key history is disabled",
+				})
+				return
+			}
+
+			limit := cfg.RateLimit.KeyHistory.Size
+			if raw := r.URL.Query().Get("limit"); raw != "" {
+				n, err := strconv.Atoi(raw)
+				if err != nil || n <= 0 {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "limit must be a positive integer",
+					})
+					return
+				}
+				// No key keeps more entries than the configured size
+				limit = min(n, cfg.RateLimit.KeyHistory.Size)
+			}
+
+			key := chi.URLParam(r, "key")
+			entries, err := components.Timeline.History(r.Context(), key, limit)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to load key history",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"key":     key,
+				"entries": entries,
+			})
+		})
+
+		// Portable dump of the counters, blocks, usage rollups and overrides,
+		// independent of Redis persistence
+		r.Get("/state.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+			dump, err := state.Collect(r.Context(), redisStrategy, rateLimiter.Policy())
+			if err != nil {
+				slog.Error("Failed to collect state dump", "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to export state",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ratelimiter-state-%s.tar.gz"`, time.Now().UTC().Format("20060102T150405Z")))
+			if err := dump.Write(w); err != nil {
+				slog.Error("Failed to write state dump", "error", err)
+			}
+		})
+
+		r.Post("/state.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+			dump, err := state.Read(http.MaxBytesReader(w, r.Body, maxStateDumpSize))
+			if err == nil && dump.Overrides.Policy != nil {
+				err = cfg.RateLimit.Caps.CheckPolicy(*dump.Overrides.Policy)
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			loaded, err := dump.Load(r.Context(), redisStrategy)
+			if err != nil {
+				slog.Error("Failed to load state dump", "loaded", loaded, "error", err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":  "Failed to load state",
+					"loaded": loaded,
+				})
+				return
+			}
+
+			response := map[string]interface{}{
+				"message":    "State loaded successfully",
+				"created_at": dump.Manifest.CreatedAt,
+				"loaded":     loaded,
+			}
+			if dump.Overrides.Policy != nil {
+				limitsMu.Lock()
+				version, err := policyHistory.Apply(r.Context(), *dump.Overrides.Policy, policy.SourceImport)
+				limitsMu.Unlock()
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"error":  "Failed to apply limits",
+						"loaded": loaded,
+					})
+					return
+				}
+				response["version"] = version
+			}
+			slog.Info("Loaded state dump", "records", loaded, "created_at", dump.Manifest.CreatedAt, "identity", ratelimitMiddleware.AdminIdentity(r))
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})
+
+		// Simulated Redis degradation for game days, on this instance only
+		if faults := redisStrategy.Faults(); faults != nil {
+			r.Get("/faults", func(w http.ResponseWriter, r *http.Request) {
+				fault, active := faults.Active()
+				response := map[string]interface{}{"active": active}
+				if active {
+					response["fault"] = fault
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(response)
+			})
+
+			r.Put("/faults", func(w http.ResponseWriter, r *http.Request) {
+				fault, err := parseFault(r.Body)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": err.Error(),
+					})
+					return
+				}
+
+				faults.Inject(fault)
+				slog.Warn("Injecting Redis faults", "latency", fault.Latency, "error_rate", fault.ErrorRate, "until", fault.Until.Format(time.RFC3339), "identity", ratelimitMiddleware.AdminIdentity(r))
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message": "Fault injected successfully",
+					"fault":   fault,
+				})
+			})
+
+			r.Delete("/faults", func(w http.ResponseWriter, r *http.Request) {
+				cleared := faults.Clear()
+				if cleared {
+					slog.Warn("Cleared Redis faults", "identity", ratelimitMiddleware.AdminIdentity(r))
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message": "Faults cleared",
+					"cleared": cleared,
+				})
+			})
+		}
+	})
+
+	// Allowlist and denylist management
+	router.Route("/admin/access-lists", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(components.AccessLists.Entries())
+		})
+
+		// The entry is a JSON body field on POST and a query parameter on DELETE,
+		// since CIDRs contain a slash
+		changeAccessList := func(w http.ResponseWriter, r *http.Request, entry string, change func(context.Context, string, string) error) {
+			list := chi.URLParam(r, "list")
+			if _, err := acl.ParseNetwork(entry); err != nil || (list != acl.Allow && list != acl.Deny) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Expected list allow or deny and an IP or CIDR entry",
+				})
+				return
+			}
+
+			if err := change(r.Context(), list, entry); err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to update access list",
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"list":    list,
+				"entry":   entry,
+				"entries": components.AccessLists.Entries()[list],
+			})
+		}
+
+		r.Post("/{list}", func(w http.ResponseWriter, r *http.Request) {
+			var request struct {
+				Entry string `json:"entry"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			changeAccessList(w, r, request.Entry, components.AccessLists.Add)
+		})
+
+		r.Delete("/{list}", func(w http.ResponseWriter, r *http.Request) {
+			changeAccessList(w, r, r.URL.Query().Get("entry"), components.AccessLists.Remove)
+		})
+	})
+
+	// Policy history and rollback
+	router.Route("/admin/config", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/history", func(w http.ResponseWriter, r *http.Request) {
+			versions, err := policyHistory.List(r.Context())
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to load policy history",
+				})
+				return
+			}
+
+			active, err := policyHistory.Active(r.Context())
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Failed to load active policy version",
+				})
+				return
+			}
+
+			entries := make([]map[string]interface{}, 0, len(versions))
+			for i, version := range versions {
+				// Versions are listed newest first, so the previous one follows
+				changes := []policy.Change{}
+				if i+1 < len(versions) {
+					changes = append(changes, policy.Diff(versions[i+1].Policy, version.Policy)...)
+				}
+				entries = append(entries, map[string]interface{}{
+					"version": version,
+					"active":  version.Number == active,
+					"changes": changes,
+				})
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"active":   active,
+				"versions": entries,
+			})
+		})
+
+		r.Post("/rollback/{version}", func(w http.ResponseWriter, r *http.Request) {
+			number, err := policy.ParseVersion(chi.URLParam(r, "version"))
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			current := rateLimiter.Policy()
+			version, err := policyHistory.Rollback(r.Context(), number)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, policy.ErrVersionNotFound):
+					status = http.StatusNotFound
+				case errors.Is(err, policy.ErrOutsideCaps):
+					status = http.StatusBadRequest
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": err.Error(),
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "Policy rolled back successfully",
+				"version": version,
+				"changes": policy.Diff(current, version.Policy),
+			})
+		})
 	})
 
 	// Start server
 	server := &http.Server{
-		Addr:    ":" + cfg.Server.Port,
-		Handler: router,
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+	}
+	if cfg.Server.TLSClientCAFile != "" {
+		tlsConfig, err := clientCertTLSConfig(cfg.Server.TLSClientCAFile)
+		if err != nil {
+			fatal("Failed to load client CA", err)
+		}
+		server.TLSConfig = tlsConfig
 	}
 
 	// Graceful shutdown
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+		var err error
+		if cfg.Server.TLSCertFile != "" {
+			err = server.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Failed to start server", err)
 		}
 	}()
+	shutdown.Register(lifecycle.Hook{Name: "http server", Phase: lifecycle.PhaseServers, Timeout: 30 * time.Second, Stop: server.Shutdown})
 
-	log.Printf("Server started on port %s", cfg.Server.Port)
-	log.Println("Available endpoints:")
-	log.Println("  GET  /health - Health check")
-	log.Println("  GET  /rate-limit/info - Rate limit information")
-	log.Println("  GET  /api/test - Test protected endpoint")
-	log.Println("  POST /api/data - Test POST endpoint")
-	log.Println("  GET  /api/status - API status")
-	log.Println("  POST /admin/reset/{key} - Reset rate limit for key")
+	slog.Info("Server started", "port", cfg.Server.Port, "tls", cfg.Server.TLSCertFile != "")
 
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// Optional gRPC server with the standard health and channelz services, and
+	// the Envoy rate limit service when enabled
+	var grpcServer *grpcserver.Server
+	if cfg.Server.GRPCPort != "" {
+		grpcServer = grpcserver.New(":"+cfg.Server.GRPCPort, cfg.Server.GRPCChannelz)
+		if cfg.RateLimit.RLS.Enabled {
+			rls.New(rateLimiter, cfg.RateLimit.RLS).Register(grpcServer.GRPC())
+		}
+		if err := grpcServer.Start(); err != nil {
+			fatal("Failed to start gRPC server", err)
+		}
+		slog.Info("gRPC server started", "port", cfg.Server.GRPCPort)
+		shutdown.OnShutdown("grpc server", lifecycle.PhaseServers, func(ctx context.Context) error {
+			grpcServer.Stop(ctx)
+			return nil
+		})
+	}
+	type endpointInfo struct{ route, description string }
+	endpoints := []endpointInfo{
+		{"GET /health", "Health check"},
+		{"GET /metrics", "Prometheus metrics"},
+		{"GET /rate-limit/info", "Rate limit information"},
+		{"GET /api/test", "Test protected endpoint"},
+		{"POST /api/data", "Test POST endpoint"},
+		{"GET /api/status", "API status"},
+		{"POST /admin/reset/{key}", "Reset rate limit for key"},
+		{"POST /admin/grant", "Grant extra units to a key"},
+		{"GET /admin/offenders", "Top offenders per policy"},
+		{"GET /admin/inflight", "Keys with the most in-flight requests"},
+		{"GET /admin/fleet", "List live instances"},
+		{"GET /admin/limits", "Limits currently enforced"},
+		{"PUT /admin/limits", "Change the IP and token limits at runtime"},
+		{"GET /admin/blocked?cursor=&count=&type=", "Currently blocked keys"},
+		{"DELETE /admin/blocked/{type}/{key}", "Lift a block, keeping the counter"},
+		{"DELETE /admin/blocked?key=", "Lift the block of a listed key, keeping the counter"},
+		{"GET /admin/key-migration", "Progress of the key pipeline migration"},
+		{"GET /admin/log/{type}/{key}", "Counted requests of a sliding log key"},
+		{"GET /admin/keys/{type}?offset=&count=", "Recently active keys, from the key index"},
+		{"GET /admin/history/{key}?limit=", "Recent windows, blocks and admin actions of a key"},
+		{"GET /admin/state.tar.gz", "Export counters, blocks, usage and overrides"},
+		{"POST /admin/state.tar.gz", "Load a state dump"},
+		{"GET /admin/error-budget", "Blocked legitimate traffic and limit relaxation"},
+		{"GET /admin/access-lists", "Allowlist and denylist entries"},
+		{"POST /admin/access-lists/{list}", "Add an IP or CIDR to a list"},
+		{"DELETE /admin/access-lists/{list}?entry=", "Remove a runtime entry"},
+		{"GET /admin/config/history", "Applied policy versions"},
+		{"POST /admin/config/rollback/{version}", "Roll back to a policy version"},
+	}
+	if redisStrategy.Faults() != nil {
+		endpoints = append(endpoints,
+			endpointInfo{"GET /admin/faults", "Active simulated Redis fault"},
+			endpointInfo{"PUT /admin/faults", "Inject a simulated Redis fault"},
+			endpointInfo{"DELETE /admin/faults", "Clear the simulated Redis fault"},
+		)
+	}
+	for _, endpoint := range endpoints {
+		slog.Info("Endpoint available", "route", endpoint.route, "description", endpoint.description)
+	}
+
+	lifecycle.WaitForSignal(context.Background())
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Graceful shutdown with timeout
 	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if err := shutdown.Shutdown(ctx); err != nil {
+		slog.Error("Server did not shut down cleanly", "error", err)
 	}
 
-	// Close Redis connection
-	if err := redisStrategy.Close(); err != nil {
-		log.Printf("Error closing Redis connection: %v", err)
+	slog.Info("Server exited")
+}
+
+// clientCertTLSConfig requests client certificates and verifies the ones sent
+// against the CA file. Certificates stay optional, so clients of the public
+// endpoints need none
+func clientCertTLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
 	}
+	return &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}, nil
+}
 
-	log.Println("Server exited")
+// rejectAdminWrites rejects the admin requests that would change the state of
+// a read-only instance
+func rejectAdminWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Instance is read-only",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-// getClientIP extracts the client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		if idx := len(xff); idx > 0 {
-			for i, c := range xff {
-				if c == ',' {
-					idx = i
-					break
-				}
-			}
-			return xff[:idx]
+// parseFault reads a fault from an admin request body, bounding its latency
+// and duration so a game day cannot degrade Redis indefinitely
+func parseFault(body io.Reader) (strategy.Fault, error) {
+	var request struct {
+		Latency   string  `json:"latency"`
+		ErrorRate float64 `json:"error_rate"`
+		// Outage fails every command, like an error rate of 1
+		Outage   bool   `json:"outage"`
+		Duration string `json:"duration"`
+	}
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		return strategy.Fault{}, fmt.Errorf("expected a JSON body with latency, error_rate, outage and duration")
+	}
+
+	fault := strategy.Fault{ErrorRate: request.ErrorRate}
+	if request.Outage {
+		fault.ErrorRate = 1
+	}
+	if request.Latency != "" {
+		latency, err := time.ParseDuration(request.Latency)
+		if err != nil || latency < 0 || latency > maxFaultLatency {
+			return fault, fmt.Errorf("latency must be a duration between 0s and %s", maxFaultLatency)
 		}
+		fault.Latency = latency
+	}
+	if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+		return fault, fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if fault.Latency == 0 && fault.ErrorRate == 0 {
+		return fault, fmt.Errorf("set latency, error_rate or outage")
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	duration := defaultFaultDuration
+	if request.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 || duration > maxFaultDuration {
+			return fault, fmt.Errorf("duration must be a positive duration up to %s", maxFaultDuration)
+		}
 	}
+	fault.Until = time.Now().Add(duration)
+	return fault, nil
+}
 
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if idx := len(ip); idx > 0 {
-		for i, c := range ip {
-			if c == ':' {
-				idx = i
-				break
-			}
+// fatal logs an error that prevents the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newWAFSyncer creates a syncer with every WAF exporter that is configured
+func newWAFSyncer(cfg config.WAFConfig, lister waf.BlockedLister) *waf.Syncer {
+	var exporters []waf.Exporter
+
+	if cfg.AWSIPSetID != "" {
+		exporter, err := waf.NewAWSExporter(cfg.AWSRegion, cfg.AWSIPSetName, cfg.AWSIPSetID, cfg.AWSScope)
+		if err != nil {
+			slog.Error("Failed to configure AWS WAF exporter", "error", err)
+		} else {
+			exporters = append(exporters, exporter)
+		}
+	}
+	if cfg.CloudflareListID != "" {
+		exporters = append(exporters, waf.NewCloudflareExporter(cfg.CloudflareAPIToken, cfg.CloudflareAccountID, cfg.CloudflareListID))
+	}
+	if cfg.FastlyACLID != "" {
+		exporters = append(exporters, waf.NewFastlyExporter(cfg.FastlyAPIToken, cfg.FastlyServiceID, cfg.FastlyACLID))
+	}
+
+	return waf.NewSyncer(lister, exporters...)
+}
+
+// getClientIP extracts the client IP from the request
+func getClientIP(r *http.Request) string {
+	// X-Forwarded-For can contain multiple IPs, take the first one
+	first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+	for _, candidate := range []string{first, r.Header.Get("X-Real-IP")} {
+		// Malformed values are skipped, so arbitrary header content never
+		// becomes a rate limit key
+		if ip := net.ParseIP(strings.TrimSpace(candidate)); ip != nil {
+			return ip.String()
 		}
-		return ip[:idx]
 	}
 
-	return ip
+	// Fall back to RemoteAddr, which may be an IPv6 address in brackets
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
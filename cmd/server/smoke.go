@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+	ratelimitMiddleware "github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/ratelimit"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes/chiroutes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// runSmoke exceeds the limit of a client, checks it is rejected with 429 and
+// then recovers once the block ends. Without --url it starts the server with
+// the memory backend on a local port, so the binary and configuration can be
+// checked without Redis; with --url it checks a running deployment
+func runSmoke(args []string) error {
+	fs := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	target := fs.String("url", "", "protected endpoint of a running server, e.g. https://api.example.com/api/test")
+	limit := fs.Int("limit", 5, "IP limit per window of the server under test")
+	window := fs.Duration("window", time.Second, "window of the limit")
+	blockTime := fs.Duration("block-time", 2*time.Second, "block time of the IP limit")
+	timeout := fs.Duration("timeout", 30*time.Second, "maximum duration of the check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *limit <= 0 || *window <= 0 || *blockTime < 0 {
+		return fmt.Errorf("--limit and --window must be positive and --block-time not negative")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	url := *target
+	if url == "" {
+		cfg, err := loader.Load()
+		if err != nil {
+			return err
+		}
+		cfg.RateLimit.IPLimit = *limit
+		cfg.RateLimit.IPBlockTime = *blockTime
+		cfg.RateLimit.Window = *window
+		cfg.RateLimit.ReadOnly = false
+
+		stop, addr, err := startSmokeServer(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		url = "http://" + addr + "/api/test"
+	}
+
+	// A client of its own per run, so earlier runs against a deployment do not interfere
+	client := &smokeClient{
+		url:  url,
+		ip:   fmt.Sprintf("203.0.113.%d", 1+rand.Intn(254)),
+		http: &http.Client{Timeout: 5 * time.Second},
+	}
+	fmt.Printf("Target:  %s (client %s)\n", url, client.ip)
+
+	for i := 1; i <= *limit; i++ {
+		if err := client.expect(ctx, http.StatusOK); err != nil {
+			return fmt.Errorf("request %d of %d within the limit: %w", i, *limit, err)
+		}
+	}
+	fmt.Printf("PASS     %d requests within the limit allowed\n", *limit)
+
+	retryAfter, err := client.expectLimited(ctx)
+	if err != nil {
+		return fmt.Errorf("request over the limit: %w", err)
+	}
+	fmt.Printf("PASS     request over the limit rejected, Retry-After %ds\n", retryAfter)
+
+	if _, err := client.expectLimited(ctx); err != nil {
+		return fmt.Errorf("request while blocked: %w", err)
+	}
+	fmt.Println("PASS     request while blocked rejected")
+
+	// Retry-After covers the block or the rest of the window
+	select {
+	case <-time.After(time.Duration(retryAfter) * time.Second):
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting %ds for the limit to reset", retryAfter)
+	}
+	for {
+		err := client.expect(ctx, http.StatusOK)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("client did not recover after the reset: %w", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	fmt.Println("PASS     client recovered after the reset")
+	return nil
+}
+
+// startSmokeServer serves the rate limited test endpoint with the memory
+// backend on a free local port, wired as in the server
+func startSmokeServer(ctx context.Context, cfg *config.Config) (func(), string, error) {
+	components, err := ratelimit.Setup(ctx, cfg,
+		ratelimit.WithStorage(strategy.NewMemoryStrategy()),
+		ratelimit.WithMiddlewareOptions(ratelimitMiddleware.WithPatternResolver(chiroutes.Pattern)),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to set up rate limiter: %w", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(components.TrustBoundary)
+	router.Use(middleware.RealIP)
+	router.Route("/api", func(r chi.Router) {
+		r.Use(components.Middleware)
+		r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"message": "Smoke test endpoint"})
+		})
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		components.Close()
+		return nil, "", err
+	}
+	server := &http.Server{Handler: router, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout}
+	go server.Serve(listener)
+
+	stop := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		components.Close()
+	}
+	return stop, listener.Addr().String(), nil
+}
+
+// smokeClient sends requests as one client, identified by X-Forwarded-For
+type smokeClient struct {
+	url  string
+	ip   string
+	http *http.Client
+}
+
+func (c *smokeClient) get(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Forwarded-For", c.ip)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// expect fails unless the response has the given status
+func (c *smokeClient) expect(ctx context.Context, status int) error {
+	resp, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("got status %d, expected %d", resp.StatusCode, status)
+	}
+	return nil
+}
+
+// expectLimited fails unless the response is a 429 with a Retry-After, which it returns
+func (c *smokeClient) expectLimited(ctx context.Context) (int, error) {
+	resp, err := c.get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, fmt.Errorf("got status %d, expected %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter <= 0 {
+		return 0, fmt.Errorf("missing or invalid Retry-After %q", resp.Header.Get("Retry-After"))
+	}
+	return retryAfter, nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Caps bound the limits, block times and windows accepted from the
+// configuration and from runtime overrides, so a typo such as a block time of
+// 1000h cannot lock clients out for weeks. Zero disables a cap
+type Caps struct {
+	MaxBlockTime time.Duration `mapstructure:"max_block_time"`
+	MaxLimit     int           `mapstructure:"max_limit"`
+	MinWindow    time.Duration `mapstructure:"min_window"`
+}
+
+// blockTime reports a block time above the cap
+func (c Caps) blockTime(blockTime time.Duration) error {
+	if c.MaxBlockTime > 0 && blockTime > c.MaxBlockTime {
+		return fmt.Errorf("block time %s exceeds the maximum of %s (RATE_LIMIT_MAX_BLOCK_TIME)", blockTime, c.MaxBlockTime)
+	}
+	return nil
+}
+
+// limit reports a limit above the cap
+func (c Caps) limit(limit int) error {
+	if c.MaxLimit > 0 && limit > c.MaxLimit {
+		return fmt.Errorf("limit %d exceeds the maximum of %d (RATE_LIMIT_MAX_LIMIT)", limit, c.MaxLimit)
+	}
+	return nil
+}
+
+// window reports a window below the cap, 0 keeping another window is accepted
+func (c Caps) window(window time.Duration) error {
+	if c.MinWindow > 0 && window > 0 && window < c.MinWindow {
+		return fmt.Errorf("window %s is below the minimum of %s (RATE_LIMIT_MIN_WINDOW)", window, c.MinWindow)
+	}
+	return nil
+}
+
+// windows reports the first further window outside the caps
+func (c Caps) windows(windows []WindowLimit) error {
+	for _, window := range windows {
+		if err := errors.Join(c.limit(window.Limit), c.window(window.Window)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPolicy returns every value of the policy outside the caps, named by
+// their field in the admin API, e.g. token_limits.ABC.block_time
+func (c Caps) CheckPolicy(policy Policy) error {
+	var errs []error
+	add := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+
+	add("ip_limit", c.limit(policy.IPLimit))
+	add("ip_block_time", c.blockTime(policy.IPBlockTime))
+	add("ip_burst", c.limit(policy.IPBurst))
+	add("ip_windows", c.windows(policy.IPWindows))
+	add("window", c.window(policy.Window))
+	for token, limit := range policy.TokenLimits {
+		field := "token_limits." + token
+		add(field+".limit", c.limit(limit.Limit))
+		add(field+".block_time", c.blockTime(limit.BlockTime))
+		add(field+".window", c.window(limit.Window))
+		add(field+".burst", c.limit(limit.Burst))
+		add(field+".windows", c.windows(limit.Windows))
+	}
+	return errors.Join(errs...)
+}
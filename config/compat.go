@@ -0,0 +1,27 @@
+package config
+
+import "errors"
+
+// load is the loader LoadConfig forwards to, set by RegisterLoader
+var load func() (*Config, error)
+
+// RegisterLoader sets the loader LoadConfig forwards to. The config/loader
+// package registers loader.Load when it is imported
+func RegisterLoader(fn func() (*Config, error)) {
+	load = fn
+}
+
+// LoadConfig loads configuration from environment variables, the .env file and
+// the structured config file.
+//
+// Deprecated: use loader.Load from the config/loader package. The config
+// package no longer depends on Viper, so LoadConfig forwards to loader.Load
+// only when config/loader is linked in, e.g. with a blank import:
+//
+//	import _ "github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"
+func LoadConfig() (*Config, error) {
+	if load == nil {
+		return nil, errors.New(`config.LoadConfig is deprecated: use loader.Load, or import _ "github.com/marcelobritu/go-expert-desafio-rate-limiter/config/loader"`)
+	}
+	return load()
+}
@@ -1,12 +1,16 @@
 package config
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/spf13/viper"
 )
 
 // Config holds all configuration for the rate limiter
@@ -14,171 +18,771 @@ type Config struct {
 	Server    ServerConfig    `mapstructure:"server"`
 	Redis     RedisConfig     `mapstructure:"redis"`
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	WAF       WAFConfig       `mapstructure:"waf"`
+	SIEM      SIEMConfig      `mapstructure:"siem"`
+	Log       LogConfig       `mapstructure:"log"`
+	Admin     AdminConfig     `mapstructure:"admin"`
 }
 
 // ServerConfig holds server configuration
 type ServerConfig struct {
-	Port string `mapstructure:"port"`
+	Port              string        `mapstructure:"port"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	LeaderLeaseTTL    time.Duration `mapstructure:"leader_lease_ttl"`
+	// GRPCPort enables the gRPC server (health, channelz) when set
+	GRPCPort     string `mapstructure:"grpc_port"`
+	GRPCChannelz bool   `mapstructure:"grpc_channelz"`
+	// TLSCertFile and TLSKeyFile serve HTTPS when set. Client certificates
+	// are requested and verified against TLSClientCAFile, when set, for mTLS
+	TLSCertFile     string `mapstructure:"tls_cert_file"`
+	TLSKeyFile      string `mapstructure:"tls_key_file"`
+	TLSClientCAFile string `mapstructure:"tls_client_ca_file"`
+}
+
+// AdminConfig holds the credentials of the admin endpoints. Readers may only
+// send GET requests, writers any; the endpoints reject every request when none
+// is set, unless AuthDisabled opens them
+type AdminConfig struct {
+	// ReadTokens and WriteTokens are bearer tokens
+	ReadTokens  []string `mapstructure:"read_tokens"`
+	WriteTokens []string `mapstructure:"write_tokens"`
+	// ReadSubjects and WriteSubjects are common names of client certificates
+	ReadSubjects  []string `mapstructure:"read_subjects"`
+	WriteSubjects []string `mapstructure:"write_subjects"`
+	// AuthDisabled serves the admin endpoints without credentials when none
+	// is set, for local development
+	AuthDisabled bool `mapstructure:"auth_disabled"`
+}
+
+// Enabled reports whether any admin credential is configured
+func (a AdminConfig) Enabled() bool {
+	return len(a.ReadTokens)+len(a.WriteTokens)+len(a.ReadSubjects)+len(a.WriteSubjects) > 0
 }
 
 // RedisConfig holds Redis configuration
 type RedisConfig struct {
-	Host     string `mapstructure:"host"`
-	Port     string `mapstructure:"port"`
-	Password string `mapstructure:"password"`
-	DB       int    `mapstructure:"db"`
+	Host     string      `mapstructure:"host"`
+	Port     string      `mapstructure:"port"`
+	Password string      `mapstructure:"password"`
+	DB       int         `mapstructure:"db"`
+	Retry    RetryConfig `mapstructure:"retry"`
+	TLS      RedisTLS    `mapstructure:"tls"`
+	Pool     RedisPool   `mapstructure:"pool"`
+	// LocalCacheTTL caches block status and counters at their limit in process
+	// memory, cutting round trips for hot keys; 0 disables the cache
+	LocalCacheTTL time.Duration `mapstructure:"local_cache_ttl"`
+	// BatchInterval accumulates fixed window increments locally and flushes
+	// them every interval, trading accuracy for Redis load; 0 writes each one
+	BatchInterval time.Duration `mapstructure:"batch_interval"`
+	// FaultInjection lets admins simulate Redis latency and outages at runtime
+	// for game days
+	FaultInjection bool `mapstructure:"fault_injection"`
+}
+
+// RedisPool tunes the connection pool of the Redis client, zero values keep
+// the go-redis defaults
+type RedisPool struct {
+	// Size is the maximum number of connections, 10 per CPU by default
+	Size int `mapstructure:"size"`
+	// MinIdleConns keeps connections open ahead of bursts
+	MinIdleConns int `mapstructure:"min_idle_conns"`
+	// ReadTimeout and WriteTimeout bound each command, 3s by default
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// MaxRetries is the number of retries of the client itself, 3 by default
+	// and -1 for none. They happen below the retry policy of RetryConfig
+	MaxRetries int `mapstructure:"max_retries"`
+}
+
+// RedisTLS holds the TLS settings of the Redis connection, required by
+// managed offerings that enforce encryption in transit
+type RedisTLS struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CAFile verifies the server certificate, the system pool when empty
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the client certificate for mutual TLS
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ServerName overrides the name verified in the server certificate
+	ServerName string `mapstructure:"server_name"`
+	// InsecureSkipVerify disables the server certificate verification
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+// Config builds the TLS configuration of the connection, nil when TLS is disabled
+func (t RedisTLS) Config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// RetryConfig bounds the retries of transient Redis errors
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts per operation, 1 disables retries
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	BaseDelay   time.Duration `mapstructure:"base_delay"`
+	MaxDelay    time.Duration `mapstructure:"max_delay"`
+	// Budget is the fraction of operations that may be retried
+	Budget float64 `mapstructure:"budget"`
+}
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig holds configuration for the structured logs
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `mapstructure:"level"`
+	// Format is text or json
+	Format string `mapstructure:"format"`
+}
+
+// SIEMConfig holds configuration for streaming events to a SIEM as CEF over syslog
+type SIEMConfig struct {
+	// Address is udp://host:port or tcp://host:port, the export is disabled when empty
+	Address string `mapstructure:"address"`
+	// Events are the event types exported, e.g. blocked and honeypot
+	Events []string `mapstructure:"events"`
+}
+
+// WAFConfig holds configuration for exporting blocked IPs to edge WAFs
+type WAFConfig struct {
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+
+	AWSRegion    string `mapstructure:"aws_region"`
+	AWSIPSetName string `mapstructure:"aws_ipset_name"`
+	AWSIPSetID   string `mapstructure:"aws_ipset_id"`
+	AWSScope     string `mapstructure:"aws_scope"`
+
+	CloudflareAPIToken  string `mapstructure:"cloudflare_api_token"`
+	CloudflareAccountID string `mapstructure:"cloudflare_account_id"`
+	CloudflareListID    string `mapstructure:"cloudflare_list_id"`
+
+	FastlyAPIToken  string `mapstructure:"fastly_api_token"`
+	FastlyServiceID string `mapstructure:"fastly_service_id"`
+	FastlyACLID     string `mapstructure:"fastly_acl_id"`
 }
 
 // RateLimitConfig holds rate limiting configuration
 type RateLimitConfig struct {
-	IPLimit     int                   `mapstructure:"ip_limit"`
-	IPBlockTime time.Duration         `mapstructure:"ip_block_time"`
+	IPLimit     int           `mapstructure:"ip_limit"`
+	IPBlockTime time.Duration `mapstructure:"ip_block_time"`
+	// IPBurst is the number of requests an IP may send at once above its
+	// steady rate with the token_bucket algorithm, 0 uses the limit
+	IPBurst int `mapstructure:"ip_burst"`
+	// IPWindows constrain an IP by further windows at the same time, e.g. 300
+	// per minute and 5000 per hour on top of 10 per second
+	IPWindows   []WindowLimit         `mapstructure:"ip_windows"`
 	TokenLimits map[string]TokenLimit `mapstructure:"token_limits"`
+	// GlobalLimit caps the requests of all clients together per GlobalWindow,
+	// checked before the per-client limits; 0 disables it
+	GlobalLimit  int           `mapstructure:"global_limit"`
+	GlobalWindow time.Duration `mapstructure:"global_window"`
+	// Window is the period limits are counted over, e.g. 1s for requests per
+	// second or 1h for requests per hour. Tokens may override it
+	Window time.Duration `mapstructure:"window"`
+	// Algorithm is the counting algorithm used by key types without their own
+	Algorithm string `mapstructure:"algorithm"`
+	// IPAlgorithm and TokenAlgorithm select the counting algorithm per key type
+	IPAlgorithm    string `mapstructure:"ip_algorithm"`
+	TokenAlgorithm string `mapstructure:"token_algorithm"`
+	// IPShadowAlgorithm and TokenShadowAlgorithm are evaluated on the same
+	// traffic without being enforced, to compare them with the enforcing one
+	IPShadowAlgorithm    string `mapstructure:"ip_shadow_algorithm"`
+	TokenShadowAlgorithm string `mapstructure:"token_shadow_algorithm"`
+	// SlidingLogRetention keeps sliding_log entries past the window, so the
+	// requests of a key can be retrieved as evidence; 0 keeps only the window
+	SlidingLogRetention time.Duration `mapstructure:"sliding_log_retention"`
+	// IPBucket and TokenBucket size the buckets of the token_bucket and leaky_bucket algorithms
+	IPBucket    BucketConfig `mapstructure:"ip_bucket"`
+	TokenBucket BucketConfig `mapstructure:"token_bucket"`
+	// TokenSources is the ordered list of credential sources, first match wins
+	TokenSources []string `mapstructure:"token_sources"`
+	// CredentialConflict is how requests carrying several distinct credentials
+	// are handled: first, reject or all
+	CredentialConflict string `mapstructure:"credential_conflict"`
+	// IPKeyTransforms and TokenKeyTransforms canonicalize identifiers before storage
+	IPKeyTransforms    []string `mapstructure:"ip_key_transforms"`
+	TokenKeyTransforms []string `mapstructure:"token_key_transforms"`
+	// IPv4Prefix and IPv6Prefix group client IPs into networks sharing one
+	// budget, e.g. 24 and 64; 32 and 128 keep one budget per address
+	IPv4Prefix int `mapstructure:"ipv4_prefix"`
+	IPv6Prefix int `mapstructure:"ipv6_prefix"`
+	// Preset names the bundle of defaults applied, empty for none
+	Preset string `mapstructure:"preset"`
+	// FailureMode is how requests are decided when the storage fails: open, closed or local
+	FailureMode string `mapstructure:"failure_mode"`
+	// ReadOnly serves reads without ever counting or blocking, e.g. against a replica
+	ReadOnly    bool              `mapstructure:"read_only"`
+	Fallback    FallbackConfig    `mapstructure:"fallback"`
+	Honeypot    HoneypotConfig    `mapstructure:"honeypot"`
+	SlowClient  SlowClientConfig  `mapstructure:"slow_client"`
+	Signing     SigningConfig     `mapstructure:"signing"`
+	Debug       DebugConfig       `mapstructure:"debug"`
+	DecisionLog DecisionLogConfig `mapstructure:"decision_log"`
+	// Tiers holds named plan limits, keyed by upper-case tier name
+	Tiers map[string]TierLimit `mapstructure:"tiers"`
+	// OffendersWindow is the rolling window of the top-N offender lists
+	OffendersWindow time.Duration `mapstructure:"offenders_window"`
+	// InFlight configures the tracking of concurrent requests per key
+	InFlight InFlightConfig `mapstructure:"in_flight"`
+	// KeyIndex lists the recently active keys without scanning Redis
+	KeyIndex KeyIndexConfig `mapstructure:"key_index"`
+	// Caps bound the limits, block times and windows of the configuration
+	// and of the runtime overrides
+	Caps Caps `mapstructure:"caps"`
+	// KeyMigration carries client state over from the keys of the previous
+	// canonicalization while the transforms change
+	KeyMigration KeyMigrationConfig `mapstructure:"key_migration"`
+	// KeyHistory configures the per-key timeline served by GET /admin/history/{key}
+	KeyHistory KeyHistoryConfig `mapstructure:"key_history"`
+	// SamplingSeed makes trace sampling and retry jitter reproducible, 0 seeds randomly
+	SamplingSeed int64 `mapstructure:"sampling_seed"`
+	// MaxBodySize caps request bodies in bytes for clients without a tier size, 0 disables
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+	// MethodPolicies sets how requests are counted per HTTP method, e.g. HEAD=ignore
+	MethodPolicies map[string]string `mapstructure:"method_policies"`
+	// RouteTemplates map request paths to routes for policies, metrics and
+	// reports, e.g. /users/{id}; other paths have their ID-like segments replaced
+	RouteTemplates []string `mapstructure:"route_templates"`
+	// Routes holds per-route limits that replace the IP/token limits on matching requests
+	Routes  []RouteLimit  `mapstructure:"routes"`
+	GraphQL GraphQLConfig `mapstructure:"graphql"`
+	Batch   BatchConfig   `mapstructure:"batch"`
+	// RulesFile is the path of a YAML file with CEL policy rules
+	RulesFile string `mapstructure:"rules_file"`
+	// OpenAPISpec is the path of an OpenAPI document whose operations declare x-rate-limit
+	OpenAPISpec   string              `mapstructure:"openapi_spec"`
+	PolicyHistory PolicyHistoryConfig `mapstructure:"policy_history"`
+	Checkpoint    CheckpointConfig    `mapstructure:"checkpoint"`
+	Force         ForceConfig         `mapstructure:"force"`
+	Trust         TrustConfig         `mapstructure:"trust"`
+	Bypass        BypassConfig        `mapstructure:"bypass"`
+	Gateway       GatewayConfig       `mapstructure:"gateway"`
+	RLS           RLSConfig           `mapstructure:"rls"`
+	AccessLists   AccessListConfig    `mapstructure:"access_lists"`
+	ErrorBudget   ErrorBudgetConfig   `mapstructure:"error_budget"`
+	// EncryptionKeys are "id:base64key" AES keys encrypting stored metadata, the first encrypts
+	EncryptionKeys []string `mapstructure:"encryption_keys"`
+	// HeaderVisibility is full, minimal, none or authenticated
+	HeaderVisibility string `mapstructure:"header_visibility"`
+	// HeaderFormat is legacy (X-RateLimit-*), ietf (RateLimit-*) or both
+	HeaderFormat string       `mapstructure:"header_format"`
+	Canary       CanaryConfig `mapstructure:"canary"`
+	// BlockServerClock computes block expiry from the Redis clock instead of the local one
+	BlockServerClock bool `mapstructure:"block_server_clock"`
+	// RequestDeadline honors X-Request-Deadline from trusted proxies, bounding
+	// the storage operations and the request context
+	RequestDeadline bool `mapstructure:"request_deadline"`
 }
 
-// TokenLimit holds configuration for a specific token
-type TokenLimit struct {
+// CanaryConfig holds configuration for the block propagation canary probes
+type CanaryConfig struct {
+	// Interval between canary blocks, probes are disabled when zero
+	Interval time.Duration `mapstructure:"interval"`
+	// SLO is the maximum acceptable time for a block to be enforced by every instance
+	SLO time.Duration `mapstructure:"slo"`
+}
+
+// ForceConfig holds who may force a synthetic 429 with X-RateLimit-Force
+type ForceConfig struct {
+	TrustedCIDRs []string `mapstructure:"trusted_cidrs"`
+	AdminToken   string   `mapstructure:"admin_token"`
+}
+
+// TrustConfig holds which peers may set internal headers, the rest having them stripped
+type TrustConfig struct {
+	// Proxies are the IPs and CIDRs of the proxies trusted to set forwarded headers
+	Proxies []string `mapstructure:"proxies"`
+	// Headers and Cookies are internal markers stripped from untrusted peers,
+	// besides the forwarded headers
+	Headers []string `mapstructure:"headers"`
+	Cookies []string `mapstructure:"cookies"`
+}
+
+// BypassConfig holds the secret of the signed tokens that exempt requests from
+// rate limiting, minted with `ratelimitctl bypass`
+type BypassConfig struct {
+	// Secret signs and verifies the tokens, empty disables them
+	Secret string `mapstructure:"secret"`
+	// MaxTTL is the longest lifetime a token may have
+	MaxTTL time.Duration `mapstructure:"max_ttl"`
+}
+
+// GatewayConfig holds how the signed identity hints of an upstream gateway
+// are verified. The hints name the customer and tier resolved by the gateway
+type GatewayConfig struct {
+	// Header carries the hint as a compact JWS
+	Header string `mapstructure:"header"`
+	// Algorithm is HS256, RS256 or ES256
+	Algorithm string `mapstructure:"algorithm"`
+	// Secret verifies HS256 hints
+	Secret string `mapstructure:"secret"`
+	// PublicKeyFile is the PEM public key verifying RS256 and ES256 hints
+	PublicKeyFile string `mapstructure:"public_key_file"`
+	// Issuer and Audience must match the iss and aud claims when set
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+}
+
+// Enabled reports whether gateway hints are verified
+func (g GatewayConfig) Enabled() bool {
+	return g.Secret != "" || g.PublicKeyFile != ""
+}
+
+// RLSConfig holds the Envoy rate limit service served on the gRPC server
+type RLSConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Domain is the only domain answered, empty for any
+	Domain string `mapstructure:"domain"`
+	// IPKeys and TokenKeys are the descriptor entry keys carrying the client
+	// IP and token, e.g. remote_address and the descriptor_key of a
+	// request_headers action
+	IPKeys    []string `mapstructure:"ip_keys"`
+	TokenKeys []string `mapstructure:"token_keys"`
+}
+
+// AccessListConfig holds the IPs and CIDRs that are never limited or always rejected
+type AccessListConfig struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+	// DenyStatus is the response status for denied IPs, 403 or 429
+	DenyStatus int `mapstructure:"deny_status"`
+	// SyncInterval is how often entries added at runtime are picked up
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+}
+
+// ErrorBudgetConfig holds the automatic relaxation of limits that block too
+// much legitimate traffic
+type ErrorBudgetConfig struct {
+	// Budget is the tolerated fraction of legitimate requests blocked, 0 disables relaxation
+	Budget float64 `mapstructure:"budget"`
+	// Window is how often the blocked fraction is evaluated
+	Window time.Duration `mapstructure:"window"`
+	// Step multiplies the limits on each relaxation and divides them on each restore
+	Step float64 `mapstructure:"step"`
+	// MaxFactor bounds the relaxation relative to the baseline limits
+	MaxFactor float64 `mapstructure:"max_factor"`
+	// MinRequests is the legitimate traffic a window needs to be evaluated
+	MinRequests int `mapstructure:"min_requests"`
+}
+
+// CheckpointConfig holds configuration for copying long-lived counters to durable storage
+type CheckpointConfig struct {
+	// Store is file:///path or s3://bucket/key, checkpointing is disabled when empty
+	Store    string        `mapstructure:"store"`
+	Interval time.Duration `mapstructure:"interval"`
+	// Prefixes selects the counters to checkpoint by key prefix
+	Prefixes []string `mapstructure:"prefixes"`
+}
+
+// GraphQLConfig holds configuration for charging GraphQL queries by complexity
+type GraphQLConfig struct {
+	// Path is the GraphQL endpoint, cost limiting is disabled when empty
+	Path string `mapstructure:"path"`
+	// FieldWeights maps field names to their cost, other fields cost DefaultWeight
+	FieldWeights  map[string]int `mapstructure:"field_weights"`
+	DefaultWeight int            `mapstructure:"default_weight"`
+	// MaxDepth caps selection nesting, deeper queries are charged MaxCost
+	MaxDepth int `mapstructure:"max_depth"`
+	MaxCost  int `mapstructure:"max_cost"`
+}
+
+// BatchConfig holds the routes whose list payloads are charged one unit per item
+type BatchConfig struct {
+	// Routes are "METHOD /pattern" entries, e.g. "POST /api/data"
+	Routes  []string `mapstructure:"routes"`
+	MaxCost int      `mapstructure:"max_cost"`
+}
+
+// IPAggregated reports whether client IPs are grouped into networks
+func (rl RateLimitConfig) IPAggregated() bool {
+	return (rl.IPv4Prefix > 0 && rl.IPv4Prefix < 32) || (rl.IPv6Prefix > 0 && rl.IPv6Prefix < 128)
+}
+
+// RouteLimit holds the limit for requests matching a method and URL pattern.
+// Patterns use chi syntax, e.g. "/api/users/{id}" or "/api/*"; an empty method matches any.
+// The method may also be a "GET|HEAD" list or a READ/WRITE method class
+type RouteLimit struct {
+	Method    string        `mapstructure:"method"`
+	Pattern   string        `mapstructure:"pattern"`
 	Limit     int           `mapstructure:"limit"`
 	BlockTime time.Duration `mapstructure:"block_time"`
+	// PerEndpoint gives every router pattern the pattern covers, e.g. each route
+	// under "/api/*", a budget of its own instead of a shared one
+	PerEndpoint bool `mapstructure:"per_endpoint"`
 }
 
-// LoadConfig loads configuration from environment variables and .env file
-func LoadConfig() (*Config, error) {
-	viper.SetConfigName(".env")
-	viper.SetConfigType("env")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-
-	// Set default values
-	setDefaults()
+// MethodClasses are the method groups usable in route limits, so reads and
+// writes on the same path can have separate budgets
+var MethodClasses = map[string][]string{
+	"READ":  {"GET", "HEAD", "OPTIONS"},
+	"WRITE": {"POST", "PUT", "PATCH", "DELETE"},
+}
 
-	// Enable reading from environment variables
-	viper.AutomaticEnv()
+// Methods expands the route method into the HTTP methods it matches, nil for any
+func (r RouteLimit) Methods() []string {
+	if r.Method == "" {
+		return nil
+	}
 
-	// Try to read .env file (optional)
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			log.Printf("Error reading config file: %v", err)
+	var methods []string
+	for _, method := range strings.Split(strings.ToUpper(r.Method), "|") {
+		method = strings.TrimSpace(method)
+		if class, ok := MethodClasses[method]; ok {
+			methods = append(methods, class...)
+		} else {
+			methods = append(methods, method)
 		}
 	}
+	return methods
+}
+
+// InFlightConfig holds configuration for in-flight request tracking
+type InFlightConfig struct {
+	// Interval is how often each instance publishes its busiest keys, 0 disables tracking
+	Interval time.Duration `mapstructure:"interval"`
+	// Top is the number of keys published and exported as metrics
+	Top int `mapstructure:"top"`
+}
+
+// KeyIndexConfig holds configuration for the index sets of recently active keys
+type KeyIndexConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Kinds are the key kinds indexed, e.g. ip and token
+	Kinds []string `mapstructure:"kinds"`
+	// TTL is how long a key stays listed after its last request or block
+	TTL time.Duration `mapstructure:"ttl"`
+	// FlushInterval is how often the buffered updates are written
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// KeyHistoryConfig holds configuration for the per-key timeline of window
+// counts, blocks and admin actions
+type KeyHistoryConfig struct {
+	// Size is the number of entries kept per key, 0 disables the timeline
+	Size int `mapstructure:"size"`
+	// TTL is how long a timeline is kept after its last entry
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+// KeyMigrationConfig holds configuration for the migration of client keys
+// when the key transforms change
+type KeyMigrationConfig struct {
+	// Enabled reads the keys of the previous transforms and carries their
+	// counters and blocks over to the current keys
+	Enabled bool `mapstructure:"enabled"`
+	// PreviousIPTransforms and PreviousTokenTransforms are the key transforms
+	// in use before the change, empty for none
+	PreviousIPTransforms    []string `mapstructure:"previous_ip_transforms"`
+	PreviousTokenTransforms []string `mapstructure:"previous_token_transforms"`
+}
+
+// DebugConfig holds configuration for decision tracing
+type DebugConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// SampleRate is the fraction of requests traced, keep it low in production
+	SampleRate float64 `mapstructure:"sample_rate"`
+}
+
+// DecisionLogConfig holds configuration for logging rate limit decisions
+type DecisionLogConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// SampleRate is the fraction of allowed decisions logged
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// DeniedSampleRate is the fraction of denied decisions logged
+	DeniedSampleRate float64 `mapstructure:"denied_sample_rate"`
+}
+
+// SigningConfig holds configuration for HMAC-signed requests
+type SigningConfig struct {
+	Secret string `mapstructure:"secret"`
+	// Required rejects unsigned requests instead of only verifying signed ones
+	Required bool `mapstructure:"required"`
+	// ReplayWindow is the accepted clock difference and how long nonces are remembered
+	ReplayWindow time.Duration `mapstructure:"replay_window"`
+	// MaxBodySize caps in bytes the body read to verify a signature
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+}
+
+// SlowClientConfig holds the per-key request read/write deadlines used to
+// protect against clients holding connections open (slowloris)
+type SlowClientConfig struct {
+	// ReadTimeout is the body read deadline for clients with no strikes
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	// MinReadTimeout is the lower bound the deadline shrinks to as strikes accumulate
+	MinReadTimeout time.Duration `mapstructure:"min_read_timeout"`
+	// WriteTimeout is the response write deadline, zero disables it
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	// MaxStrikes is the number of slow requests within StrikeWindow that triggers a block
+	MaxStrikes   int           `mapstructure:"max_strikes"`
+	StrikeWindow time.Duration `mapstructure:"strike_window"`
+	BlockTime    time.Duration `mapstructure:"block_time"`
+}
+
+// HoneypotConfig holds the paths that immediately block any visitor
+type HoneypotConfig struct {
+	Paths     []string      `mapstructure:"paths"`
+	BlockTime time.Duration `mapstructure:"block_time"`
+}
+
+// Method counting policies
+const (
+	// MethodCount counts requests against the client's limits, the default
+	MethodCount = "count"
+	// MethodIgnore never counts requests, only blocked clients are rejected
+	MethodIgnore = "ignore"
+	// MethodSeparate counts requests against a separate budget of the same size
+	MethodSeparate = "separate"
+)
+
+// Storage failure modes
+const (
+	// FailureOpen lets requests through when the storage fails, the default
+	FailureOpen = "open"
+	// FailureClosed rejects requests with 503 when the storage fails
+	FailureClosed = "closed"
+	// FailureLocal counts requests against the local fallback limits when the storage fails
+	FailureLocal = "local"
+)
+
+// Credential conflict handling
+const (
+	// CredentialFirst uses the credential of the first source, in source order, the default
+	CredentialFirst = "first"
+	// CredentialReject rejects requests carrying distinct credentials with 400
+	CredentialReject = "reject"
+	// CredentialAll charges the request against every configured credential it carries
+	CredentialAll = "all"
+)
+
+// Rate limiting algorithms
+const (
+	// AlgorithmFixedWindow counts requests in fixed windows, allowing bursts at window boundaries
+	AlgorithmFixedWindow = "fixed_window"
+	// AlgorithmSlidingLog logs each request and enforces the limit over the trailing window
+	AlgorithmSlidingLog = "sliding_log"
+	// AlgorithmTokenBucket lets clients burst up to a capacity, then throttles them to the refill rate
+	AlgorithmTokenBucket = "token_bucket"
+	// AlgorithmLeakyBucket queues requests and lets them through at a constant drain rate
+	AlgorithmLeakyBucket = "leaky_bucket"
+)
+
+// BucketConfig holds the bucket size. Zero values are derived from the limit:
+// a capacity of one window's limit, refilled (or drained) at the limit per second
+type BucketConfig struct {
+	// Capacity is the token bucket burst, or the number of requests a leaky bucket can queue
+	Capacity int `mapstructure:"capacity"`
+	// RefillRate is the number of tokens added, or queued requests released, per second
+	RefillRate float64 `mapstructure:"refill_rate"`
+}
+
+// TierLimit holds the limits of a named pricing tier
+type TierLimit struct {
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+	// MaxBodySize caps request bodies of the tier's tokens in bytes, 0 uses the default
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+}
+
+// TokenLimit holds configuration for a specific token
+type TokenLimit struct {
+	Limit     int           `mapstructure:"limit" json:"limit"`
+	BlockTime time.Duration `mapstructure:"block_time" json:"block_time"`
+	// Tier names the pricing tier of the token, exposed to policy rules
+	Tier string `mapstructure:"tier" json:"tier,omitempty"`
+	// Window overrides the window of the policy for the token, 0 keeps it
+	Window time.Duration `mapstructure:"window" json:"window,omitempty"`
+	// Burst is the bucket capacity of the token with the token_bucket algorithm, 0 uses the limit
+	Burst int `mapstructure:"burst" json:"burst,omitempty"`
+	// DailyQuota and MonthlyQuota cap the requests of the token per UTC
+	// calendar day and month, on top of its limit. 0 disables them
+	DailyQuota   int `mapstructure:"daily_quota" json:"daily_quota,omitempty"`
+	MonthlyQuota int `mapstructure:"monthly_quota" json:"monthly_quota,omitempty"`
+	// Windows constrain the token by further windows at the same time
+	Windows []WindowLimit `mapstructure:"windows" json:"windows,omitempty"`
+}
+
+// WindowLimit is a limit per window enforced alongside the main limit of a key
+type WindowLimit struct {
+	Limit  int           `mapstructure:"limit" json:"limit"`
+	Window time.Duration `mapstructure:"window" json:"window"`
+}
 
-	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, err
+// String formats the limit as accepted by ParseWindowLimits
+func (w WindowLimit) String() string {
+	return fmt.Sprintf("%d/%s", w.Limit, w.Window)
+}
+
+// ParseWindowLimits parses a comma separated list of limit/window pairs such
+// as "300/1m,5000/1h"
+func ParseWindowLimits(value string) ([]WindowLimit, error) {
+	var limits []WindowLimit
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		limit, window, found := strings.Cut(pair, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid window limit %q, expected <limit>/<window>", pair)
+		}
+		parsed, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid limit in %q", pair)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid window in %q", pair)
+		}
+		limits = append(limits, WindowLimit{Limit: parsed, Window: duration})
 	}
+	return limits, nil
+}
+
+// Policy holds the limits that can be changed while the server is running
+type Policy struct {
+	IPLimit     int                   `json:"ip_limit"`
+	IPBlockTime time.Duration         `json:"ip_block_time"`
+	IPBurst     int                   `json:"ip_burst,omitempty"`
+	IPWindows   []WindowLimit         `json:"ip_windows,omitempty"`
+	TokenLimits map[string]TokenLimit `json:"token_limits"`
+	// Window is the window of the IP limit and of limits without their own
+	Window time.Duration `json:"window,omitempty"`
+}
 
-	// Manually set values from environment variables if they exist
-	if viper.IsSet("REDIS_HOST") {
-		config.Redis.Host = viper.GetString("REDIS_HOST")
+// DefaultWindow is the window of policies recorded before it was configurable
+const DefaultWindow = time.Second
+
+// LimitWindow returns the window of the IP limit and of the other limits
+// without their own
+func (p Policy) LimitWindow() time.Duration {
+	if p.Window > 0 {
+		return p.Window
 	}
-	if viper.IsSet("REDIS_PORT") {
-		config.Redis.Port = viper.GetString("REDIS_PORT")
+	return DefaultWindow
+}
+
+// TokenWindow returns the window a token limit is counted over
+func (p Policy) TokenWindow(limit TokenLimit) time.Duration {
+	if limit.Window > 0 {
+		return limit.Window
 	}
-	if viper.IsSet("REDIS_PASSWORD") {
-		config.Redis.Password = viper.GetString("REDIS_PASSWORD")
+	return p.LimitWindow()
+}
+
+// Policy returns the runtime-adjustable limits of the configuration
+func (c RateLimitConfig) Policy() Policy {
+	tokenLimits := make(map[string]TokenLimit, len(c.TokenLimits))
+	for token, limit := range c.TokenLimits {
+		tokenLimits[token] = limit
 	}
-	if viper.IsSet("REDIS_DB") {
-		config.Redis.DB = viper.GetInt("REDIS_DB")
+
+	return Policy{
+		IPLimit:     c.IPLimit,
+		IPBlockTime: c.IPBlockTime,
+		IPBurst:     c.IPBurst,
+		IPWindows:   c.IPWindows,
+		TokenLimits: tokenLimits,
+		Window:      c.Window,
 	}
-	if viper.IsSet("SERVER_PORT") {
-		config.Server.Port = viper.GetString("SERVER_PORT")
+}
+
+// PolicyHistoryConfig holds configuration for the versioned policy history
+type PolicyHistoryConfig struct {
+	// Size is the number of applied policy versions kept for rollback
+	Size int `mapstructure:"size"`
+	// SyncInterval is how often instances pick up the active policy version
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+}
+
+// FallbackConfig holds the per-instance limits used by the local fallback limiter
+// when the distributed storage is unavailable
+type FallbackConfig struct {
+	// IPLimit overrides the derived per-instance IP limit when greater than zero
+	IPLimit int `mapstructure:"ip_limit"`
+	// Ratio is the fraction of the distributed limit shared by the whole fleet
+	Ratio float64 `mapstructure:"ratio"`
+	// InstanceCount is the static number of instances sharing the limit
+	InstanceCount int `mapstructure:"instance_count"`
+	// InstanceDNS is a hostname whose A/AAAA records are counted to discover the instance count
+	InstanceDNS string `mapstructure:"instance_dns"`
+}
+
+// LocalLimit derives the per-instance limit from a distributed limit,
+// dividing the configured fraction of it by the number of instances
+func (f FallbackConfig) LocalLimit(distributed, instances int) int {
+	if instances < 1 {
+		instances = 1
 	}
-	if viper.IsSet("RATE_LIMIT_IP_LIMIT") {
-		config.RateLimit.IPLimit = viper.GetInt("RATE_LIMIT_IP_LIMIT")
+
+	ratio := f.Ratio
+	if ratio <= 0 {
+		ratio = 1
 	}
-	if viper.IsSet("RATE_LIMIT_IP_BLOCK_TIME") {
-		if blockTime, err := time.ParseDuration(viper.GetString("RATE_LIMIT_IP_BLOCK_TIME")); err == nil {
-			config.RateLimit.IPBlockTime = blockTime
-		}
+
+	limit := int(float64(distributed) * ratio / float64(instances))
+	if limit < 1 {
+		limit = 1
 	}
 
-	// Load token configurations manually
-	config.RateLimit.TokenLimits = make(map[string]TokenLimit)
+	return limit
+}
 
-	// Check for specific tokens
-	if viper.IsSet("RATE_LIMIT_TOKEN_ABC123_LIMIT") {
-		limit := viper.GetInt("RATE_LIMIT_TOKEN_ABC123_LIMIT")
-		blockTime := time.Minute
-		if viper.IsSet("RATE_LIMIT_TOKEN_ABC123_BLOCK_TIME") {
-			if bt, err := time.ParseDuration(viper.GetString("RATE_LIMIT_TOKEN_ABC123_BLOCK_TIME")); err == nil {
-				blockTime = bt
-			}
-		}
-		config.RateLimit.TokenLimits["ABC123"] = TokenLimit{
-			Limit:     limit,
-			BlockTime: blockTime,
-		}
+// LocalIPLimit returns the per-instance IP limit, honoring the explicit override
+func (f FallbackConfig) LocalIPLimit(distributed, instances int) int {
+	if f.IPLimit > 0 {
+		return f.IPLimit
 	}
+	return f.LocalLimit(distributed, instances)
+}
 
-	log.Printf("Final token configs: %+v", config.RateLimit.TokenLimits)
-
-	return &config, nil
-}
-
-// loadTokenConfigs loads token-specific configurations from environment variables
-func loadTokenConfigs() map[string]TokenLimit {
-	tokenConfigs := make(map[string]TokenLimit)
-
-	// Check environment variables directly
-	for _, env := range os.Environ() {
-		parts := strings.SplitN(env, "=", 2)
-		if len(parts) == 2 {
-			key := parts[0]
-
-			// Debug: log all environment variables that start with RATE_LIMIT_TOKEN_
-			if strings.HasPrefix(key, "RATE_LIMIT_TOKEN_") {
-				log.Printf("Found token env var: %s", key)
-			}
-
-			// Check for token limit pattern: RATE_LIMIT_TOKEN_<TOKEN>_LIMIT
-			if len(key) > 25 && key[:25] == "RATE_LIMIT_TOKEN_" && key[len(key)-6:] == "_LIMIT" {
-				tokenName := key[25 : len(key)-6]
-				log.Printf("Processing token: %s", tokenName)
-
-				// Get the limit value
-				limit := viper.GetInt(key)
-				log.Printf("Token %s limit: %d", tokenName, limit)
-
-				// Get the block time for this token
-				blockTimeKey := "RATE_LIMIT_TOKEN_" + tokenName + "_BLOCK_TIME"
-				blockTimeStr := viper.GetString(blockTimeKey)
-
-				var blockTime time.Duration
-				if blockTimeStr != "" {
-					var err error
-					blockTime, err = time.ParseDuration(blockTimeStr)
-					if err != nil {
-						log.Printf("Invalid block time for token %s: %v", tokenName, err)
-						blockTime = time.Minute // Default block time
-					}
-				} else {
-					blockTime = time.Minute // Default block time
-				}
-
-				tokenConfigs[tokenName] = TokenLimit{
-					Limit:     limit,
-					BlockTime: blockTime,
-				}
-				log.Printf("Added token config: %+v", tokenConfigs[tokenName])
-			}
-		}
+// ResolveInstanceCount returns the number of instances sharing the limits.
+// When InstanceDNS is set the addresses it resolves to are counted, falling back
+// to the static InstanceCount if the lookup fails
+func (f FallbackConfig) ResolveInstanceCount(ctx context.Context) int {
+	count := f.InstanceCount
+	if count < 1 {
+		count = 1
 	}
 
-	return tokenConfigs
-}
-
-// setDefaults sets default configuration values
-func setDefaults() {
-	// Server defaults
-	viper.SetDefault("SERVER_PORT", "8080")
+	if f.InstanceDNS == "" {
+		return count
+	}
 
-	// Redis defaults
-	viper.SetDefault("REDIS_HOST", "localhost")
-	viper.SetDefault("REDIS_PORT", "6379")
-	viper.SetDefault("REDIS_PASSWORD", "")
-	viper.SetDefault("REDIS_DB", 0)
+	addrs, err := net.DefaultResolver.LookupHost(ctx, f.InstanceDNS)
+	if err != nil || len(addrs) == 0 {
+		slog.Warn("Failed to discover instances", "dns", f.InstanceDNS, "instances", count, "error", err)
+		return count
+	}
 
-	// Rate limit defaults
-	viper.SetDefault("RATE_LIMIT_IP_LIMIT", 10)
-	viper.SetDefault("RATE_LIMIT_IP_BLOCK_TIME", "1m")
+	return len(addrs)
 }
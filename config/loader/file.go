@@ -0,0 +1,203 @@
+package loader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// fileNames are the structured config files looked up, in order, in the
+// working directory and ./config when CONFIG_FILE is not set
+var fileNames = []string{"config.yaml", "config.yml", "config.json"}
+
+// fileConfig is the structure of config.yaml or config.json. JSON files are
+// parsed as YAML, of which JSON is a subset
+type fileConfig struct {
+	RateLimit fileRateLimit `yaml:"rate_limit"`
+}
+
+type fileRateLimit struct {
+	IPLimit     *int                      `yaml:"ip_limit"`
+	IPBlockTime string                    `yaml:"ip_block_time"`
+	IPBurst     *int                      `yaml:"ip_burst"`
+	IPWindows   []fileWindowLimit         `yaml:"ip_windows"`
+	Window      string                    `yaml:"window"`
+	Tokens      map[string]fileTokenLimit `yaml:"tokens"`
+	Routes      []fileRouteLimit          `yaml:"routes"`
+	Tiers       map[string]fileTierLimit  `yaml:"tiers"`
+	Caps        fileCaps                  `yaml:"caps"`
+}
+
+type fileCaps struct {
+	MaxBlockTime string `yaml:"max_block_time"`
+	MaxLimit     *int   `yaml:"max_limit"`
+	MinWindow    string `yaml:"min_window"`
+}
+
+type fileTokenLimit struct {
+	Limit     int    `yaml:"limit"`
+	BlockTime string `yaml:"block_time"`
+	Window    string `yaml:"window"`
+	Burst     int    `yaml:"burst"`
+	Tier      string `yaml:"tier"`
+	// DailyQuota and MonthlyQuota cap the requests per calendar day and month
+	DailyQuota   int               `yaml:"daily_quota"`
+	MonthlyQuota int               `yaml:"monthly_quota"`
+	Windows      []fileWindowLimit `yaml:"windows"`
+}
+
+type fileWindowLimit struct {
+	Limit  int    `yaml:"limit"`
+	Window string `yaml:"window"`
+}
+
+type fileRouteLimit struct {
+	Method      string `yaml:"method"`
+	Pattern     string `yaml:"pattern"`
+	Limit       int    `yaml:"limit"`
+	BlockTime   string `yaml:"block_time"`
+	PerEndpoint bool   `yaml:"per_endpoint"`
+}
+
+type fileTierLimit struct {
+	Limit       int    `yaml:"limit"`
+	Window      string `yaml:"window"`
+	MaxBodySize string `yaml:"max_body_size"`
+}
+
+// FilePath returns the structured config file in use, empty when there is none
+func FilePath() string {
+	v := newViper()
+	v.ReadInConfig()
+	return filePath(v)
+}
+
+// filePath returns the structured config file named by CONFIG_FILE or found
+// in the working directory or ./config
+func filePath(v *viper.Viper) string {
+	if path := v.GetString("CONFIG_FILE"); path != "" {
+		return path
+	}
+	for _, dir := range []string{".", "./config"} {
+		for _, name := range fileNames {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// readFile parses the structured config file, returning nil when there is none
+func readFile(path string) (*fileConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// fileDefaults makes the scalar settings of the file defaults, so
+// environment variables and the .env file still override them
+func (f *fileConfig) fileDefaults(v *viper.Viper) {
+	if f.RateLimit.IPLimit != nil {
+		v.SetDefault("RATE_LIMIT_IP_LIMIT", *f.RateLimit.IPLimit)
+	}
+	if f.RateLimit.IPBlockTime != "" {
+		v.SetDefault("RATE_LIMIT_IP_BLOCK_TIME", f.RateLimit.IPBlockTime)
+	}
+	if f.RateLimit.IPBurst != nil {
+		v.SetDefault("RATE_LIMIT_IP_BURST", *f.RateLimit.IPBurst)
+	}
+	if len(f.RateLimit.IPWindows) > 0 {
+		pairs := make([]string, len(f.RateLimit.IPWindows))
+		for i, window := range f.RateLimit.IPWindows {
+			pairs[i] = fmt.Sprintf("%d/%s", window.Limit, window.Window)
+		}
+		v.SetDefault("RATE_LIMIT_IP_WINDOWS", strings.Join(pairs, ","))
+	}
+	if f.RateLimit.Window != "" {
+		v.SetDefault("RATE_LIMIT_WINDOW", f.RateLimit.Window)
+	}
+	caps := f.RateLimit.Caps
+	if caps.MaxBlockTime != "" {
+		v.SetDefault("RATE_LIMIT_MAX_BLOCK_TIME", caps.MaxBlockTime)
+	}
+	if caps.MaxLimit != nil {
+		v.SetDefault("RATE_LIMIT_MAX_LIMIT", *caps.MaxLimit)
+	}
+	if caps.MinWindow != "" {
+		v.SetDefault("RATE_LIMIT_MIN_WINDOW", caps.MinWindow)
+	}
+}
+
+// fileLimits adds the tokens, routes and tiers declared in the file. Token names
+// are kept as written, since they are matched exactly against credentials
+func (l *parser) fileLimits(f *fileConfig, rl *config.RateLimitConfig) {
+	for name, token := range f.RateLimit.Tokens {
+		field := "rate_limit.tokens." + name
+		limit := config.TokenLimit{Limit: token.Limit, BlockTime: defaultTokenBlockTime, Burst: token.Burst, DailyQuota: token.DailyQuota, MonthlyQuota: token.MonthlyQuota, Tier: strings.ToLower(token.Tier)}
+		l.parseDuration(field+".block_time", token.BlockTime, &limit.BlockTime)
+		l.parseDuration(field+".window", token.Window, &limit.Window)
+		for i, window := range token.Windows {
+			windowLimit := config.WindowLimit{Limit: window.Limit}
+			l.parseDuration(fmt.Sprintf("%s.windows[%d].window", field, i), window.Window, &windowLimit.Window)
+			limit.Windows = append(limit.Windows, windowLimit)
+		}
+		rl.TokenLimits[name] = limit
+	}
+
+	for i, route := range f.RateLimit.Routes {
+		field := fmt.Sprintf("rate_limit.routes[%d]", i)
+		limit := config.RouteLimit{Method: strings.ToUpper(strings.TrimSpace(route.Method)), Pattern: strings.TrimSpace(route.Pattern), Limit: route.Limit, PerEndpoint: route.PerEndpoint}
+		l.parseDuration(field+".block_time", route.BlockTime, &limit.BlockTime)
+		rl.Routes = append(rl.Routes, limit)
+	}
+
+	for name, tier := range f.RateLimit.Tiers {
+		field := "rate_limit.tiers." + name
+		limit := config.TierLimit{Limit: tier.Limit, Window: defaultTierWindow}
+		l.parseDuration(field+".window", tier.Window, &limit.Window)
+		if tier.MaxBodySize != "" {
+			size, err := parseSize(tier.MaxBodySize)
+			if err != nil {
+				l.errs = append(l.errs, fieldErrorf(field+".max_body_size", "invalid size %q", tier.MaxBodySize))
+			}
+			limit.MaxBodySize = size
+		}
+		rl.Tiers[strings.ToUpper(name)] = limit
+	}
+}
+
+// parseDuration parses an optional duration of the file into target
+func (l *parser) parseDuration(field, value string, target *time.Duration) {
+	if value == "" {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(field, "invalid duration %q", value))
+		return
+	}
+	*target = parsed
+}
@@ -0,0 +1,627 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/spf13/viper"
+)
+
+// newViper returns a Viper instance reading the environment and the .env file
+// of the working directory or ./config
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetConfigName(".env")
+	v.SetConfigType("env")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./config")
+	// Enable reading from environment variables
+	v.AutomaticEnv()
+	return v
+}
+
+func init() {
+	// Keeps the deprecated config.LoadConfig working for callers linking this package
+	config.RegisterLoader(Load)
+}
+
+// Load loads configuration from environment variables and .env file. Each call
+// starts from a fresh Viper instance, so settings removed from the files since
+// the previous load fall back to their defaults
+func Load() (*config.Config, error) {
+	v := newViper()
+
+	// Set default values
+	setDefaults(v)
+
+	// Try to read .env file (optional)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			slog.Error("Error reading config file", "error", err)
+		}
+	}
+
+	// Preset defaults depend on the configured preset, so they come after reading it
+	preset := applyPreset(v)
+
+	// Settings of the structured config file are defaults too, overriding the
+	// preset but not the environment
+	file, err := readFile(filePath(v))
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		file.fileDefaults(v)
+	}
+
+	var cfg config.Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	cfg.RateLimit.Preset = preset
+
+	// Manually set values from environment variables if they exist,
+	// collecting every invalid value before failing
+	l := &parser{v: v}
+	if v.IsSet("REDIS_HOST") {
+		cfg.Redis.Host = v.GetString("REDIS_HOST")
+	}
+	if v.IsSet("REDIS_PORT") {
+		cfg.Redis.Port = v.GetString("REDIS_PORT")
+	}
+	if v.IsSet("REDIS_PASSWORD") {
+		cfg.Redis.Password = v.GetString("REDIS_PASSWORD")
+	}
+	l.integer("REDIS_DB", &cfg.Redis.DB)
+	l.integer("REDIS_RETRY_MAX_ATTEMPTS", &cfg.Redis.Retry.MaxAttempts)
+	l.duration("REDIS_RETRY_BASE_DELAY", &cfg.Redis.Retry.BaseDelay)
+	l.duration("REDIS_RETRY_MAX_DELAY", &cfg.Redis.Retry.MaxDelay)
+	l.float("REDIS_RETRY_BUDGET", &cfg.Redis.Retry.Budget)
+	cfg.Redis.TLS.Enabled = v.GetBool("REDIS_TLS")
+	cfg.Redis.TLS.CAFile = v.GetString("REDIS_TLS_CA_FILE")
+	cfg.Redis.TLS.CertFile = v.GetString("REDIS_TLS_CERT_FILE")
+	cfg.Redis.TLS.KeyFile = v.GetString("REDIS_TLS_KEY_FILE")
+	cfg.Redis.TLS.ServerName = v.GetString("REDIS_TLS_SERVER_NAME")
+	cfg.Redis.TLS.InsecureSkipVerify = v.GetBool("REDIS_TLS_INSECURE_SKIP_VERIFY")
+	l.integer("REDIS_POOL_SIZE", &cfg.Redis.Pool.Size)
+	l.integer("REDIS_MIN_IDLE_CONNS", &cfg.Redis.Pool.MinIdleConns)
+	l.duration("REDIS_READ_TIMEOUT", &cfg.Redis.Pool.ReadTimeout)
+	l.duration("REDIS_WRITE_TIMEOUT", &cfg.Redis.Pool.WriteTimeout)
+	l.integer("REDIS_MAX_RETRIES", &cfg.Redis.Pool.MaxRetries)
+	l.duration("REDIS_LOCAL_CACHE_TTL", &cfg.Redis.LocalCacheTTL)
+	l.duration("REDIS_BATCH_INTERVAL", &cfg.Redis.BatchInterval)
+	cfg.Redis.FaultInjection = v.GetBool("REDIS_FAULT_INJECTION")
+	if v.IsSet("SERVER_PORT") {
+		cfg.Server.Port = v.GetString("SERVER_PORT")
+	}
+	l.duration("SERVER_HEARTBEAT_INTERVAL", &cfg.Server.HeartbeatInterval)
+	l.duration("SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout)
+	l.duration("SERVER_LEADER_LEASE_TTL", &cfg.Server.LeaderLeaseTTL)
+	cfg.Server.GRPCPort = v.GetString("SERVER_GRPC_PORT")
+	cfg.Server.GRPCChannelz = v.GetBool("SERVER_GRPC_CHANNELZ")
+	cfg.Server.TLSCertFile = v.GetString("SERVER_TLS_CERT_FILE")
+	cfg.Server.TLSKeyFile = v.GetString("SERVER_TLS_KEY_FILE")
+	cfg.Server.TLSClientCAFile = v.GetString("SERVER_TLS_CLIENT_CA_FILE")
+	cfg.Admin.ReadTokens = splitList(v.GetString("ADMIN_READ_TOKENS"))
+	cfg.Admin.WriteTokens = splitList(v.GetString("ADMIN_WRITE_TOKENS"))
+	cfg.Admin.ReadSubjects = splitList(v.GetString("ADMIN_READ_CERT_SUBJECTS"))
+	cfg.Admin.WriteSubjects = splitList(v.GetString("ADMIN_WRITE_CERT_SUBJECTS"))
+	cfg.Admin.AuthDisabled = v.GetBool("ADMIN_AUTH_DISABLED")
+	cfg.Log.Level = strings.ToLower(v.GetString("LOG_LEVEL"))
+	cfg.Log.Format = strings.ToLower(v.GetString("LOG_FORMAT"))
+	l.integer("RATE_LIMIT_IP_LIMIT", &cfg.RateLimit.IPLimit)
+	l.duration("RATE_LIMIT_IP_BLOCK_TIME", &cfg.RateLimit.IPBlockTime)
+	l.duration("RATE_LIMIT_WINDOW", &cfg.RateLimit.Window)
+	l.duration("RATE_LIMIT_MAX_BLOCK_TIME", &cfg.RateLimit.Caps.MaxBlockTime)
+	l.integer("RATE_LIMIT_MAX_LIMIT", &cfg.RateLimit.Caps.MaxLimit)
+	l.duration("RATE_LIMIT_MIN_WINDOW", &cfg.RateLimit.Caps.MinWindow)
+	l.integer("RATE_LIMIT_IP_BURST", &cfg.RateLimit.IPBurst)
+	l.integer("RATE_LIMIT_GLOBAL_LIMIT", &cfg.RateLimit.GlobalLimit)
+	l.duration("RATE_LIMIT_GLOBAL_WINDOW", &cfg.RateLimit.GlobalWindow)
+	l.windows("RATE_LIMIT_IP_WINDOWS", &cfg.RateLimit.IPWindows)
+
+	cfg.RateLimit.FailureMode = strings.ToLower(v.GetString("RATE_LIMIT_FAILURE_MODE"))
+	cfg.RateLimit.ReadOnly = v.GetBool("RATE_LIMIT_READ_ONLY")
+	l.integer("RATE_LIMIT_FALLBACK_IP_LIMIT", &cfg.RateLimit.Fallback.IPLimit)
+	l.float("RATE_LIMIT_FALLBACK_RATIO", &cfg.RateLimit.Fallback.Ratio)
+	l.integer("RATE_LIMIT_FALLBACK_INSTANCE_COUNT", &cfg.RateLimit.Fallback.InstanceCount)
+	if v.IsSet("RATE_LIMIT_FALLBACK_INSTANCE_DNS") {
+		cfg.RateLimit.Fallback.InstanceDNS = v.GetString("RATE_LIMIT_FALLBACK_INSTANCE_DNS")
+	}
+
+	cfg.RateLimit.TokenSources = splitList(v.GetString("RATE_LIMIT_TOKEN_SOURCES"))
+	cfg.RateLimit.CredentialConflict = strings.ToLower(v.GetString("RATE_LIMIT_CREDENTIAL_CONFLICT"))
+	cfg.RateLimit.IPKeyTransforms = splitList(v.GetString("RATE_LIMIT_IP_KEY_TRANSFORMS"))
+	cfg.RateLimit.TokenKeyTransforms = splitList(v.GetString("RATE_LIMIT_TOKEN_KEY_TRANSFORMS"))
+	cfg.RateLimit.KeyMigration.Enabled = v.GetBool("RATE_LIMIT_KEY_MIGRATION")
+	cfg.RateLimit.KeyMigration.PreviousIPTransforms = splitList(v.GetString("RATE_LIMIT_KEY_MIGRATION_PREVIOUS_IP_TRANSFORMS"))
+	cfg.RateLimit.KeyMigration.PreviousTokenTransforms = splitList(v.GetString("RATE_LIMIT_KEY_MIGRATION_PREVIOUS_TOKEN_TRANSFORMS"))
+	l.integer("RATE_LIMIT_IPV4_PREFIX", &cfg.RateLimit.IPv4Prefix)
+	l.integer("RATE_LIMIT_IPV6_PREFIX", &cfg.RateLimit.IPv6Prefix)
+
+	if v.IsSet("RATE_LIMIT_HONEYPOT_PATHS") {
+		cfg.RateLimit.Honeypot.Paths = splitList(v.GetString("RATE_LIMIT_HONEYPOT_PATHS"))
+	}
+	l.duration("RATE_LIMIT_HONEYPOT_BLOCK_TIME", &cfg.RateLimit.Honeypot.BlockTime)
+
+	slowClient := &cfg.RateLimit.SlowClient
+	l.duration("RATE_LIMIT_SLOW_CLIENT_READ_TIMEOUT", &slowClient.ReadTimeout)
+	l.duration("RATE_LIMIT_SLOW_CLIENT_MIN_READ_TIMEOUT", &slowClient.MinReadTimeout)
+	l.duration("RATE_LIMIT_SLOW_CLIENT_WRITE_TIMEOUT", &slowClient.WriteTimeout)
+	l.integer("RATE_LIMIT_SLOW_CLIENT_MAX_STRIKES", &slowClient.MaxStrikes)
+	l.duration("RATE_LIMIT_SLOW_CLIENT_STRIKE_WINDOW", &slowClient.StrikeWindow)
+	l.duration("RATE_LIMIT_SLOW_CLIENT_BLOCK_TIME", &slowClient.BlockTime)
+
+	cfg.RateLimit.Signing.Secret = v.GetString("RATE_LIMIT_SIGNING_SECRET")
+	cfg.RateLimit.Signing.Required = v.GetBool("RATE_LIMIT_SIGNING_REQUIRED")
+	l.duration("RATE_LIMIT_SIGNING_REPLAY_WINDOW", &cfg.RateLimit.Signing.ReplayWindow)
+	l.size("RATE_LIMIT_SIGNING_MAX_BODY_SIZE", &cfg.RateLimit.Signing.MaxBodySize)
+
+	cfg.RateLimit.Debug.Enabled = v.GetBool("RATE_LIMIT_DEBUG")
+	if v.IsSet("RATE_LIMIT_SAMPLING_SEED") {
+		var seed int
+		l.integer("RATE_LIMIT_SAMPLING_SEED", &seed)
+		cfg.RateLimit.SamplingSeed = int64(seed)
+	}
+	l.float("RATE_LIMIT_DEBUG_SAMPLE_RATE", &cfg.RateLimit.Debug.SampleRate)
+
+	cfg.RateLimit.DecisionLog.Enabled = v.GetBool("RATE_LIMIT_DECISION_LOG")
+	l.float("RATE_LIMIT_DECISION_LOG_SAMPLE_RATE", &cfg.RateLimit.DecisionLog.SampleRate)
+	l.float("RATE_LIMIT_DECISION_LOG_DENIED_SAMPLE_RATE", &cfg.RateLimit.DecisionLog.DeniedSampleRate)
+
+	l.duration("RATE_LIMIT_OFFENDERS_WINDOW", &cfg.RateLimit.OffendersWindow)
+	l.size("RATE_LIMIT_MAX_BODY_SIZE", &cfg.RateLimit.MaxBodySize)
+	l.duration("RATE_LIMIT_INFLIGHT_INTERVAL", &cfg.RateLimit.InFlight.Interval)
+	l.integer("RATE_LIMIT_INFLIGHT_TOP", &cfg.RateLimit.InFlight.Top)
+	cfg.RateLimit.KeyIndex.Enabled = v.GetBool("RATE_LIMIT_KEY_INDEX")
+	cfg.RateLimit.KeyIndex.Kinds = splitList(v.GetString("RATE_LIMIT_KEY_INDEX_KINDS"))
+	l.duration("RATE_LIMIT_KEY_INDEX_TTL", &cfg.RateLimit.KeyIndex.TTL)
+	l.duration("RATE_LIMIT_KEY_INDEX_FLUSH_INTERVAL", &cfg.RateLimit.KeyIndex.FlushInterval)
+	l.integer("RATE_LIMIT_KEY_HISTORY_SIZE", &cfg.RateLimit.KeyHistory.Size)
+	l.duration("RATE_LIMIT_KEY_HISTORY_TTL", &cfg.RateLimit.KeyHistory.TTL)
+
+	cfg.RateLimit.OpenAPISpec = v.GetString("RATE_LIMIT_OPENAPI_SPEC")
+	cfg.RateLimit.RulesFile = v.GetString("RATE_LIMIT_RULES_FILE")
+
+	l.duration("RATE_LIMIT_CANARY_INTERVAL", &cfg.RateLimit.Canary.Interval)
+	l.duration("RATE_LIMIT_CANARY_SLO", &cfg.RateLimit.Canary.SLO)
+
+	l.integer("RATE_LIMIT_IP_BUCKET_CAPACITY", &cfg.RateLimit.IPBucket.Capacity)
+	l.float("RATE_LIMIT_IP_REFILL_RATE", &cfg.RateLimit.IPBucket.RefillRate)
+	l.integer("RATE_LIMIT_TOKEN_BUCKET_CAPACITY", &cfg.RateLimit.TokenBucket.Capacity)
+	l.float("RATE_LIMIT_TOKEN_REFILL_RATE", &cfg.RateLimit.TokenBucket.RefillRate)
+
+	cfg.RateLimit.HeaderVisibility = v.GetString("RATE_LIMIT_HEADER_VISIBILITY")
+	cfg.RateLimit.HeaderFormat = v.GetString("RATE_LIMIT_HEADER_FORMAT")
+
+	cfg.RateLimit.Algorithm = v.GetString("RATE_LIMIT_ALGORITHM")
+	cfg.RateLimit.IPAlgorithm = v.GetString("RATE_LIMIT_IP_ALGORITHM")
+	if cfg.RateLimit.IPAlgorithm == "" {
+		cfg.RateLimit.IPAlgorithm = cfg.RateLimit.Algorithm
+	}
+	cfg.RateLimit.TokenAlgorithm = v.GetString("RATE_LIMIT_TOKEN_ALGORITHM")
+	if cfg.RateLimit.TokenAlgorithm == "" {
+		cfg.RateLimit.TokenAlgorithm = cfg.RateLimit.Algorithm
+	}
+
+	shadowAlgorithm := v.GetString("RATE_LIMIT_SHADOW_ALGORITHM")
+	cfg.RateLimit.IPShadowAlgorithm = v.GetString("RATE_LIMIT_IP_SHADOW_ALGORITHM")
+	if cfg.RateLimit.IPShadowAlgorithm == "" {
+		cfg.RateLimit.IPShadowAlgorithm = shadowAlgorithm
+	}
+	cfg.RateLimit.TokenShadowAlgorithm = v.GetString("RATE_LIMIT_TOKEN_SHADOW_ALGORITHM")
+	if cfg.RateLimit.TokenShadowAlgorithm == "" {
+		cfg.RateLimit.TokenShadowAlgorithm = shadowAlgorithm
+	}
+
+	l.duration("RATE_LIMIT_SLIDING_LOG_RETENTION", &cfg.RateLimit.SlidingLogRetention)
+
+	cfg.RateLimit.BlockServerClock = v.GetBool("RATE_LIMIT_BLOCK_SERVER_CLOCK")
+	cfg.RateLimit.RequestDeadline = v.GetBool("RATE_LIMIT_REQUEST_DEADLINE")
+
+	cfg.RateLimit.Force.TrustedCIDRs = splitList(v.GetString("RATE_LIMIT_FORCE_TRUSTED_CIDRS"))
+	cfg.RateLimit.Force.AdminToken = v.GetString("RATE_LIMIT_FORCE_ADMIN_TOKEN")
+	cfg.RateLimit.Trust.Proxies = splitList(v.GetString("RATE_LIMIT_TRUSTED_PROXIES"))
+	cfg.RateLimit.Trust.Headers = splitList(v.GetString("RATE_LIMIT_INTERNAL_HEADERS"))
+	cfg.RateLimit.Trust.Cookies = splitList(v.GetString("RATE_LIMIT_INTERNAL_COOKIES"))
+	cfg.RateLimit.Bypass.Secret = v.GetString("RATE_LIMIT_BYPASS_SECRET")
+	l.duration("RATE_LIMIT_BYPASS_MAX_TTL", &cfg.RateLimit.Bypass.MaxTTL)
+
+	gateway := &cfg.RateLimit.Gateway
+	gateway.Header = v.GetString("RATE_LIMIT_GATEWAY_HEADER")
+	gateway.Algorithm = strings.ToUpper(v.GetString("RATE_LIMIT_GATEWAY_ALGORITHM"))
+	gateway.Secret = v.GetString("RATE_LIMIT_GATEWAY_SECRET")
+	gateway.PublicKeyFile = v.GetString("RATE_LIMIT_GATEWAY_PUBLIC_KEY_FILE")
+	gateway.Issuer = v.GetString("RATE_LIMIT_GATEWAY_ISSUER")
+	gateway.Audience = v.GetString("RATE_LIMIT_GATEWAY_AUDIENCE")
+
+	rls := &cfg.RateLimit.RLS
+	rls.Enabled = v.GetBool("RATE_LIMIT_RLS")
+	rls.Domain = v.GetString("RATE_LIMIT_RLS_DOMAIN")
+	rls.IPKeys = splitList(v.GetString("RATE_LIMIT_RLS_IP_KEYS"))
+	rls.TokenKeys = splitList(v.GetString("RATE_LIMIT_RLS_TOKEN_KEYS"))
+
+	cfg.RateLimit.AccessLists.Allow = splitList(v.GetString("RATE_LIMIT_ALLOWLIST"))
+	cfg.RateLimit.AccessLists.Deny = splitList(v.GetString("RATE_LIMIT_DENYLIST"))
+	l.integer("RATE_LIMIT_DENYLIST_STATUS", &cfg.RateLimit.AccessLists.DenyStatus)
+	l.duration("RATE_LIMIT_ACCESS_LIST_SYNC_INTERVAL", &cfg.RateLimit.AccessLists.SyncInterval)
+
+	errorBudget := &cfg.RateLimit.ErrorBudget
+	l.float("RATE_LIMIT_ERROR_BUDGET", &errorBudget.Budget)
+	l.duration("RATE_LIMIT_ERROR_BUDGET_WINDOW", &errorBudget.Window)
+	l.float("RATE_LIMIT_ERROR_BUDGET_STEP", &errorBudget.Step)
+	l.float("RATE_LIMIT_ERROR_BUDGET_MAX_FACTOR", &errorBudget.MaxFactor)
+	l.integer("RATE_LIMIT_ERROR_BUDGET_MIN_REQUESTS", &errorBudget.MinRequests)
+
+	cfg.RateLimit.Checkpoint.Store = v.GetString("RATE_LIMIT_CHECKPOINT_STORE")
+	l.duration("RATE_LIMIT_CHECKPOINT_INTERVAL", &cfg.RateLimit.Checkpoint.Interval)
+	cfg.RateLimit.Checkpoint.Prefixes = splitList(v.GetString("RATE_LIMIT_CHECKPOINT_PREFIXES"))
+
+	cfg.RateLimit.EncryptionKeys = splitList(v.GetString("RATE_LIMIT_ENCRYPTION_KEYS"))
+
+	l.integer("RATE_LIMIT_POLICY_HISTORY_SIZE", &cfg.RateLimit.PolicyHistory.Size)
+	l.duration("RATE_LIMIT_POLICY_SYNC_INTERVAL", &cfg.RateLimit.PolicyHistory.SyncInterval)
+
+	cfg.RateLimit.Batch.Routes = splitList(v.GetString("RATE_LIMIT_BATCH_ROUTES"))
+	l.integer("RATE_LIMIT_BATCH_MAX_COST", &cfg.RateLimit.Batch.MaxCost)
+
+	cfg.RateLimit.RouteTemplates = splitList(v.GetString("RATE_LIMIT_ROUTE_TEMPLATES"))
+
+	// Method policies are "METHOD=policy" entries
+	cfg.RateLimit.MethodPolicies = make(map[string]string)
+	for _, item := range splitList(v.GetString("RATE_LIMIT_METHOD_POLICIES")) {
+		method, policy, found := strings.Cut(item, "=")
+		if !found {
+			l.errs = append(l.errs, fieldErrorf("RATE_LIMIT_METHOD_POLICIES", "invalid entry %q, expected METHOD=policy", item))
+			continue
+		}
+		cfg.RateLimit.MethodPolicies[strings.ToUpper(strings.TrimSpace(method))] = strings.ToLower(strings.TrimSpace(policy))
+	}
+
+	// Tokens, route limits and tiers declared in the config file come first
+	cfg.RateLimit.TokenLimits = make(map[string]config.TokenLimit)
+	cfg.RateLimit.Tiers = make(map[string]config.TierLimit)
+	if file != nil {
+		l.fileLimits(file, &cfg.RateLimit)
+	}
+
+	// Route limits are "METHOD /pattern=limit[:block_time]" entries
+	for _, item := range splitList(v.GetString("RATE_LIMIT_ROUTES")) {
+		route, err := parseRouteLimit(item)
+		if err != nil {
+			l.errs = append(l.errs, fieldErrorf("RATE_LIMIT_ROUTES", "%v", err))
+			continue
+		}
+		route.PerEndpoint = v.GetBool("RATE_LIMIT_ROUTES_PER_ENDPOINT")
+		cfg.RateLimit.Routes = append(cfg.RateLimit.Routes, route)
+	}
+
+	graphQL := &cfg.RateLimit.GraphQL
+	graphQL.Path = v.GetString("RATE_LIMIT_GRAPHQL_PATH")
+	l.integer("RATE_LIMIT_GRAPHQL_DEFAULT_WEIGHT", &graphQL.DefaultWeight)
+	l.integer("RATE_LIMIT_GRAPHQL_MAX_DEPTH", &graphQL.MaxDepth)
+	l.integer("RATE_LIMIT_GRAPHQL_MAX_COST", &graphQL.MaxCost)
+	graphQL.FieldWeights = make(map[string]int)
+	for _, item := range splitList(v.GetString("RATE_LIMIT_GRAPHQL_FIELD_WEIGHTS")) {
+		field, weight, _ := strings.Cut(item, "=")
+		value, err := strconv.Atoi(weight)
+		if err != nil {
+			l.errs = append(l.errs, fieldErrorf("RATE_LIMIT_GRAPHQL_FIELD_WEIGHTS", "invalid weight %q", item))
+			continue
+		}
+		graphQL.FieldWeights[strings.TrimSpace(field)] = value
+	}
+
+	// WAF exporters are enabled by setting their identifiers
+	cfg.SIEM.Address = v.GetString("SIEM_ADDRESS")
+	cfg.SIEM.Events = splitList(v.GetString("SIEM_EVENTS"))
+
+	l.duration("WAF_SYNC_INTERVAL", &cfg.WAF.SyncInterval)
+	cfg.WAF.AWSRegion = v.GetString("WAF_AWS_REGION")
+	cfg.WAF.AWSIPSetName = v.GetString("WAF_AWS_IPSET_NAME")
+	cfg.WAF.AWSIPSetID = v.GetString("WAF_AWS_IPSET_ID")
+	cfg.WAF.AWSScope = v.GetString("WAF_AWS_SCOPE")
+	cfg.WAF.CloudflareAPIToken = v.GetString("WAF_CLOUDFLARE_API_TOKEN")
+	cfg.WAF.CloudflareAccountID = v.GetString("WAF_CLOUDFLARE_ACCOUNT_ID")
+	cfg.WAF.CloudflareListID = v.GetString("WAF_CLOUDFLARE_LIST_ID")
+	cfg.WAF.FastlyAPIToken = v.GetString("WAF_FASTLY_API_TOKEN")
+	cfg.WAF.FastlyServiceID = v.GetString("WAF_FASTLY_SERVICE_ID")
+	cfg.WAF.FastlyACLID = v.GetString("WAF_FASTLY_ACL_ID")
+
+	// Environment tokens and tiers override those of the file with the same name
+	l.tokens(cfg.RateLimit.TokenLimits)
+	l.tiers(cfg.RateLimit.Tiers)
+
+	if err := errors.Join(l.merge(cfg.Validate())...); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return &cfg, nil
+}
+
+// applyPreset sets the defaults of the configured preset over the base
+// defaults. Unknown presets are reported by Validate
+func applyPreset(v *viper.Viper) string {
+	name := strings.ToLower(v.GetString("RATE_LIMIT_PRESET"))
+	for key, value := range config.PresetSettings(name) {
+		v.SetDefault(key, value)
+	}
+	return name
+}
+
+// defaultTokenBlockTime and defaultTierWindow apply when tokens and tiers
+// leave them out
+const (
+	defaultTokenBlockTime = time.Minute
+	defaultTierWindow     = time.Second
+)
+
+// tokens loads tokens from RATE_LIMIT_TOKEN_<NAME>_LIMIT, RATE_LIMIT_TOKEN_<NAME>_BLOCK_TIME,
+// RATE_LIMIT_TOKEN_<NAME>_WINDOW, RATE_LIMIT_TOKEN_<NAME>_BURST, RATE_LIMIT_TOKEN_<NAME>_DAILY_QUOTA,
+// RATE_LIMIT_TOKEN_<NAME>_MONTHLY_QUOTA, RATE_LIMIT_TOKEN_<NAME>_WINDOWS and RATE_LIMIT_TOKEN_<NAME>_TIER. Token names are upper case like the
+// variable names, tokens in other cases belong in the config file
+func (l *parser) tokens(tokens map[string]config.TokenLimit) {
+	const prefix, suffix = "RATE_LIMIT_TOKEN_", "_LIMIT"
+
+	for _, key := range envKeys(l.v) {
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if name, ok = strings.CutSuffix(name, suffix); !ok || name == "" {
+			continue
+		}
+
+		token := config.TokenLimit{BlockTime: defaultTokenBlockTime, Tier: strings.ToLower(l.v.GetString(prefix + name + "_TIER"))}
+		l.integer(key, &token.Limit)
+		l.duration(prefix+name+"_BLOCK_TIME", &token.BlockTime)
+		l.duration(prefix+name+"_WINDOW", &token.Window)
+		l.integer(prefix+name+"_BURST", &token.Burst)
+		l.integer(prefix+name+"_DAILY_QUOTA", &token.DailyQuota)
+		l.integer(prefix+name+"_MONTHLY_QUOTA", &token.MonthlyQuota)
+		l.windows(prefix+name+"_WINDOWS", &token.Windows)
+		tokens[name] = token
+	}
+}
+
+// envKeys returns the upper-case names of the environment variables and of
+// the settings of the .env file
+func envKeys(v *viper.Viper) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if key = strings.ToUpper(key); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		add(key)
+	}
+	for _, key := range v.AllKeys() {
+		add(key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseRouteLimit parses a "METHOD /pattern=limit[:block_time]" route limit,
+// where the method is optional
+func parseRouteLimit(item string) (config.RouteLimit, error) {
+	route, value, found := strings.Cut(item, "=")
+	if !found {
+		return config.RouteLimit{}, fmt.Errorf("%q: missing limit", item)
+	}
+
+	var limit config.RouteLimit
+	method, pattern, found := strings.Cut(strings.TrimSpace(route), " ")
+	if !found {
+		method, pattern = "", method
+	}
+	limit.Method = strings.ToUpper(method)
+	limit.Pattern = strings.TrimSpace(pattern)
+
+	count, blockTime, found := strings.Cut(value, ":")
+	var err error
+	if limit.Limit, err = strconv.Atoi(strings.TrimSpace(count)); err != nil {
+		return config.RouteLimit{}, fmt.Errorf("%q: invalid limit", item)
+	}
+	if found {
+		if limit.BlockTime, err = time.ParseDuration(strings.TrimSpace(blockTime)); err != nil {
+			return config.RouteLimit{}, fmt.Errorf("%q: invalid block time", item)
+		}
+	}
+	return limit, nil
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// tiers loads tiers from RATE_LIMIT_TIER_<NAME>_LIMIT, RATE_LIMIT_TIER_<NAME>_WINDOW
+// and RATE_LIMIT_TIER_<NAME>_MAX_BODY_SIZE
+func (l *parser) tiers(tiers map[string]config.TierLimit) {
+	const prefix, suffix = "RATE_LIMIT_TIER_", "_LIMIT"
+
+	for _, key := range envKeys(l.v) {
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) || len(key) <= len(prefix)+len(suffix) {
+			continue
+		}
+
+		name := key[len(prefix) : len(key)-len(suffix)]
+		tier := config.TierLimit{
+			Limit:  l.v.GetInt(key),
+			Window: defaultTierWindow,
+		}
+		if window, err := time.ParseDuration(l.v.GetString(prefix + name + "_WINDOW")); err == nil {
+			tier.Window = window
+		}
+		l.size(prefix+name+"_MAX_BODY_SIZE", &tier.MaxBodySize)
+		tiers[name] = tier
+	}
+}
+
+// setDefaults sets default configuration values
+func setDefaults(v *viper.Viper) {
+	// Server defaults
+	v.SetDefault("SERVER_PORT", "8080")
+	v.SetDefault("SERVER_HEARTBEAT_INTERVAL", "10s")
+	v.SetDefault("SERVER_LEADER_LEASE_TTL", "15s")
+	v.SetDefault("SERVER_GRPC_PORT", "")
+	v.SetDefault("SERVER_GRPC_CHANNELZ", true)
+
+	// Log defaults
+	v.SetDefault("LOG_LEVEL", "info")
+	v.SetDefault("LOG_FORMAT", config.LogFormatText)
+
+	// Redis defaults
+	v.SetDefault("REDIS_HOST", "localhost")
+	v.SetDefault("REDIS_PORT", "6379")
+	v.SetDefault("REDIS_PASSWORD", "")
+	v.SetDefault("REDIS_DB", 0)
+	v.SetDefault("REDIS_RETRY_MAX_ATTEMPTS", 1)
+	v.SetDefault("REDIS_RETRY_BASE_DELAY", "10ms")
+	v.SetDefault("REDIS_RETRY_MAX_DELAY", "200ms")
+	v.SetDefault("REDIS_RETRY_BUDGET", 0.1)
+	v.SetDefault("REDIS_TLS", false)
+	v.SetDefault("REDIS_TLS_INSECURE_SKIP_VERIFY", false)
+	v.SetDefault("REDIS_POOL_SIZE", 0)
+	v.SetDefault("REDIS_MIN_IDLE_CONNS", 0)
+	v.SetDefault("REDIS_READ_TIMEOUT", "0s")
+	v.SetDefault("REDIS_WRITE_TIMEOUT", "0s")
+	v.SetDefault("REDIS_MAX_RETRIES", 0)
+	v.SetDefault("REDIS_LOCAL_CACHE_TTL", "0s")
+	v.SetDefault("REDIS_BATCH_INTERVAL", "0s")
+	v.SetDefault("REDIS_FAULT_INJECTION", false)
+
+	// Rate limit defaults
+	v.SetDefault("RATE_LIMIT_IP_LIMIT", 10)
+	v.SetDefault("RATE_LIMIT_IP_BLOCK_TIME", "1m")
+	v.SetDefault("RATE_LIMIT_WINDOW", "1s")
+	v.SetDefault("RATE_LIMIT_MAX_BLOCK_TIME", "24h")
+	v.SetDefault("RATE_LIMIT_MAX_LIMIT", 1000000)
+	v.SetDefault("RATE_LIMIT_MIN_WINDOW", "100ms")
+	v.SetDefault("RATE_LIMIT_GLOBAL_LIMIT", 0)
+	v.SetDefault("RATE_LIMIT_GLOBAL_WINDOW", "1s")
+
+	// Gateway hint defaults
+	v.SetDefault("RATE_LIMIT_GATEWAY_HEADER", "X-Gateway-Identity")
+	v.SetDefault("RATE_LIMIT_GATEWAY_ALGORITHM", "HS256")
+
+	// Envoy rate limit service defaults
+	v.SetDefault("RATE_LIMIT_RLS", false)
+	v.SetDefault("RATE_LIMIT_RLS_IP_KEYS", "remote_address")
+	v.SetDefault("RATE_LIMIT_RLS_TOKEN_KEYS", "api_key")
+
+	// Honeypot defaults
+	v.SetDefault("RATE_LIMIT_HONEYPOT_BLOCK_TIME", "24h")
+
+	// Slow client defaults
+	v.SetDefault("SERVER_READ_HEADER_TIMEOUT", "10s")
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_READ_TIMEOUT", "30s")
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_MIN_READ_TIMEOUT", "2s")
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_WRITE_TIMEOUT", "0s")
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_MAX_STRIKES", 3)
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_STRIKE_WINDOW", "10m")
+	v.SetDefault("RATE_LIMIT_SLOW_CLIENT_BLOCK_TIME", "10m")
+
+	// Request signing defaults
+	v.SetDefault("RATE_LIMIT_SIGNING_REPLAY_WINDOW", "5m")
+	v.SetDefault("RATE_LIMIT_SIGNING_MAX_BODY_SIZE", "1MB")
+
+	// Debug defaults
+	v.SetDefault("RATE_LIMIT_DEBUG", false)
+	v.SetDefault("RATE_LIMIT_DEBUG_SAMPLE_RATE", 1.0)
+	v.SetDefault("RATE_LIMIT_DECISION_LOG", false)
+	v.SetDefault("RATE_LIMIT_DECISION_LOG_SAMPLE_RATE", 0.01)
+	v.SetDefault("RATE_LIMIT_DECISION_LOG_DENIED_SAMPLE_RATE", 1.0)
+
+	// GraphQL cost defaults
+	v.SetDefault("RATE_LIMIT_GRAPHQL_DEFAULT_WEIGHT", 1)
+	v.SetDefault("RATE_LIMIT_GRAPHQL_MAX_DEPTH", 10)
+	v.SetDefault("RATE_LIMIT_GRAPHQL_MAX_COST", 1000)
+
+	// Batch cost defaults
+	v.SetDefault("RATE_LIMIT_BATCH_MAX_COST", 100)
+
+	// Offender tracking defaults
+	v.SetDefault("RATE_LIMIT_OFFENDERS_WINDOW", "1h")
+	v.SetDefault("RATE_LIMIT_INFLIGHT_INTERVAL", "5s")
+	v.SetDefault("RATE_LIMIT_INFLIGHT_TOP", 10)
+	v.SetDefault("RATE_LIMIT_KEY_INDEX", false)
+	v.SetDefault("RATE_LIMIT_KEY_INDEX_KINDS", "ip,token")
+	v.SetDefault("RATE_LIMIT_KEY_INDEX_TTL", "1h")
+	v.SetDefault("RATE_LIMIT_KEY_INDEX_FLUSH_INTERVAL", "1s")
+	v.SetDefault("RATE_LIMIT_KEY_HISTORY_SIZE", 100)
+	v.SetDefault("RATE_LIMIT_KEY_HISTORY_TTL", "24h")
+	v.SetDefault("RATE_LIMIT_KEY_MIGRATION", false)
+	v.SetDefault("RATE_LIMIT_DENYLIST_STATUS", 403)
+	v.SetDefault("RATE_LIMIT_IPV4_PREFIX", 32)
+	v.SetDefault("RATE_LIMIT_IPV6_PREFIX", 128)
+	v.SetDefault("RATE_LIMIT_ACCESS_LIST_SYNC_INTERVAL", "5s")
+	v.SetDefault("RATE_LIMIT_BYPASS_MAX_TTL", "24h")
+	// Loopback and private networks, where reverse proxies usually run
+	v.SetDefault("RATE_LIMIT_TRUSTED_PROXIES", "127.0.0.0/8,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7")
+
+	// Error budget defaults, relaxation is disabled until a budget is set
+	v.SetDefault("RATE_LIMIT_ERROR_BUDGET", 0)
+	v.SetDefault("RATE_LIMIT_ERROR_BUDGET_WINDOW", "1m")
+	v.SetDefault("RATE_LIMIT_ERROR_BUDGET_STEP", 1.5)
+	v.SetDefault("RATE_LIMIT_ERROR_BUDGET_MAX_FACTOR", 2.0)
+	v.SetDefault("RATE_LIMIT_ERROR_BUDGET_MIN_REQUESTS", 100)
+
+	// Policy history defaults
+	v.SetDefault("RATE_LIMIT_POLICY_HISTORY_SIZE", 10)
+	v.SetDefault("RATE_LIMIT_POLICY_SYNC_INTERVAL", "5s")
+
+	// Counter checkpoint defaults
+	v.SetDefault("RATE_LIMIT_CHECKPOINT_INTERVAL", "1m")
+	v.SetDefault("RATE_LIMIT_CHECKPOINT_PREFIXES", "quota:")
+
+	// Block propagation canary defaults
+	v.SetDefault("RATE_LIMIT_CANARY_INTERVAL", "0s")
+	v.SetDefault("RATE_LIMIT_CANARY_SLO", "1s")
+
+	// SIEM export defaults
+	v.SetDefault("SIEM_EVENTS", "blocked,honeypot")
+
+	// WAF export defaults
+	v.SetDefault("WAF_SYNC_INTERVAL", "1m")
+	v.SetDefault("WAF_AWS_SCOPE", "REGIONAL")
+
+	// Quota headers are visible to everyone by default
+	v.SetDefault("RATE_LIMIT_HEADER_VISIBILITY", "full")
+	v.SetDefault("RATE_LIMIT_HEADER_FORMAT", "legacy")
+	v.SetDefault("RATE_LIMIT_FAILURE_MODE", "open")
+	v.SetDefault("RATE_LIMIT_READ_ONLY", false)
+	v.SetDefault("RATE_LIMIT_BLOCK_SERVER_CLOCK", false)
+	v.SetDefault("RATE_LIMIT_REQUEST_DEADLINE", false)
+	v.SetDefault("RATE_LIMIT_SLIDING_LOG_RETENTION", "0s")
+
+	// Counting algorithms
+	v.SetDefault("RATE_LIMIT_ALGORITHM", config.AlgorithmFixedWindow)
+
+	// Credential sources, first match wins
+	v.SetDefault("RATE_LIMIT_TOKEN_SOURCES", "header:API_KEY")
+	v.SetDefault("RATE_LIMIT_CREDENTIAL_CONFLICT", "first")
+	v.SetDefault("RATE_LIMIT_ROUTES_PER_ENDPOINT", false)
+
+	// Local fallback defaults
+	v.SetDefault("RATE_LIMIT_FALLBACK_RATIO", 1.0)
+	v.SetDefault("RATE_LIMIT_FALLBACK_INSTANCE_COUNT", 1)
+}
@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadForgetsRemovedFileSettings reloads after settings were removed from
+// the config file, as the watcher does, and expects their defaults back
+func TestLoadForgetsRemovedFileSettings(t *testing.T) {
+	for _, key := range []string{"RATE_LIMIT_IP_LIMIT", "RATE_LIMIT_IP_WINDOWS", "RATE_LIMIT_PRESET"} {
+		t.Setenv(key, "")
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("CONFIG_FILE", path)
+
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("rate_limit:\n  ip_limit: 42\n  ip_windows:\n    - {limit: 100, window: 1m}\n")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimit.IPLimit != 42 || len(cfg.RateLimit.IPWindows) != 1 {
+		t.Fatalf("loaded ip_limit %d and ip_windows %v, want the file's", cfg.RateLimit.IPLimit, cfg.RateLimit.IPWindows)
+	}
+
+	write("rate_limit:\n  ip_limit: 42\n")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.RateLimit.IPWindows) != 0 {
+		t.Errorf("ip_windows removed from the file loads as %v, want none", cfg.RateLimit.IPWindows)
+	}
+	if cfg.RateLimit.IPLimit != 42 {
+		t.Errorf("ip_limit loads as %d, want the file's 42", cfg.RateLimit.IPLimit)
+	}
+
+	// The environment still overrides the file
+	t.Setenv("RATE_LIMIT_IP_LIMIT", "7")
+	if cfg, err = Load(); err != nil || cfg.RateLimit.IPLimit != 7 {
+		t.Errorf("Load = %v, %v, want ip_limit 7 from the environment", cfg, err)
+	}
+}
@@ -0,0 +1,146 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/spf13/viper"
+)
+
+// fieldErrorf creates a config.FieldError with a formatted message
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &config.FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// parser reads typed values from viper, collecting parse errors instead of
+// failing on the first one. Empty values leave the target unchanged
+type parser struct {
+	v    *viper.Viper
+	errs []error
+}
+
+// merge appends the validation errors to the parse errors, skipping fields
+// that already failed to parse since their value was never applied
+func (l *parser) merge(validation error) []error {
+	var problems []error
+	if joined, ok := validation.(interface{ Unwrap() []error }); ok {
+		problems = joined.Unwrap()
+	}
+
+	failed := make(map[string]bool, len(l.errs))
+	for _, err := range l.errs {
+		var fieldErr *config.FieldError
+		if errors.As(err, &fieldErr) {
+			failed[fieldErr.Field] = true
+		}
+	}
+
+	errs := l.errs
+	for _, err := range problems {
+		var fieldErr *config.FieldError
+		if errors.As(err, &fieldErr) && failed[fieldErr.Field] {
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (l *parser) duration(key string, target *time.Duration) {
+	value := strings.TrimSpace(l.v.GetString(key))
+	if value == "" {
+		return
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(key, "invalid duration %q", value))
+		return
+	}
+	*target = parsed
+}
+
+func (l *parser) integer(key string, target *int) {
+	value := strings.TrimSpace(l.v.GetString(key))
+	if value == "" {
+		return
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(key, "invalid integer %q", value))
+		return
+	}
+	*target = parsed
+}
+
+// windows reads a list of limit/window pairs such as 300/1m,5000/1h
+func (l *parser) windows(key string, target *[]config.WindowLimit) {
+	value := strings.TrimSpace(l.v.GetString(key))
+	if value == "" {
+		return
+	}
+
+	limits, err := config.ParseWindowLimits(value)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(key, "%v", err))
+		return
+	}
+	*target = limits
+}
+
+// size reads a byte size such as 1048576, 512KB or 10MB (multiples of 1024)
+func (l *parser) size(key string, target *int64) {
+	value := strings.TrimSpace(l.v.GetString(key))
+	if value == "" {
+		return
+	}
+
+	parsed, err := parseSize(value)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(key, "invalid size %q", value))
+		return
+	}
+	*target = parsed
+}
+
+// parseSize parses a byte count with an optional KB, MB or GB suffix
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	upper := strings.ToUpper(value)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			upper, multiplier = strings.TrimSpace(number), unit.multiplier
+			break
+		}
+	}
+
+	parsed, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return parsed * multiplier, nil
+}
+
+func (l *parser) float(key string, target *float64) {
+	value := strings.TrimSpace(l.v.GetString(key))
+	if value == "" {
+		return
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.errs = append(l.errs, fieldErrorf(key, "invalid number %q", value))
+		return
+	}
+	*target = parsed
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// Watch loads the configuration again whenever the .env or structured config
+// file changes or the process receives SIGHUP, calling onChange with each valid result until ctx
+// is done. Invalid configurations are logged and skipped, keeping the current one
+func Watch(ctx context.Context, onChange func(*config.Config)) {
+	var mu sync.Mutex
+	reload := func(trigger string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cfg, err := Load()
+		if err != nil {
+			slog.Error("Invalid configuration, keeping the current one", "trigger", trigger, "error", err)
+			return
+		}
+		slog.Info("Configuration reloaded", "trigger", trigger)
+		onChange(cfg)
+	}
+
+	// Only a .env file found as Load finds it can be watched; this instance is
+	// kept for the notifications, each reload reads the files anew
+	v := newViper()
+	if err := v.ReadInConfig(); err == nil {
+		v.OnConfigChange(func(event fsnotify.Event) {
+			if ctx.Err() == nil {
+				reload("file")
+			}
+		})
+		v.WatchConfig()
+	}
+	if path := FilePath(); path != "" {
+		if err := watchFile(ctx, path, func() { reload("file") }); err != nil {
+			slog.Error("Failed to watch config file", "path", path, "error", err)
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				reload("signal")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// watchFile calls onChange when the file is written or replaced. The
+// directory is watched, since editors and ConfigMap updates replace the file
+func watchFile(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create) {
+					onChange()
+				}
+			case err := <-watcher.Errors:
+				slog.Error("Config file watch failed", "path", path, "error", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
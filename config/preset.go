@@ -0,0 +1,56 @@
+package config
+
+import "sort"
+
+// Presets bundling the precision and cost choices of the rate limiter
+const (
+	// PresetStrict favors exact enforcement: sliding logs, Redis-clock blocks
+	// and rejecting requests when the storage fails
+	PresetStrict = "strict"
+	// PresetBalanced counts in fixed windows and falls back to local limits
+	// when the storage fails
+	PresetBalanced = "balanced"
+	// PresetHighThroughput minimizes storage round trips: fixed windows, no
+	// retries, no per-key timeline and letting requests through on failures
+	PresetHighThroughput = "high-throughput"
+)
+
+// presets holds the settings of each preset. They are applied as defaults,
+// so settings configured explicitly still win
+var presets = map[string]map[string]any{
+	PresetStrict: {
+		"RATE_LIMIT_ALGORITHM":          AlgorithmSlidingLog,
+		"RATE_LIMIT_BLOCK_SERVER_CLOCK": true,
+		"RATE_LIMIT_FAILURE_MODE":       FailureClosed,
+		"REDIS_RETRY_MAX_ATTEMPTS":      3,
+	},
+	PresetBalanced: {
+		"RATE_LIMIT_ALGORITHM":     AlgorithmFixedWindow,
+		"RATE_LIMIT_FAILURE_MODE":  FailureLocal,
+		"REDIS_RETRY_MAX_ATTEMPTS": 2,
+	},
+	PresetHighThroughput: {
+		"RATE_LIMIT_ALGORITHM":         AlgorithmFixedWindow,
+		"RATE_LIMIT_FAILURE_MODE":      FailureOpen,
+		"REDIS_RETRY_MAX_ATTEMPTS":     1,
+		"RATE_LIMIT_KEY_HISTORY_SIZE":  0,
+		"RATE_LIMIT_INFLIGHT_INTERVAL": "0s",
+		"REDIS_BATCH_INTERVAL":         "10ms",
+		"REDIS_LOCAL_CACHE_TTL":        "100ms",
+	},
+}
+
+// Presets returns the names of the available presets
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetSettings returns the settings a preset applies, nil for unknown presets
+func PresetSettings(name string) map[string]any {
+	return presets[name]
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with a single configuration field, identified by its
+// environment variable
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// knownMethods are the HTTP methods accepted in route limits
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "OPTIONS": true, "POST": true,
+	"PUT": true, "PATCH": true, "DELETE": true, "CONNECT": true, "TRACE": true,
+}
+
+// siemEvents are the event types that can be exported to a SIEM
+var siemEvents = map[string]bool{"blocked": true, "honeypot": true, "limited": true, "relaxed": true, "unblocked": true}
+
+// fieldErrorf creates a FieldError with a formatted message
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate checks the configuration for missing, out of range and conflicting
+// values, returning every problem found joined in a single error
+func (c *Config) Validate() error {
+	return errors.Join(c.validate()...)
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fieldErrorf(field, format, args...))
+		}
+	}
+
+	check(c.Server.Port != "", "SERVER_PORT", "is required")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "SERVER_TLS_KEY_FILE", "must be set together with SERVER_TLS_CERT_FILE")
+	check(c.Server.TLSClientCAFile == "" || c.Server.TLSCertFile != "", "SERVER_TLS_CLIENT_CA_FILE", "requires SERVER_TLS_CERT_FILE")
+	check(len(c.Admin.ReadSubjects)+len(c.Admin.WriteSubjects) == 0 || c.Server.TLSClientCAFile != "", "ADMIN_WRITE_CERT_SUBJECTS",
+		"client certificate subjects require SERVER_TLS_CLIENT_CA_FILE")
+	for _, token := range append(append([]string{}, c.Admin.ReadTokens...), c.Admin.WriteTokens...) {
+		check(len(token) >= 16, "ADMIN_WRITE_TOKENS", "admin tokens must have at least 16 characters")
+	}
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL", "unknown level %q, expected debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == LogFormatText || c.Log.Format == LogFormatJSON, "LOG_FORMAT", "unknown format %q, expected text or json", c.Log.Format)
+	if c.Server.GRPCPort != "" {
+		_, err := strconv.Atoi(c.Server.GRPCPort)
+		check(err == nil, "SERVER_GRPC_PORT", "invalid port %q", c.Server.GRPCPort)
+		check(c.Server.GRPCPort != c.Server.Port, "SERVER_GRPC_PORT", "must differ from SERVER_PORT")
+	}
+	check(c.Redis.Host != "", "REDIS_HOST", "is required")
+	_, err := strconv.Atoi(c.Redis.Port)
+	check(err == nil, "REDIS_PORT", "invalid port %q", c.Redis.Port)
+	check(c.Redis.Retry.MaxAttempts >= 1, "REDIS_RETRY_MAX_ATTEMPTS", "must be at least 1, got %d", c.Redis.Retry.MaxAttempts)
+	check(c.Redis.Retry.BaseDelay <= c.Redis.Retry.MaxDelay, "REDIS_RETRY_BASE_DELAY", "must not exceed REDIS_RETRY_MAX_DELAY (%s)", c.Redis.Retry.MaxDelay)
+	check(c.Redis.Retry.Budget >= 0 && c.Redis.Retry.Budget <= 1, "REDIS_RETRY_BUDGET", "must be between 0 and 1, got %g", c.Redis.Retry.Budget)
+	redisTLS := c.Redis.TLS
+	check(redisTLS.Enabled || (redisTLS.CAFile == "" && redisTLS.CertFile == "" && redisTLS.KeyFile == ""), "REDIS_TLS", "must be true when TLS files are set")
+	if (redisTLS.CertFile == "") != (redisTLS.KeyFile == "") {
+		errs = append(errs, fieldErrorf("REDIS_TLS_CERT_FILE", "and REDIS_TLS_KEY_FILE must be set together"))
+	} else if _, err := redisTLS.Config(); err != nil {
+		errs = append(errs, fieldErrorf("REDIS_TLS", "%v", err))
+	}
+	pool := c.Redis.Pool
+	check(pool.Size >= 0, "REDIS_POOL_SIZE", "must not be negative, got %d", pool.Size)
+	check(pool.MinIdleConns >= 0, "REDIS_MIN_IDLE_CONNS", "must not be negative, got %d", pool.MinIdleConns)
+	check(pool.Size == 0 || pool.MinIdleConns <= pool.Size, "REDIS_MIN_IDLE_CONNS", "must not exceed REDIS_POOL_SIZE (%d)", pool.Size)
+	check(pool.ReadTimeout >= 0, "REDIS_READ_TIMEOUT", "must not be negative")
+	check(pool.WriteTimeout >= 0, "REDIS_WRITE_TIMEOUT", "must not be negative")
+	check(pool.MaxRetries >= -1, "REDIS_MAX_RETRIES", "must be at least -1, got %d", pool.MaxRetries)
+	check(c.Redis.LocalCacheTTL >= 0 && c.Redis.LocalCacheTTL <= time.Second, "REDIS_LOCAL_CACHE_TTL", "must be between 0s and 1s, got %s", c.Redis.LocalCacheTTL)
+	check(c.Redis.BatchInterval >= 0 && c.Redis.BatchInterval <= time.Second, "REDIS_BATCH_INTERVAL", "must be between 0s and 1s, got %s", c.Redis.BatchInterval)
+	check(!c.Redis.FaultInjection || len(c.Admin.WriteTokens)+len(c.Admin.WriteSubjects) > 0, "REDIS_FAULT_INJECTION",
+		"requires ADMIN_WRITE_TOKENS or ADMIN_WRITE_CERT_SUBJECTS, so only operators can degrade Redis")
+
+	rl := c.RateLimit
+	check(rl.IPLimit > 0, "RATE_LIMIT_IP_LIMIT", "must be positive, got %d", rl.IPLimit)
+	check(rl.IPBlockTime >= 0, "RATE_LIMIT_IP_BLOCK_TIME", "must not be negative")
+	check(rl.Window > 0, "RATE_LIMIT_WINDOW", "must be positive")
+	check(rl.GlobalLimit >= 0, "RATE_LIMIT_GLOBAL_LIMIT", "must not be negative")
+	check(rl.GlobalLimit == 0 || rl.GlobalWindow > 0, "RATE_LIMIT_GLOBAL_WINDOW", "must be positive")
+	// A burst is the capacity of the token bucket, other algorithms have none
+	check(rl.IPBurst == 0 || rl.IPBurst >= rl.IPLimit, "RATE_LIMIT_IP_BURST", "must be 0 or at least RATE_LIMIT_IP_LIMIT (%d), got %d", rl.IPLimit, rl.IPBurst)
+	for _, window := range rl.IPWindows {
+		check(window.Limit > 0 && window.Window > 0, "RATE_LIMIT_IP_WINDOWS", "limit and window of %s must be positive", window)
+	}
+	check(rl.IPBurst == 0 || rl.IPAlgorithm == AlgorithmTokenBucket, "RATE_LIMIT_IP_BURST", "requires RATE_LIMIT_IP_ALGORITHM=%s", AlgorithmTokenBucket)
+	for token, limit := range rl.TokenLimits {
+		check(limit.Limit > 0, "RATE_LIMIT_TOKEN_"+token+"_LIMIT", "must be positive, got %d", limit.Limit)
+		check(limit.Window >= 0, "RATE_LIMIT_TOKEN_"+token+"_WINDOW", "must not be negative")
+		check(limit.Burst == 0 || limit.Burst >= limit.Limit, "RATE_LIMIT_TOKEN_"+token+"_BURST", "must be 0 or at least the token limit (%d), got %d", limit.Limit, limit.Burst)
+		for _, window := range limit.Windows {
+			check(window.Limit > 0 && window.Window > 0, "RATE_LIMIT_TOKEN_"+token+"_WINDOWS", "limit and window of %s must be positive", window)
+		}
+		check(limit.DailyQuota >= 0, "RATE_LIMIT_TOKEN_"+token+"_DAILY_QUOTA", "must not be negative")
+		check(limit.MonthlyQuota >= 0, "RATE_LIMIT_TOKEN_"+token+"_MONTHLY_QUOTA", "must not be negative")
+		check(limit.Burst == 0 || rl.TokenAlgorithm == AlgorithmTokenBucket, "RATE_LIMIT_TOKEN_"+token+"_BURST", "requires RATE_LIMIT_TOKEN_ALGORITHM=%s", AlgorithmTokenBucket)
+	}
+
+	caps := rl.Caps
+	check(caps.MaxBlockTime >= 0, "RATE_LIMIT_MAX_BLOCK_TIME", "must not be negative")
+	check(caps.MaxLimit >= 0, "RATE_LIMIT_MAX_LIMIT", "must not be negative")
+	check(caps.MinWindow >= 0, "RATE_LIMIT_MIN_WINDOW", "must not be negative")
+	capped := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &FieldError{Field: field, Err: err})
+		}
+	}
+	capped("RATE_LIMIT_IP_LIMIT", caps.limit(rl.IPLimit))
+	capped("RATE_LIMIT_IP_BLOCK_TIME", caps.blockTime(rl.IPBlockTime))
+	capped("RATE_LIMIT_IP_BURST", caps.limit(rl.IPBurst))
+	capped("RATE_LIMIT_IP_WINDOWS", caps.windows(rl.IPWindows))
+	capped("RATE_LIMIT_WINDOW", caps.window(rl.Window))
+	for token, limit := range rl.TokenLimits {
+		capped("RATE_LIMIT_TOKEN_"+token+"_LIMIT", caps.limit(limit.Limit))
+		capped("RATE_LIMIT_TOKEN_"+token+"_BLOCK_TIME", caps.blockTime(limit.BlockTime))
+		capped("RATE_LIMIT_TOKEN_"+token+"_WINDOW", caps.window(limit.Window))
+		capped("RATE_LIMIT_TOKEN_"+token+"_BURST", caps.limit(limit.Burst))
+		capped("RATE_LIMIT_TOKEN_"+token+"_WINDOWS", caps.windows(limit.Windows))
+	}
+	for name, tier := range rl.Tiers {
+		capped("RATE_LIMIT_TIER_"+name+"_LIMIT", caps.limit(tier.Limit))
+		capped("RATE_LIMIT_TIER_"+name+"_WINDOW", caps.window(tier.Window))
+	}
+	for _, route := range rl.Routes {
+		id := strings.TrimSpace(route.Method + " " + route.Pattern)
+		if err := errors.Join(caps.limit(route.Limit), caps.blockTime(route.BlockTime)); err != nil {
+			errs = append(errs, fieldErrorf("RATE_LIMIT_ROUTES", "%s: %v", id, err))
+		}
+	}
+	capped("RATE_LIMIT_HONEYPOT_BLOCK_TIME", caps.blockTime(rl.Honeypot.BlockTime))
+	capped("RATE_LIMIT_SLOW_CLIENT_BLOCK_TIME", caps.blockTime(rl.SlowClient.BlockTime))
+
+	for _, field := range []struct{ name, algorithm string }{
+		{"RATE_LIMIT_ALGORITHM", rl.Algorithm},
+		{"RATE_LIMIT_IP_ALGORITHM", rl.IPAlgorithm},
+		{"RATE_LIMIT_TOKEN_ALGORITHM", rl.TokenAlgorithm},
+		{"RATE_LIMIT_IP_SHADOW_ALGORITHM", rl.IPShadowAlgorithm},
+		{"RATE_LIMIT_TOKEN_SHADOW_ALGORITHM", rl.TokenShadowAlgorithm},
+	} {
+		switch field.algorithm {
+		case "", AlgorithmFixedWindow, AlgorithmSlidingLog, AlgorithmTokenBucket, AlgorithmLeakyBucket:
+		default:
+			errs = append(errs, fieldErrorf(field.name, "unknown algorithm %q", field.algorithm))
+		}
+	}
+	check(rl.SlidingLogRetention >= 0, "RATE_LIMIT_SLIDING_LOG_RETENTION", "must not be negative")
+	check(rl.IPBucket.Capacity >= 0, "RATE_LIMIT_IP_BUCKET_CAPACITY", "must not be negative")
+	check(rl.IPBucket.RefillRate >= 0, "RATE_LIMIT_IP_REFILL_RATE", "must not be negative")
+	check(rl.TokenBucket.Capacity >= 0, "RATE_LIMIT_TOKEN_BUCKET_CAPACITY", "must not be negative")
+	check(rl.TokenBucket.RefillRate >= 0, "RATE_LIMIT_TOKEN_REFILL_RATE", "must not be negative")
+
+	routes := make(map[string]bool)
+	for _, route := range rl.Routes {
+		id := strings.TrimSpace(route.Method + " " + route.Pattern)
+		check(route.Limit > 0, "RATE_LIMIT_ROUTES", "%s: limit must be positive", id)
+		for _, method := range route.Methods() {
+			check(knownMethods[method], "RATE_LIMIT_ROUTES", "%s: unknown method %q", id, method)
+		}
+		check(!routes[id], "RATE_LIMIT_ROUTES", "%s: conflicting limits for the same route", id)
+		routes[id] = true
+	}
+
+	for _, template := range rl.RouteTemplates {
+		check(strings.HasPrefix(template, "/"), "RATE_LIMIT_ROUTE_TEMPLATES", "%q must start with /", template)
+	}
+
+	for method, policy := range rl.MethodPolicies {
+		check(knownMethods[method], "RATE_LIMIT_METHOD_POLICIES", "unknown method %q", method)
+		check(policy == MethodCount || policy == MethodIgnore || policy == MethodSeparate, "RATE_LIMIT_METHOD_POLICIES",
+			"%s: unknown policy %q, expected count, ignore or separate", method, policy)
+	}
+
+	check(rl.MaxBodySize >= 0, "RATE_LIMIT_MAX_BODY_SIZE", "must not be negative")
+	for name, tier := range rl.Tiers {
+		check(tier.MaxBodySize >= 0, "RATE_LIMIT_TIER_"+name+"_MAX_BODY_SIZE", "must not be negative")
+	}
+
+	check(rl.Debug.SampleRate >= 0 && rl.Debug.SampleRate <= 1, "RATE_LIMIT_DEBUG_SAMPLE_RATE", "must be between 0 and 1")
+	check(rl.DecisionLog.SampleRate >= 0 && rl.DecisionLog.SampleRate <= 1, "RATE_LIMIT_DECISION_LOG_SAMPLE_RATE", "must be between 0 and 1")
+	check(rl.DecisionLog.DeniedSampleRate >= 0 && rl.DecisionLog.DeniedSampleRate <= 1, "RATE_LIMIT_DECISION_LOG_DENIED_SAMPLE_RATE", "must be between 0 and 1")
+	check(rl.Preset == "" || presets[rl.Preset] != nil, "RATE_LIMIT_PRESET", "unknown preset %q, expected one of %s", rl.Preset, strings.Join(Presets(), ", "))
+	check(rl.FailureMode == FailureOpen || rl.FailureMode == FailureClosed || rl.FailureMode == FailureLocal, "RATE_LIMIT_FAILURE_MODE",
+		"unknown mode %q, expected open, closed or local", rl.FailureMode)
+	check(rl.Fallback.Ratio > 0 && rl.Fallback.Ratio <= 1, "RATE_LIMIT_FALLBACK_RATIO", "must be greater than 0 and at most 1")
+	check(rl.CredentialConflict == CredentialFirst || rl.CredentialConflict == CredentialReject || rl.CredentialConflict == CredentialAll,
+		"RATE_LIMIT_CREDENTIAL_CONFLICT", "unknown mode %q, expected first, reject or all", rl.CredentialConflict)
+
+	slow := rl.SlowClient
+	check(slow.MinReadTimeout <= slow.ReadTimeout || slow.ReadTimeout == 0, "RATE_LIMIT_SLOW_CLIENT_MIN_READ_TIMEOUT",
+		"%s exceeds RATE_LIMIT_SLOW_CLIENT_READ_TIMEOUT %s", slow.MinReadTimeout, slow.ReadTimeout)
+
+	check(!rl.Signing.Required || rl.Signing.Secret != "", "RATE_LIMIT_SIGNING_REQUIRED", "requires RATE_LIMIT_SIGNING_SECRET")
+	check(rl.Signing.Secret == "" || rl.Signing.MaxBodySize > 0, "RATE_LIMIT_SIGNING_MAX_BODY_SIZE", "must be positive")
+	check(rl.Canary.Interval == 0 || rl.Canary.SLO > 0, "RATE_LIMIT_CANARY_SLO", "must be positive when canaries are enabled")
+	check(rl.Checkpoint.Store == "" || len(rl.Checkpoint.Prefixes) > 0, "RATE_LIMIT_CHECKPOINT_PREFIXES", "is required when RATE_LIMIT_CHECKPOINT_STORE is set")
+	check(rl.PolicyHistory.Size > 0, "RATE_LIMIT_POLICY_HISTORY_SIZE", "must be positive")
+	check(rl.InFlight.Interval >= 0, "RATE_LIMIT_INFLIGHT_INTERVAL", "must not be negative")
+	check(rl.InFlight.Interval == 0 || rl.InFlight.Top > 0, "RATE_LIMIT_INFLIGHT_TOP", "must be positive, got %d", rl.InFlight.Top)
+	if index := rl.KeyIndex; index.Enabled {
+		check(len(index.Kinds) > 0, "RATE_LIMIT_KEY_INDEX_KINDS", "is required when RATE_LIMIT_KEY_INDEX is enabled")
+		check(index.TTL > 0, "RATE_LIMIT_KEY_INDEX_TTL", "must be positive")
+		check(index.FlushInterval > 0, "RATE_LIMIT_KEY_INDEX_FLUSH_INTERVAL", "must be positive")
+	}
+	check(rl.KeyHistory.Size >= 0, "RATE_LIMIT_KEY_HISTORY_SIZE", "must not be negative, got %d", rl.KeyHistory.Size)
+	check(rl.KeyHistory.Size == 0 || rl.KeyHistory.TTL > 0, "RATE_LIMIT_KEY_HISTORY_TTL", "must be positive")
+
+	check(rl.IPv4Prefix >= 1 && rl.IPv4Prefix <= 32, "RATE_LIMIT_IPV4_PREFIX", "must be between 1 and 32")
+	check(rl.IPv6Prefix >= 1 && rl.IPv6Prefix <= 128, "RATE_LIMIT_IPV6_PREFIX", "must be between 1 and 128")
+	for _, transform := range rl.IPKeyTransforms {
+		check(!rl.IPAggregated() || !strings.HasPrefix(strings.TrimSpace(transform), "ip_prefix"), "RATE_LIMIT_IP_KEY_TRANSFORMS",
+			"ip_prefix conflicts with RATE_LIMIT_IPV4_PREFIX/RATE_LIMIT_IPV6_PREFIX")
+	}
+
+	for _, cidr := range rl.Force.TrustedCIDRs {
+		_, _, err := net.ParseCIDR(cidr)
+		check(err == nil || net.ParseIP(cidr) != nil, "RATE_LIMIT_FORCE_TRUSTED_CIDRS", "invalid network %q", cidr)
+	}
+	check(rl.Bypass.Secret == "" || len(rl.Bypass.Secret) >= 32, "RATE_LIMIT_BYPASS_SECRET", "must be at least 32 characters")
+	check(rl.Bypass.MaxTTL > 0, "RATE_LIMIT_BYPASS_MAX_TTL", "must be positive")
+
+	if gateway := rl.Gateway; gateway.Enabled() {
+		check(gateway.Header != "", "RATE_LIMIT_GATEWAY_HEADER", "must not be empty")
+		switch gateway.Algorithm {
+		case "HS256":
+			check(len(gateway.Secret) >= 32, "RATE_LIMIT_GATEWAY_SECRET", "must be at least 32 characters for HS256")
+			check(gateway.PublicKeyFile == "", "RATE_LIMIT_GATEWAY_PUBLIC_KEY_FILE", "is not used by HS256, set RATE_LIMIT_GATEWAY_ALGORITHM")
+		case "RS256", "ES256":
+			check(gateway.PublicKeyFile != "", "RATE_LIMIT_GATEWAY_PUBLIC_KEY_FILE", "is required by %s", gateway.Algorithm)
+			check(gateway.Secret == "", "RATE_LIMIT_GATEWAY_SECRET", "is not used by %s", gateway.Algorithm)
+		default:
+			errs = append(errs, fieldErrorf("RATE_LIMIT_GATEWAY_ALGORITHM", "unknown algorithm %q, expected HS256, RS256 or ES256", gateway.Algorithm))
+		}
+		check(len(rl.Tiers) > 0, "RATE_LIMIT_TIER_<NAME>_LIMIT", "at least one tier is required by gateway hints")
+	}
+	if rl.RLS.Enabled {
+		check(c.Server.GRPCPort != "", "RATE_LIMIT_RLS", "requires SERVER_GRPC_PORT")
+		check(len(rl.RLS.IPKeys)+len(rl.RLS.TokenKeys) > 0, "RATE_LIMIT_RLS_IP_KEYS", "at least one IP or token descriptor key is required")
+	}
+	for _, proxy := range rl.Trust.Proxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "RATE_LIMIT_TRUSTED_PROXIES", "invalid network %q", proxy)
+	}
+	for field, entries := range map[string][]string{
+		"RATE_LIMIT_ALLOWLIST": rl.AccessLists.Allow,
+		"RATE_LIMIT_DENYLIST":  rl.AccessLists.Deny,
+	} {
+		for _, entry := range entries {
+			_, _, err := net.ParseCIDR(entry)
+			check(err == nil || net.ParseIP(entry) != nil, field, "invalid network %q", entry)
+		}
+	}
+	check(rl.AccessLists.DenyStatus == http.StatusForbidden || rl.AccessLists.DenyStatus == http.StatusTooManyRequests,
+		"RATE_LIMIT_DENYLIST_STATUS", "must be 403 or 429")
+	check(rl.AccessLists.SyncInterval > 0, "RATE_LIMIT_ACCESS_LIST_SYNC_INTERVAL", "must be positive")
+
+	budget := rl.ErrorBudget
+	check(budget.Budget >= 0 && budget.Budget < 1, "RATE_LIMIT_ERROR_BUDGET", "must be at least 0 and below 1, got %g", budget.Budget)
+	if budget.Budget > 0 {
+		check(budget.Window > 0, "RATE_LIMIT_ERROR_BUDGET_WINDOW", "must be positive")
+		check(budget.Step > 1, "RATE_LIMIT_ERROR_BUDGET_STEP", "must be greater than 1, got %g", budget.Step)
+		check(budget.MaxFactor >= budget.Step, "RATE_LIMIT_ERROR_BUDGET_MAX_FACTOR", "must be at least RATE_LIMIT_ERROR_BUDGET_STEP (%g)", budget.Step)
+		check(budget.MinRequests >= 0, "RATE_LIMIT_ERROR_BUDGET_MIN_REQUESTS", "must not be negative")
+	}
+
+	if c.SIEM.Address != "" {
+		scheme, _, _ := strings.Cut(c.SIEM.Address, "://")
+		check(scheme == "udp" || scheme == "tcp", "SIEM_ADDRESS", "must be udp://host:port or tcp://host:port, got %q", c.SIEM.Address)
+		for _, eventType := range c.SIEM.Events {
+			check(siemEvents[eventType], "SIEM_EVENTS", "unknown event type %q", eventType)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values, followed by the key ID and the sealed data
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned when a value was encrypted with a key that is no
+// longer in the keyring
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring encrypts values with AES-GCM under its primary key and decrypts
+// values sealed with any of its keys, so keys can be rotated by adding a new
+// primary and keeping the old ones until every value is re-encrypted. A nil
+// keyring stores values in plain text
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// ParseKeys parses "id:base64key" entries, the first one being the primary.
+// Keys must be 16, 24 or 32 bytes (AES-128, AES-192 or AES-256)
+func ParseKeys(entries []string) (*Keyring, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	k := &Keyring{aeads: make(map[string]cipher.AEAD, len(entries))}
+	for _, entry := range entries {
+		id, encoded, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("invalid key entry: expected id:base64key")
+		}
+		if _, exists := k.aeads[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid base64: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+
+		k.aeads[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key new values are encrypted with
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Encrypt seals a value under the primary key. The storage key is
+// authenticated with it, so a value cannot be moved to another key
+func (k *Keyring) Encrypt(storageKey string, plaintext []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(storageKey))
+	return []byte(prefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt opens a value sealed by Encrypt. Plain text values are returned
+// unchanged, so encryption can be enabled on existing data
+func (k *Keyring) Decrypt(storageKey string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte(prefix)) {
+		return value, nil
+	}
+	if k == nil {
+		return nil, fmt.Errorf("value is encrypted but no encryption keys are configured")
+	}
+
+	id, encoded, found := strings.Cut(string(value[len(prefix):]), ":")
+	if !found {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(storageKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value with key %q: %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// Current reports whether a value is already sealed under the primary key, or
+// stored in plain text without a keyring
+func (k *Keyring) Current(value []byte) bool {
+	if k == nil {
+		return !bytes.HasPrefix(value, []byte(prefix))
+	}
+	return bytes.HasPrefix(value, []byte(prefix+k.primary+":"))
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// Event types emitted by the rate limiter
+const (
+	TypeBlocked  = "blocked"
+	TypeHoneypot = "honeypot"
+	TypeLimited  = "limited"
+	// TypeRelaxed reports limits relaxed or restored by the error budget
+	TypeRelaxed = "relaxed"
+	// TypeUnblocked reports a block lifted by an operator
+	TypeUnblocked = "unblocked"
+)
+
+// Event describes something notable that happened in the rate limiter
+type Event struct {
+	Type      string        `json:"type"`
+	Key       string        `json:"key"`
+	Policy    string        `json:"policy,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	BlockTime time.Duration `json:"block_time,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	// RequestID is the ID of the request that caused the event, if known
+	RequestID string    `json:"request_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sink receives emitted events
+type Sink interface {
+	Handle(event Event)
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(event Event)
+
+// Handle calls f(event)
+func (f SinkFunc) Handle(event Event) {
+	f(event)
+}
+
+// Bus fans events out to the subscribed sinks
+type Bus struct {
+	mu    sync.RWMutex
+	sinks []Sink
+}
+
+// NewBus creates an event bus that logs every event
+func NewBus() *Bus {
+	return &Bus{
+		sinks: []Sink{SinkFunc(logEvent)},
+	}
+}
+
+// Subscribe adds a sink to the bus
+func (b *Bus) Subscribe(sink Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, sink)
+}
+
+// Emit delivers an event to all sinks
+func (b *Bus) Emit(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	metrics.Events.WithLabelValues(event.Type).Inc()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sink := range b.sinks {
+		sink.Handle(event)
+	}
+}
+
+// logEvent is the default sink writing events to the standard logger.
+// Limited events are skipped since they fire on every rejected request
+func logEvent(event Event) {
+	if event.Type == TypeLimited {
+		return
+	}
+	slog.Info("Event", "type", event.Type, "key", event.Key, "reason", event.Reason, "block_time", event.BlockTime, "path", event.Path, "request_id", event.RequestID)
+}
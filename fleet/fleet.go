@@ -0,0 +1,189 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const keyPrefix = "fleet:instance:"
+
+// Instance describes a running server instance as seen by the fleet
+type Instance struct {
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname"`
+	Version   string    `json:"version"`
+	Mode      string    `json:"mode"`
+	QPS       float64   `json:"qps"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Registry registers the current instance in Redis and keeps it alive with heartbeats
+type Registry struct {
+	client   *redis.Client
+	interval time.Duration
+
+	mu       sync.Mutex
+	self     Instance
+	requests atomic.Uint64
+	lastBeat time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRegistry creates a registry for the current instance
+func NewRegistry(client *redis.Client, version, mode string, interval time.Duration) *Registry {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	now := time.Now()
+	return &Registry{
+		client:   client,
+		interval: interval,
+		self: Instance{
+			ID:        fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+			Hostname:  hostname,
+			Version:   version,
+			Mode:      mode,
+			StartedAt: now,
+		},
+		lastBeat: now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// ID returns the unique identifier of this instance
+func (r *Registry) ID() string {
+	return r.self.ID
+}
+
+// SetMode updates the enforcement mode reported by this instance
+func (r *Registry) SetMode(mode string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.self.Mode = mode
+}
+
+// Middleware counts served requests so the instance can report its QPS
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests.Add(1)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Start registers the instance and sends heartbeats until Stop is called
+func (r *Registry) Start(ctx context.Context) {
+	if err := r.heartbeat(ctx); err != nil {
+		slog.Error("Failed to register instance", "error", err)
+	}
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.heartbeat(context.Background()); err != nil {
+					slog.Error("Failed to send fleet heartbeat", "error", err)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the heartbeats and removes the instance from the fleet
+func (r *Registry) Stop(ctx context.Context) error {
+	close(r.stop)
+	<-r.done
+	return r.client.Del(ctx, keyPrefix+r.self.ID).Err()
+}
+
+// heartbeat refreshes the instance entry with its current QPS
+func (r *Registry) heartbeat(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(r.lastBeat).Seconds()
+	r.lastBeat = now
+
+	requests := r.requests.Swap(0)
+	if elapsed > 0 {
+		r.self.QPS = float64(requests) / elapsed
+	}
+	r.self.LastSeen = now
+	instance := r.self
+	r.mu.Unlock()
+
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	// Entries expire when an instance misses a few heartbeats
+	return r.client.Set(ctx, keyPrefix+instance.ID, data, 3*r.interval).Err()
+}
+
+// List returns all live instances ordered by ID
+func (r *Registry) List(ctx context.Context) ([]Instance, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	instances := make([]Instance, 0, len(keys))
+	if len(keys) == 0 {
+		return instances, nil
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between SCAN and MGET
+			continue
+		}
+
+		var instance Instance
+		if err := json.Unmarshal([]byte(data), &instance); err != nil {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+
+	return instances, nil
+}
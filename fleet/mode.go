@@ -0,0 +1,23 @@
+package fleet
+
+import "github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+
+// Mode describes how an instance with the given configuration decides
+// requests: "read-only" or "enforce", "+shadow" when shadow algorithms are
+// evaluated next to the enforcing ones, then the failure mode, e.g.
+// "enforce+shadow fail-closed"
+func Mode(cfg config.RateLimitConfig) string {
+	mode := "enforce"
+	if cfg.ReadOnly {
+		mode = "read-only"
+	}
+	if cfg.IPShadowAlgorithm != "" || cfg.TokenShadowAlgorithm != "" {
+		mode += "+shadow"
+	}
+
+	failure := cfg.FailureMode
+	if failure == "" {
+		failure = config.FailureOpen
+	}
+	return mode + " fail-" + failure
+}
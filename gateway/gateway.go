@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// clockSkew is the tolerance applied to the exp, nbf and iat claims
+const clockSkew = 30 * time.Second
+
+var (
+	// ErrInvalid is returned for malformed hints or hints with a bad signature
+	ErrInvalid = errors.New("invalid gateway hint")
+	// ErrExpired is returned for hints past their expiry or not yet valid
+	ErrExpired = errors.New("gateway hint expired")
+)
+
+// Claims are the customer and tier resolved by the gateway
+type Claims struct {
+	Subject   string `json:"sub"`
+	Tier      string `json:"tier"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Verifier checks the compact JWS hints signed by an upstream gateway that
+// authenticated the request. Only the configured algorithm is accepted, so a
+// hint cannot pick a weaker one in its header
+type Verifier struct {
+	algorithm string
+	secret    []byte
+	publicKey crypto.PublicKey
+	issuer    string
+	audience  string
+}
+
+// NewVerifier creates a verifier from the configuration, reading the public
+// key of RS256 and ES256
+func NewVerifier(cfg config.GatewayConfig) (*Verifier, error) {
+	v := &Verifier{algorithm: cfg.Algorithm, issuer: cfg.Issuer, audience: cfg.Audience}
+
+	switch cfg.Algorithm {
+	case "HS256":
+		v.secret = []byte(cfg.Secret)
+	case "RS256", "ES256":
+		key, err := readPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		_, isRSA := key.(*rsa.PublicKey)
+		ecKey, isEC := key.(*ecdsa.PublicKey)
+		if (cfg.Algorithm == "RS256" && !isRSA) || (cfg.Algorithm == "ES256" && (!isEC || ecKey.Curve != elliptic.P256())) {
+			return nil, fmt.Errorf("public key %s does not match %s", cfg.PublicKeyFile, cfg.Algorithm)
+		}
+		v.publicKey = key
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", cfg.Algorithm)
+	}
+	return v, nil
+}
+
+// readPublicKey parses a PEM encoded PKIX public key
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Verify checks the signature, lifetime, issuer and audience of a hint,
+// returning its claims. The subject and tier are required
+func (v *Verifier) Verify(hint string) (Claims, error) {
+	parts := strings.Split(hint, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalid
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != v.algorithm {
+		return Claims{}, ErrInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !v.verifySignature(parts[0]+"."+parts[1], signature) {
+		return Claims{}, ErrInvalid
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.Tier == "" || claims.ExpiresAt == 0 {
+		return Claims{}, ErrInvalid
+	}
+
+	now := time.Now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) ||
+		(claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0))) ||
+		(claims.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0))) {
+		return Claims{}, ErrExpired
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalid, claims.Issuer)
+	}
+	if v.audience != "" && claims.Audience != v.audience {
+		return Claims{}, fmt.Errorf("%w: unexpected audience %q", ErrInvalid, claims.Audience)
+	}
+	return claims, nil
+}
+
+// verifySignature checks the signature of the signing input with the configured key
+func (v *Verifier) verifySignature(input string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(input))
+
+	switch key := v.publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the fixed-size concatenation of r and s
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(input))
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWS
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
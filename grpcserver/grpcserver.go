@@ -0,0 +1,93 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Server hosts the gRPC side of the limiter. It always registers the standard
+// health service, and channelz when enabled, so grpc_health_probe, grpcdebug
+// and similar tooling work against it. Other services register on GRPC()
+type Server struct {
+	addr   string
+	server *grpc.Server
+	health *health.Server
+}
+
+// New creates a server listening on addr once started
+func New(addr string, channelz bool, opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		addr:   addr,
+		server: grpc.NewServer(opts...),
+		health: health.NewServer(),
+	}
+
+	healthpb.RegisterHealthServer(s.server, s.health)
+	if channelz {
+		channelzsvc.RegisterChannelzServiceToServer(s.server)
+	}
+	// Not serving until Start succeeds
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return s
+}
+
+// GRPC returns the underlying server to register services on before Start
+func (s *Server) GRPC() *grpc.Server {
+	return s.server
+}
+
+// SetServing reports the health of a service, "" being the whole server
+func (s *Server) SetServing(service string, serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus(service, status)
+}
+
+// Start listens on the configured address and serves in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+
+	for service := range s.server.GetServiceInfo() {
+		s.SetServing(service, true)
+	}
+	s.SetServing("", true)
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop reports every service as not serving and drains in-flight RPCs,
+// closing the remaining connections if ctx ends first
+func (s *Server) Stop(ctx context.Context) {
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
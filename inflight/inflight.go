@@ -0,0 +1,192 @@
+package inflight
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+const keyPrefix = "inflight:"
+
+// Entry is a key ranked by its in-flight requests
+type Entry struct {
+	Key      string `json:"key"`
+	InFlight int64  `json:"in_flight"`
+	// Instances is the number of instances serving requests for the key
+	Instances int `json:"instances"`
+}
+
+// Tracker counts the requests currently being served per key. Each instance
+// keeps its counts in memory and periodically publishes its top keys to Redis,
+// so the fleet-wide view costs no Redis round trip on the request path
+type Tracker struct {
+	client     *redis.Client
+	instanceID string
+	interval   time.Duration
+	top        int
+
+	mu     sync.Mutex
+	counts map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTracker creates a tracker publishing the top keys of this instance every interval
+func NewTracker(client *redis.Client, instanceID string, interval time.Duration, top int) *Tracker {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if top <= 0 {
+		top = 10
+	}
+
+	return &Tracker{
+		client:     client,
+		instanceID: instanceID,
+		interval:   interval,
+		top:        top,
+		counts:     make(map[string]int64),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Acquire counts a request for key as in flight until the returned release is called
+func (t *Tracker) Acquire(key string) (release func()) {
+	t.mu.Lock()
+	t.counts[key]++
+	t.mu.Unlock()
+	metrics.InFlight.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			if t.counts[key]--; t.counts[key] <= 0 {
+				delete(t.counts, key)
+			}
+			t.mu.Unlock()
+			metrics.InFlight.Dec()
+		})
+	}
+}
+
+// Local returns the n keys of this instance with the most in-flight requests
+func (t *Tracker) Local(n int) []Entry {
+	t.mu.Lock()
+	entries := make([]Entry, 0, len(t.counts))
+	for key, count := range t.counts {
+		entries = append(entries, Entry{Key: key, InFlight: count, Instances: 1})
+	}
+	t.mu.Unlock()
+
+	return topEntries(entries, n)
+}
+
+// Start publishes the top keys of this instance until Stop is called
+func (t *Tracker) Start(ctx context.Context) {
+	go func() {
+		defer close(t.done)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.publish(context.Background()); err != nil {
+					slog.Error("Failed to publish in-flight requests", "error", err)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing and removes the snapshot of this instance
+func (t *Tracker) Stop(ctx context.Context) error {
+	close(t.stop)
+	<-t.done
+	return t.client.Del(ctx, keyPrefix+t.instanceID).Err()
+}
+
+// publish replaces the snapshot of this instance and the per-key gauges
+func (t *Tracker) publish(ctx context.Context) error {
+	top := t.Local(t.top)
+
+	metrics.InFlightTopKeys.Reset()
+	values := make(map[string]interface{}, len(top))
+	for _, entry := range top {
+		metrics.InFlightTopKeys.WithLabelValues(entry.Key).Set(float64(entry.InFlight))
+		values[entry.Key] = entry.InFlight
+	}
+
+	key := keyPrefix + t.instanceID
+	pipe := t.client.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(values) > 0 {
+		pipe.HSet(ctx, key, values)
+		// Snapshots expire when an instance stops publishing
+		pipe.Expire(ctx, key, 3*t.interval)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Top returns the n keys with the most in-flight requests across the fleet,
+// as of the latest snapshot of each instance
+func (t *Tracker) Top(ctx context.Context, n int) ([]Entry, error) {
+	byKey := make(map[string]*Entry)
+
+	iter := t.client.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		values, err := t.client.HGetAll(ctx, iter.Val()).Result()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			entry, ok := byKey[key]
+			if !ok {
+				entry = &Entry{Key: key}
+				byKey[key] = entry
+			}
+			entry.InFlight += count
+			entry.Instances++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(byKey))
+	for _, entry := range byKey {
+		entries = append(entries, *entry)
+	}
+	return topEntries(entries, n), nil
+}
+
+// topEntries sorts entries by in-flight requests and keeps the first n
+func topEntries(entries []Entry, n int) []Entry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].InFlight != entries[j].InFlight {
+			return entries[i].InFlight > entries[j].InFlight
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
@@ -0,0 +1,108 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Transform rewrites an identifier into a more canonical form
+type Transform func(string) string
+
+// Pipeline applies transforms in order
+type Pipeline []Transform
+
+// Apply runs the identifier through every transform
+func (p Pipeline) Apply(identifier string) string {
+	for _, transform := range p {
+		identifier = transform(identifier)
+	}
+	return identifier
+}
+
+// Parse builds a pipeline from specs such as "trim", "lower", "nfc", "nfkc",
+// "hash" and "ip_prefix:24:64" (IPv4 and IPv6 prefix lengths)
+func Parse(specs []string) (Pipeline, error) {
+	pipeline := make(Pipeline, 0, len(specs))
+	for _, spec := range specs {
+		name, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+
+		switch strings.ToLower(name) {
+		case "trim":
+			pipeline = append(pipeline, strings.TrimSpace)
+		case "lower":
+			pipeline = append(pipeline, strings.ToLower)
+		case "upper":
+			pipeline = append(pipeline, strings.ToUpper)
+		case "nfc":
+			pipeline = append(pipeline, norm.NFC.String)
+		case "nfkc":
+			pipeline = append(pipeline, norm.NFKC.String)
+		case "hash":
+			pipeline = append(pipeline, Hash)
+		case "ip_prefix":
+			v4, v6, err := parsePrefixes(args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transform %q: %w", spec, err)
+			}
+			pipeline = append(pipeline, IPPrefix(v4, v6))
+		default:
+			return nil, fmt.Errorf("unknown transform %q", spec)
+		}
+	}
+	return pipeline, nil
+}
+
+// Hash replaces the identifier with a truncated SHA-256 digest so raw
+// credentials are never written to storage
+func Hash(identifier string) string {
+	sum := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(sum[:16])
+}
+
+// IPPrefix truncates IP addresses to their network prefix, leaving other values untouched
+func IPPrefix(v4Bits, v6Bits int) Transform {
+	return func(identifier string) string {
+		ip := net.ParseIP(identifier)
+		if ip == nil {
+			return identifier
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			if v4Bits >= 32 {
+				return ip4.String()
+			}
+			return fmt.Sprintf("%s/%d", ip4.Mask(net.CIDRMask(v4Bits, 32)), v4Bits)
+		}
+
+		if v6Bits >= 128 {
+			return ip.String()
+		}
+		return fmt.Sprintf("%s/%d", ip.Mask(net.CIDRMask(v6Bits, 128)), v6Bits)
+	}
+}
+
+// parsePrefixes parses "24:64" into IPv4 and IPv6 prefix lengths
+func parsePrefixes(args string) (int, int, error) {
+	v4Arg, v6Arg, _ := strings.Cut(args, ":")
+
+	v4, err := strconv.Atoi(v4Arg)
+	if err != nil || v4 < 0 || v4 > 32 {
+		return 0, 0, fmt.Errorf("IPv4 prefix must be between 0 and 32")
+	}
+
+	v6 := 128
+	if v6Arg != "" {
+		v6, err = strconv.Atoi(v6Arg)
+		if err != nil || v6 < 0 || v6 > 128 {
+			return 0, 0, fmt.Errorf("IPv6 prefix must be between 0 and 128")
+		}
+	}
+
+	return v4, v6, nil
+}
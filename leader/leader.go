@@ -0,0 +1,189 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// renewScript extends the lease only if it is still owned by this instance
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is still owned by this instance
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Job is a background task that must run on a single instance
+type Job func(ctx context.Context) error
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      Job
+}
+
+// Elector elects a single leader across the fleet using a Redis lease
+// (SET NX with periodic renewal) and runs scheduled jobs only while leading
+type Elector struct {
+	client *redis.Client
+	key    string
+	id     string
+	ttl    time.Duration
+
+	leader atomic.Bool
+	jobs   []scheduledJob
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewElector creates an elector for the given lease name and instance ID
+func NewElector(client *redis.Client, name, id string, ttl time.Duration) *Elector {
+	if ttl <= 0 {
+		ttl = 15 * time.Second
+	}
+
+	return &Elector{
+		client: client,
+		key:    "leader:" + name,
+		id:     id,
+		ttl:    ttl,
+	}
+}
+
+// Schedule registers a job to run every interval while this instance is leader.
+// Jobs must be scheduled before Start
+func (e *Elector) Schedule(name string, interval time.Duration, job Job) {
+	e.jobs = append(e.jobs, scheduledJob{name: name, interval: interval, run: job})
+}
+
+// IsLeader reports whether this instance currently holds the lease
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Start begins campaigning for leadership and runs the scheduled jobs
+func (e *Elector) Start(ctx context.Context) {
+	ctx, e.cancel = context.WithCancel(ctx)
+
+	e.wg.Add(1)
+	go e.campaign(ctx)
+
+	for _, job := range e.jobs {
+		e.wg.Add(1)
+		go e.runJob(ctx, job)
+	}
+}
+
+// Stop stops the jobs and releases the lease so another instance can take over
+func (e *Elector) Stop(ctx context.Context) error {
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.wg.Wait()
+
+	if !e.leader.Load() {
+		return nil
+	}
+	e.setLeader(false)
+
+	return releaseScript.Run(ctx, e.client, []string{e.key}, e.id).Err()
+}
+
+// campaign tries to acquire or renew the lease at a third of its TTL
+func (e *Elector) campaign(ctx context.Context) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.tick(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// tick performs a single acquisition or renewal attempt
+func (e *Elector) tick(ctx context.Context) {
+	if e.leader.Load() {
+		renewed, err := renewScript.Run(ctx, e.client, []string{e.key}, e.id, e.ttl.Milliseconds()).Int()
+		if err != nil || renewed == 0 {
+			if err != nil {
+				slog.Error("Failed to renew leadership", "error", err)
+			}
+			e.setLeader(false)
+		}
+		return
+	}
+
+	acquired, err := e.client.SetNX(ctx, e.key, e.id, e.ttl).Result()
+	if err != nil {
+		slog.Error("Failed to acquire leadership", "error", err)
+		return
+	}
+	if acquired {
+		e.setLeader(true)
+	}
+}
+
+// setLeader records a leadership change
+func (e *Elector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+
+	if leader {
+		slog.Info("Acquired leadership", "instance", e.id, "key", e.key)
+		metrics.LeaderTransitions.WithLabelValues("acquired").Inc()
+		metrics.IsLeader.Set(1)
+	} else {
+		slog.Info("Lost leadership", "instance", e.id, "key", e.key)
+		metrics.LeaderTransitions.WithLabelValues("lost").Inc()
+		metrics.IsLeader.Set(0)
+	}
+}
+
+// runJob executes a job on its interval whenever this instance is leader
+func (e *Elector) runJob(ctx context.Context, job scheduledJob) {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !e.leader.Load() {
+				continue
+			}
+
+			if err := job.run(ctx); err != nil {
+				slog.Error("Job failed", "job", job.name, "error", err)
+				metrics.JobRuns.WithLabelValues(job.name, "error").Inc()
+				continue
+			}
+			metrics.JobRuns.WithLabelValues(job.name, "success").Inc()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Shutdown phases, run in ascending order. Hooks may use any value in between
+const (
+	// PhaseServers stops accepting traffic and drains in-flight requests
+	PhaseServers = 100
+	// PhaseJobs stops background jobs and hands over leadership
+	PhaseJobs = 200
+	// PhaseSinks flushes and closes event sinks
+	PhaseSinks = 300
+	// PhaseFleet leaves the fleet while the storage is still reachable
+	PhaseFleet = 400
+	// PhaseStorage closes the storage connections
+	PhaseStorage = 500
+)
+
+// DefaultTimeout bounds a hook registered without a timeout
+const DefaultTimeout = 10 * time.Second
+
+// Hook is a named shutdown step of a subsystem
+type Hook struct {
+	Name  string
+	Phase int
+	// Timeout bounds the hook, DefaultTimeout when zero
+	Timeout time.Duration
+	Stop    func(ctx context.Context) error
+}
+
+// Manager runs the registered shutdown hooks in phase order. Hooks of the same
+// phase run in reverse registration order, like deferred calls, so a subsystem
+// registered after its dependencies stops before them
+type Manager struct {
+	mu    sync.Mutex
+	hooks []Hook
+	done  bool
+}
+
+// New creates a manager without hooks
+func New() *Manager {
+	return &Manager{}
+}
+
+// Register adds a shutdown hook. Hooks registered after Shutdown started are
+// ignored, so the caller must stop the subsystem itself
+func (m *Manager) Register(hook Hook) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done {
+		return false
+	}
+	m.hooks = append(m.hooks, hook)
+	return true
+}
+
+// OnShutdown registers a hook with the default timeout
+func (m *Manager) OnShutdown(name string, phase int, stop func(ctx context.Context) error) bool {
+	return m.Register(Hook{Name: name, Phase: phase, Stop: stop})
+}
+
+// Shutdown runs every hook once, each bounded by its timeout and by ctx.
+// A failing or timed out hook does not stop the following ones; their errors
+// are returned joined. Later calls return nil
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.done {
+		m.mu.Unlock()
+		return nil
+	}
+	m.done = true
+	hooks := make([]Hook, len(m.hooks))
+	for i, hook := range m.hooks {
+		hooks[len(hooks)-1-i] = hook
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Phase < hooks[j].Phase })
+
+	var errs []error
+	for _, hook := range hooks {
+		start := time.Now()
+		if err := run(ctx, hook); err != nil {
+			slog.Error("Shutdown hook failed", "hook", hook.Name, "duration", time.Since(start), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", hook.Name, err))
+			continue
+		}
+		slog.Debug("Shutdown hook finished", "hook", hook.Name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// run calls a hook, returning when it finishes or its timeout expires
+func run(ctx context.Context, hook Hook) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- hook.Stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitForSignal blocks until the process receives SIGINT or SIGTERM, or ctx
+// is done. Binaries embedding the server can skip it and call Shutdown when
+// they stop
+func WaitForSignal(ctx context.Context) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+}
@@ -0,0 +1,353 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Request is a request counted against a key by an Algorithm
+type Request struct {
+	// Policy names the limit being checked, e.g. "ip", "token" or "route"
+	Policy string
+	Key    string
+	// Limit is the configured limit, before the units granted to the key
+	Limit int
+	// Burst is the configured bucket capacity of the key, 0 when not set
+	Burst  int
+	Cost   int
+	Window time.Duration
+	// BlockFor is how long exceeding the limit blocks the key, algorithms that
+	// do not block ignore it
+	BlockFor time.Duration
+}
+
+// Decision is the outcome of an Algorithm for a request
+type Decision struct {
+	Allowed   bool
+	Remaining int
+	ResetTime time.Time
+	// Delay is how long an allowed request must wait for its turn
+	Delay time.Duration
+	// BlockTime is how long the key stays blocked
+	BlockTime time.Duration
+	// BlockedBefore reports a rejection by an existing block, nothing was counted
+	BlockedBefore bool
+	// BlockedNow reports that this request exceeded the limit and blocked the key
+	BlockedNow bool
+}
+
+// Algorithm implements the windowing and counting of a limit on top of the
+// primitives provided by the storage strategy
+type Algorithm interface {
+	// Name returns the configuration name of the algorithm
+	Name() string
+	// Check counts the request against its key and decides whether it fits
+	Check(ctx context.Context, req Request) (*Decision, error)
+}
+
+// NewAlgorithm builds the named algorithm over the storage, failing when the
+// storage lacks the primitives it needs. bucket sizes the bucket algorithms
+func NewAlgorithm(name string, storage strategy.StorageStrategy, bucket config.BucketConfig) (Algorithm, error) {
+	switch name {
+	case "", config.AlgorithmFixedWindow:
+		return NewFixedWindow(storage), nil
+	case config.AlgorithmSlidingLog:
+		if requests, ok := storage.(strategy.SlidingLogStorage); ok {
+			return NewSlidingWindow(storage, requests), nil
+		}
+	case config.AlgorithmTokenBucket:
+		if tokens, ok := storage.(strategy.TokenBucketStorage); ok {
+			return NewTokenBucket(storage, tokens, bucket), nil
+		}
+	case config.AlgorithmLeakyBucket:
+		if queue, ok := storage.(strategy.LeakyBucketStorage); ok {
+			return NewLeakyBucket(storage, queue, bucket), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+	return nil, fmt.Errorf("storage does not support the %s algorithm", name)
+}
+
+// granted returns the extra units currently granted to a key. Lookup errors
+// are traced and ignored so that grants never fail a check
+func granted(ctx context.Context, storage strategy.StorageStrategy, key string) int {
+	start := time.Now()
+	info, err := storage.Get(ctx, strategy.GrantKeyFor(key))
+	if err != nil {
+		traceStep(ctx, "grant", start, "error(%v)", err)
+		return 0
+	}
+	if info.Count > 0 {
+		traceStep(ctx, "grant", start, "+%d", info.Count)
+	}
+	return max(info.Count, 0)
+}
+
+// FixedWindow counts requests per key in windows starting at the first request,
+// blocking the key when it exceeds the limit
+type FixedWindow struct {
+	storage strategy.StorageStrategy
+}
+
+// NewFixedWindow creates a fixed window algorithm, atomic when the storage
+// implements strategy.AtomicCheckStorage
+func NewFixedWindow(storage strategy.StorageStrategy) *FixedWindow {
+	return &FixedWindow{storage: storage}
+}
+
+// Name returns the configuration name of the algorithm
+func (a *FixedWindow) Name() string {
+	return config.AlgorithmFixedWindow
+}
+
+// Check counts the request in the current window of its key
+func (a *FixedWindow) Check(ctx context.Context, req Request) (*Decision, error) {
+	if atomic, ok := a.storage.(strategy.AtomicCheckStorage); ok {
+		return a.checkAtomic(ctx, atomic, req)
+	}
+
+	step := req.Policy + "_limit"
+
+	// The key's own block is only looked up when exceeding the limit blocks it
+	if req.BlockFor > 0 {
+		start := time.Now()
+		blocked, blockUntil, err := a.storage.IsBlocked(ctx, req.Key)
+		if err != nil {
+			traceStep(ctx, "blocked", start, "error(%v)", err)
+			return nil, fmt.Errorf("failed to check block: %w", err)
+		}
+		traceStep(ctx, "blocked", start, "%s %t", req.Key, blocked)
+		if blocked {
+			return &Decision{
+				ResetTime:     blockUntil,
+				BlockTime:     time.Until(blockUntil),
+				BlockedBefore: true,
+			}, nil
+		}
+	}
+
+	// Increment counter by the request cost first (Redis will handle TTL automatically)
+	start := time.Now()
+	newCount, err := a.storage.Increment(ctx, req.Key, req.Cost, req.Window)
+	if err != nil {
+		traceStep(ctx, step, start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to increment counter: %w", err)
+	}
+	limit := req.Limit + granted(ctx, a.storage, req.Key)
+	traceStep(ctx, step, start, "%s %d/%d", req.Key, newCount, limit)
+
+	// Check if limit is exceeded after increment
+	if newCount > limit {
+		if req.BlockFor > 0 {
+			if err := a.storage.SetBlocked(ctx, req.Key, time.Now().Add(req.BlockFor)); err != nil {
+				return nil, fmt.Errorf("failed to block key: %w", err)
+			}
+			return &Decision{
+				ResetTime:  time.Now().Add(req.BlockFor),
+				BlockTime:  req.BlockFor,
+				BlockedNow: true,
+			}, nil
+		}
+
+		return &Decision{ResetTime: time.Now().Add(req.Window)}, nil
+	}
+
+	return &Decision{
+		Allowed:   true,
+		Remaining: max(limit-newCount, 0),
+		ResetTime: time.Now().Add(req.Window),
+	}, nil
+}
+
+// checkAtomic runs the block lookup, increment, grant lookup and blocking on
+// exceed as a single atomic storage operation
+func (a *FixedWindow) checkAtomic(ctx context.Context, storage strategy.AtomicCheckStorage, req Request) (*Decision, error) {
+	step := req.Policy + "_limit"
+
+	start := time.Now()
+	outcome, err := storage.CheckAndIncrement(ctx, req.Key, strategy.GrantKeyFor(req.Key), req.Cost, req.Limit, req.Window, req.BlockFor)
+	if err != nil {
+		traceStep(ctx, step, start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to check limit: %w", err)
+	}
+	traceStep(ctx, step, start, "%s %d/%d blocked=%t", req.Key, outcome.Count, outcome.Limit, outcome.Blocked)
+
+	if outcome.Allowed {
+		return &Decision{
+			Allowed:   true,
+			Remaining: max(outcome.Limit-outcome.Count, 0),
+			ResetTime: outcome.ResetTime,
+		}, nil
+	}
+
+	decision := &Decision{ResetTime: outcome.ResetTime}
+	if outcome.Blocked {
+		decision.BlockTime = time.Until(outcome.ResetTime)
+		// Nothing is counted while an earlier block holds
+		decision.BlockedBefore = outcome.Count == 0
+		decision.BlockedNow = outcome.Count > 0
+	}
+	return decision, nil
+}
+
+// SlidingWindow enforces the limit over the trailing window using a request log.
+// Rejected requests are not logged, so they do not extend the client's wait
+type SlidingWindow struct {
+	storage  strategy.StorageStrategy
+	requests strategy.SlidingLogStorage
+}
+
+// NewSlidingWindow creates a sliding-window log algorithm
+func NewSlidingWindow(storage strategy.StorageStrategy, requests strategy.SlidingLogStorage) *SlidingWindow {
+	return &SlidingWindow{storage: storage, requests: requests}
+}
+
+// Name returns the configuration name of the algorithm
+func (a *SlidingWindow) Name() string {
+	return config.AlgorithmSlidingLog
+}
+
+// Check logs the request if it fits within the trailing window
+func (a *SlidingWindow) Check(ctx context.Context, req Request) (*Decision, error) {
+	step := req.Policy + "_limit"
+	limit := req.Limit + granted(ctx, a.storage, req.Key)
+
+	start := time.Now()
+	count, allowed, resetTime, err := a.requests.AddToLog(ctx, req.Key, req.Cost, limit, req.Window)
+	if err != nil {
+		traceStep(ctx, step, start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to update request log: %w", err)
+	}
+	traceStep(ctx, step, start, "%s sliding_log %d/%d", req.Key, count, limit)
+
+	if !allowed {
+		return &Decision{ResetTime: resetTime}, nil
+	}
+
+	return &Decision{
+		Allowed:   true,
+		Remaining: max(limit-count, 0),
+		ResetTime: resetTime,
+	}, nil
+}
+
+// TokenBucket takes the request cost from the key's bucket. Clients can burst
+// up to the capacity and are then throttled to the refill rate
+type TokenBucket struct {
+	storage strategy.StorageStrategy
+	tokens  strategy.TokenBucketStorage
+	bucket  config.BucketConfig
+}
+
+// NewTokenBucket creates a token bucket algorithm. The burst of a request
+// takes precedence over the capacity, and a zero capacity or refill rate is
+// derived from the limit of each request
+func NewTokenBucket(storage strategy.StorageStrategy, tokens strategy.TokenBucketStorage, bucket config.BucketConfig) *TokenBucket {
+	return &TokenBucket{storage: storage, tokens: tokens, bucket: bucket}
+}
+
+// Name returns the configuration name of the algorithm
+func (a *TokenBucket) Name() string {
+	return config.AlgorithmTokenBucket
+}
+
+// Check takes the request cost from the bucket if enough tokens are available
+func (a *TokenBucket) Check(ctx context.Context, req Request) (*Decision, error) {
+	step := req.Policy + "_limit"
+
+	capacity := a.bucket.Capacity
+	if req.Burst > 0 {
+		capacity = req.Burst
+	}
+	if capacity <= 0 {
+		capacity = req.Limit
+	}
+	capacity += granted(ctx, a.storage, req.Key)
+	refillRate := a.bucket.RefillRate
+	if refillRate <= 0 {
+		refillRate = float64(req.Limit) / req.Window.Seconds()
+	}
+
+	start := time.Now()
+	tokens, allowed, resetTime, err := a.tokens.TakeTokens(ctx, req.Key, req.Cost, capacity, refillRate)
+	if err != nil {
+		traceStep(ctx, step, start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to take tokens: %w", err)
+	}
+	traceStep(ctx, step, start, "%s token_bucket %d/%d +%g/s", req.Key, tokens, capacity, refillRate)
+
+	if !allowed {
+		return &Decision{ResetTime: resetTime}, nil
+	}
+
+	return &Decision{
+		Allowed:   true,
+		Remaining: tokens,
+		ResetTime: resetTime,
+	}, nil
+}
+
+// LeakyBucket queues requests on the key's bucket, which drains at a constant
+// rate. Allowed requests carry the delay before their turn; requests that
+// would wait longer than the queue capacity are rejected
+type LeakyBucket struct {
+	storage strategy.StorageStrategy
+	queue   strategy.LeakyBucketStorage
+	bucket  config.BucketConfig
+}
+
+// NewLeakyBucket creates a leaky bucket algorithm. A zero capacity or drain
+// rate is derived from the limit of each request
+func NewLeakyBucket(storage strategy.StorageStrategy, queue strategy.LeakyBucketStorage, bucket config.BucketConfig) *LeakyBucket {
+	return &LeakyBucket{storage: storage, queue: queue, bucket: bucket}
+}
+
+// Name returns the configuration name of the algorithm
+func (a *LeakyBucket) Name() string {
+	return config.AlgorithmLeakyBucket
+}
+
+// Check schedules the request on the bucket if its wait fits in the queue
+func (a *LeakyBucket) Check(ctx context.Context, req Request) (*Decision, error) {
+	step := req.Policy + "_limit"
+
+	capacity := a.bucket.Capacity
+	if capacity <= 0 {
+		capacity = req.Limit
+	}
+	capacity += granted(ctx, a.storage, req.Key)
+	drainRate := a.bucket.RefillRate
+	if drainRate <= 0 {
+		drainRate = float64(req.Limit) / req.Window.Seconds()
+	}
+	if drainRate <= 0 {
+		return nil, fmt.Errorf("leaky bucket drain rate must be positive")
+	}
+
+	interval := time.Duration(float64(time.Second) / drainRate)
+	maxDelay := time.Duration(max(capacity-1, 0)) * interval
+
+	start := time.Now()
+	delay, allowed, remaining, resetTime, err := a.queue.Schedule(ctx, req.Key, req.Cost, interval, maxDelay)
+	if err != nil {
+		traceStep(ctx, step, start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to schedule request: %w", err)
+	}
+	traceStep(ctx, step, start, "%s leaky_bucket wait=%s slots=%d", req.Key, delay, remaining)
+
+	if !allowed {
+		return &Decision{ResetTime: time.Now().Add(delay - maxDelay)}, nil
+	}
+
+	return &Decision{
+		Allowed:   true,
+		Remaining: remaining,
+		ResetTime: resetTime,
+		Delay:     delay,
+	}, nil
+}
@@ -0,0 +1,38 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+type clientKey struct{}
+
+// ClientKey identifies a client by a custom dimension such as a user ID,
+// session or tenant
+type ClientKey struct {
+	Kind string
+	Key  string
+	// Limit and Window replace the IP limit for the client when Limit is positive
+	Limit  int
+	Window time.Duration
+}
+
+// WithClientKey returns a context identifying the client by kind and key
+// instead of its IP and token. Custom kinds are counted against the IP limit
+// unless a route or rule limit applies
+func WithClientKey(ctx context.Context, kind, key string) context.Context {
+	return context.WithValue(ctx, clientKey{}, ClientKey{Kind: kind, Key: key})
+}
+
+// WithClientLimit returns a context identifying the client by kind and key,
+// counted against its own limit per window instead of the IP limit. Exceeding
+// it rejects requests until the window ends, without blocking the client
+func WithClientLimit(ctx context.Context, kind, key string, limit int, window time.Duration) context.Context {
+	return context.WithValue(ctx, clientKey{}, ClientKey{Kind: kind, Key: key, Limit: limit, Window: window})
+}
+
+// ClientKeyFromContext returns the custom client identity of the request, if any
+func ClientKeyFromContext(ctx context.Context) (ClientKey, bool) {
+	client, ok := ctx.Value(clientKey{}).(ClientKey)
+	return client, ok
+}
@@ -0,0 +1,139 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+const (
+	concurrencyGoroutines = 300
+	concurrencyRequests   = 5
+	concurrencyLimit      = 100
+)
+
+func TestCheckRateLimitConcurrentMemory(t *testing.T) {
+	storage := strategy.NewMemoryStrategy()
+	defer storage.Close()
+
+	testCheckRateLimitConcurrent(t, storage)
+}
+
+// TestCheckRateLimitConcurrentRedis runs against the Redis at REDIS_ADDR, e.g.
+// REDIS_ADDR=localhost:6379 go test ./limiter
+func TestCheckRateLimitConcurrentRedis(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+
+	storage := strategy.NewRedisStrategyWithOptions(&redis.Options{Addr: addr})
+	defer storage.Close()
+	if err := storage.Ping(context.Background()); err != nil {
+		t.Fatalf("failed to connect to Redis at %s: %v", addr, err)
+	}
+
+	testCheckRateLimitConcurrent(t, storage)
+}
+
+// testCheckRateLimitConcurrent checks one client from hundreds of goroutines
+// and verifies that no more than the limit is admitted, and that the client
+// ends up blocked with every later request denied
+func testCheckRateLimitConcurrent(t *testing.T, storage strategy.StorageStrategy) {
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{
+		IPLimit:     concurrencyLimit,
+		IPBlockTime: time.Minute,
+		// A window longer than the test, so every request lands in the same one
+		Window: time.Hour,
+	}}
+	rateLimiter := NewRateLimiter(storage, cfg)
+
+	ctx := context.Background()
+	// A fresh client per run, so earlier runs against Redis do not interfere
+	ip := fmt.Sprintf("concurrency-%d", time.Now().UnixNano())
+	key := rateLimiter.Key("ip", ip)
+	defer rateLimiter.ResetRateLimit(ctx, key)
+
+	var (
+		mu        sync.Mutex
+		admitted  int
+		denied    int
+		remaining = make(map[int]int)
+		errs      []error
+	)
+	var wg sync.WaitGroup
+	for range concurrencyGoroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range concurrencyRequests {
+				result, err := rateLimiter.CheckRateLimit(ctx, ip, "")
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, err)
+				case result.Allowed:
+					admitted++
+					remaining[result.Remaining]++
+				default:
+					denied++
+					if result.Remaining != 0 {
+						t.Errorf("denied result with %d remaining", result.Remaining)
+					}
+					if result.ResetTime.Before(time.Now()) {
+						t.Errorf("denied result resets in the past: %s", result.ResetTime)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		t.Fatalf("%d checks failed, first: %v", len(errs), errs[0])
+	}
+	if total := concurrencyGoroutines * concurrencyRequests; admitted+denied != total {
+		t.Fatalf("admitted %d and denied %d, want %d decisions", admitted, denied, total)
+	}
+	if admitted > concurrencyLimit {
+		t.Fatalf("admitted %d requests, above the limit of %d", admitted, concurrencyLimit)
+	}
+	if admitted < concurrencyLimit {
+		t.Errorf("admitted %d requests, want the full limit of %d", admitted, concurrencyLimit)
+	}
+	// Each admitted request saw its own count, so no remaining value repeats
+	for value, count := range remaining {
+		if count > 1 {
+			t.Errorf("%d admitted requests saw %d remaining", count, value)
+		}
+	}
+
+	blocked, blockUntil, err := storage.IsBlocked(ctx, key)
+	if err != nil {
+		t.Fatalf("IsBlocked: %v", err)
+	}
+	if !blocked {
+		t.Fatalf("client not blocked after exceeding the limit")
+	}
+	if until := time.Until(blockUntil); until <= 0 || until > cfg.RateLimit.IPBlockTime {
+		t.Errorf("blocked for %s, want at most %s", until, cfg.RateLimit.IPBlockTime)
+	}
+
+	result, err := rateLimiter.CheckRateLimit(ctx, ip, "")
+	if err != nil {
+		t.Fatalf("CheckRateLimit: %v", err)
+	}
+	if result.Allowed || result.Policy != "blocked" {
+		t.Errorf("request after the run: allowed=%t policy=%q, want denied by the block", result.Allowed, result.Policy)
+	}
+}
@@ -0,0 +1,18 @@
+package limiter
+
+import "context"
+
+type costKey struct{}
+
+// WithCost returns a context charging cost units for the request instead of one
+func WithCost(ctx context.Context, cost int) context.Context {
+	return context.WithValue(ctx, costKey{}, cost)
+}
+
+// CostFromContext returns the units charged for the request, at least one
+func CostFromContext(ctx context.Context) int {
+	if cost, ok := ctx.Value(costKey{}).(int); ok && cost > 0 {
+		return cost
+	}
+	return 1
+}
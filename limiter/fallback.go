@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Fallback counts requests in process memory while the distributed storage
+// is unavailable. Each instance enforces its share of the primary limits, as
+// derived by the fallback configuration
+type Fallback struct {
+	primary   *RateLimiter
+	local     *RateLimiter
+	cfg       config.FallbackConfig
+	instances int
+
+	mu sync.Mutex
+	// derivedFrom is the primary policy the local limits were derived from
+	derivedFrom *config.Policy
+}
+
+// NewFallback creates a local fallback for the primary limiter, with its
+// limits divided among the given number of instances
+func NewFallback(primary *RateLimiter, cfg config.FallbackConfig, instances int) *Fallback {
+	local := NewRateLimiter(strategy.NewMemoryStrategy(), primary.config)
+	local.canonicalizers = primary.canonicalizers
+	local.events = primary.events
+
+	return &Fallback{
+		primary:   primary,
+		local:     local,
+		cfg:       cfg,
+		instances: instances,
+	}
+}
+
+// CheckRateLimit checks the local IP and token limits of a client
+func (f *Fallback) CheckRateLimit(ctx context.Context, ip, token string) (*CheckResult, error) {
+	f.sync()
+	return f.local.CheckRateLimit(ctx, ip, token)
+}
+
+// Policy returns the local limits currently enforced
+func (f *Fallback) Policy() config.Policy {
+	f.sync()
+	return f.local.Policy()
+}
+
+// sync derives the local limits again when the primary policy changed
+func (f *Fallback) sync() {
+	current := f.primary.policy.Load()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if current == f.derivedFrom {
+		return
+	}
+
+	policy := *current
+	policy.IPLimit = f.cfg.LocalIPLimit(current.IPLimit, f.instances)
+	policy.IPWindows = f.localWindows(current.IPWindows)
+	policy.TokenLimits = make(map[string]config.TokenLimit, len(current.TokenLimits))
+	for token, limit := range current.TokenLimits {
+		limit.Limit = f.cfg.LocalLimit(limit.Limit, f.instances)
+		limit.Windows = f.localWindows(limit.Windows)
+		policy.TokenLimits[token] = limit
+	}
+
+	f.local.SetPolicy(policy)
+	f.derivedFrom = current
+}
+
+// localWindows returns the per-instance share of further windows
+func (f *Fallback) localWindows(windows []config.WindowLimit) []config.WindowLimit {
+	local := make([]config.WindowLimit, len(windows))
+	for i, window := range windows {
+		local[i] = config.WindowLimit{Limit: f.cfg.LocalLimit(window.Limit, f.instances), Window: window.Window}
+	}
+	return local
+}
+
+// Close releases the locally counted requests
+func (f *Fallback) Close() error {
+	return f.local.storage.Close()
+}
@@ -0,0 +1,19 @@
+package limiter
+
+import (
+	"context"
+)
+
+// GlobalKey is the storage key shared by all clients for the global limit
+const GlobalKey = "global:all"
+
+// CheckGlobalLimit counts a request against the server-wide limit shared by
+// all clients, returning nil when there is none. It never blocks: once the
+// limit is reached requests are rejected until the window ends
+func (rl *RateLimiter) CheckGlobalLimit(ctx context.Context) (*CheckResult, error) {
+	limit := rl.config.RateLimit.GlobalLimit
+	if limit <= 0 {
+		return nil, nil
+	}
+	return rl.checkLimit(ctx, "global", GlobalKey, limit, 0, rl.config.RateLimit.GlobalWindow, 0, nil, "Global rate limit exceeded")
+}
@@ -3,10 +3,14 @@ package limiter
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/keys"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
 	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
 )
 
@@ -14,132 +18,474 @@ import (
 type RateLimiter struct {
 	storage strategy.StorageStrategy
 	config  *config.Config
+	policy  atomic.Pointer[config.Policy]
+	events  *events.Bus
+	// canonicalizers normalize identifiers per key kind before they reach storage
+	canonicalizers map[string]keys.Pipeline
+	// algorithms selects the counting algorithm per policy, fixed window by default
+	algorithms map[string]Algorithm
+	// shadows are evaluated per policy next to the enforcing algorithm, never enforced
+	shadows map[string]Algorithm
+	// buckets overrides the derived bucket size per policy
+	buckets map[string]config.BucketConfig
+	// fixedWindow is the default algorithm
+	fixedWindow Algorithm
+	// migration carries state over from the keys of the previous transforms
+	migration *keyMigration
 }
 
-// NewRateLimiter creates a new rate limiter instance
-func NewRateLimiter(storage strategy.StorageStrategy, config *config.Config) *RateLimiter {
-	return &RateLimiter{
-		storage: storage,
-		config:  config,
+// NewRateLimiter creates a new rate limiter instance. It is kept for
+// compatibility, new code should use limiter.New of the v2 API
+func NewRateLimiter(storage strategy.StorageStrategy, cfg *config.Config) *RateLimiter {
+	rl := &RateLimiter{
+		storage:        storage,
+		config:         cfg,
+		events:         events.NewBus(),
+		canonicalizers: make(map[string]keys.Pipeline),
+		algorithms:     make(map[string]Algorithm),
+		shadows:        make(map[string]Algorithm),
+		buckets:        make(map[string]config.BucketConfig),
+		fixedWindow:    NewFixedWindow(storage),
 	}
+	rl.SetPolicy(cfg.RateLimit.Policy())
+	return rl
 }
 
-// CheckResult represents the result of a rate limit check
-type CheckResult struct {
-	Allowed   bool          `json:"allowed"`
-	Remaining int           `json:"remaining"`
-	ResetTime time.Time     `json:"reset_time"`
-	BlockTime time.Duration `json:"block_time,omitempty"`
-	Reason    string        `json:"reason,omitempty"`
+// Policy returns the limits currently enforced
+func (rl *RateLimiter) Policy() config.Policy {
+	return *rl.policy.Load()
 }
 
-// CheckIPRateLimit checks rate limit for an IP address
-func (rl *RateLimiter) CheckIPRateLimit(ctx context.Context, ip string) (*CheckResult, error) {
-	key := strategy.GetKeyWithPrefix("ip", ip)
+// SetPolicy replaces the enforced limits, taking effect on the next check
+func (rl *RateLimiter) SetPolicy(policy config.Policy) {
+	rl.policy.Store(&policy)
+}
+
+// SetKeyPipeline sets the canonicalization pipeline for a key kind ("ip" or "token")
+func (rl *RateLimiter) SetKeyPipeline(kind string, pipeline keys.Pipeline) {
+	rl.canonicalizers[kind] = pipeline
+}
 
-	// Increment counter first (Redis will handle TTL automatically)
-	newCount, err := rl.storage.Increment(ctx, key, time.Second)
+// SetAlgorithm sets the counting algorithm for a policy ("ip" or "token") by
+// its configuration name
+func (rl *RateLimiter) SetAlgorithm(policy, name string) error {
+	algorithm, err := NewAlgorithm(name, rl.storage, rl.buckets[policy])
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment counter: %w", err)
+		return err
 	}
+	rl.UseAlgorithm(policy, algorithm)
+	return nil
+}
+
+// UseAlgorithm sets a custom counting algorithm for a policy
+func (rl *RateLimiter) UseAlgorithm(policy string, algorithm Algorithm) {
+	rl.algorithms[policy] = algorithm
+}
 
-	// Check if limit is exceeded after increment
-	if newCount > rl.config.RateLimit.IPLimit {
-		// Return rate limit exceeded (no permanent blocking)
-		now := time.Now()
-		resetTime := now.Add(time.Second)
+// SetBucket overrides the bucket capacity and refill rate for a policy
+func (rl *RateLimiter) SetBucket(policy string, bucket config.BucketConfig) {
+	rl.buckets[policy] = bucket
 
-		return &CheckResult{
-			Allowed:   false,
-			Remaining: 0,
-			ResetTime: resetTime,
-			Reason:    "IP rate limit exceeded",
-		}, nil
+	// Rebuild a bucket algorithm already selected for the policy
+	switch algorithm := rl.algorithms[policy]; algorithm.(type) {
+	case *TokenBucket, *LeakyBucket:
+		rl.SetAlgorithm(policy, algorithm.Name())
+	}
+	switch shadow := rl.shadows[policy]; shadow.(type) {
+	case *TokenBucket, *LeakyBucket:
+		rl.SetShadowAlgorithm(policy, shadow.Name())
 	}
+}
 
-	remaining := rl.config.RateLimit.IPLimit - newCount
-	if remaining < 0 {
-		remaining = 0
+// algorithm returns the counting algorithm of a policy
+func (rl *RateLimiter) algorithm(policy string) Algorithm {
+	if algorithm, ok := rl.algorithms[policy]; ok {
+		return algorithm
 	}
+	return rl.fixedWindow
+}
 
-	// Calculate reset time (current time + 1 second)
-	resetTime := time.Now().Add(time.Second)
+// AlgorithmName returns the configuration name of the counting algorithm of a policy
+func (rl *RateLimiter) AlgorithmName(policy string) string {
+	return rl.algorithm(policy).Name()
+}
 
-	return &CheckResult{
-		Allowed:   true,
-		Remaining: remaining,
-		ResetTime: resetTime,
-	}, nil
+// Key returns the storage key for an identifier after canonicalization
+func (rl *RateLimiter) Key(kind, identifier string) string {
+	return strategy.GetKeyWithPrefix(kind, rl.canonicalizers[kind].Apply(identifier))
+}
+
+// Events returns the bus on which the rate limiter emits its events
+func (rl *RateLimiter) Events() *events.Bus {
+	return rl.events
+}
+
+// CheckResult represents the result of a rate limit check
+type CheckResult struct {
+	Allowed   bool          `json:"allowed"`
+	Remaining int           `json:"remaining"`
+	ResetTime time.Time     `json:"reset_time"`
+	BlockTime time.Duration `json:"block_time,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	// Limit is the limit counted against, 0 when the client was already blocked
+	Limit int `json:"limit,omitempty"`
+	// Delay is how long an allowed request must wait for its turn (leaky bucket)
+	Delay time.Duration `json:"delay,omitempty"`
+	// Policy and Key identify the counter or block that decided the request
+	Policy string `json:"-"`
+	Key    string `json:"-"`
+}
+
+// CheckIPRateLimit checks rate limit for an IP address
+func (rl *RateLimiter) CheckIPRateLimit(ctx context.Context, ip string) (*CheckResult, error) {
+	policy := rl.Policy()
+	return rl.checkLimit(ctx, "ip", rl.Key("ip", ip), policy.IPLimit, policy.IPBurst, policy.LimitWindow(), policy.IPBlockTime, policy.IPWindows, "IP rate limit exceeded")
 }
 
 // CheckTokenRateLimit checks rate limit for a token
 func (rl *RateLimiter) CheckTokenRateLimit(ctx context.Context, token string) (*CheckResult, error) {
-	key := strategy.GetKeyWithPrefix("token", token)
+	rl.migrateKey(ctx, "token", token)
+	key := rl.Key("token", token)
 
 	// Get token-specific configuration
-	tokenConfig, exists := rl.config.RateLimit.TokenLimits[token]
+	policy := rl.Policy()
+	tokenConfig, exists := policy.TokenLimits[token]
 	if !exists {
 		// Token not configured, use IP limits as fallback
+		traceStep(ctx, "token_limit", time.Now(), "%s not_configured", key)
 		return nil, fmt.Errorf("token not configured")
 	}
 
-	// Increment counter first (Redis will handle TTL automatically)
-	newCount, err := rl.storage.Increment(ctx, key, time.Second)
+	return rl.checkLimit(ctx, "token", key, tokenConfig.Limit, tokenConfig.Burst, policy.TokenWindow(tokenConfig), tokenConfig.BlockTime, tokenConfig.Windows, "Token rate limit exceeded")
+}
+
+// CheckRouteRateLimit checks a per-route limit for a client, identified by its
+// token when present and by its IP otherwise. Route limits replace the default
+// IP/token limits, but blocked IPs are still rejected
+func (rl *RateLimiter) CheckRouteRateLimit(ctx context.Context, route config.RouteLimit, ip, token string) (*CheckResult, error) {
+	scope := "route:" + route.Method + ":" + route.Pattern
+	return rl.checkScopedLimit(ctx, "route", scope, route.Limit, 0, rl.Policy().LimitWindow(), route.BlockTime, nil, ip, token, "Route rate limit exceeded")
+}
+
+// CheckMethodRateLimit counts a client against a budget of its own for an
+// HTTP method, the same size as its IP or token limit, so e.g. monitoring
+// HEAD requests do not consume the quota of regular requests
+func (rl *RateLimiter) CheckMethodRateLimit(ctx context.Context, method, ip, token string) (*CheckResult, error) {
+	policy := rl.Policy()
+	limit, burst, window, blockTime, windows := policy.IPLimit, policy.IPBurst, policy.LimitWindow(), policy.IPBlockTime, policy.IPWindows
+	if tokenConfig, ok := policy.TokenLimits[token]; ok && token != "" {
+		limit, burst, window, blockTime, windows = tokenConfig.Limit, tokenConfig.Burst, policy.TokenWindow(tokenConfig), tokenConfig.BlockTime, tokenConfig.Windows
+	} else {
+		token = ""
+	}
+	return rl.checkScopedLimit(ctx, "method", "method:"+method, limit, burst, window, blockTime, windows, ip, token, method+" rate limit exceeded")
+}
+
+// CheckBlocked returns a denied result if the IP is blocked, or nil otherwise,
+// without counting the request
+func (rl *RateLimiter) CheckBlocked(ctx context.Context, ip string) (*CheckResult, error) {
+	rl.migrateKey(ctx, "ip", ip)
+	return rl.checkBlocked(ctx, rl.Key("ip", ip), "IP blocked")
+}
+
+// CheckRuleRateLimit checks the limit of a policy rule for a client, counted
+// separately per rule like route limits
+func (rl *RateLimiter) CheckRuleRateLimit(ctx context.Context, name string, limit int, blockTime time.Duration, ip, token string) (*CheckResult, error) {
+	return rl.checkScopedLimit(ctx, "rule", "rule:"+name, limit, 0, rl.Policy().LimitWindow(), blockTime, nil, ip, token, "Rule "+name+" rate limit exceeded")
+}
+
+// checkScopedLimit counts a client against a limit scoped to a route or rule
+func (rl *RateLimiter) checkScopedLimit(ctx context.Context, policy, scope string, limit, burst int, window, blockTime time.Duration, windows []config.WindowLimit, ip, token, reason string) (*CheckResult, error) {
+	rl.migrateKey(ctx, "ip", ip)
+	blockResult, err := rl.checkBlocked(ctx, rl.Key("ip", ip), "IP blocked")
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment counter: %w", err)
+		return nil, err
+	}
+	if blockResult != nil {
+		return blockResult, nil
+	}
+
+	client := rl.Key("ip", ip)
+	if custom, ok := ClientKeyFromContext(ctx); ok {
+		client = rl.Key(custom.Kind, custom.Key)
+	} else if token != "" {
+		client = rl.Key("token", token)
 	}
+	key := strategy.GetKeyWithPrefix(scope, client)
 
-	// Check if limit is exceeded after increment
-	if newCount > tokenConfig.Limit {
-		// Return rate limit exceeded (no permanent blocking)
-		now := time.Now()
-		resetTime := now.Add(time.Second)
+	return rl.checkLimit(ctx, policy, key, limit, burst, window, blockTime, windows, reason)
+}
 
-		return &CheckResult{
-			Allowed:   false,
-			Remaining: 0,
-			ResetTime: resetTime,
-			Reason:    "Token rate limit exceeded",
-		}, nil
+// checkLimit counts a request against a key with the policy's algorithm, the
+// limit applying per window and burst sizing the token bucket. A request
+// within the limit is then counted against each further window, and the
+// strictest decision wins
+func (rl *RateLimiter) checkLimit(ctx context.Context, policy, key string, limit, burst int, window, blockFor time.Duration, windows []config.WindowLimit, reason string) (*CheckResult, error) {
+	start := time.Now()
+	algorithm := rl.algorithm(policy)
+	req := Request{
+		Policy:   policy,
+		Key:      key,
+		Limit:    limit,
+		Burst:    burst,
+		Cost:     CostFromContext(ctx),
+		Window:   window,
+		BlockFor: blockFor,
+	}
+	decision, err := algorithm.Check(ctx, req)
+	if err == nil {
+		rl.checkShadow(ctx, req, decision.Allowed)
+	}
+	if err == nil && decision.Allowed {
+		decision, limit, err = rl.checkWindows(ctx, algorithm, policy, key, decision, limit, windows)
+	}
+	metrics.CheckLatency.WithLabelValues(policy).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
 	}
 
-	remaining := tokenConfig.Limit - newCount
-	if remaining < 0 {
-		remaining = 0
+	// Indexed storages list the key, and keep a new block listed while it lasts
+	if index, ok := rl.storage.(strategy.KeyIndexStorage); ok {
+		index.Touch(key, time.Now().Add(decision.BlockTime))
 	}
 
-	// Calculate reset time (current time + 1 second)
-	resetTime := time.Now().Add(time.Second)
+	if decision.BlockedBefore {
+		rl.recordDecision(ctx, "blocked", key, false)
+	} else {
+		rl.recordDecision(ctx, policy, key, decision.Allowed)
+	}
+	if decision.BlockedNow {
+		rl.events.Emit(events.Event{
+			Type:      events.TypeBlocked,
+			Key:       key,
+			Reason:    reason,
+			BlockTime: decision.BlockTime,
+			RequestID: RequestIDFromContext(ctx),
+		})
+	}
 
-	return &CheckResult{
-		Allowed:   true,
-		Remaining: remaining,
-		ResetTime: resetTime,
-	}, nil
+	result := &CheckResult{
+		Allowed:   decision.Allowed,
+		Limit:     limit,
+		Remaining: decision.Remaining,
+		ResetTime: decision.ResetTime,
+		BlockTime: decision.BlockTime,
+		Delay:     decision.Delay,
+		Policy:    policy,
+		Key:       key,
+	}
+	if decision.BlockedBefore {
+		result.Policy = "blocked"
+	}
+	if !decision.Allowed {
+		result.Remaining = 0
+		result.Reason = reason
+	}
+	return result, nil
+}
+
+// checkWindows counts an allowed request against the further windows of its
+// limit, each in a key of its own. They never block: a window that is
+// exceeded rejects the request until it ends. It returns the strictest
+// decision with its limit
+func (rl *RateLimiter) checkWindows(ctx context.Context, algorithm Algorithm, policy, key string, decision *Decision, limit int, windows []config.WindowLimit) (*Decision, int, error) {
+	for _, window := range windows {
+		current, err := algorithm.Check(ctx, Request{
+			Policy: policy,
+			Key:    key + ":" + window.Window.String(),
+			Limit:  window.Limit,
+			Cost:   CostFromContext(ctx),
+			Window: window.Window,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if !current.Allowed {
+			return current, window.Limit, nil
+		}
+		if current.Remaining < decision.Remaining {
+			decision, limit = current, window.Limit
+		}
+	}
+	return decision, limit, nil
 }
 
 // CheckRateLimit checks rate limit for both IP and token, prioritizing token limits
 func (rl *RateLimiter) CheckRateLimit(ctx context.Context, ip, token string) (*CheckResult, error) {
+	rl.migrateKey(ctx, "ip", ip)
+
+	// Blocked IPs are rejected regardless of the token they present
+	blockResult, err := rl.checkBlocked(ctx, rl.Key("ip", ip), "IP blocked")
+	if err != nil {
+		return nil, err
+	}
+	if blockResult != nil {
+		return blockResult, nil
+	}
+
+	// A custom client identity replaces the IP and token limits
+	if custom, ok := ClientKeyFromContext(ctx); ok {
+		if custom.Limit > 0 {
+			window := custom.Window
+			if window <= 0 {
+				window = rl.Policy().LimitWindow()
+			}
+			return rl.checkLimit(ctx, custom.Kind, rl.Key(custom.Kind, custom.Key), custom.Limit, 0, window, 0, nil, custom.Kind+" rate limit exceeded")
+		}
+		policy := rl.Policy()
+		return rl.checkLimit(ctx, custom.Kind, rl.Key(custom.Kind, custom.Key), policy.IPLimit, policy.IPBurst, policy.LimitWindow(), policy.IPBlockTime, policy.IPWindows, custom.Kind+" rate limit exceeded")
+	}
+
 	// If token is provided, check token limits first
 	if token != "" {
-		log.Printf("Checking token rate limit for token: %s", token)
 		tokenResult, err := rl.CheckTokenRateLimit(ctx, token)
 		if err == nil {
-			log.Printf("Token rate limit result: Allowed=%t, Remaining=%d", tokenResult.Allowed, tokenResult.Remaining)
 			return tokenResult, nil
 		}
-		log.Printf("Token rate limit failed: %v, falling back to IP", err)
 		// If token check fails (e.g., token not configured), fall back to IP check
+		logger(ctx).Warn("Token rate limit check failed, falling back to IP", "error", err)
 	}
 
 	// Check IP limits
-	log.Printf("Checking IP rate limit for IP: %s", ip)
 	return rl.CheckIPRateLimit(ctx, ip)
 }
 
+// checkBlocked returns a denied result if the key is currently blocked, or nil otherwise
+func (rl *RateLimiter) checkBlocked(ctx context.Context, key, reason string) (*CheckResult, error) {
+	start := time.Now()
+	blocked, blockUntil, err := rl.storage.IsBlocked(ctx, key)
+	if err != nil {
+		traceStep(ctx, "blocked", start, "error(%v)", err)
+		return nil, fmt.Errorf("failed to check block: %w", err)
+	}
+	traceStep(ctx, "blocked", start, "%s %t", key, blocked)
+	if !blocked {
+		return nil, nil
+	}
+	rl.recordDecision(ctx, "blocked", key, false)
+
+	return &CheckResult{
+		Allowed:   false,
+		Remaining: 0,
+		ResetTime: blockUntil,
+		BlockTime: time.Until(blockUntil),
+		Reason:    reason,
+		Policy:    "blocked",
+		Key:       key,
+	}, nil
+}
+
+// recordDecision updates the per-policy metrics and reports rejected keys
+func (rl *RateLimiter) recordDecision(ctx context.Context, policy, key string, allowed bool) {
+	if allowed {
+		metrics.Decisions.WithLabelValues(policy, "allowed").Inc()
+		return
+	}
+
+	metrics.Decisions.WithLabelValues(policy, "denied").Inc()
+	rl.events.Emit(events.Event{
+		Type:      events.TypeLimited,
+		Key:       key,
+		Policy:    policy,
+		RequestID: RequestIDFromContext(ctx),
+	})
+}
+
+// Block blocks a key for the given duration and emits a block event
+func (rl *RateLimiter) Block(ctx context.Context, key string, duration time.Duration, reason string) error {
+	if err := rl.storage.SetBlocked(ctx, key, time.Now().Add(duration)); err != nil {
+		return fmt.Errorf("failed to block key: %w", err)
+	}
+
+	rl.events.Emit(events.Event{
+		Type:      events.TypeBlocked,
+		Key:       key,
+		Reason:    reason,
+		BlockTime: duration,
+		RequestID: RequestIDFromContext(ctx),
+	})
+
+	return nil
+}
+
+// Grant gives a key extra units on top of its configured limit until the given
+// time, e.g. the end of the current window or day. Grants add up, and the
+// balance lasts until the latest expiry. It returns the total units granted
+func (rl *RateLimiter) Grant(ctx context.Context, key string, units int, until time.Time) (int, error) {
+	grantKey := strategy.GrantKeyFor(key)
+
+	expiration := time.Until(until)
+	current, err := rl.storage.Get(ctx, grantKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read grant: %w", err)
+	}
+	if current.Count > 0 {
+		expiration = max(expiration, time.Until(current.ResetTime))
+	}
+	if expiration <= 0 {
+		return 0, fmt.Errorf("grant expiry is in the past")
+	}
+
+	total, err := rl.storage.Increment(ctx, grantKey, units, expiration)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store grant: %w", err)
+	}
+
+	slog.Info("Granted extra units", "key", key, "units", units, "total", total, "until", time.Now().Add(expiration).Format(time.RFC3339))
+	return total, nil
+}
+
+// RecordStrike counts a misbehavior strike for a key within the given window
+// and returns the number of strikes recorded so far
+func (rl *RateLimiter) RecordStrike(ctx context.Context, key string, window time.Duration) (int, error) {
+	return rl.storage.Increment(ctx, strategy.GetKeyWithPrefix("strikes", key), 1, window)
+}
+
+// Strikes returns the number of strikes currently recorded for a key
+func (rl *RateLimiter) Strikes(ctx context.Context, key string) (int, error) {
+	info, err := rl.storage.Get(ctx, strategy.GetKeyWithPrefix("strikes", key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Count, nil
+}
+
 // ResetRateLimit resets rate limit for a specific key
 func (rl *RateLimiter) ResetRateLimit(ctx context.Context, key string) error {
-	return rl.storage.Delete(ctx, key)
+	if err := rl.storage.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	// The counters of the further windows configured for any key
+	policy := rl.Policy()
+	seen := make(map[time.Duration]bool)
+	windows := append([]config.WindowLimit(nil), policy.IPWindows...)
+	for _, limit := range policy.TokenLimits {
+		windows = append(windows, limit.Windows...)
+	}
+	for _, window := range windows {
+		if seen[window.Window] {
+			continue
+		}
+		seen[window.Window] = true
+		if err := rl.storage.Delete(ctx, key+":"+window.Window.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unblock lifts the block of a key without clearing its counter, reporting
+// whether it was blocked
+func (rl *RateLimiter) Unblock(ctx context.Context, key string) (bool, error) {
+	unblocked, err := rl.storage.Unblock(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to unblock %s: %w", key, err)
+	}
+	if unblocked {
+		rl.events.Emit(events.Event{Type: events.TypeUnblocked, Key: key})
+	}
+	return unblocked, nil
 }
 
 // GetRateLimitInfo returns current rate limit information for a key
@@ -0,0 +1,169 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/keys"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// keyMigration carries counters and blocks from the keys of the previous
+// canonicalization to the current ones, once per previous key
+type keyMigration struct {
+	previous  map[string]keys.Pipeline
+	startedAt time.Time
+
+	mu     sync.Mutex
+	report MigrationReport
+}
+
+// MigrationReport describes the progress of a key migration on this instance
+type MigrationReport struct {
+	StartedAt time.Time `json:"started_at"`
+	// Checked counts the previous keys looked up, Carried those that had a
+	// counter or block carried over
+	Checked int `json:"checked"`
+	Carried int `json:"carried"`
+	Blocks  int `json:"blocks"`
+	Errors  int `json:"errors"`
+	// LastCarriedAt is when state was last found under a previous key
+	LastCarriedAt time.Time `json:"last_carried_at,omitempty"`
+	// CompleteAt is when no previous key can hold live state anymore, the
+	// longest window or block time after the last carried state
+	CompleteAt time.Time `json:"complete_at"`
+	Complete   bool      `json:"complete"`
+}
+
+// SetKeyMigration starts a migration from the keys built by the previous
+// pipelines, by kind ("ip" or "token"). Until it is removed, the first check
+// of each client adds the counter of its previous key to the current one and
+// copies its block, so clients are neither charged twice nor reset
+func (rl *RateLimiter) SetKeyMigration(previous map[string]keys.Pipeline) {
+	now := time.Now()
+	rl.migration = &keyMigration{
+		previous:  previous,
+		startedAt: now,
+		report:    MigrationReport{StartedAt: now},
+	}
+}
+
+// KeyMigrationReport returns the progress of the key migration, false when
+// there is none
+func (rl *RateLimiter) KeyMigrationReport() (MigrationReport, bool) {
+	m := rl.migration
+	if m == nil {
+		return MigrationReport{}, false
+	}
+
+	m.mu.Lock()
+	report := m.report
+	m.mu.Unlock()
+
+	since := report.StartedAt
+	if report.LastCarriedAt.After(since) {
+		since = report.LastCarriedAt
+	}
+	report.CompleteAt = since.Add(stateLifetime(rl.Policy()))
+	report.Complete = !time.Now().Before(report.CompleteAt)
+	return report, true
+}
+
+// migrateKey carries the state of the previous key of a client over to its
+// current key, the first time the previous key is seen. Errors are counted
+// and otherwise ignored, so the migration never fails a check
+func (rl *RateLimiter) migrateKey(ctx context.Context, kind, identifier string) {
+	m := rl.migration
+	if m == nil || identifier == "" {
+		return
+	}
+	pipeline, ok := m.previous[kind]
+	if !ok {
+		return
+	}
+	previous := strategy.GetKeyWithPrefix(kind, pipeline.Apply(identifier))
+	current := rl.Key(kind, identifier)
+	if previous == current {
+		return
+	}
+
+	// The marker outlives any state of the previous key, so it is carried once
+	first, err := rl.storage.SetNX(ctx, strategy.GetKeyWithPrefix("migrated", previous), current, stateLifetime(rl.Policy()))
+	if err != nil {
+		m.record(kind, "error", false)
+		return
+	}
+	if !first {
+		return
+	}
+
+	info, err := rl.storage.Get(ctx, previous)
+	if err != nil {
+		m.record(kind, "error", false)
+		return
+	}
+	blocked, blockUntil, err := rl.storage.IsBlocked(ctx, previous)
+	if err != nil {
+		m.record(kind, "error", false)
+		return
+	}
+
+	if info.Count > 0 {
+		if ttl := time.Until(info.ResetTime); ttl > 0 {
+			if _, err := rl.storage.Increment(ctx, current, info.Count, ttl); err != nil {
+				m.record(kind, "error", false)
+				return
+			}
+		}
+	}
+	if blocked {
+		if err := rl.storage.SetBlocked(ctx, current, blockUntil); err != nil {
+			m.record(kind, "error", false)
+			return
+		}
+	}
+
+	if info.Count > 0 || blocked {
+		m.record(kind, "carried", blocked)
+	} else {
+		m.record(kind, "empty", false)
+	}
+}
+
+// record counts the outcome of a previous key lookup
+func (m *keyMigration) record(kind, result string, blocked bool) {
+	metrics.KeyMigrations.WithLabelValues(kind, result).Inc()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.Checked++
+	switch result {
+	case "carried":
+		m.report.Carried++
+		m.report.LastCarriedAt = time.Now()
+		if blocked {
+			m.report.Blocks++
+		}
+	case "error":
+		m.report.Errors++
+	}
+}
+
+// stateLifetime returns the longest a key can hold state under a policy: its
+// longest window or block time
+func stateLifetime(policy config.Policy) time.Duration {
+	lifetime := max(policy.LimitWindow(), policy.IPBlockTime)
+	for _, window := range policy.IPWindows {
+		lifetime = max(lifetime, window.Window)
+	}
+	for _, limit := range policy.TokenLimits {
+		lifetime = max(lifetime, policy.TokenWindow(limit), limit.BlockTime)
+		for _, window := range limit.Windows {
+			lifetime = max(lifetime, window.Window)
+		}
+	}
+	return lifetime
+}
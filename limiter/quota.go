@@ -0,0 +1,97 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Quota periods
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// quotaRetention keeps quota counters past the end of their period, so
+// instances with slightly late clocks still find them
+const quotaRetention = time.Hour
+
+// QuotaResult is the outcome of the quotas of a token
+type QuotaResult struct {
+	Allowed bool `json:"allowed"`
+	// Period, Limit, Remaining and ResetTime describe the quota closest to
+	// running out, or the exceeded one
+	Period    string    `json:"period"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetTime time.Time `json:"reset_time"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// quotaPeriod returns the storage prefix and the end of the UTC calendar
+// period containing now
+func quotaPeriod(period string, now time.Time) (string, time.Time) {
+	now = now.UTC()
+	if period == QuotaDaily {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return "quota:" + period + ":" + start.Format("20060102"), start.AddDate(0, 0, 1)
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return "quota:" + period + ":" + start.Format("200601"), start.AddDate(0, 1, 0)
+}
+
+// CheckTokenQuota counts a request against the daily and monthly quotas of a
+// token, returning nil when it has none. Quotas are counted from the shortest
+// period and the first one exceeded denies the request, so requests denied
+// by the daily quota do not consume the monthly one
+func (rl *RateLimiter) CheckTokenQuota(ctx context.Context, token string) (*QuotaResult, error) {
+	limit, ok := rl.Policy().TokenLimits[token]
+	if !ok || token == "" || (limit.DailyQuota <= 0 && limit.MonthlyQuota <= 0) {
+		return nil, nil
+	}
+
+	key := rl.Key("token", token)
+	now := time.Now()
+	var result *QuotaResult
+	for _, quota := range []struct {
+		period string
+		limit  int
+	}{
+		{QuotaDaily, limit.DailyQuota},
+		{QuotaMonthly, limit.MonthlyQuota},
+	} {
+		if quota.limit <= 0 {
+			continue
+		}
+
+		prefix, end := quotaPeriod(quota.period, now)
+		start := time.Now()
+		count, err := rl.storage.Increment(ctx, strategy.GetKeyWithPrefix(prefix, key), CostFromContext(ctx), end.Sub(now)+quotaRetention)
+		if err != nil {
+			traceStep(ctx, quota.period+"_quota", start, "error(%v)", err)
+			return nil, fmt.Errorf("failed to count %s quota: %w", quota.period, err)
+		}
+		traceStep(ctx, quota.period+"_quota", start, "%s %d/%d", key, count, quota.limit)
+
+		current := &QuotaResult{
+			Allowed:   count <= quota.limit,
+			Period:    quota.period,
+			Limit:     quota.limit,
+			Remaining: max(quota.limit-count, 0),
+			ResetTime: end,
+		}
+		if !current.Allowed {
+			current.Reason = "Token " + quota.period + " quota exceeded"
+			rl.recordDecision(ctx, "quota", key, false)
+			return current, nil
+		}
+		if result == nil || current.Remaining < result.Remaining {
+			result = current
+		}
+	}
+
+	rl.recordDecision(ctx, "quota", key, true)
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"context"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// SetShadowAlgorithm evaluates the named algorithm for a policy ("ip" or
+// "token") alongside the enforcing one, without enforcing it. An empty name
+// disables the shadow
+func (rl *RateLimiter) SetShadowAlgorithm(policy, name string) error {
+	if name == "" {
+		delete(rl.shadows, policy)
+		return nil
+	}
+
+	algorithm, err := NewAlgorithm(name, rl.storage, rl.buckets[policy])
+	if err != nil {
+		return err
+	}
+	rl.shadows[policy] = algorithm
+	return nil
+}
+
+// checkShadow counts the request with the shadow algorithm of its policy, in
+// keys of its own so the enforcing state is never touched, and records how
+// its decision compares with the enforced one
+func (rl *RateLimiter) checkShadow(ctx context.Context, req Request, enforced bool) {
+	shadow, ok := rl.shadows[req.Policy]
+	if !ok {
+		return
+	}
+
+	req.Key = strategy.GetKeyWithPrefix("shadow", req.Key)
+	decision, err := shadow.Check(ctx, req)
+	if err != nil {
+		metrics.ShadowDecisions.WithLabelValues(req.Policy, decisionLabel(enforced), "error").Inc()
+		return
+	}
+
+	metrics.ShadowDecisions.WithLabelValues(req.Policy, decisionLabel(enforced), decisionLabel(decision.Allowed)).Inc()
+	switch {
+	case enforced && !decision.Allowed:
+		metrics.ShadowDivergence.WithLabelValues(req.Policy, "stricter").Inc()
+	case !enforced && decision.Allowed:
+		metrics.ShadowDivergence.WithLabelValues(req.Policy, "looser").Inc()
+	}
+}
+
+// decisionLabel returns the metric label of a decision
+func decisionLabel(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
@@ -0,0 +1,74 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type traceKey struct{}
+
+// TraceStep is a single evaluated rule or storage operation
+type TraceStep struct {
+	Name     string        `json:"name"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Trace records the rule chain evaluated for a request and the storage timings
+type Trace struct {
+	mu    sync.Mutex
+	steps []TraceStep
+}
+
+// WithTrace returns a context that collects a decision trace
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	trace := &Trace{}
+	return context.WithValue(ctx, traceKey{}, trace), trace
+}
+
+// TraceFromContext returns the trace carried by ctx, or nil when tracing is off
+func TraceFromContext(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+// Record appends a step to the trace. It is a no-op on a nil trace
+func (t *Trace) Record(name, detail string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, TraceStep{Name: name, Detail: detail, Duration: duration})
+}
+
+// Steps returns a copy of the recorded steps
+func (t *Trace) Steps() []TraceStep {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceStep(nil), t.steps...)
+}
+
+// String formats the trace as a compact header value, e.g.
+// "ip_blocked(ip:1.2.3.4)=false 312µs; ip_limit(ip:1.2.3.4)=3/10 402µs"
+func (t *Trace) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parts := make([]string, 0, len(t.steps))
+	for _, step := range t.steps {
+		parts = append(parts, fmt.Sprintf("%s=%s %s", step.Name, step.Detail, step.Duration.Round(time.Microsecond)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// traceStep records the time elapsed since start when tracing is enabled
+func traceStep(ctx context.Context, name string, start time.Time, format string, args ...interface{}) {
+	if trace := TraceFromContext(ctx); trace != nil {
+		trace.Record(name, fmt.Sprintf(format, args...), time.Since(start))
+	}
+}
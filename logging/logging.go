@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// New creates a logger writing to w with the configured level and format
+func New(w io.Writer, cfg config.LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch cfg.Format {
+	case config.LogFormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case config.LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+}
+
+// Setup makes the configured logger, writing to stderr, the default one. The
+// standard log package writes through it too
+func Setup(cfg config.LogConfig) error {
+	logger, err := New(os.Stderr, cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ratelimiter"
+
+var (
+	// LeaderTransitions counts leadership acquisitions and losses
+	LeaderTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "leader_transitions_total",
+		Help:      "Number of times this instance acquired or lost leadership.",
+	}, []string{"transition"})
+
+	// IsLeader is 1 while this instance holds leadership
+	IsLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this instance is currently the leader.",
+	})
+
+	// Decisions counts rate limit decisions by policy and result
+	Decisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decisions_total",
+		Help:      "Number of rate limit decisions by policy and result.",
+	}, []string{"policy", "result"})
+
+	// JobRuns counts background job executions by job and result
+	JobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Number of leader-only background job runs.",
+	}, []string{"job", "result"})
+
+	// BlockPropagation observes how long a block takes to be enforced by this instance
+	BlockPropagation = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "block_propagation_seconds",
+		Help:      "Time between a canary block being set and this instance enforcing it.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+
+	// BlockPropagationSLO counts canary probes by whether they met the propagation SLO
+	BlockPropagationSLO = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "block_propagation_slo_total",
+		Help:      "Number of canary block probes that met or missed the propagation SLO.",
+	}, []string{"result"})
+
+	// BlockPropagationSLOSeconds is the configured propagation SLO
+	BlockPropagationSLOSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "block_propagation_slo_seconds",
+		Help:      "Configured maximum time for a block to be enforced fleet-wide.",
+	})
+
+	// StorageRetries counts retries of transient storage errors by outcome
+	StorageRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_retries_total",
+		Help:      "Number of storage operation retries by result (retried, exhausted, budget_exceeded).",
+	}, []string{"result"})
+
+	// StorageLatency observes storage commands by command and result
+	StorageLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_command_duration_seconds",
+		Help:      "Latency of storage commands by command (pipelines as \"pipeline\") and result (ok, error).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "result"})
+
+	// CheckLatency observes rate limit checks by policy, storage round trips included
+	CheckLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_duration_seconds",
+		Help:      "Latency of rate limit checks by policy.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"policy"})
+
+	// Events counts events emitted by the rate limiter by type (blocked, honeypot, limited, relaxed)
+	Events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Number of rate limiter events by type.",
+	}, []string{"type"})
+
+	// InFlight is the number of requests currently being served by this instance
+	InFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of requests currently being served.",
+	})
+
+	// InFlightTopKeys is the in-flight requests of the busiest keys, bounded to the top N
+	InFlightTopKeys = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_requests_by_key",
+		Help:      "In-flight requests of the keys with the most concurrent requests on this instance.",
+	}, []string{"key"})
+
+	// BodyTooLarge counts requests rejected for exceeding the body size of their tier
+	BodyTooLarge = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "body_too_large_total",
+		Help:      "Number of requests rejected with 413 by tier.",
+	}, []string{"tier"})
+
+	// ErrorBudgetBlockedRatio is the fraction of legitimate requests blocked in the last window
+	ErrorBudgetBlockedRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "error_budget_blocked_ratio",
+		Help:      "Fraction of requests from legitimate clients blocked in the last error budget window.",
+	})
+
+	// ErrorBudgetFactor is the multiplier currently applied to the baseline limits
+	ErrorBudgetFactor = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "error_budget_relax_factor",
+		Help:      "Multiplier applied to the baseline limits by the error budget, 1 when not relaxed.",
+	})
+
+	// ErrorBudgetAdjustments counts limit adjustments by direction
+	ErrorBudgetAdjustments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "error_budget_adjustments_total",
+		Help:      "Number of times the error budget relaxed or restored the limits.",
+	}, []string{"direction"})
+
+	// RouteDecisions counts rate limit decisions by method, normalized route and result
+	RouteDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_decisions_total",
+		Help:      "Number of rate limit decisions by method, normalized route and result.",
+	}, []string{"method", "route", "result"})
+
+	// DegradedDecisions counts requests decided without the storage, by failure mode and result
+	DegradedDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "degraded_decisions_total",
+		Help:      "Number of requests decided by the failure mode because the storage failed.",
+	}, []string{"mode", "result"})
+
+	// SchemaMigrations counts stored values migrated on read, by the schema version they had and result
+	SchemaMigrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_migrations_total",
+		Help:      "Number of stored values read in an older schema version and rewritten in the current one, by source version and result (rewritten, error).",
+	}, []string{"from_version", "result"})
+
+	// ShadowDecisions counts requests decided by both the enforcing and the
+	// shadow algorithm of a policy, by the result of each
+	ShadowDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shadow_decisions_total",
+		Help:      "Number of requests decided by the enforcing and the shadow algorithm, by policy, enforced result and shadow result (allowed, denied, error).",
+	}, []string{"policy", "enforced", "shadow"})
+
+	// ShadowDivergence counts requests the shadow algorithm decided differently
+	ShadowDivergence = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shadow_divergence_total",
+		Help:      "Number of requests the shadow algorithm decided differently from the enforcing one, by policy and direction (stricter, looser).",
+	}, []string{"policy", "direction"})
+
+	// KeyMigrations counts previous keys looked up during a key migration, by kind and result
+	KeyMigrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_migrations_total",
+		Help:      "Number of keys of the previous canonicalization looked up during a key migration, by kind and result (carried, empty, error).",
+	}, []string{"kind", "result"})
+
+	// BlocksCreated counts the blocks set by this instance, by key kind and reason
+	BlocksCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_created_total",
+		Help:      "Number of blocks set by this instance, by key kind and reason.",
+	}, []string{"kind", "reason"})
+
+	// BlocksExpired counts the blocks set by this instance that ran their full time
+	BlocksExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_expired_total",
+		Help:      "Number of blocks set by this instance that expired without being lifted, by key kind and reason.",
+	}, []string{"kind", "reason"})
+
+	// CredentialConflicts counts requests carrying several distinct credentials, by handling mode
+	CredentialConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "credential_conflicts_total",
+		Help:      "Number of requests carrying several distinct credentials, by handling mode (reject, all).",
+	}, []string{"mode"})
+
+	// LocalCacheLookups counts lookups of the local cache in front of Redis, by kind and result
+	LocalCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "local_cache_lookups_total",
+		Help:      "Number of lookups of the local cache in front of Redis, by kind (block, counter) and result (hit, miss).",
+	}, []string{"kind", "result"})
+
+	// BatchedIncrements counts the increments written to Redis by batch flushes
+	BatchedIncrements = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batched_increments_total",
+		Help:      "Number of increments accumulated locally and written to Redis by batch flushes.",
+	})
+
+	// BatchFlushes counts the flushes of batched increments, by result
+	BatchFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batch_flushes_total",
+		Help:      "Number of flushes of batched increments to Redis, by result (ok, error).",
+	}, []string{"result"})
+
+	// InjectedFaults counts the Redis commands degraded by fault injection, by type
+	InjectedFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "injected_faults_total",
+		Help:      "Number of Redis commands degraded by fault injection, by type (latency, error).",
+	}, []string{"type"})
+
+	// RLSDescriptors counts the descriptors answered by the Envoy rate limit
+	// service, by code
+	RLSDescriptors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rls_descriptors_total",
+		Help:      "Number of Envoy rate limit descriptors answered, by code (ok, over_limit, unmatched, error).",
+	}, []string{"code"})
+)
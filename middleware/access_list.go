@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/acl"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// WithAccessLists checks the client IP against the allowlist and denylist
+// before any storage access: allowed IPs are never limited and denied IPs are
+// rejected with denyStatus (403 or 429)
+func WithAccessLists(lists *acl.Lists, denyStatus int) Option {
+	return func(o *options) {
+		o.accessLists = lists
+		o.denyStatus = denyStatus
+	}
+}
+
+// rejectDenied responds to a denylisted client
+func rejectDenied(w http.ResponseWriter, status int) {
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   http.StatusText(status),
+		"message": "Access denied for this IP",
+	})
+}
+
+// checkAccessLists applies the access lists, reporting whether the request was handled
+func (o *options) checkAccessLists(w http.ResponseWriter, r *http.Request, next http.Handler, clientIP string) bool {
+	switch o.accessLists.Check(clientIP) {
+	case acl.Allowed:
+		metrics.Decisions.WithLabelValues("allowlist", "allowed").Inc()
+		next.ServeHTTP(w, r)
+		return true
+	case acl.Denied:
+		metrics.Decisions.WithLabelValues("denylist", "denied").Inc()
+		rejectDenied(w, o.denyStatus)
+		return true
+	}
+	return false
+}
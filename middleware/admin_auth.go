@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// Admin roles, writers may also read
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+)
+
+// adminIdentityKey is the context key of the authenticated admin identity
+type adminIdentityKey struct{}
+
+// AdminIdentity returns the identity that authenticated an admin request,
+// "token" or "cert:<CN>", or an empty string when ADMIN_AUTH_DISABLED opened
+// the endpoints
+func AdminIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(adminIdentityKey{}).(string)
+	return identity
+}
+
+// adminCredential is a bearer token or client certificate subject with its role
+type adminCredential struct {
+	value string
+	role  string
+}
+
+// AdminAuthMiddleware authenticates the admin endpoints with bearer tokens in
+// the Authorization header or verified client certificates, by common name.
+// GET and HEAD requests need the read role, other methods the write role.
+// Without any configured credential every request is rejected, unless
+// cfg.AuthDisabled opens the endpoints
+func AdminAuthMiddleware(cfg config.AdminConfig) func(http.Handler) http.Handler {
+	var tokens, subjects []adminCredential
+	for role, values := range map[string][]string{RoleRead: cfg.ReadTokens, RoleWrite: cfg.WriteTokens} {
+		for _, value := range values {
+			tokens = append(tokens, adminCredential{value: value, role: role})
+		}
+	}
+	for role, values := range map[string][]string{RoleRead: cfg.ReadSubjects, RoleWrite: cfg.WriteSubjects} {
+		for _, value := range values {
+			subjects = append(subjects, adminCredential{value: value, role: role})
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(tokens) == 0 && len(subjects) == 0 {
+			if cfg.AuthDisabled {
+				return next
+			}
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeAdminError(w, http.StatusForbidden,
+					"Admin endpoints are disabled: set ADMIN_WRITE_TOKENS or ADMIN_WRITE_CERT_SUBJECTS, or ADMIN_AUTH_DISABLED=true")
+			})
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, role := authenticateAdmin(r, tokens, subjects)
+			if role == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeAdminError(w, http.StatusUnauthorized, "Admin credentials required")
+				return
+			}
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				if role != RoleWrite {
+					writeAdminError(w, http.StatusForbidden, "Admin write role required")
+					return
+				}
+				slog.Info("Admin request", "method", r.Method, "path", r.URL.Path, "identity", identity)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminIdentityKey{}, identity)))
+		})
+	}
+}
+
+// authenticateAdmin returns the identity and highest role of the request
+// credentials, or an empty role when none is valid
+func authenticateAdmin(r *http.Request, tokens, subjects []adminCredential) (string, string) {
+	identity, role := "", ""
+	grant := func(id, granted string) {
+		if role != RoleWrite {
+			identity, role = id, granted
+		}
+	}
+
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(token)
+		for _, credential := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(credential.value)) == 1 {
+				grant("token", credential.role)
+			}
+		}
+	}
+
+	// Only certificates verified against the client CA count
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, credential := range subjects {
+			if name == credential.value {
+				grant("cert:"+name, credential.role)
+			}
+		}
+	}
+
+	return identity, role
+}
+
+// writeAdminError writes a JSON error for an admin request
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
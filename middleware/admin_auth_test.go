@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	const (
+		readToken  = "read-token-0123456789"
+		writeToken = "write-token-0123456789"
+	)
+	configured := config.AdminConfig{ReadTokens: []string{readToken}, WriteTokens: []string{writeToken}}
+
+	tests := []struct {
+		name   string
+		cfg    config.AdminConfig
+		method string
+		token  string
+		status int
+	}{
+		{"no credentials configured", config.AdminConfig{}, "GET", "", http.StatusForbidden},
+		{"no credentials configured, write", config.AdminConfig{}, "POST", writeToken, http.StatusForbidden},
+		{"auth explicitly disabled", config.AdminConfig{AuthDisabled: true}, "POST", "", http.StatusOK},
+		{"credentials win over the opt-out", config.AdminConfig{WriteTokens: []string{writeToken}, AuthDisabled: true}, "POST", "", http.StatusUnauthorized},
+		{"missing token", configured, "GET", "", http.StatusUnauthorized},
+		{"unknown token", configured, "GET", "not-a-valid-token-000", http.StatusUnauthorized},
+		{"reader reads", configured, "GET", readToken, http.StatusOK},
+		{"reader writes", configured, "POST", readToken, http.StatusForbidden},
+		{"writer writes", configured, "DELETE", writeToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AdminAuthMiddleware(tt.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/admin/reset/ip:127.0.0.1", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+)
+
+// WithBatchCost charges requests to the given routes one unit per item of a
+// JSON array body, capped at maxCost, so batching cannot bypass the limits
+func WithBatchCost(table *routes.Table, maxCost int) Option {
+	return func(o *options) {
+		o.costFuncs = append(o.costFuncs, batchCost(table, maxCost))
+	}
+}
+
+// batchCost returns a cost function counting the items of batch payloads
+func batchCost(table *routes.Table, maxCost int) func(*http.Request) int {
+	return func(r *http.Request) int {
+		if _, ok := table.Match(r.Method, r.URL.Path); !ok {
+			return 0
+		}
+
+		body, truncated, err := peekBody(r)
+		if err != nil {
+			return 1
+		}
+		if truncated {
+			// Too large to count in full, charge the items read so far at least
+			if maxCost > 0 {
+				return maxCost
+			}
+			return max(countItems(body), 1)
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			// Not a list payload
+			return 1
+		}
+
+		cost := max(len(items), 1)
+		if maxCost > 0 && cost > maxCost {
+			cost = maxCost
+		}
+		return cost
+	}
+}
+
+// countItems counts the complete items at the start of a JSON array, 0 when
+// the payload is not one
+func countItems(body []byte) int {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return 0
+	}
+	n := 0
+	for decoder.More() {
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// maxCostBodySize caps the bytes of a body read to compute the request cost
+const maxCostBodySize = 1 << 20
+
+// peekBody reads up to maxCostBodySize bytes of the request body, leaving the
+// whole body, or what was read followed by the read error, e.g. a body size
+// limit, for the next handler. It reports whether the body is larger
+func peekBody(r *http.Request) ([]byte, bool, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCostBodySize+1))
+	rest := io.Reader(r.Body)
+	if err != nil {
+		rest = errorReader{err}
+	}
+	r.Body = replayBody{io.MultiReader(bytes.NewReader(body), rest), r.Body}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > maxCostBodySize {
+		return body[:maxCostBodySize], true, nil
+	}
+	return body, false, nil
+}
+
+// replayBody serves the bytes read ahead before the rest of a request body
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// errorReader fails every read with err
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
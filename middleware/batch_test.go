@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+)
+
+func TestBatchCost(t *testing.T) {
+	largeBatch := "[" + strings.Repeat(`{"id":1},`, maxCostBodySize/9) + `{"id":1}]`
+
+	tests := []struct {
+		name    string
+		maxCost int
+		body    string
+		want    int
+	}{
+		{"list payload", 100, `[{"id":1},{"id":2},{"id":3}]`, 3},
+		{"capped at the maximum", 2, `[1,2,3]`, 2},
+		{"empty list", 100, `[]`, 1},
+		{"not a list", 100, `{"id":1}`, 1},
+		{"larger than the read cap", 100, largeBatch, 100},
+		// Without a maximum, the items read before the cap are charged
+		{"larger than the read cap without a maximum", 0, largeBatch, maxCostBodySize / 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost := batchCost(routes.NewTable([]config.RouteLimit{{Method: "POST", Pattern: "/api/batch"}}), tt.maxCost)
+			r := httptest.NewRequest("POST", "/api/batch", strings.NewReader(tt.body))
+
+			if got := cost(r); got != tt.want {
+				t.Errorf("cost = %d, want %d", got, tt.want)
+			}
+			// The handler still reads the whole body
+			if read, err := io.ReadAll(r.Body); err != nil || string(read) != tt.body {
+				t.Errorf("body read by the handler has %d bytes, %v, want %d", len(read), err, len(tt.body))
+			}
+		})
+	}
+}
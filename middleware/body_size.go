@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// defaultTier labels requests whose token has no tier with a body size
+const defaultTier = "default"
+
+// BodySizeMiddleware caps request bodies at the size of the client's tier, or
+// at maxBodySize for clients without one. Requests declaring a larger
+// Content-Length get a 413 right away; other bodies are cut off with
+// http.MaxBytesReader and handlers see an *http.MaxBytesError when reading past
+// the limit. A size of 0 leaves bodies unbounded
+func BodySizeMiddleware(rateLimiter *limiter.RateLimiter, maxBodySize int64, tiers map[string]config.TierLimit, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tier, limit := defaultTier, maxBodySize
+			if token := extractToken(r, o.tokenSources); token != "" {
+				name := rateLimiter.Policy().TokenLimits[token].Tier
+				if size := tiers[strings.ToUpper(name)].MaxBodySize; size > 0 {
+					tier, limit = name, size
+				}
+			}
+
+			if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > limit {
+				metrics.BodyTooLarge.WithLabelValues(tier).Inc()
+				rejectBodySize(w, limit)
+				return
+			}
+
+			r.Body = &boundedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), tier: tier}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// boundedBody counts the first read past the size limit
+type boundedBody struct {
+	io.ReadCloser
+	tier     string
+	exceeded bool
+}
+
+func (b *boundedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && !b.exceeded && errors.As(err, &tooLarge) {
+		b.exceeded = true
+		metrics.BodyTooLarge.WithLabelValues(b.tier).Inc()
+	}
+	return n, err
+}
+
+// rejectBodySize responds with 413 and the size limit
+func rejectBodySize(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "Request body too large",
+		"message": fmt.Sprintf("Request bodies are limited to %d bytes", limit),
+	})
+}
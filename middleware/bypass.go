@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/bypass"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// BypassHeader carries a signed token exempting the request from rate limiting
+const BypassHeader = "X-RateLimit-Bypass"
+
+// WithBypassTokens exempts requests carrying a valid token minted by
+// `ratelimitctl bypass` in BypassHeader. Denylisted IPs are still rejected
+func WithBypassTokens(signer *bypass.Signer) Option {
+	return func(o *options) {
+		o.bypass = signer
+	}
+}
+
+// bypassed reports whether the request carries a valid bypass token
+func (o *options) bypassed(r *http.Request, clientIP string) bool {
+	token := r.Header.Get(BypassHeader)
+	if o.bypass == nil || token == "" {
+		return false
+	}
+
+	claims, err := o.bypass.Verify(token)
+	if err != nil {
+		slog.Warn("Rejected bypass token", "ip", clientIP, "error", err)
+		return false
+	}
+
+	metrics.Decisions.WithLabelValues("bypass", "allowed").Inc()
+	slog.Info("Rate limit bypassed", "method", r.Method, "path", r.URL.Path, "ip", clientIP,
+		"subject", claims.Subject, "expires", claims.Expires().Format(time.RFC3339))
+	return true
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// FuzzGetClientIP checks that forwarded headers only ever yield canonical IP
+// addresses, and that malformed ones fall back to the peer address
+func FuzzGetClientIP(f *testing.F) {
+	for _, seed := range []struct{ forwardedFor, realIP, remoteAddr string }{
+		{"203.0.113.7", "", "192.0.2.1:1234"},
+		{"203.0.113.7, 10.0.0.1", "", "192.0.2.1:1234"},
+		{" 2001:DB8::1 ", "", "[::1]:80"},
+		{"", "198.51.100.2", "192.0.2.1:1234"},
+		{"not-an-ip", "also bad", "[2001:db8::2]:443"},
+		{"::ffff:192.0.2.9", "", "192.0.2.1"},
+		{"", "", ""},
+		{",,,", "\x00", "[::1"},
+	} {
+		f.Add(seed.forwardedFor, seed.realIP, seed.remoteAddr)
+	}
+
+	f.Fuzz(func(t *testing.T, forwardedFor, realIP, remoteAddr string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header["X-Forwarded-For"] = []string{forwardedFor}
+		r.Header["X-Real-Ip"] = []string{realIP}
+		r.RemoteAddr = remoteAddr
+
+		got := getClientIP(r)
+
+		first, _, _ := strings.Cut(forwardedFor, ",")
+		var want net.IP
+		for _, candidate := range []string{first, realIP} {
+			if want = net.ParseIP(strings.TrimSpace(candidate)); want != nil {
+				break
+			}
+		}
+		if want != nil {
+			// Header values are normalized, so equivalent spellings share a key
+			if got != want.String() {
+				t.Fatalf("getClientIP = %q, want %q", got, want.String())
+			}
+			if ip := net.ParseIP(got); ip == nil || ip.String() != got {
+				t.Fatalf("getClientIP = %q, not a canonical IP", got)
+			}
+			return
+		}
+
+		// Without a valid forwarded address the peer address is used as is
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			if got != host {
+				t.Fatalf("getClientIP = %q, want host %q of %q", got, host, remoteAddr)
+			}
+		} else if got != remoteAddr {
+			t.Fatalf("getClientIP = %q, want RemoteAddr %q", got, remoteAddr)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// WithCredentialConflict sets how requests carrying several distinct
+// credentials, e.g. an API_KEY header and a Bearer token, are handled:
+// config.CredentialFirst uses the first in source order, config.CredentialReject
+// rejects them with 400 and config.CredentialAll charges every one of them
+func WithCredentialConflict(mode string) (Option, error) {
+	switch mode {
+	case config.CredentialFirst, config.CredentialReject, config.CredentialAll:
+	default:
+		return nil, fmt.Errorf("unknown credential conflict mode %q", mode)
+	}
+
+	return func(o *options) {
+		o.credentialConflict = mode
+	}, nil
+}
+
+// resolveCredentials returns the token identifying the request and the further
+// credentials to charge, reporting false when the request was rejected
+func (o *options) resolveCredentials(w http.ResponseWriter, r *http.Request) (string, []string, bool) {
+	if o.credentialConflict == "" || o.credentialConflict == config.CredentialFirst {
+		return extractToken(r, o.tokenSources), nil, true
+	}
+
+	tokens := extractTokens(r, o.tokenSources)
+	if len(tokens) < 2 {
+		return extractToken(r, o.tokenSources), nil, true
+	}
+
+	metrics.CredentialConflicts.WithLabelValues(o.credentialConflict).Inc()
+	if o.credentialConflict == config.CredentialReject {
+		slog.Info("Rejected request with conflicting credentials", "method", r.Method, "path", r.URL.Path, "credentials", len(tokens))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Ambiguous credentials"})
+		return "", nil, false
+	}
+	return tokens[0], tokens[1:], true
+}
+
+// checkExtraCredentials charges an allowed request against the limits of the
+// further configured credentials, returning the first denial. Unconfigured
+// credentials have no limits of their own, and storage errors leave the
+// decision to the main check
+func (o *options) checkExtraCredentials(ctx context.Context, r *http.Request, rateLimiter *limiter.RateLimiter, tokens []string) *limiter.CheckResult {
+	policy := rateLimiter.Policy()
+	for _, token := range tokens {
+		if _, configured := policy.TokenLimits[token]; !configured {
+			continue
+		}
+
+		result, err := rateLimiter.CheckTokenRateLimit(ctx, token)
+		if err != nil {
+			slog.Warn("Additional credential check failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			continue
+		}
+		if !result.Allowed {
+			return result
+		}
+	}
+	return nil
+}
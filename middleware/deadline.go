@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader carries the absolute deadline of a request, as an RFC 3339
+// time or Unix time in milliseconds, set by internal callers
+const DeadlineHeader = "X-Request-Deadline"
+
+// WithRequestDeadline honors DeadlineHeader: the storage operations of the
+// check are bounded by the deadline and the request context carries it to the
+// handler. Clients could otherwise fail the check on purpose, so the header
+// must be stripped from untrusted peers by TrustBoundaryMiddleware
+func WithRequestDeadline() Option {
+	return func(o *options) {
+		o.deadlines = true
+	}
+}
+
+// parseDeadline reads an RFC 3339 time or Unix time in milliseconds
+func parseDeadline(value string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && ms > 0 {
+		return time.UnixMilli(ms), true
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	return deadline, err == nil
+}
+
+// applyDeadline bounds ctx and the request context by the request deadline,
+// ignoring missing or malformed values. When the deadline has already passed
+// it writes a 504 without counting the request and reports false
+func (o *options) applyDeadline(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, *http.Request, context.CancelFunc, bool) {
+	noop := func() {}
+	if !o.deadlines {
+		return ctx, r, noop, true
+	}
+	deadline, ok := parseDeadline(r.Header.Get(DeadlineHeader))
+	if !ok {
+		return ctx, r, noop, true
+	}
+
+	if !time.Now().Before(deadline) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Request deadline exceeded"})
+		return ctx, r, noop, false
+	}
+
+	ctx, cancelCheck := context.WithDeadline(ctx, deadline)
+	requestCtx, cancelRequest := context.WithDeadline(r.Context(), deadline)
+	return ctx, r.WithContext(requestCtx), func() {
+		cancelCheck()
+		cancelRequest()
+	}, true
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/sampling"
+)
+
+// decisionLog holds the sample rates of the decision log
+type decisionLog struct {
+	allowedRate float64
+	deniedRate  float64
+}
+
+// WithDecisionLog logs a sampled fraction of the rate limit decisions, with
+// separate rates for allowed and denied requests so blocked traffic can be
+// logged in full while allowed traffic is only sampled
+func WithDecisionLog(allowedRate, deniedRate float64) Option {
+	return func(o *options) {
+		o.decisionLog = &decisionLog{allowedRate: allowedRate, deniedRate: deniedRate}
+	}
+}
+
+// logDecision writes the decision of a request when it is sampled
+func (o *options) logDecision(r *http.Request, route string, result *limiter.CheckResult, latency time.Duration) {
+	if o.decisionLog == nil {
+		return
+	}
+	rate := o.decisionLog.allowedRate
+	if !result.Allowed {
+		rate = o.decisionLog.deniedRate
+	}
+	if !sampling.Sample(rate) {
+		return
+	}
+
+	logger(r).Info("Rate limit decision",
+		"method", r.Method,
+		"route", route,
+		"policy", result.Policy,
+		"key", result.Key,
+		"allowed", result.Allowed,
+		"remaining", result.Remaining,
+		"limit", result.Limit,
+		"reason", result.Reason,
+		"latency", latency,
+	)
+}
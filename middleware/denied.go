@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+type deniedKey struct{}
+
+// WithDeniedHandler replaces the JSON 429 response for rate limited requests,
+// e.g. to return a localized page or redirect. The quota headers and
+// Retry-After are set before the handler runs; the handler writes the status
+// and body and can read the decision with DeniedResult
+func WithDeniedHandler(handler http.Handler) Option {
+	return func(o *options) {
+		o.deniedHandler = handler
+	}
+}
+
+// DeniedResult returns the decision that rejected the request, inside a
+// handler set with WithDeniedHandler
+func DeniedResult(r *http.Request) (*limiter.CheckResult, bool) {
+	result, ok := r.Context().Value(deniedKey{}).(*limiter.CheckResult)
+	return result, ok
+}
+
+// serveDenied runs the denied handler with the decision in the request context
+func (o *options) serveDenied(w http.ResponseWriter, r *http.Request, result *limiter.CheckResult) {
+	o.deniedHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deniedKey{}, result)))
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/budget"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// WithErrorBudget reports the outcome of requests from clients with a
+// configured token to the relaxer, which relaxes the limits when too many of
+// them are blocked
+func WithErrorBudget(relaxer *budget.Relaxer) Option {
+	return func(o *options) {
+		o.relaxer = relaxer
+	}
+}
+
+// budgetClient returns the key identifying an authenticated client for the
+// error budget, empty when the request is not tracked
+func (o *options) budgetClient(rateLimiter *limiter.RateLimiter, token string) string {
+	if o.relaxer == nil || token == "" {
+		return ""
+	}
+	if _, ok := rateLimiter.Policy().TokenLimits[token]; !ok {
+		return ""
+	}
+	return rateLimiter.Key("token", token)
+}
+
+// serveTracked serves the request, recording a success for the client when
+// the handler responds without an error status
+func (o *options) serveTracked(w http.ResponseWriter, r *http.Request, next http.Handler, client string) {
+	if client == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+	if recorder.status < http.StatusBadRequest {
+		o.relaxer.Succeeded(client)
+	}
+}
+
+// statusRecorder captures the status written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
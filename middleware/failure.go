@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// WithFailureMode sets how requests are decided when the storage fails:
+// config.FailureOpen lets them through, config.FailureClosed rejects them with
+// 503 and config.FailureLocal counts them against the fallback limits
+func WithFailureMode(mode string, fallback *limiter.Fallback) (Option, error) {
+	switch mode {
+	case config.FailureOpen, config.FailureClosed:
+	case config.FailureLocal:
+		if fallback == nil {
+			return nil, fmt.Errorf("failure mode %q requires a fallback limiter", mode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown failure mode %q", mode)
+	}
+
+	return func(o *options) {
+		o.failureMode = mode
+		o.fallback = fallback
+	}, nil
+}
+
+// handleFailure decides a request whose limit check failed, reporting whether
+// the response was served. Otherwise the returned result is enforced as usual
+func (o *options) handleFailure(ctx context.Context, w http.ResponseWriter, r *http.Request, next http.Handler, methodPolicy, ip, token string, checkErr error) (*limiter.CheckResult, bool) {
+	mode := o.failureMode
+	if mode == "" {
+		mode = config.FailureOpen
+	}
+
+	if mode == config.FailureLocal {
+		// Ignored methods are never counted, and blocks are not known locally
+		if methodPolicy == config.MethodIgnore {
+			o.recordDegraded(r, mode, "allowed", checkErr)
+			return nil, false
+		}
+
+		result, err := o.fallback.CheckRateLimit(ctx, ip, token)
+		if err == nil {
+			outcome := "allowed"
+			if !result.Allowed {
+				outcome = "denied"
+			}
+			o.recordDegraded(r, mode, outcome, checkErr)
+			return result, false
+		}
+		checkErr = fmt.Errorf("%w; fallback: %v", checkErr, err)
+		mode = config.FailureOpen
+	}
+
+	if mode == config.FailureClosed {
+		o.recordDegraded(r, mode, "denied", checkErr)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Rate limit check failed"})
+		return nil, true
+	}
+
+	o.recordDegraded(r, mode, "allowed", checkErr)
+	w.Header().Set("X-RateLimit-Error", "Rate limit check failed")
+	next.ServeHTTP(w, r)
+	return nil, true
+}
+
+// recordDegraded logs and counts a decision taken without the storage
+func (o *options) recordDegraded(r *http.Request, mode, outcome string, err error) {
+	metrics.DegradedDecisions.WithLabelValues(mode, outcome).Inc()
+	logger(r).Warn("Rate limit check failed", "method", r.Method, "path", r.URL.Path, "mode", mode, "result", outcome, "error", err)
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ForceHeader requests a synthetic response, "block" forces a 429
+const ForceHeader = "X-RateLimit-Force"
+
+// AdminTokenHeader carries the admin token that authorizes forced responses
+const AdminTokenHeader = "X-Admin-Token"
+
+// forceGuard decides who may force a 429 with ForceHeader
+type forceGuard struct {
+	trusted    []*net.IPNet
+	adminToken string
+}
+
+// WithForceHeader honors "X-RateLimit-Force: block" from peers in the trusted
+// networks or requests carrying the admin token in X-Admin-Token, so client
+// teams can test their backoff handling against real 429 responses.
+// The peer address is r.RemoteAddr, which must not be taken from
+// client-controlled headers for the trusted networks to be meaningful
+func WithForceHeader(trustedCIDRs []string, adminToken string) (Option, error) {
+	guard := &forceGuard{adminToken: adminToken}
+	for _, cidr := range trustedCIDRs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() == nil {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network %q: %w", cidr, err)
+		}
+		guard.trusted = append(guard.trusted, network)
+	}
+
+	return func(o *options) {
+		o.force = guard
+	}, nil
+}
+
+// forced reports whether the request asks for and is allowed a forced 429
+func (g *forceGuard) forced(r *http.Request) bool {
+	if g == nil || !strings.EqualFold(r.Header.Get(ForceHeader), "block") {
+		return false
+	}
+
+	if g.adminToken != "" {
+		token := r.Header.Get(AdminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.adminToken)) == 1 {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range g.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
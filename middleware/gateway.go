@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/gateway"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// gatewayHints verifies the identity hints of an upstream gateway
+type gatewayHints struct {
+	verifier *gateway.Verifier
+	header   string
+	tiers    map[string]config.TierLimit
+}
+
+// WithGatewayHints identifies requests carrying a hint signed by the upstream
+// gateway in header as the customer it names, counted against the limit of
+// its tier instead of the IP or token limits. Requests without a valid hint,
+// or naming an unknown tier, are identified as usual
+func WithGatewayHints(verifier *gateway.Verifier, header string, tiers map[string]config.TierLimit) Option {
+	return func(o *options) {
+		o.gateway = &gatewayHints{verifier: verifier, header: header, tiers: tiers}
+	}
+}
+
+// applyGatewayHint returns the context identifying the customer of a valid
+// hint, reporting whether the request carried one
+func (o *options) applyGatewayHint(ctx context.Context, r *http.Request, clientIP string) (context.Context, bool) {
+	if o.gateway == nil {
+		return ctx, false
+	}
+	hint := r.Header.Get(o.gateway.header)
+	if hint == "" {
+		return ctx, false
+	}
+
+	claims, err := o.gateway.verifier.Verify(hint)
+	if err != nil {
+		slog.Warn("Rejected gateway hint", "ip", clientIP, "error", err)
+		return ctx, false
+	}
+	tier, ok := o.gateway.tiers[strings.ToUpper(claims.Tier)]
+	if !ok {
+		slog.Warn("Gateway hint names an unknown tier", "ip", clientIP, "customer", claims.Subject, "tier", claims.Tier)
+		return ctx, false
+	}
+
+	return limiter.WithClientLimit(ctx, "customer", claims.Subject, tier.Limit, tier.Window), true
+}
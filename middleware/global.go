@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// checkGlobal counts the request against the server-wide limit before the
+// per-client checks, reporting whether it was rejected. Ignored methods are
+// not counted, and storage errors leave the decision to the per-client checks
+func (o *options) checkGlobal(ctx context.Context, w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, route, token string) bool {
+	if o.readOnly || o.methodPolicy(r.Method) == config.MethodIgnore {
+		return false
+	}
+
+	start := time.Now()
+	result, err := rateLimiter.CheckGlobalLimit(ctx)
+	if err != nil {
+		logger(r).Warn("Global rate limit check failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		return false
+	}
+	if result == nil || result.Allowed {
+		return false
+	}
+
+	o.recordRoute(r.Method, route, false)
+	o.logDecision(r, route, result, time.Since(start))
+	o.writeLimited(w, r, o.headerLevel(rateLimiter, token), result)
+	return true
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// paginationArgs multiply the cost of a field's selection by their value
+var paginationArgs = []string{"first", "last", "limit"}
+
+// WithGraphQLCost charges GraphQL requests the complexity of their query
+// instead of one unit per request
+func WithGraphQLCost(cfg config.GraphQLConfig) Option {
+	return func(o *options) {
+		o.costFuncs = append(o.costFuncs, graphQLCost(cfg))
+	}
+}
+
+// graphQLCost returns a cost function scoring queries sent to the GraphQL path
+func graphQLCost(cfg config.GraphQLConfig) func(*http.Request) int {
+	return func(r *http.Request) int {
+		if r.URL.Path != cfg.Path {
+			return 0
+		}
+
+		query, operationName, truncated := readGraphQLQuery(r)
+		if truncated {
+			// Too large to parse, charge the maximum
+			return max(cfg.MaxCost, 1)
+		}
+		if query == "" {
+			return 1
+		}
+
+		doc, err := parser.ParseQuery(&ast.Source{Input: query})
+		if err != nil {
+			return 1
+		}
+
+		scorer := &complexityScorer{cfg: cfg, fragments: doc.Fragments}
+		cost := 0
+		for _, operation := range doc.Operations {
+			if operationName != "" && operation.Name != operationName {
+				continue
+			}
+			cost += scorer.selectionSet(operation.SelectionSet, 1)
+		}
+
+		if cfg.MaxCost > 0 && cost > cfg.MaxCost {
+			cost = cfg.MaxCost
+		}
+		return max(cost, 1)
+	}
+}
+
+// readGraphQLQuery returns the query and operation name from the query string
+// or the JSON body, restoring the body for the next handler. It reports
+// whether the body is too large to read
+func readGraphQLQuery(r *http.Request) (string, string, bool) {
+	if r.Method == http.MethodGet {
+		return r.URL.Query().Get("query"), r.URL.Query().Get("operationName"), false
+	}
+
+	body, truncated, err := peekBody(r)
+	if err != nil || truncated {
+		return "", "", truncated
+	}
+
+	var payload struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", "", false
+	}
+	return payload.Query, payload.OperationName, false
+}
+
+// complexityScorer sums field weights, multiplying nested selections by
+// pagination arguments and stopping at the maximum depth
+type complexityScorer struct {
+	cfg       config.GraphQLConfig
+	fragments ast.FragmentDefinitionList
+	depth     int
+}
+
+func (s *complexityScorer) selectionSet(set ast.SelectionSet, multiplier int) int {
+	s.depth++
+	defer func() { s.depth-- }()
+
+	if s.cfg.MaxDepth > 0 && s.depth > s.cfg.MaxDepth {
+		// Too deep to be legitimate, charge the maximum
+		return s.cfg.MaxCost
+	}
+
+	cost := 0
+	for _, selection := range set {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			weight, ok := s.cfg.FieldWeights[selection.Name]
+			if !ok {
+				weight = s.cfg.DefaultWeight
+			}
+			cost = s.add(cost, s.mul(weight, multiplier))
+
+			if len(selection.SelectionSet) > 0 {
+				cost = s.add(cost, s.selectionSet(selection.SelectionSet, s.mul(multiplier, pageSize(selection))))
+			}
+		case *ast.InlineFragment:
+			cost = s.add(cost, s.selectionSet(selection.SelectionSet, multiplier))
+		case *ast.FragmentSpread:
+			if fragment := s.fragments.ForName(selection.Name); fragment != nil {
+				cost = s.add(cost, s.selectionSet(fragment.SelectionSet, multiplier))
+			}
+		}
+
+		if cost >= s.limit() {
+			return s.limit()
+		}
+	}
+	return cost
+}
+
+// limit returns the cost at which scoring saturates: the maximum cost, or the
+// largest int without one
+func (s *complexityScorer) limit() int {
+	if s.cfg.MaxCost > 0 {
+		return s.cfg.MaxCost
+	}
+	return math.MaxInt
+}
+
+// mul multiplies two non-negative costs, saturating at the limit instead of
+// overflowing
+func (s *complexityScorer) mul(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > s.limit()/b {
+		return s.limit()
+	}
+	return min(a*b, s.limit())
+}
+
+// add adds two non-negative costs, saturating at the limit
+func (s *complexityScorer) add(a, b int) int {
+	if b > s.limit()-a {
+		return s.limit()
+	}
+	return a + b
+}
+
+// pageSize returns the literal value of the field's pagination argument, or 1
+func pageSize(field *ast.Field) int {
+	for _, name := range paginationArgs {
+		arg := field.Arguments.ForName(name)
+		if arg == nil || arg.Value == nil || arg.Value.Kind != ast.IntValue {
+			continue
+		}
+		if size, err := strconv.Atoi(arg.Value.Raw); err == nil && size > 0 {
+			return size
+		}
+	}
+	return 1
+}
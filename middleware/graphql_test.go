@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+func TestGraphQLCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxCost int
+		query   string
+		want    int
+	}{
+		{"flat query", 1000, `{ a { b } }`, 2},
+		{"paginated query", 1000, `{ a(first: 10) { b } }`, 11},
+		{"capped at the maximum", 1000, `{ a(first: 100) { b(first: 100) { c } } }`, 1000},
+		// The page sizes multiply past the largest int
+		{"overflowing page sizes", 1000, `{ a(first: 4611686018427387904) { b(first: 2) { c } } }`, 1000},
+		{"overflowing without a maximum", 0, `{ a(first: 4611686018427387904) { b(first: 4) { c } } }`, math.MaxInt},
+		{"overflowing field weights", 0, `{ a(first: 4611686018427387904) { b c d } }`, math.MaxInt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost := graphQLCost(config.GraphQLConfig{Path: "/graphql", DefaultWeight: 1, MaxCost: tt.maxCost})
+			body, _ := json.Marshal(map[string]string{"query": tt.query})
+			r := httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+
+			if got := cost(r); got != tt.want {
+				t.Errorf("cost = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLCostLargeBody(t *testing.T) {
+	cost := graphQLCost(config.GraphQLConfig{Path: "/graphql", DefaultWeight: 1, MaxCost: 1000})
+	body := `{"query": "{ a }", "padding": "` + strings.Repeat("x", maxCostBodySize) + `"}`
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+
+	if got := cost(r); got != 1000 {
+		t.Errorf("cost = %d, want the maximum of 1000", got)
+	}
+	// The handler still reads the whole body
+	if read, err := io.ReadAll(r.Body); err != nil || string(read) != body {
+		t.Errorf("body read by the handler has %d bytes, %v, want %d", len(read), err, len(body))
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// Quota header formats
+const (
+	// HeaderFormatLegacy emits the X-RateLimit-* headers
+	HeaderFormatLegacy = "legacy"
+	// HeaderFormatIETF emits the RateLimit-Limit, RateLimit-Remaining and
+	// RateLimit-Reset headers of draft-ietf-httpapi-ratelimit-headers
+	HeaderFormatIETF = "ietf"
+	// HeaderFormatBoth emits both sets of headers
+	HeaderFormatBoth = "both"
+)
+
+// WithHeaderFormat selects the quota headers emitted, legacy by default
+func WithHeaderFormat(format string) (Option, error) {
+	switch format {
+	case HeaderFormatLegacy, HeaderFormatIETF, HeaderFormatBoth:
+	default:
+		return nil, fmt.Errorf("unknown header format %q", format)
+	}
+
+	return func(o *options) {
+		o.headerFormat = format
+	}, nil
+}
+
+// setQuotaHeaders writes the quota headers allowed by the visibility level in
+// the configured formats. Minimal visibility only reveals when to come back,
+// and only on rejected requests
+func (o *options) setQuotaHeaders(w http.ResponseWriter, level string, result *limiter.CheckResult, limited bool) {
+	if level == HeadersNone || (level == HeadersMinimal && !limited) {
+		return
+	}
+	full := level == HeadersFull
+	header := w.Header()
+
+	if o.headerFormat != HeaderFormatIETF {
+		if full {
+			header.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", result.Remaining))
+		}
+		header.Set("X-RateLimit-Reset", result.ResetTime.Format(time.RFC3339))
+		if limited && result.BlockTime > 0 {
+			header.Set("X-RateLimit-Block-Time", result.BlockTime.String())
+		}
+	}
+
+	if o.headerFormat == HeaderFormatIETF || o.headerFormat == HeaderFormatBoth {
+		if full {
+			// Blocked clients are rejected before their limit is resolved
+			if result.Limit > 0 {
+				header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			}
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		}
+		header.Set("RateLimit-Reset", strconv.Itoa(secondsUntil(result.ResetTime)))
+	}
+}
+
+// retryAfter returns the seconds until a denied client may retry: the end of
+// its block, or of the window when it is not blocked. It is at least 1 so
+// clients never retry immediately
+func retryAfter(result *limiter.CheckResult) int {
+	retryAt := result.ResetTime
+	if blockEnd := time.Now().Add(result.BlockTime); blockEnd.After(retryAt) {
+		retryAt = blockEnd
+	}
+	return max(secondsUntil(retryAt), 1)
+}
+
+// secondsUntil returns the whole seconds left until t, rounded up
+func secondsUntil(t time.Time) int {
+	remaining := time.Until(t)
+	if remaining <= 0 {
+		return 0
+	}
+	return int(math.Ceil(remaining.Seconds()))
+}
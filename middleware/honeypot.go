@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// HoneypotMiddleware blocks the source IP of any request hitting one of the honeypot paths.
+// It must be mounted at the router root so it also sees requests for unrouted paths
+func HoneypotMiddleware(rateLimiter *limiter.RateLimiter, paths []string, blockTime time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(paths) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !matchesHoneypot(r.URL.Path, paths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			clientIP := getClientIP(r)
+			key := rateLimiter.Key("ip", clientIP)
+
+			rateLimiter.Events().Emit(events.Event{
+				Type:      events.TypeHoneypot,
+				Key:       key,
+				Path:      r.URL.Path,
+				BlockTime: blockTime,
+			})
+
+			if err := rateLimiter.Block(context.Background(), key, blockTime, "honeypot"); err != nil {
+				slog.Error("Failed to block honeypot visitor", "ip", clientIP, "error", err)
+			}
+
+			// Look like any other missing page
+			http.NotFound(w, r)
+		})
+	}
+}
+
+// matchesHoneypot reports whether path equals a honeypot path or is nested under it
+func matchesHoneypot(path string, honeypots []string) bool {
+	for _, honeypot := range honeypots {
+		if path == honeypot || strings.HasPrefix(path, strings.TrimSuffix(honeypot, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
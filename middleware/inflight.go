@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/inflight"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// InFlightMiddleware counts the requests being served per client, keyed by
+// token when a credential is present and by IP otherwise
+func InFlightMiddleware(rateLimiter *limiter.RateLimiter, tracker *inflight.Tracker, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimiter.Key("ip", getClientIP(r))
+			if token := extractToken(r, o.tokenSources); token != "" {
+				key = rateLimiter.Key("token", token)
+			}
+
+			release := tracker.Acquire(key)
+			defer release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// KeyExtractor identifies the client of a request by a key kind and value,
+// e.g. ("user", "42") from a session or ("tenant", "acme") from a header
+type KeyExtractor func(r *http.Request) (kind, key string, err error)
+
+// WithKeyExtractor identifies clients with a custom function instead of their
+// IP and API token. Returning the "ip" or "token" kind replaces that value and
+// keeps its limits; any other kind is counted separately against the IP
+// limit. Requests for which the extractor fails or returns an empty key are
+// identified as usual. Access lists still apply to the connection IP
+func WithKeyExtractor(extractor func(*http.Request) (kind, key string, err error)) Option {
+	return func(o *options) {
+		o.keyExtractor = extractor
+	}
+}
+
+// extractKey runs the key extractor, reporting whether it identified the client
+func (o *options) extractKey(r *http.Request) (kind, key string, ok bool) {
+	if o.keyExtractor == nil {
+		return "", "", false
+	}
+
+	kind, key, err := o.keyExtractor(r)
+	if err != nil || kind == "" || key == "" {
+		return "", "", false
+	}
+	return kind, key, true
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// WithMethodPolicies sets how requests are counted per HTTP method:
+// config.MethodCount (default), config.MethodIgnore to never count them, or
+// config.MethodSeparate to count them against a budget of their own. Rule and
+// route limits still apply to separately counted methods
+func WithMethodPolicies(policies map[string]string) Option {
+	return func(o *options) {
+		o.methodPolicies = policies
+	}
+}
+
+// methodPolicy returns the counting policy of a method
+func (o *options) methodPolicy(method string) string {
+	if o.readOnly {
+		return config.MethodIgnore
+	}
+	if policy, ok := o.methodPolicies[method]; ok {
+		return policy
+	}
+	return config.MethodCount
+}
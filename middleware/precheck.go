@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// PreCheck inspects a request before the storage is reached and vetoes it with
+// a reason, e.g. when a required header is missing
+type PreCheck func(r *http.Request) (veto bool, reason string)
+
+// WithPreCheck adds a hook rejecting requests before the storage is reached.
+// Hooks run in the order they were added, after the access lists and bypass
+// tokens, and the first veto answers with the regular 429 response
+func WithPreCheck(check PreCheck) Option {
+	return func(o *options) {
+		if check != nil {
+			o.preChecks = append(o.preChecks, check)
+		}
+	}
+}
+
+// checkPre runs the pre-check hooks, reporting whether the request was vetoed.
+// Vetoed requests are not counted against any limit
+func (o *options) checkPre(w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, route, token string) bool {
+	for _, check := range o.preChecks {
+		veto, reason := check(r)
+		if !veto {
+			continue
+		}
+		if reason == "" {
+			reason = "Request rejected by pre-check"
+		}
+
+		result := &limiter.CheckResult{
+			Allowed:   false,
+			ResetTime: time.Now().Add(time.Second),
+			Reason:    reason,
+			Policy:    "precheck",
+		}
+		metrics.Decisions.WithLabelValues(result.Policy, "denied").Inc()
+		o.recordRoute(r.Method, route, false)
+		o.logDecision(r, route, result, 0)
+		o.writeLimited(w, r, o.headerLevel(rateLimiter, token), result)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// checkQuota counts an allowed request against the daily and monthly quotas
+// of its token, reporting whether the response was served. Quotas are billing
+// limits on top of the rate limit, so storage failures let the request through
+func (o *options) checkQuota(ctx context.Context, w http.ResponseWriter, r *http.Request, rateLimiter *limiter.RateLimiter, level, token string) bool {
+	if o.readOnly || token == "" {
+		return false
+	}
+
+	quota, err := rateLimiter.CheckTokenQuota(ctx, token)
+	if err != nil {
+		logger(r).Warn("Quota check failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		return false
+	}
+	if quota == nil {
+		return false
+	}
+
+	o.setQuotaPeriodHeaders(w, level, quota)
+	if quota.Allowed {
+		return false
+	}
+
+	o.writeLimited(w, r, level, &limiter.CheckResult{
+		Allowed:   false,
+		Limit:     quota.Limit,
+		ResetTime: quota.ResetTime,
+		Reason:    quota.Reason,
+		Policy:    "quota",
+		Key:       rateLimiter.Key("token", token),
+	})
+	return true
+}
+
+// setQuotaPeriodHeaders writes the X-Quota-* headers allowed by the visibility
+// level, which like the rate limit headers only reveals the reset when minimal
+func (o *options) setQuotaPeriodHeaders(w http.ResponseWriter, level string, quota *limiter.QuotaResult) {
+	if level == HeadersNone || (level == HeadersMinimal && quota.Allowed) {
+		return
+	}
+
+	header := w.Header()
+	if level == HeadersFull {
+		header.Set("X-Quota-Period", quota.Period)
+		header.Set("X-Quota-Limit", strconv.Itoa(quota.Limit))
+		header.Set("X-Quota-Remaining", strconv.Itoa(quota.Remaining))
+	}
+	header.Set("X-Quota-Reset", quota.ResetTime.Format(time.RFC3339))
+}
@@ -4,77 +4,360 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/acl"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/budget"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/bypass"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
 	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
-	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/rules"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/sampling"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/timeline"
 )
 
-// RateLimitMiddleware creates a rate limiting middleware for go-chi
-func RateLimitMiddleware(rateLimiter *limiter.RateLimiter) func(http.Handler) http.Handler {
+// Option configures RateLimitMiddleware
+type Option func(*options)
+
+type options struct {
+	debug           bool
+	debugSampleRate float64
+	tokenSources    []TokenSource
+	// credentialConflict is one of the config.Credential* modes, first by default
+	credentialConflict string
+	routes             *routes.Table
+	rules              *rules.Set
+	force              *forceGuard
+	bypass             *bypass.Signer
+	gateway            *gatewayHints
+	accessLists        *acl.Lists
+	denyStatus         int
+	relaxer            *budget.Relaxer
+	keyExtractor       KeyExtractor
+	methodPolicies     map[string]string
+	deniedHandler      http.Handler
+	normalizer         *routes.Normalizer
+	resolvePattern     func(*http.Request) string
+	resolveRequestID   func(*http.Request) string
+	failureMode        string
+	fallback           *limiter.Fallback
+	decisionLog        *decisionLog
+	timeline           *timeline.Recorder
+	readOnly           bool
+	deadlines          bool
+	// headerVisibility is one of the Headers* levels, full by default
+	headerVisibility string
+	// headerFormat is one of the HeaderFormat* values, legacy by default
+	headerFormat string
+	// costFuncs price the request, the first non-zero cost wins
+	costFuncs []func(*http.Request) int
+	// preChecks veto requests before the storage is reached, the first veto wins
+	preChecks []PreCheck
+}
+
+// newOptions applies opts over the defaults
+func newOptions(opts []Option) *options {
+	o := &options{tokenSources: defaultTokenSources, headerFormat: HeaderFormatLegacy}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.normalizer == nil {
+		o.normalizer = routes.NewNormalizer(nil)
+	}
+	return o
+}
+
+// WithTokenSources sets the ordered credential sources, the first match wins
+func WithTokenSources(sources ...TokenSource) Option {
+	return func(o *options) {
+		if len(sources) > 0 {
+			o.tokenSources = sources
+		}
+	}
+}
+
+// WithDebug enables decision tracing for a sampled fraction of requests.
+// Traced requests get an X-RateLimit-Trace header and a log line
+func WithDebug(sampleRate float64) Option {
+	return func(o *options) {
+		o.debug = true
+		o.debugSampleRate = sampleRate
+	}
+}
+
+// cost returns the first non-zero cost computed for the request, or 1
+func (o *options) cost(r *http.Request) int {
+	for _, costFunc := range o.costFuncs {
+		if cost := costFunc(r); cost > 0 {
+			return cost
+		}
+	}
+	return 1
+}
+
+// WithRoutes sets the per-route limits resolved for each request
+func WithRoutes(table *routes.Table) Option {
+	return func(o *options) {
+		o.routes = table
+	}
+}
+
+// WithRules sets the policy rules evaluated for each request. A matching rule
+// limit takes precedence over route limits, and a rule cost over other costs
+func WithRules(set *rules.Set) Option {
+	return func(o *options) {
+		o.rules = set
+	}
+}
+
+// WithCostFunc adds a function pricing requests in units of the limit, e.g.
+// 10 for bulk endpoints. Cost functions run in the order they were added and
+// the first non-zero cost wins; a matching policy rule cost takes precedence
+func WithCostFunc(costFunc func(*http.Request) int) Option {
+	return func(o *options) {
+		o.costFuncs = append(o.costFuncs, costFunc)
+	}
+}
+
+// RateLimitMiddleware creates a rate limiting middleware for any net/http
+// server. It is kept for compatibility, new code should use middleware.RateLimit
+// of the v2 API
+func RateLimitMiddleware(rateLimiter *limiter.RateLimiter, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.Background()
+			// The request ID travels with the check to the limiter's events and logs
+			r = o.withRequestID(r)
+			ctx := limiter.WithRequestID(context.Background(), limiter.RequestIDFromContext(r.Context()))
+
+			// Synthetic 429 for testing client backoff, the request is not counted
+			if o.force.forced(r) {
+				o.writeLimited(w, r, o.headerLevel(rateLimiter, extractToken(r, o.tokenSources)), &limiter.CheckResult{
+					Allowed:   false,
+					Remaining: 0,
+					ResetTime: time.Now().Add(time.Second),
+					Reason:    "Rate limit forced by " + ForceHeader,
+				})
+				return
+			}
+
+			// A trusted caller's deadline bounds the check and travels to the handler
+			ctx, r, cancel, ok := o.applyDeadline(ctx, w, r)
+			defer cancel()
+			if !ok {
+				return
+			}
+
+			var trace *limiter.Trace
+			if o.debug && sampling.Sample(o.debugSampleRate) {
+				ctx, trace = limiter.WithTrace(ctx)
+			}
+
+			// Normalize the route once for rules, metrics and handlers
+			r = o.withPattern(r)
+			route := o.normalizer.Normalize(r)
+			r = r.WithContext(routes.WithRoute(r.Context(), route))
 
 			// Get client IP
 			clientIP := getClientIP(r)
 
-			// Get token from header
-			token := ""
-			if apiKey := r.Header.Get("API_KEY"); apiKey != "" {
-				var err error
-				token, err = strategy.ParseTokenFromHeader(apiKey)
-				if err != nil {
-					// Invalid token format, continue with IP-only rate limiting
-					token = ""
+			// Allowlisted and denylisted IPs never reach the storage
+			if o.checkAccessLists(w, r, next, clientIP) {
+				return
+			}
+
+			// Operators hand out signed bypass tokens during incident recovery
+			if o.bypassed(r, clientIP) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Get token from the configured sources, invalid tokens fall back to IP-only rate limiting.
+			// Requests carrying several distinct credentials are handled by the conflict mode
+			token, extraTokens, ok := o.resolveCredentials(w, r)
+			if !ok {
+				return
+			}
+
+			// A custom key extractor replaces the IP or token identification
+			if kind, key, ok := o.extractKey(r); ok {
+				switch kind {
+				case "ip":
+					clientIP = key
+				case "token":
+					token, extraTokens = key, nil
+				default:
+					ctx = limiter.WithClientKey(ctx, kind, key)
 				}
 			}
 
-			// Check rate limit
-			result, err := rateLimiter.CheckRateLimit(ctx, clientIP, token)
+			// A signed hint of the gateway names the customer and tier, skipping the token lookup
+			if hinted, ok := o.applyGatewayHint(ctx, r, clientIP); ok {
+				ctx, token, extraTokens = hinted, "", nil
+			}
+
+			// Custom cheap rejections run before any storage access
+			if o.checkPre(w, r, rateLimiter, route, token) {
+				return
+			}
+
+			// The server-wide limit protects the backends from aggregate overload
+			if o.checkGlobal(ctx, w, r, rateLimiter, route, token) {
+				return
+			}
+
+			// Evaluate the policy rules
+			matched := o.evaluateRules(r, rateLimiter, clientIP, token)
+
+			// Charge the request cost against the budget
+			cost := matched.Cost
+			if cost == 0 {
+				cost = o.cost(r)
+			}
+			if cost > 1 {
+				ctx = limiter.WithCost(ctx, cost)
+			}
+
+			// Ignored methods only reject blocked clients. Otherwise check the rule
+			// or route limit when one matches, the default limits otherwise
+			var result *limiter.CheckResult
+			var err error
+			checkStart := time.Now()
+			methodPolicy := o.methodPolicy(r.Method)
+			if methodPolicy == config.MethodIgnore {
+				result, err = rateLimiter.CheckBlocked(ctx, clientIP)
+			} else if rule := matched.Rule; rule != nil {
+				result, err = rateLimiter.CheckRuleRateLimit(ctx, rule.Name, rule.Limit, rule.BlockTime, clientIP, token)
+			} else if route, ok := o.routes.MatchRequest(r); ok {
+				result, err = rateLimiter.CheckRouteRateLimit(ctx, route, clientIP, token)
+			} else if methodPolicy == config.MethodSeparate {
+				result, err = rateLimiter.CheckMethodRateLimit(ctx, r.Method, clientIP, token)
+			} else {
+				result, err = rateLimiter.CheckRateLimit(ctx, clientIP, token)
+			}
+			if trace != nil {
+				w.Header().Set("X-RateLimit-Trace", trace.String())
+				logger(r).Info("Rate limit trace", "method", r.Method, "path", r.URL.Path, "trace", trace.String())
+			}
 			if err != nil {
-				// Log error but don't block the request
-				w.Header().Set("X-RateLimit-Error", "Rate limit check failed")
+				var served bool
+				if result, served = o.handleFailure(ctx, w, r, next, methodPolicy, clientIP, token, err); served {
+					return
+				}
+			}
+
+			// Ignored method from a client that is not blocked
+			if result == nil {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// Set rate limit headers
-			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", result.Remaining))
-			w.Header().Set("X-RateLimit-Reset", result.ResetTime.Format(time.RFC3339))
-
-			if result.BlockTime > 0 {
-				w.Header().Set("X-RateLimit-Block-Time", result.BlockTime.String())
+			// Every further credential is charged too, the first denial wins
+			if result.Allowed && len(extraTokens) > 0 && methodPolicy != config.MethodIgnore {
+				if denied := o.checkExtraCredentials(ctx, r, rateLimiter, extraTokens); denied != nil {
+					result = denied
+				}
 			}
 
+			o.recordRoute(r.Method, route, result.Allowed)
+			o.logDecision(r, route, result, time.Since(checkStart))
+			o.observe(result)
+
 			// Check if request is allowed
+			level := o.headerLevel(rateLimiter, token)
+			client := o.budgetClient(rateLimiter, token)
 			if !result.Allowed {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-
-				response := map[string]interface{}{
-					"error":   "Rate limit exceeded",
-					"message": "you have reached the maximum number of requests or actions allowed within a certain time frame",
-					"details": map[string]interface{}{
-						"reason":     result.Reason,
-						"reset_time": result.ResetTime,
-						"block_time": result.BlockTime,
-					},
+				if client != "" {
+					o.relaxer.Blocked(client)
 				}
+				o.writeLimited(w, r, level, result)
+				return
+			}
 
-				json.NewEncoder(w).Encode(response)
+			// Daily and monthly quotas only count requests within the rate limit
+			if o.checkQuota(ctx, w, r, rateLimiter, level, token) {
 				return
 			}
 
+			// Set rate limit headers
+			o.setQuotaHeaders(w, level, result, false)
+
+			// Smoothed algorithms release the request at its turn
+			if result.Delay > 0 {
+				timer := time.NewTimer(result.Delay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+
 			// Request is allowed, continue
-			next.ServeHTTP(w, r)
+			o.serveTracked(w, r, next, client)
 		})
 	}
 }
 
-// RateLimitInfoMiddleware provides rate limit information without blocking
-func RateLimitInfoMiddleware(rateLimiter *limiter.RateLimiter) func(http.Handler) http.Handler {
+// evaluateRules runs the policy rules with the token's configured limits
+func (o *options) evaluateRules(r *http.Request, rateLimiter *limiter.RateLimiter, ip, token string) rules.Result {
+	if o.rules.Len() == 0 {
+		return rules.Result{}
+	}
+
+	tokenLimit, configured := rateLimiter.Policy().TokenLimits[token]
+	return o.rules.Evaluate(r, ip, rules.Token{
+		Value:      token,
+		Tier:       tokenLimit.Tier,
+		Limit:      tokenLimit.Limit,
+		Configured: configured,
+	})
+}
+
+// writeLimited writes the rate limit headers allowed by the visibility level
+// and the 429 response for a denied request, or runs the denied handler
+func (o *options) writeLimited(w http.ResponseWriter, r *http.Request, level string, result *limiter.CheckResult) {
+	o.setQuotaHeaders(w, level, result, true)
+
+	// Retry-After is standard backoff information, sent at every visibility level
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter(result)))
+
+	if o.deniedHandler != nil {
+		o.serveDenied(w, r, result)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	response := map[string]interface{}{
+		"error":   "Rate limit exceeded",
+		"message": "you have reached the maximum number of requests or actions allowed within a certain time frame",
+		"details": map[string]interface{}{
+			"reason":     result.Reason,
+			"reset_time": result.ResetTime,
+			"block_time": result.BlockTime,
+		},
+	}
+	// The request ID lets support find the decision records of a reported 429
+	if id := limiter.RequestIDFromContext(r.Context()); id != "" {
+		response["request_id"] = id
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// RateLimitInfoMiddleware provides rate limit information without blocking. It
+// is kept for compatibility, new code should use middleware.Info of the v2 API
+func RateLimitInfoMiddleware(rateLimiter *limiter.RateLimiter, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.Background()
@@ -82,29 +365,28 @@ func RateLimitInfoMiddleware(rateLimiter *limiter.RateLimiter) func(http.Handler
 			// Get client IP
 			clientIP := getClientIP(r)
 
-			// Get token from header
-			token := ""
-			if apiKey := r.Header.Get("API_KEY"); apiKey != "" {
-				var err error
-				token, err = strategy.ParseTokenFromHeader(apiKey)
-				if err != nil {
-					token = ""
+			// Get token from the configured sources, invalid tokens fall back to IP-only rate limiting
+			token := extractToken(r, o.tokenSources)
+
+			// Identify the client as RateLimitMiddleware does
+			kind, identifier := "ip", clientIP
+			if token != "" {
+				kind, identifier = "token", token
+			}
+			if customKind, key, ok := o.extractKey(r); ok {
+				switch {
+				case customKind == "token":
+					token = key
+					kind, identifier = customKind, key
+				case customKind != "ip" || token == "":
+					kind, identifier = customKind, key
 				}
 			}
 
 			// Get rate limit info without incrementing
-			var info *strategy.RateLimitInfo
-			var err error
+			info, err := rateLimiter.GetRateLimitInfo(ctx, rateLimiter.Key(kind, identifier))
 
-			if token != "" {
-				key := strategy.GetKeyWithPrefix("token", token)
-				info, err = rateLimiter.GetRateLimitInfo(ctx, key)
-			} else {
-				key := strategy.GetKeyWithPrefix("ip", clientIP)
-				info, err = rateLimiter.GetRateLimitInfo(ctx, key)
-			}
-
-			if err == nil && info != nil {
+			if err == nil && info != nil && o.headerLevel(rateLimiter, token) == HeadersFull {
 				w.Header().Set("X-RateLimit-Count", fmt.Sprintf("%d", info.Count))
 				w.Header().Set("X-RateLimit-Reset", info.ResetTime.Format(time.RFC3339))
 				w.Header().Set("X-RateLimit-Blocked", fmt.Sprintf("%t", info.Blocked))
@@ -117,36 +399,20 @@ func RateLimitInfoMiddleware(rateLimiter *limiter.RateLimiter) func(http.Handler
 
 // getClientIP extracts the client IP from the request
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		if idx := len(xff); idx > 0 {
-			for i, c := range xff {
-				if c == ',' {
-					idx = i
-					break
-				}
-			}
-			return xff[:idx]
+	// X-Forwarded-For can contain multiple IPs, take the first one
+	first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+	for _, candidate := range []string{first, r.Header.Get("X-Real-IP")} {
+		// Malformed values are skipped, so arbitrary header content never
+		// becomes a rate limit key
+		if ip := net.ParseIP(strings.TrimSpace(candidate)); ip != nil {
+			return ip.String()
 		}
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if idx := len(ip); idx > 0 {
-		for i, c := range ip {
-			if c == ':' {
-				idx = i
-				break
-			}
-		}
-		return ip[:idx]
+	// Fall back to RemoteAddr, which may be an IPv6 address in brackets
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-
-	return ip
+	return host
 }
@@ -0,0 +1,10 @@
+package middleware
+
+// WithReadOnly makes the middleware only reject clients that are already
+// blocked, as for ignored methods, without counting or blocking anyone. It
+// suits instances reading a replica of the storage
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// WithRequestIDResolver sets how the ID a request is known by is resolved,
+// e.g. the one assigned by chi's RequestID middleware. The ID is stored in the
+// request context (limiter.WithRequestID) and reported in the logs, the events
+// and the 429 body of the request, so a rejection reported by a client can be
+// matched with its decision records
+func WithRequestIDResolver(resolve func(*http.Request) string) Option {
+	return func(o *options) {
+		o.resolveRequestID = resolve
+	}
+}
+
+// withRequestID stores the resolved request ID in the request context
+func (o *options) withRequestID(r *http.Request) *http.Request {
+	if o.resolveRequestID == nil || limiter.RequestIDFromContext(r.Context()) != "" {
+		return r
+	}
+	if id := o.resolveRequestID(r); id != "" {
+		return r.WithContext(limiter.WithRequestID(r.Context(), id))
+	}
+	return r
+}
+
+// logger returns the default logger, tagged with the request ID if any
+func logger(r *http.Request) *slog.Logger {
+	if id := limiter.RequestIDFromContext(r.Context()); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+)
+
+// WithRouteNormalizer sets how request paths are mapped to routes for policy
+// rules (request.route), the route decision metrics and handlers
+// (routes.RouteFromContext). By default the route pattern resolved by the
+// router is used (WithPatternResolver), and ID-like path segments are replaced
+// with "{id}" without one
+func WithRouteNormalizer(normalizer *routes.Normalizer) Option {
+	return func(o *options) {
+		o.normalizer = normalizer
+	}
+}
+
+// WithPatternResolver sets how the pattern of the route serving a request is
+// resolved from the router, e.g. chiroutes.Pattern for go-chi. The pattern is
+// stored in the request context (routes.WithPattern) and takes precedence over
+// the path for route limits and normalization. Without a resolver, a pattern
+// stored by the caller is used
+func WithPatternResolver(resolve func(*http.Request) string) Option {
+	return func(o *options) {
+		o.resolvePattern = resolve
+	}
+}
+
+// withPattern stores the resolved route pattern in the request context
+func (o *options) withPattern(r *http.Request) *http.Request {
+	if o.resolvePattern == nil || routes.Pattern(r) != "" {
+		return r
+	}
+	if pattern := o.resolvePattern(r); pattern != "" {
+		return r.WithContext(routes.WithPattern(r.Context(), pattern))
+	}
+	return r
+}
+
+// recordRoute counts a decision per method and normalized route
+func (o *options) recordRoute(method, route string, allowed bool) {
+	result := "allowed"
+	if !allowed {
+		result = "denied"
+	}
+	metrics.RouteDecisions.WithLabelValues(method, o.normalizer.Label(route), result).Inc()
+}
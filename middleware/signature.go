@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Headers carrying the request signature
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// defaultSignedBodySize caps signed bodies when the configuration sets no size
+const defaultSignedBodySize = 1 << 20
+
+// SignatureMiddleware verifies HMAC-SHA256 signed requests and rejects replays.
+// Nonces are remembered in the limiter storage for twice the replay window.
+// Signed bodies larger than cfg.MaxBodySize (1MB when unset) are rejected
+// with 413 before the signature is checked
+func SignatureMiddleware(storage strategy.StorageStrategy, cfg config.SigningConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.Secret == "" {
+			return next
+		}
+		maxBodySize := cfg.MaxBodySize
+		if maxBodySize <= 0 {
+			maxBodySize = defaultSignedBodySize
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signature := r.Header.Get(SignatureHeader)
+			if signature == "" {
+				if cfg.Required {
+					rejectSignature(w, "missing signature")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+			if err != nil {
+				rejectSignature(w, "invalid timestamp")
+				return
+			}
+
+			skew := time.Since(time.Unix(timestamp, 0))
+			if skew > cfg.ReplayWindow || skew < -cfg.ReplayWindow {
+				rejectSignature(w, "timestamp outside replay window")
+				return
+			}
+
+			nonce := r.Header.Get(NonceHeader)
+			if nonce == "" {
+				rejectSignature(w, "missing nonce")
+				return
+			}
+
+			if r.ContentLength > maxBodySize {
+				rejectBodySize(w, maxBodySize)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					rejectBodySize(w, tooLarge.Limit)
+					return
+				}
+				rejectSignature(w, "failed to read body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			expected := SignRequest(cfg.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+			if !hmac.Equal([]byte(expected), []byte(signature)) {
+				rejectSignature(w, "invalid signature")
+				return
+			}
+
+			// Only verified requests consume a nonce
+			stored, err := storage.SetNX(context.Background(), strategy.GetKeyWithPrefix("nonce", nonce), "1", 2*cfg.ReplayWindow)
+			if err != nil {
+				rejectSignature(w, "failed to verify nonce")
+				return
+			}
+			if !stored {
+				rejectSignature(w, "replayed request")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SignRequest computes the hex-encoded signature of a request:
+// HMAC-SHA256(secret, method \n uri \n timestamp \n nonce \n sha256(body))
+func SignRequest(secret, method, uri string, timestamp int64, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	payload := fmt.Sprintf("%s\n%s\n%d\n%s\n%s", method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rejectSignature responds with 401 and the reason the signature was refused
+func rejectSignature(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "Invalid request signature",
+		"message": reason,
+	})
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+func TestSignatureMiddlewareBodySize(t *testing.T) {
+	const secret = "signing-secret"
+	storage := strategy.NewMemoryStrategy()
+	defer storage.Close()
+
+	handler := SignatureMiddleware(storage, config.SigningConfig{
+		Secret:       secret,
+		ReplayWindow: time.Minute,
+		MaxBodySize:  16,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{"within the limit", "small body", false, http.StatusOK},
+		{"declared too large", strings.Repeat("a", 17), false, http.StatusRequestEntityTooLarge},
+		{"streamed too large", strings.Repeat("a", 17), true, http.StatusRequestEntityTooLarge},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp := time.Now().Unix()
+			nonce := "nonce-" + strconv.Itoa(i)
+			r := httptest.NewRequest("POST", "/api/orders", strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			r.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+			r.Header.Set(NonceHeader, nonce)
+			r.Header.Set(SignatureHeader, SignRequest(secret, "POST", "/api/orders", timestamp, nonce, []byte(tt.body)))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// SlowClientMiddleware sets per-request connection deadlines derived from the
+// client's reputation: every slow request recorded for a key halves its read
+// deadline, and keys reaching the maximum number of strikes are blocked
+func SlowClientMiddleware(rateLimiter *limiter.RateLimiter, cfg config.SlowClientConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.ReadTimeout <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.Background()
+			key := rateLimiter.Key("ip", getClientIP(r))
+
+			strikes, err := rateLimiter.Strikes(ctx, key)
+			if err != nil {
+				strikes = 0
+			}
+
+			controller := http.NewResponseController(w)
+			now := time.Now()
+			if err := controller.SetReadDeadline(now.Add(readTimeout(cfg, strikes))); err != nil {
+				slog.Error("Failed to set read deadline", "error", err)
+			}
+			if cfg.WriteTimeout > 0 {
+				controller.SetWriteDeadline(now.Add(cfg.WriteTimeout))
+			}
+
+			r.Body = &slowBody{
+				ReadCloser: r.Body,
+				onTimeout: func() {
+					recordSlowClient(rateLimiter, cfg, key)
+				},
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readTimeout shrinks the read deadline for each strike, bounded by the minimum
+func readTimeout(cfg config.SlowClientConfig, strikes int) time.Duration {
+	timeout := cfg.ReadTimeout
+	for i := 0; i < strikes && timeout > cfg.MinReadTimeout; i++ {
+		timeout /= 2
+	}
+	return max(timeout, cfg.MinReadTimeout)
+}
+
+// recordSlowClient adds a strike for the key and blocks it once it reaches the limit
+func recordSlowClient(rateLimiter *limiter.RateLimiter, cfg config.SlowClientConfig, key string) {
+	ctx := context.Background()
+
+	strikes, err := rateLimiter.RecordStrike(ctx, key, cfg.StrikeWindow)
+	if err != nil {
+		slog.Error("Failed to record slow client strike", "key", key, "error", err)
+		return
+	}
+
+	if cfg.MaxStrikes > 0 && strikes >= cfg.MaxStrikes {
+		if err := rateLimiter.Block(ctx, key, cfg.BlockTime, "slow client"); err != nil {
+			slog.Error("Failed to block slow client", "key", key, "error", err)
+		}
+	}
+}
+
+// slowBody reports the first read that fails because the deadline expired
+type slowBody struct {
+	io.ReadCloser
+	once      sync.Once
+	onTimeout func()
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && isTimeout(err) {
+		b.once.Do(b.onTimeout)
+	}
+	return n, err
+}
+
+// isTimeout reports whether err was caused by an expired deadline
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/timeline"
+)
+
+// WithTimeline counts every decision in the timeline of its key
+func WithTimeline(recorder *timeline.Recorder) Option {
+	return func(o *options) {
+		o.timeline = recorder
+	}
+}
+
+// observe counts a decision in the timeline, when enabled
+func (o *options) observe(result *limiter.CheckResult) {
+	if o.timeline != nil {
+		o.timeline.Observe(result)
+	}
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Token source kinds
+const (
+	SourceHeader = "header"
+	SourceBearer = "bearer"
+	SourceQuery  = "query"
+)
+
+// TokenSource is a place in the request where a credential may be found
+type TokenSource struct {
+	Kind string
+	Name string
+}
+
+// defaultTokenSources keeps the historical API_KEY header behavior
+var defaultTokenSources = []TokenSource{{Kind: SourceHeader, Name: "API_KEY"}}
+
+// ParseTokenSources parses an ordered list of sources such as
+// "header:API_KEY", "header:X-Api-Key", "bearer" and "query:api_key"
+func ParseTokenSources(specs []string) ([]TokenSource, error) {
+	sources := make([]TokenSource, 0, len(specs))
+	for _, spec := range specs {
+		kind, name, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		kind = strings.ToLower(kind)
+
+		switch kind {
+		case SourceBearer:
+			sources = append(sources, TokenSource{Kind: kind})
+		case SourceHeader, SourceQuery:
+			if name == "" {
+				return nil, fmt.Errorf("token source %q requires a name", spec)
+			}
+			sources = append(sources, TokenSource{Kind: kind, Name: name})
+		default:
+			return nil, fmt.Errorf("unknown token source %q", spec)
+		}
+	}
+	return sources, nil
+}
+
+// extractToken returns the credential from the first source that provides one
+func extractToken(r *http.Request, sources []TokenSource) string {
+	for _, source := range sources {
+		if value := source.lookup(r); value != "" {
+			token, err := strategy.ParseTokenFromHeader(value)
+			if err == nil {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// extractTokens returns every distinct valid credential of the request, in
+// source order, including repeated headers and query parameters
+func extractTokens(r *http.Request, sources []TokenSource) []string {
+	var tokens []string
+	for _, source := range sources {
+		for _, value := range source.lookupAll(r) {
+			token, err := strategy.ParseTokenFromHeader(value)
+			if err == nil && !slices.Contains(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// lookupAll returns every raw value of the source in the request
+func (s TokenSource) lookupAll(r *http.Request) []string {
+	switch s.Kind {
+	case SourceHeader:
+		return r.Header.Values(s.Name)
+	case SourceQuery:
+		return r.URL.Query()[s.Name]
+	}
+	if value := s.lookup(r); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// lookup returns the raw value of the source in the request
+func (s TokenSource) lookup(r *http.Request) string {
+	switch s.Kind {
+	case SourceHeader:
+		return r.Header.Get(s.Name)
+	case SourceBearer:
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	case SourceQuery:
+		return r.URL.Query().Get(s.Name)
+	}
+	return ""
+}
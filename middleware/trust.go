@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/acl"
+)
+
+// ForwardedHeaders carry the client address set by proxies, and are always
+// treated as internal by TrustBoundaryMiddleware
+var ForwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// trustDecidedKey is the context key marking requests a trust boundary
+// already checked
+type trustDecidedKey struct{}
+
+// TrustBoundaryMiddleware strips internal headers and cookies from requests
+// whose peer is not a trusted proxy, so external clients cannot pick the IP
+// they are limited by or forge markers meant for internal traffic. The
+// forwarded headers are always internal; headers and cookies add to them. It
+// must run before anything rewriting r.RemoteAddr from those headers, such as
+// chi's RealIP. Only the first boundary a request crosses decides: later ones,
+// which may see the rewritten address, pass it through untouched
+func TrustBoundaryMiddleware(trustedProxies, headers, cookies []string) (func(http.Handler) http.Handler, error) {
+	trusted := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, entry := range trustedProxies {
+		network, err := acl.ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	internal := append(append([]string{}, ForwardedHeaders...), headers...)
+	internalCookies := make(map[string]bool, len(cookies))
+	for _, cookie := range cookies {
+		internalCookies[cookie] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(trustDecidedKey{}) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), trustDecidedKey{}, true))
+			if !trustedPeer(r, trusted) {
+				for _, header := range internal {
+					r.Header.Del(header)
+				}
+				if len(internalCookies) > 0 {
+					stripCookies(r, internalCookies)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// trustedPeer reports whether the direct peer of a request is a trusted proxy
+func trustedPeer(r *http.Request, trusted []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripCookies removes the named cookies from the request
+func stripCookies(r *http.Request, names map[string]bool) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+
+	kept := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		if !names[cookie.Name] {
+			kept = append(kept, cookie.String())
+		}
+	}
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+func TestTrustBoundaryMiddleware(t *testing.T) {
+	trust, err := TrustBoundaryMiddleware(
+		[]string{"10.0.0.0/8", "192.0.2.10", "2001:db8::/32"},
+		[]string{"X-Internal-Caller"},
+		[]string{"internal_session"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		trusted    bool
+	}{
+		{"peer in trusted CIDR", "10.1.2.3:4567", true},
+		{"trusted single IP", "192.0.2.10:80", true},
+		{"peer in trusted IPv6 CIDR", "[2001:db8::7]:443", true},
+		{"trusted peer without port", "10.9.9.9", true},
+		{"untrusted peer", "203.0.113.7:1234", false},
+		{"neighbour of trusted IP", "192.0.2.11:80", false},
+		{"untrusted IPv6 peer", "[2001:db9::1]:443", false},
+		{"unparsable peer", "not-an-address", false},
+		{"empty peer", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			handler := trust(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+
+			r := httptest.NewRequest("GET", "/api/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("Forwarded", "for=198.51.100.1")
+			r.Header.Set("X-Forwarded-For", "198.51.100.1, 10.0.0.1")
+			r.Header.Set("X-Real-IP", "198.51.100.1")
+			r.Header.Set("X-Internal-Caller", "billing")
+			r.Header.Set("API_KEY", "abc123")
+			r.AddCookie(&http.Cookie{Name: "internal_session", Value: "secret"})
+			r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-IP", "X-Internal-Caller"} {
+				if kept := got.Header.Get(header) != ""; kept != tt.trusted {
+					t.Errorf("header %s kept = %t, want %t", header, kept, tt.trusted)
+				}
+			}
+			if _, err := got.Cookie("internal_session"); (err == nil) != tt.trusted {
+				t.Errorf("internal cookie kept = %t, want %t", err == nil, tt.trusted)
+			}
+
+			// Other headers and cookies always reach the handler
+			if got.Header.Get("API_KEY") != "abc123" {
+				t.Errorf("API_KEY header = %q, want it kept", got.Header.Get("API_KEY"))
+			}
+			if cookie, err := got.Cookie("theme"); err != nil || cookie.Value != "dark" {
+				t.Errorf("theme cookie = %v, %v, want it kept", cookie, err)
+			}
+		})
+	}
+}
+
+func TestTrustBoundaryMiddlewareWithoutCookies(t *testing.T) {
+	trust, err := TrustBoundaryMiddleware(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	handler := trust(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+
+	// Without trusted proxies every peer is untrusted
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("Cookie", "a=1; b=2")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got.Header.Get("X-Forwarded-For") != "" {
+		t.Error("X-Forwarded-For kept for an untrusted peer")
+	}
+	if cookie := got.Header.Get("Cookie"); cookie != "a=1; b=2" {
+		t.Errorf("Cookie = %q, want it untouched without internal cookies", cookie)
+	}
+}
+
+func TestTrustBoundaryMiddlewareInvalidProxy(t *testing.T) {
+	for _, entry := range []string{"", "not-an-ip", "10.0.0.0/33", "10.0.0/8", "192.0.2.1:80", "2001:db8::/129"} {
+		t.Run(entry, func(t *testing.T) {
+			trust, err := TrustBoundaryMiddleware([]string{"10.0.0.0/8", entry}, nil, nil)
+			if err == nil {
+				t.Fatalf("TrustBoundaryMiddleware accepted trusted proxy %q", entry)
+			}
+			if trust != nil {
+				t.Error("TrustBoundaryMiddleware returned a middleware with an error")
+			}
+			if !strings.Contains(err.Error(), "invalid trusted proxy") {
+				t.Errorf("error = %q, want it to name the trusted proxy", err)
+			}
+		})
+	}
+}
+
+func TestTrustBoundaryMiddlewareBehindRealIP(t *testing.T) {
+	trust, err := TrustBoundaryMiddleware([]string{"10.0.0.0/8"}, []string{"X-Internal-Caller"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	// As in the server: boundary, RealIP, then the boundary of the rate limit middleware
+	handler := trust(chimiddleware.RealIP(trust(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		client     string
+		trusted    bool
+	}{
+		{"trusted proxy", "10.1.2.3:4567", "203.0.113.9", true},
+		{"untrusted peer", "198.51.100.7:4567", "198.51.100.7:4567", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", "203.0.113.9")
+			r.Header.Set("X-Internal-Caller", "billing")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got.RemoteAddr != tt.client {
+				t.Errorf("RemoteAddr = %q, want %q", got.RemoteAddr, tt.client)
+			}
+			if kept := got.Header.Get("X-Internal-Caller") != ""; kept != tt.trusted {
+				t.Errorf("X-Internal-Caller kept = %t, want %t", kept, tt.trusted)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// Header visibility levels for the quota headers
+const (
+	// HeadersFull emits every quota header on every response
+	HeadersFull = "full"
+	// HeadersMinimal emits only what a client needs to back off, on 429 responses
+	HeadersMinimal = "minimal"
+	// HeadersNone emits no quota headers
+	HeadersNone = "none"
+	// HeadersAuthenticated emits full headers to clients presenting a configured
+	// token and minimal headers to everyone else
+	HeadersAuthenticated = "authenticated"
+)
+
+// WithHeaderVisibility controls which quota headers are emitted and to whom
+func WithHeaderVisibility(level string) (Option, error) {
+	switch level {
+	case HeadersFull, HeadersMinimal, HeadersNone, HeadersAuthenticated:
+	default:
+		return nil, fmt.Errorf("unknown header visibility %q", level)
+	}
+
+	return func(o *options) {
+		o.headerVisibility = level
+	}, nil
+}
+
+// headerLevel resolves the visibility for a request to full, minimal or none
+func (o *options) headerLevel(rateLimiter *limiter.RateLimiter, token string) string {
+	switch o.headerVisibility {
+	case "", HeadersFull:
+		return HeadersFull
+	case HeadersAuthenticated:
+		if token != "" {
+			if _, ok := rateLimiter.Policy().TokenLimits[token]; ok {
+				return HeadersFull
+			}
+		}
+		return HeadersMinimal
+	default:
+		return o.headerVisibility
+	}
+}
@@ -0,0 +1,105 @@
+package offenders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+)
+
+// buckets is the number of time buckets covering the rolling window
+const buckets = 12
+
+// Offender is a key ranked by the number of violations within the window
+type Offender struct {
+	Key        string `json:"key"`
+	Violations int64  `json:"violations"`
+}
+
+// Tracker keeps rolling top-N offender lists per policy in Redis sorted sets.
+// Violations are written to time buckets so old ones age out of the window
+type Tracker struct {
+	client *redis.Client
+	window time.Duration
+	bucket time.Duration
+	queue  chan events.Event
+}
+
+// NewTracker creates a tracker for the given rolling window and starts its writer
+func NewTracker(client *redis.Client, window time.Duration) *Tracker {
+	if window <= 0 {
+		window = time.Hour
+	}
+
+	t := &Tracker{
+		client: client,
+		window: window,
+		bucket: window / buckets,
+		queue:  make(chan events.Event, 1024),
+	}
+	go t.run()
+
+	return t
+}
+
+// Handle records limited events. Events are dropped when the writer falls behind
+// so the request path never waits on Redis
+func (t *Tracker) Handle(event events.Event) {
+	if event.Type != events.TypeLimited || event.Policy == "" {
+		return
+	}
+
+	select {
+	case t.queue <- event:
+	default:
+	}
+}
+
+// run writes queued violations to the current bucket
+func (t *Tracker) run() {
+	for event := range t.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		key := t.bucketKey(event.Policy, event.Timestamp)
+
+		pipe := t.client.Pipeline()
+		pipe.ZIncrBy(ctx, key, 1, event.Key)
+		pipe.Expire(ctx, key, t.window+t.bucket)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.Error("Failed to record offender", "key", event.Key, "error", err)
+		}
+		cancel()
+	}
+}
+
+// Top returns the n keys with the most violations for a policy within the window
+func (t *Tracker) Top(ctx context.Context, policy string, n int) ([]Offender, error) {
+	now := time.Now()
+	keys := make([]string, 0, buckets+1)
+	for i := 0; i <= buckets; i++ {
+		keys = append(keys, t.bucketKey(policy, now.Add(-time.Duration(i)*t.bucket)))
+	}
+
+	scores, err := t.client.ZUnionWithScores(ctx, redis.ZStore{Keys: keys, Aggregate: "SUM"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// ZUNION returns members in ascending score order
+	offenders := make([]Offender, 0, n)
+	for i := len(scores) - 1; i >= 0 && len(offenders) < n; i-- {
+		offenders = append(offenders, Offender{
+			Key:        fmt.Sprint(scores[i].Member),
+			Violations: int64(scores[i].Score),
+		})
+	}
+
+	return offenders, nil
+}
+
+// bucketKey returns the sorted set holding the violations of a policy at time at
+func (t *Tracker) bucketKey(policy string, at time.Time) string {
+	return fmt.Sprintf("offenders:%s:%d", policy, at.Truncate(t.bucket).Unix())
+}
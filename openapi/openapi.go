@@ -0,0 +1,148 @@
+package openapi
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Extension is the operation extension declaring its rate limit
+const Extension = "x-rate-limit"
+
+// methods are the OpenAPI operation keys of a path item
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Operation is a route derived from the spec, with its limit when declared
+type Operation struct {
+	Method  string
+	Pattern string
+	Limit   *config.RouteLimit
+}
+
+type document struct {
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths map[string]map[string]yaml.Node `yaml:"paths"`
+}
+
+type rateLimitExtension struct {
+	Limit     int    `yaml:"limit"`
+	BlockTime string `yaml:"block_time"`
+}
+
+// Load reads an OpenAPI document (JSON or YAML) and returns every operation,
+// prefixing paths with the base path of the first server
+func Load(specPath string) ([]Operation, error) {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+
+	basePath := ""
+	if len(doc.Servers) > 0 {
+		if serverURL, err := url.Parse(doc.Servers[0].URL); err == nil {
+			basePath = strings.TrimSuffix(serverURL.Path, "/")
+		}
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var operations []Operation
+	for _, p := range paths {
+		item := doc.Paths[p]
+		pattern := path.Clean(basePath + "/" + strings.TrimPrefix(p, "/"))
+
+		for _, method := range methods {
+			node, ok := item[method]
+			if !ok {
+				continue
+			}
+
+			limit, err := parseLimit(&node)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), p, err)
+			}
+
+			operation := Operation{Method: strings.ToUpper(method), Pattern: pattern}
+			if limit != nil {
+				limit.Method = operation.Method
+				limit.Pattern = operation.Pattern
+				operation.Limit = limit
+			}
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations, nil
+}
+
+// LoadRouteLimits returns the route limits declared with x-rate-limit in the spec
+func LoadRouteLimits(specPath string) ([]config.RouteLimit, error) {
+	operations, err := Load(specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits []config.RouteLimit
+	for _, operation := range operations {
+		if operation.Limit != nil {
+			limits = append(limits, *operation.Limit)
+		}
+	}
+	return limits, nil
+}
+
+// parseLimit reads the x-rate-limit extension of an operation, which is either
+// a number or an object with limit and block_time
+func parseLimit(operation *yaml.Node) (*config.RouteLimit, error) {
+	var fields map[string]yaml.Node
+	if err := operation.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	node, ok := fields[Extension]
+	if !ok {
+		return nil, nil
+	}
+
+	var ext rateLimitExtension
+	if node.Kind == yaml.ScalarNode {
+		if err := node.Decode(&ext.Limit); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", Extension, err)
+		}
+	} else if err := node.Decode(&ext); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", Extension, err)
+	}
+
+	if ext.Limit <= 0 {
+		return nil, fmt.Errorf("%s limit must be positive", Extension)
+	}
+
+	limit := &config.RouteLimit{Limit: ext.Limit}
+	if ext.BlockTime != "" {
+		blockTime, err := time.ParseDuration(ext.BlockTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s block_time: %w", Extension, err)
+		}
+		limit.BlockTime = blockTime
+	}
+
+	return limit, nil
+}
@@ -0,0 +1,440 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/encryption"
+)
+
+const (
+	historyKey = "policy:history"
+	counterKey = "policy:version"
+	activeKey  = "policy:active"
+)
+
+// Source values recorded with each version
+const (
+	SourceEnv      = "env"
+	SourceRollback = "rollback"
+	// SourceAPI marks limits changed through the admin API
+	SourceAPI = "api"
+	// SourceImport marks limits loaded from a state dump
+	SourceImport = "import"
+)
+
+// ErrVersionNotFound is returned when a version is not (or no longer) in the history
+var ErrVersionNotFound = errors.New("policy version not found")
+
+// ErrOutsideCaps is returned when rolling back to a version the current caps reject
+var ErrOutsideCaps = errors.New("policy version outside the caps")
+
+// Version is an applied policy, numbered in the order it was applied
+type Version struct {
+	Number    int64         `json:"version"`
+	AppliedAt time.Time     `json:"applied_at"`
+	Source    string        `json:"source"`
+	Policy    config.Policy `json:"policy"`
+	// RolledBackFrom is the version restored by a rollback
+	RolledBackFrom int64 `json:"rolled_back_from,omitempty"`
+}
+
+// Change is a single field that differs between two policies
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Applier receives the policy to enforce, typically the rate limiter
+type Applier interface {
+	SetPolicy(policy config.Policy)
+}
+
+// History keeps the last applied policy versions in Redis and keeps the local
+// limiter in sync with the active version chosen by any instance
+type History struct {
+	client   *redis.Client
+	size     int
+	interval time.Duration
+	applier  Applier
+	// keyring encrypts the stored versions, which contain the tokens
+	keyring *encryption.Keyring
+	// caps bound the versions that can be rolled back to
+	caps config.Caps
+
+	mu     sync.Mutex
+	active int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHistory creates a history keeping the last size versions
+func NewHistory(client *redis.Client, applier Applier, size int, interval time.Duration) *History {
+	if size <= 0 {
+		size = 10
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	return &History{
+		client:   client,
+		size:     size,
+		interval: interval,
+		applier:  applier,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// SetKeyring encrypts the stored versions with the keyring. Versions stored
+// in plain text or under an older key remain readable
+func (h *History) SetKeyring(keyring *encryption.Keyring) {
+	h.keyring = keyring
+}
+
+// SetCaps sets the caps versions are checked against before a rollback, so
+// limits applied before the caps were tightened cannot come back
+func (h *History) SetCaps(caps config.Caps) {
+	h.caps = caps
+}
+
+// Apply records a policy as a new version, makes it the active one and enforces it locally
+func (h *History) Apply(ctx context.Context, policy config.Policy, source string) (Version, error) {
+	return h.apply(ctx, Version{Source: source, Policy: policy})
+}
+
+// Rollback re-applies a previous version as a new version, unless the caps
+// reject it
+func (h *History) Rollback(ctx context.Context, number int64) (Version, error) {
+	previous, err := h.Get(ctx, number)
+	if err != nil {
+		return Version{}, err
+	}
+	if err := h.caps.CheckPolicy(previous.Policy); err != nil {
+		return Version{}, fmt.Errorf("%w: %w", ErrOutsideCaps, err)
+	}
+
+	return h.apply(ctx, Version{
+		Source:         SourceRollback,
+		Policy:         previous.Policy,
+		RolledBackFrom: previous.Number,
+	})
+}
+
+func (h *History) apply(ctx context.Context, version Version) (Version, error) {
+	number, err := h.client.Incr(ctx, counterKey).Result()
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to allocate policy version: %w", err)
+	}
+	version.Number = number
+	version.AppliedAt = time.Now()
+
+	data, err := json.Marshal(version)
+	if err != nil {
+		return Version{}, err
+	}
+	data, err = h.keyring.Encrypt(historyKey, data)
+	if err != nil {
+		return Version{}, err
+	}
+
+	_, err = h.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, historyKey, data)
+		pipe.LTrim(ctx, historyKey, 0, int64(h.size-1))
+		pipe.Set(ctx, activeKey, number, 0)
+		return nil
+	})
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to store policy version: %w", err)
+	}
+
+	h.enforce(version)
+	return version, nil
+}
+
+// List returns the stored versions, newest first
+func (h *History) List(ctx context.Context) ([]Version, error) {
+	values, err := h.client.LRange(ctx, historyKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version, 0, len(values))
+	for _, value := range values {
+		data, err := h.keyring.Decrypt(historyKey, []byte(value))
+		if err != nil {
+			slog.Warn("Skipping unreadable policy version", "error", err)
+			continue
+		}
+
+		var version Version
+		if err := json.Unmarshal(data, &version); err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// Reencrypt rewrites the stored versions not sealed under the primary key,
+// after a key rotation or when encryption is turned on or off. It returns the
+// number of versions rewritten
+func (h *History) Reencrypt(ctx context.Context) (int, error) {
+	rewritten := 0
+	err := h.client.Watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.LRange(ctx, historyKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		rewritten = 0
+		updated := make([]interface{}, len(values))
+		for i, value := range values {
+			updated[i] = value
+			if h.keyring.Current([]byte(value)) {
+				continue
+			}
+
+			data, err := h.keyring.Decrypt(historyKey, []byte(value))
+			if err != nil {
+				return err
+			}
+			sealed, err := h.keyring.Encrypt(historyKey, data)
+			if err != nil {
+				return err
+			}
+			updated[i] = sealed
+			rewritten++
+		}
+		if rewritten == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, historyKey)
+			pipe.RPush(ctx, historyKey, updated...)
+			return nil
+		})
+		return err
+	}, historyKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to re-encrypt policy history: %w", err)
+	}
+	return rewritten, nil
+}
+
+// Get returns a stored version by number
+func (h *History) Get(ctx context.Context, number int64) (Version, error) {
+	versions, err := h.List(ctx)
+	if err != nil {
+		return Version{}, err
+	}
+
+	for _, version := range versions {
+		if version.Number == number {
+			return version, nil
+		}
+	}
+
+	return Version{}, ErrVersionNotFound
+}
+
+// Active returns the number of the version currently active across the fleet, zero if none
+func (h *History) Active(ctx context.Context) (int64, error) {
+	number, err := h.client.Get(ctx, activeKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return number, err
+}
+
+// Start reconciles the configured policy with the stored history and then
+// follows the active version until Stop is called.
+//
+// The configured policy becomes a new version when it differs from the last
+// version recorded from the configuration, i.e. after a deploy that changed
+// the limits. Otherwise the active version is kept, so restarting an instance
+// does not undo a rollback
+func (h *History) Start(ctx context.Context, configured config.Policy) error {
+	err := h.reconcile(ctx, configured)
+	h.follow()
+	return err
+}
+
+// Reload reconciles a reloaded configured policy with the stored history,
+// recording it as a new version only when it changed since the last version
+// recorded from the configuration
+func (h *History) Reload(ctx context.Context, configured config.Policy) error {
+	return h.reconcile(ctx, configured)
+}
+
+// Follow enforces the active version until Stop is called, without recording
+// the configured policy, for instances that must not write to the storage
+func (h *History) Follow(ctx context.Context) error {
+	err := h.sync(ctx)
+	h.follow()
+	return err
+}
+
+// follow syncs the active version in the background
+func (h *History) follow() {
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.sync(context.Background()); err != nil {
+					slog.Error("Failed to sync policy version", "error", err)
+				}
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// reconcile records the configured policy or adopts the active version
+func (h *History) reconcile(ctx context.Context, configured config.Policy) error {
+	versions, err := h.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load policy history: %w", err)
+	}
+
+	var lastEnv *Version
+	for i := range versions {
+		if versions[i].Source == SourceEnv {
+			lastEnv = &versions[i]
+			break
+		}
+	}
+
+	if lastEnv != nil && reflect.DeepEqual(lastEnv.Policy, configured) {
+		return h.sync(ctx)
+	}
+
+	_, err = h.Apply(ctx, configured, SourceEnv)
+	return err
+}
+
+// Stop stops following the active version
+func (h *History) Stop() {
+	close(h.stop)
+	<-h.done
+}
+
+// sync enforces the active version if another instance changed it
+func (h *History) sync(ctx context.Context) error {
+	number, err := h.Active(ctx)
+	if err != nil || number == 0 {
+		return err
+	}
+
+	h.mu.Lock()
+	current := h.active
+	h.mu.Unlock()
+	if number == current {
+		return nil
+	}
+
+	version, err := h.Get(ctx, number)
+	if err != nil {
+		return fmt.Errorf("active version %d: %w", number, err)
+	}
+
+	h.enforce(version)
+	return nil
+}
+
+// enforce applies a version locally unless a newer one is already enforced
+func (h *History) enforce(version Version) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if version.Number == h.active {
+		return
+	}
+	h.active = version.Number
+	h.applier.SetPolicy(version.Policy)
+	slog.Info("Enforcing policy version", "version", version.Number, "source", version.Source)
+}
+
+// Diff lists the fields that changed from old to new, token limits by token
+func Diff(old, new config.Policy) []Change {
+	var changes []Change
+	if old.IPLimit != new.IPLimit {
+		changes = append(changes, Change{Field: "ip_limit", Old: old.IPLimit, New: new.IPLimit})
+	}
+	if old.IPBlockTime != new.IPBlockTime {
+		changes = append(changes, Change{Field: "ip_block_time", Old: old.IPBlockTime.String(), New: new.IPBlockTime.String()})
+	}
+	if old.IPBurst != new.IPBurst {
+		changes = append(changes, Change{Field: "ip_burst", Old: old.IPBurst, New: new.IPBurst})
+	}
+	if !reflect.DeepEqual(old.IPWindows, new.IPWindows) {
+		changes = append(changes, Change{Field: "ip_windows", Old: old.IPWindows, New: new.IPWindows})
+	}
+	if old.LimitWindow() != new.LimitWindow() {
+		changes = append(changes, Change{Field: "window", Old: old.LimitWindow().String(), New: new.LimitWindow().String()})
+	}
+
+	tokens := make(map[string]struct{})
+	for token := range old.TokenLimits {
+		tokens[token] = struct{}{}
+	}
+	for token := range new.TokenLimits {
+		tokens[token] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(tokens))
+	for token := range tokens {
+		sorted = append(sorted, token)
+	}
+	sort.Strings(sorted)
+
+	for _, token := range sorted {
+		oldLimit, hadOld := old.TokenLimits[token]
+		newLimit, hasNew := new.TokenLimits[token]
+		if hadOld == hasNew && reflect.DeepEqual(oldLimit, newLimit) {
+			continue
+		}
+
+		change := Change{Field: "token_limits." + token}
+		if hadOld {
+			change.Old = oldLimit
+		}
+		if hasNew {
+			change.New = newLimit
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// ParseVersion parses a version number from a URL parameter
+func ParseVersion(value string) (int64, error) {
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid policy version %q", value)
+	}
+	return number, nil
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// TokenUpdate sets the limits of a token, block time, window, burst, further
+// windows, quotas and tier keeping their current values when omitted
+type TokenUpdate struct {
+	Limit     int     `json:"limit"`
+	BlockTime *string `json:"block_time"`
+	// Window of "0s" makes the token use the window of the policy
+	Window *string `json:"window"`
+	// Burst of 0 makes the bucket capacity the limit
+	Burst *int `json:"burst"`
+	// Windows are further limit/window pairs such as "300/1m,5000/1h",
+	// replacing the current ones, empty to remove them
+	Windows *string `json:"windows"`
+	// DailyQuota and MonthlyQuota of 0 remove the quota
+	DailyQuota   *int    `json:"daily_quota"`
+	MonthlyQuota *int    `json:"monthly_quota"`
+	Tier         *string `json:"tier"`
+}
+
+// Update is a partial change of the policy made at runtime. Omitted fields
+// are kept, and tokens set to null are removed
+type Update struct {
+	IPLimit     *int                    `json:"ip_limit"`
+	IPBlockTime *string                 `json:"ip_block_time"`
+	IPBurst     *int                    `json:"ip_burst"`
+	IPWindows   *string                 `json:"ip_windows"`
+	Window      *string                 `json:"window"`
+	TokenLimits map[string]*TokenUpdate `json:"token_limits"`
+}
+
+// Apply returns the policy with the update applied, leaving it unchanged
+func (u Update) Apply(current config.Policy) (config.Policy, error) {
+	updated := current
+	updated.TokenLimits = make(map[string]config.TokenLimit, len(current.TokenLimits))
+	for token, limit := range current.TokenLimits {
+		updated.TokenLimits[token] = limit
+	}
+
+	if u.IPLimit != nil {
+		if *u.IPLimit <= 0 {
+			return config.Policy{}, fmt.Errorf("ip_limit must be positive")
+		}
+		updated.IPLimit = *u.IPLimit
+	}
+	if u.IPBlockTime != nil {
+		blockTime, err := parseDuration("ip_block_time", *u.IPBlockTime)
+		if err != nil {
+			return config.Policy{}, err
+		}
+		updated.IPBlockTime = blockTime
+	}
+	if u.IPBurst != nil {
+		if *u.IPBurst < 0 {
+			return config.Policy{}, fmt.Errorf("ip_burst must not be negative")
+		}
+		updated.IPBurst = *u.IPBurst
+	}
+	if updated.IPBurst > 0 && updated.IPBurst < updated.IPLimit {
+		return config.Policy{}, fmt.Errorf("ip_burst must be 0 or at least ip_limit (%d)", updated.IPLimit)
+	}
+	if u.IPWindows != nil {
+		windows, err := config.ParseWindowLimits(*u.IPWindows)
+		if err != nil {
+			return config.Policy{}, fmt.Errorf("ip_windows: %w", err)
+		}
+		updated.IPWindows = windows
+	}
+	if u.Window != nil {
+		window, err := parseDuration("window", *u.Window)
+		if err != nil || window == 0 {
+			return config.Policy{}, fmt.Errorf("window: invalid duration %q", *u.Window)
+		}
+		updated.Window = window
+	}
+
+	for token, change := range u.TokenLimits {
+		if token == "" {
+			return config.Policy{}, fmt.Errorf("token_limits: empty token")
+		}
+		if change == nil {
+			delete(updated.TokenLimits, token)
+			continue
+		}
+		if change.Limit <= 0 {
+			return config.Policy{}, fmt.Errorf("token_limits.%s.limit must be positive", token)
+		}
+
+		limit, exists := updated.TokenLimits[token]
+		if !exists {
+			limit.BlockTime = time.Minute
+		}
+		limit.Limit = change.Limit
+		if change.BlockTime != nil {
+			blockTime, err := parseDuration("token_limits."+token+".block_time", *change.BlockTime)
+			if err != nil {
+				return config.Policy{}, err
+			}
+			limit.BlockTime = blockTime
+		}
+		if change.Window != nil {
+			window, err := parseDuration("token_limits."+token+".window", *change.Window)
+			if err != nil {
+				return config.Policy{}, err
+			}
+			limit.Window = window
+		}
+		if change.Burst != nil {
+			if *change.Burst < 0 {
+				return config.Policy{}, fmt.Errorf("token_limits.%s.burst must not be negative", token)
+			}
+			limit.Burst = *change.Burst
+		}
+		if limit.Burst > 0 && limit.Burst < limit.Limit {
+			return config.Policy{}, fmt.Errorf("token_limits.%s.burst must be 0 or at least the limit (%d)", token, limit.Limit)
+		}
+		if change.Windows != nil {
+			windows, err := config.ParseWindowLimits(*change.Windows)
+			if err != nil {
+				return config.Policy{}, fmt.Errorf("token_limits.%s.windows: %w", token, err)
+			}
+			limit.Windows = windows
+		}
+		if change.DailyQuota != nil {
+			if *change.DailyQuota < 0 {
+				return config.Policy{}, fmt.Errorf("token_limits.%s.daily_quota must not be negative", token)
+			}
+			limit.DailyQuota = *change.DailyQuota
+		}
+		if change.MonthlyQuota != nil {
+			if *change.MonthlyQuota < 0 {
+				return config.Policy{}, fmt.Errorf("token_limits.%s.monthly_quota must not be negative", token)
+			}
+			limit.MonthlyQuota = *change.MonthlyQuota
+		}
+		if change.Tier != nil {
+			limit.Tier = *change.Tier
+		}
+		updated.TokenLimits[token] = limit
+	}
+
+	return updated, nil
+}
+
+// parseDuration parses a non-negative duration such as "30s"
+func parseDuration(field, value string) (time.Duration, error) {
+	blockTime, err := time.ParseDuration(value)
+	if err != nil || blockTime < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", field, value)
+	}
+	return blockTime, nil
+}
@@ -0,0 +1,444 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/acl"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/budget"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/bypass"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/gateway"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/keys"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/lifecycle"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/openapi"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/rules"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/sampling"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/timeline"
+)
+
+// Components holds everything assembled by Setup
+type Components struct {
+	Config  *config.Config
+	Storage strategy.StorageStrategy
+	// Redis is the Redis strategy built by Setup, nil when a custom storage was
+	// provided. Unlike Storage, it is writable in read-only mode
+	Redis   *strategy.RedisStrategy
+	Limiter *limiter.RateLimiter
+	// AccessLists holds the allowlist and denylist, kept in sync until Close
+	AccessLists *acl.Lists
+	// Relaxer relaxes the limits when they block too much legitimate traffic,
+	// nil unless an error budget is configured
+	Relaxer *budget.Relaxer
+	// Fallback counts requests locally when the storage fails, nil unless the
+	// local failure mode is configured
+	Fallback *limiter.Fallback
+	// Timeline records window counts, blocks and admin actions per key, nil
+	// when disabled or when a custom storage was provided
+	Timeline *timeline.Recorder
+
+	// TrustBoundary strips forwarded headers and internal markers from
+	// untrusted peers. Middleware and InfoMiddleware apply it to requests no
+	// boundary checked yet, but it must run before any middleware rewriting
+	// r.RemoteAddr, such as chi's RealIP
+	TrustBoundary func(http.Handler) http.Handler
+	// Middleware enforces the rate limits
+	Middleware func(http.Handler) http.Handler
+	// InfoMiddleware exposes rate limit information without counting the request
+	InfoMiddleware func(http.Handler) http.Handler
+	// Options are the middleware options in use, for building further middleware
+	Options []middleware.Option
+}
+
+// Option overrides a component built by Setup
+type Option func(*settings)
+
+type settings struct {
+	storage           strategy.StorageStrategy
+	middlewareOptions []middleware.Option
+	lifecycle         *lifecycle.Manager
+}
+
+// WithStorage uses the given storage instead of connecting to the configured Redis
+func WithStorage(storage strategy.StorageStrategy) Option {
+	return func(s *settings) {
+		s.storage = storage
+	}
+}
+
+// WithMiddlewareOptions appends options to those derived from the configuration
+func WithMiddlewareOptions(opts ...middleware.Option) Option {
+	return func(s *settings) {
+		s.middlewareOptions = append(s.middlewareOptions, opts...)
+	}
+}
+
+// WithLifecycle registers Close as a shutdown hook of the storage phase
+func WithLifecycle(m *lifecycle.Manager) Option {
+	return func(s *settings) {
+		s.lifecycle = m
+	}
+}
+
+// Setup wires the configured storage, rate limiter and middleware in one call
+func Setup(ctx context.Context, cfg *config.Config, opts ...Option) (*Components, error) {
+	s := &settings{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	c := &Components{
+		Config:  cfg,
+		Storage: s.storage,
+	}
+
+	if c.Storage == nil {
+		redisStrategy, err := NewRedis(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		c.Redis = redisStrategy
+
+		if err := c.Redis.Ping(ctx); err != nil {
+			c.Redis.Close()
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		c.Redis.SetServerClock(cfg.RateLimit.BlockServerClock)
+		c.Redis.SetLogRetention(cfg.RateLimit.SlidingLogRetention)
+		// Read-only instances never touch keys, they list those indexed by the others
+		if index := cfg.RateLimit.KeyIndex; index.Enabled {
+			c.Redis.EnableKeyIndex(index.Kinds, index.TTL, index.FlushInterval)
+		}
+		c.Storage = c.Redis
+
+		// Moves block and grant keys of earlier versions under the hash tag of
+		// their counter; read-only instances leave it to the others
+		if !cfg.RateLimit.ReadOnly {
+			go func(redisStrategy *strategy.RedisStrategy) {
+				moved, err := redisStrategy.MigrateLegacyKeys(context.Background())
+				if err != nil {
+					slog.Error("Failed to migrate legacy keys", "moved", moved, "error", err)
+					return
+				}
+				if moved > 0 {
+					slog.Info("Migrated legacy keys", "moved", moved)
+				}
+			}(c.Redis)
+		}
+	}
+	if cfg.RateLimit.ReadOnly {
+		c.Storage = strategy.NewReadOnlyStrategy(c.Storage)
+	}
+
+	if cfg.RateLimit.SamplingSeed != 0 {
+		sampling.SetSeed(cfg.RateLimit.SamplingSeed)
+	}
+
+	c.Limiter = limiter.NewRateLimiter(c.Storage, cfg)
+	for kind, specs := range map[string][]string{
+		"ip":    cfg.RateLimit.IPKeyTransforms,
+		"token": cfg.RateLimit.TokenKeyTransforms,
+	} {
+		pipeline, err := keys.Parse(specs)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid %s key transforms: %w", kind, err)
+		}
+		if kind == "ip" && cfg.RateLimit.IPAggregated() {
+			pipeline = append(pipeline, keys.IPPrefix(cfg.RateLimit.IPv4Prefix, cfg.RateLimit.IPv6Prefix))
+		}
+		c.Limiter.SetKeyPipeline(kind, pipeline)
+	}
+	if migration := cfg.RateLimit.KeyMigration; migration.Enabled {
+		previous := make(map[string]keys.Pipeline, 2)
+		for kind, specs := range map[string][]string{
+			"ip":    migration.PreviousIPTransforms,
+			"token": migration.PreviousTokenTransforms,
+		} {
+			pipeline, err := keys.Parse(specs)
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("invalid previous %s key transforms: %w", kind, err)
+			}
+			previous[kind] = pipeline
+		}
+		c.Limiter.SetKeyMigration(previous)
+	}
+
+	for policy, algorithm := range map[string]string{
+		"ip":    cfg.RateLimit.IPAlgorithm,
+		"token": cfg.RateLimit.TokenAlgorithm,
+	} {
+		if err := c.Limiter.SetAlgorithm(policy, algorithm); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid %s algorithm: %w", policy, err)
+		}
+	}
+	c.Limiter.SetBucket("ip", cfg.RateLimit.IPBucket)
+	c.Limiter.SetBucket("token", cfg.RateLimit.TokenBucket)
+	for policy, algorithm := range map[string]string{
+		"ip":    cfg.RateLimit.IPShadowAlgorithm,
+		"token": cfg.RateLimit.TokenShadowAlgorithm,
+	} {
+		if err := c.Limiter.SetShadowAlgorithm(policy, algorithm); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid %s shadow algorithm: %w", policy, err)
+		}
+	}
+
+	configOpts, err := MiddlewareOptions(cfg)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	var client *redis.Client
+	if c.Redis != nil {
+		client = c.Redis.Client()
+	}
+	lists, err := acl.NewLists(client, cfg.RateLimit.AccessLists.Allow, cfg.RateLimit.AccessLists.Deny, cfg.RateLimit.AccessLists.SyncInterval)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := lists.Start(ctx); err != nil {
+		slog.Error("Failed to load runtime access list entries", "error", err)
+	}
+	c.AccessLists = lists
+	configOpts = append(configOpts, middleware.WithAccessLists(lists, cfg.RateLimit.AccessLists.DenyStatus))
+
+	if client != nil && cfg.RateLimit.KeyHistory.Size > 0 {
+		c.Timeline = timeline.NewRecorder(client, cfg.RateLimit.KeyHistory.Size, cfg.RateLimit.KeyHistory.TTL)
+		// Read-only instances serve the timeline without adding to it
+		if !cfg.RateLimit.ReadOnly {
+			c.Limiter.Events().Subscribe(c.Timeline)
+			configOpts = append(configOpts, middleware.WithTimeline(c.Timeline))
+		}
+	}
+
+	if cfg.RateLimit.FailureMode == config.FailureLocal {
+		c.Fallback = limiter.NewFallback(c.Limiter, cfg.RateLimit.Fallback, cfg.RateLimit.Fallback.ResolveInstanceCount(ctx))
+	}
+	if cfg.RateLimit.FailureMode != "" {
+		failure, err := middleware.WithFailureMode(cfg.RateLimit.FailureMode, c.Fallback)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		configOpts = append(configOpts, failure)
+	}
+
+	if cfg.RateLimit.ErrorBudget.Budget > 0 {
+		c.Relaxer = budget.NewRelaxer(c.Limiter, c.Limiter.Events(), cfg.RateLimit.ErrorBudget)
+		c.Relaxer.Start()
+		configOpts = append(configOpts, middleware.WithErrorBudget(c.Relaxer))
+	}
+
+	// Deadlines are only honored from trusted proxies
+	internalHeaders := cfg.RateLimit.Trust.Headers
+	if cfg.RateLimit.RequestDeadline {
+		internalHeaders = append(append([]string{}, internalHeaders...), middleware.DeadlineHeader)
+	}
+	boundary, err := middleware.TrustBoundaryMiddleware(cfg.RateLimit.Trust.Proxies, internalHeaders, cfg.RateLimit.Trust.Cookies)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.TrustBoundary = boundary
+
+	middlewareOpts := append(configOpts, s.middlewareOptions...)
+	c.Options = middlewareOpts
+	rateLimit := middleware.RateLimitMiddleware(c.Limiter, middlewareOpts...)
+	info := middleware.RateLimitInfoMiddleware(c.Limiter, middlewareOpts...)
+	c.Middleware = func(next http.Handler) http.Handler { return boundary(rateLimit(next)) }
+	c.InfoMiddleware = func(next http.Handler) http.Handler { return boundary(info(next)) }
+
+	if s.lifecycle != nil {
+		s.lifecycle.OnShutdown("rate limiter", lifecycle.PhaseStorage, func(context.Context) error {
+			return c.Close()
+		})
+	}
+
+	return c, nil
+}
+
+// NewRedis creates the Redis strategy of the configuration, with its TLS
+// settings and retry policy. The connection is made lazily, Ping checks it
+func NewRedis(cfg config.RedisConfig) (*strategy.RedisStrategy, error) {
+	tlsConfig, err := cfg.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis TLS settings: %w", err)
+	}
+
+	redisStrategy := strategy.NewRedisStrategyWithOptions(&redis.Options{
+		Addr:         fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		TLSConfig:    tlsConfig,
+		PoolSize:     cfg.Pool.Size,
+		MinIdleConns: cfg.Pool.MinIdleConns,
+		ReadTimeout:  cfg.Pool.ReadTimeout,
+		WriteTimeout: cfg.Pool.WriteTimeout,
+		MaxRetries:   cfg.Pool.MaxRetries,
+	})
+	redisStrategy.SetRetryPolicy(strategy.RetryPolicy{
+		MaxAttempts: cfg.Retry.MaxAttempts,
+		BaseDelay:   cfg.Retry.BaseDelay,
+		MaxDelay:    cfg.Retry.MaxDelay,
+		Budget:      cfg.Retry.Budget,
+	})
+	if cfg.LocalCacheTTL > 0 {
+		redisStrategy.EnableLocalCache(cfg.LocalCacheTTL)
+	}
+	if cfg.BatchInterval > 0 {
+		redisStrategy.EnableBatching(cfg.BatchInterval)
+	}
+	if cfg.FaultInjection {
+		redisStrategy.EnableFaultInjection()
+	}
+	return redisStrategy, nil
+}
+
+// MiddlewareOptions builds the rate limit middleware options from the configuration
+func MiddlewareOptions(cfg *config.Config) ([]middleware.Option, error) {
+	var opts []middleware.Option
+	if cfg.RateLimit.Debug.Enabled {
+		opts = append(opts, middleware.WithDebug(cfg.RateLimit.Debug.SampleRate))
+	}
+	if cfg.RateLimit.ReadOnly {
+		opts = append(opts, middleware.WithReadOnly())
+	}
+	if cfg.RateLimit.RequestDeadline {
+		opts = append(opts, middleware.WithRequestDeadline())
+	}
+	if decisions := cfg.RateLimit.DecisionLog; decisions.Enabled {
+		opts = append(opts, middleware.WithDecisionLog(decisions.SampleRate, decisions.DeniedSampleRate))
+	}
+
+	if len(cfg.RateLimit.TokenSources) > 0 {
+		sources, err := middleware.ParseTokenSources(cfg.RateLimit.TokenSources)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token sources: %w", err)
+		}
+		opts = append(opts, middleware.WithTokenSources(sources...))
+	}
+	if cfg.RateLimit.CredentialConflict != "" {
+		conflict, err := middleware.WithCredentialConflict(cfg.RateLimit.CredentialConflict)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, conflict)
+	}
+
+	if cfg.RateLimit.HeaderVisibility != "" {
+		visibility, err := middleware.WithHeaderVisibility(cfg.RateLimit.HeaderVisibility)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, visibility)
+	}
+
+	if cfg.RateLimit.HeaderFormat != "" {
+		format, err := middleware.WithHeaderFormat(cfg.RateLimit.HeaderFormat)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, format)
+	}
+
+	if len(cfg.RateLimit.Force.TrustedCIDRs) > 0 || cfg.RateLimit.Force.AdminToken != "" {
+		force, err := middleware.WithForceHeader(cfg.RateLimit.Force.TrustedCIDRs, cfg.RateLimit.Force.AdminToken)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, force)
+	}
+
+	if cfg.RateLimit.Bypass.Secret != "" {
+		opts = append(opts, middleware.WithBypassTokens(bypass.NewSigner(cfg.RateLimit.Bypass.Secret, cfg.RateLimit.Bypass.MaxTTL)))
+	}
+
+	if cfg.RateLimit.Gateway.Enabled() {
+		verifier, err := gateway.NewVerifier(cfg.RateLimit.Gateway)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway hint verifier: %w", err)
+		}
+		opts = append(opts, middleware.WithGatewayHints(verifier, cfg.RateLimit.Gateway.Header, cfg.RateLimit.Tiers))
+	}
+
+	if len(cfg.RateLimit.RouteTemplates) > 0 {
+		opts = append(opts, middleware.WithRouteNormalizer(routes.NewNormalizer(cfg.RateLimit.RouteTemplates)))
+	}
+
+	if len(cfg.RateLimit.MethodPolicies) > 0 {
+		opts = append(opts, middleware.WithMethodPolicies(cfg.RateLimit.MethodPolicies))
+	}
+
+	if cfg.RateLimit.GraphQL.Path != "" {
+		opts = append(opts, middleware.WithGraphQLCost(cfg.RateLimit.GraphQL))
+	}
+
+	if len(cfg.RateLimit.Batch.Routes) > 0 {
+		batchRoutes := make([]config.RouteLimit, 0, len(cfg.RateLimit.Batch.Routes))
+		for _, route := range cfg.RateLimit.Batch.Routes {
+			method, pattern, found := strings.Cut(route, " ")
+			if !found {
+				method, pattern = "", route
+			}
+			batchRoutes = append(batchRoutes, config.RouteLimit{Method: method, Pattern: strings.TrimSpace(pattern)})
+		}
+		opts = append(opts, middleware.WithBatchCost(routes.NewTable(batchRoutes), cfg.RateLimit.Batch.MaxCost))
+	}
+
+	if cfg.RateLimit.RulesFile != "" {
+		ruleList, err := rules.Load(cfg.RateLimit.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rules: %w", err)
+		}
+		set, err := rules.Compile(ruleList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules: %w", err)
+		}
+		opts = append(opts, middleware.WithRules(set))
+	}
+
+	routeLimits := cfg.RateLimit.Routes
+	if cfg.RateLimit.OpenAPISpec != "" {
+		specLimits, err := openapi.LoadRouteLimits(cfg.RateLimit.OpenAPISpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+		}
+		routeLimits = append(routeLimits, specLimits...)
+	}
+	if len(routeLimits) > 0 {
+		opts = append(opts, middleware.WithRoutes(routes.NewTable(routeLimits)))
+	}
+
+	return opts, nil
+}
+
+// Close stops the background loops and releases the storage connection
+func (c *Components) Close() error {
+	if c.AccessLists != nil {
+		c.AccessLists.Stop()
+	}
+	if c.Relaxer != nil {
+		c.Relaxer.Stop()
+	}
+	if c.Fallback != nil {
+		c.Fallback.Close()
+	}
+	if c.Timeline != nil {
+		c.Timeline.Close()
+	}
+	return c.Storage.Close()
+}
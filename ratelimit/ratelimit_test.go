@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// TestRequestDeadlineThroughTrustedProxy sends a deadline through a trusted
+// proxy to a router wired as the server's: trust boundary, RealIP, then the
+// rate limit middleware
+func TestRequestDeadlineThroughTrustedProxy(t *testing.T) {
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{
+		IPLimit:         10,
+		IPBlockTime:     time.Minute,
+		Window:          time.Minute,
+		RequestDeadline: true,
+		Trust:           config.TrustConfig{Proxies: []string{"10.0.0.0/8"}},
+	}}
+	storage := strategy.NewMemoryStrategy()
+	components, err := Setup(context.Background(), cfg, WithStorage(storage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer components.Close()
+
+	var remoteAddr string
+	var deadline time.Time
+	var hasDeadline bool
+	router := chi.NewRouter()
+	router.Use(components.TrustBoundary)
+	router.Use(chimiddleware.RealIP)
+	router.With(components.Middleware).Get("/api/test", func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		deadline, hasDeadline = r.Context().Deadline()
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		client     string
+		honored    bool
+	}{
+		{"trusted proxy", "10.1.2.3:4567", "203.0.113.9", true},
+		{"untrusted peer", "198.51.100.7:4567", "198.51.100.7:4567", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+			r := httptest.NewRequest("GET", "/api/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", "203.0.113.9")
+			r.Header.Set(middleware.DeadlineHeader, want.Format(time.RFC3339Nano))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if remoteAddr != tt.client {
+				t.Errorf("RemoteAddr = %q, want %q", remoteAddr, tt.client)
+			}
+			if honored := hasDeadline && deadline.Equal(want); honored != tt.honored {
+				t.Errorf("deadline honored = %t (%v, %t), want %t", honored, deadline, hasDeadline, tt.honored)
+			}
+		})
+	}
+}
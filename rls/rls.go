@@ -0,0 +1,180 @@
+package rls
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Service implements the Envoy rate limit service
+// (envoy.service.ratelimit.v3.RateLimitService) on top of the limiter, so Envoy
+// and Istio sidecars can delegate their decisions to it. Each descriptor is
+// checked as one request of the client its entries identify, against the same
+// IP and token keys as the HTTP middleware
+type Service struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+
+	limiter   *limiter.RateLimiter
+	domain    string
+	ipKeys    map[string]bool
+	tokenKeys map[string]bool
+}
+
+// New creates the service answering with rateLimiter
+func New(rateLimiter *limiter.RateLimiter, cfg config.RLSConfig) *Service {
+	s := &Service{
+		limiter:   rateLimiter,
+		domain:    cfg.Domain,
+		ipKeys:    make(map[string]bool, len(cfg.IPKeys)),
+		tokenKeys: make(map[string]bool, len(cfg.TokenKeys)),
+	}
+	for _, key := range cfg.IPKeys {
+		s.ipKeys[key] = true
+	}
+	for _, key := range cfg.TokenKeys {
+		s.tokenKeys[key] = true
+	}
+	return s
+}
+
+// Register registers the service on a gRPC server, before it starts
+func (s *Service) Register(server grpc.ServiceRegistrar) {
+	rlsv3.RegisterRateLimitServiceServer(server, s)
+}
+
+// ShouldRateLimit checks every descriptor of the request, which is over limit
+// when any of them is. Descriptors identifying no client, and every descriptor
+// of another domain, are answered OK. Storage errors fail the RPC with
+// Unavailable, leaving the decision to the failure_mode_deny setting of Envoy
+func (s *Service) ShouldRateLimit(ctx context.Context, request *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if len(request.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "rate limit request must have at least one descriptor")
+	}
+
+	response := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+	for _, descriptor := range request.GetDescriptors() {
+		if s.domain != "" && request.GetDomain() != s.domain {
+			metrics.RLSDescriptors.WithLabelValues("unmatched").Inc()
+			response.Statuses = append(response.Statuses, &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK})
+			continue
+		}
+
+		descriptorStatus, err := s.check(ctx, descriptor, hits(request, descriptor))
+		if err != nil {
+			metrics.RLSDescriptors.WithLabelValues("error").Inc()
+			slog.Warn("Envoy rate limit check failed", "domain", request.GetDomain(), "error", err)
+			return nil, status.Errorf(codes.Unavailable, "rate limit check failed: %v", err)
+		}
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			response.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		response.Statuses = append(response.Statuses, descriptorStatus)
+	}
+	return response, nil
+}
+
+// check counts a descriptor against the limits of the client it identifies.
+// The IP decides which limit applies, the token one configured for it; a
+// descriptor with only a token is checked when the token is configured
+func (s *Service) check(ctx context.Context, descriptor *ratelimitv3.RateLimitDescriptor, hits int) (*rlsv3.RateLimitResponse_DescriptorStatus, error) {
+	var ip, token string
+	for _, entry := range descriptor.GetEntries() {
+		switch {
+		case s.ipKeys[entry.GetKey()] && net.ParseIP(entry.GetValue()) != nil:
+			ip = entry.GetValue()
+		case s.tokenKeys[entry.GetKey()]:
+			// Invalid tokens are ignored, as by the HTTP middleware
+			if parsed, err := strategy.ParseTokenFromHeader(entry.GetValue()); err == nil {
+				token = parsed
+			}
+		}
+	}
+
+	policy := s.limiter.Policy()
+	_, configured := policy.TokenLimits[token]
+	ctx = limiter.WithCost(ctx, hits)
+
+	var result *limiter.CheckResult
+	var err error
+	switch {
+	case ip != "":
+		result, err = s.limiter.CheckRateLimit(ctx, ip, token)
+	case token != "" && configured:
+		result, err = s.limiter.CheckTokenRateLimit(ctx, token)
+	default:
+		metrics.RLSDescriptors.WithLabelValues("unmatched").Inc()
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	descriptorStatus := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code:           rlsv3.RateLimitResponse_OK,
+		LimitRemaining: uint32(max(result.Remaining, 0)),
+	}
+	if !result.Allowed {
+		descriptorStatus.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+		metrics.RLSDescriptors.WithLabelValues("over_limit").Inc()
+	} else {
+		metrics.RLSDescriptors.WithLabelValues("ok").Inc()
+	}
+	if until := time.Until(result.ResetTime); until > 0 {
+		descriptorStatus.DurationUntilReset = durationpb.New(until)
+	}
+	if result.Limit > 0 {
+		window := policy.LimitWindow()
+		if result.Policy == "token" {
+			window = policy.TokenWindow(policy.TokenLimits[token])
+		}
+		descriptorStatus.CurrentLimit = &rlsv3.RateLimitResponse_RateLimit{
+			Name:            result.Policy,
+			RequestsPerUnit: uint32(result.Limit),
+			Unit:            unit(window),
+		}
+	}
+	return descriptorStatus, nil
+}
+
+// hits returns the units a descriptor consumes: its own hits_addend, the
+// request's or 1
+func hits(request *rlsv3.RateLimitRequest, descriptor *ratelimitv3.RateLimitDescriptor) int {
+	addend := uint64(request.GetHitsAddend())
+	if override := descriptor.GetHitsAddend(); override != nil {
+		addend = override.GetValue()
+	}
+	if addend == 0 {
+		return 1
+	}
+	return int(min(addend, math.MaxInt32))
+}
+
+// unit maps a window to the Envoy unit of the same length, UNKNOWN for windows
+// of other lengths
+func unit(window time.Duration) rlsv3.RateLimitResponse_RateLimit_Unit {
+	switch window {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR
+	case 24 * time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_DAY
+	default:
+		return rlsv3.RateLimitResponse_RateLimit_UNKNOWN
+	}
+}
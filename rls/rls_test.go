@@ -0,0 +1,236 @@
+package rls
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+const testLimit = 5
+
+// newTestService creates a service over an in-memory limiter allowing
+// testLimit requests per IP and token abc123
+func newTestService(t *testing.T, storage strategy.StorageStrategy) *Service {
+	t.Helper()
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{
+		IPLimit:     testLimit,
+		IPBlockTime: time.Minute,
+		Window:      time.Hour,
+		TokenLimits: map[string]config.TokenLimit{
+			"abc123": {Limit: testLimit, BlockTime: time.Minute},
+		},
+	}}
+	return New(limiter.NewRateLimiter(storage, cfg), config.RLSConfig{
+		Domain:    "edge",
+		IPKeys:    []string{"remote_address"},
+		TokenKeys: []string{"api_key"},
+	})
+}
+
+func memoryStorage(t *testing.T) strategy.StorageStrategy {
+	storage := strategy.NewMemoryStrategy()
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func descriptor(entries ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+	}
+	return d
+}
+
+func TestShouldRateLimitDomainMismatch(t *testing.T) {
+	s := newTestService(t, memoryStorage(t))
+	ctx := context.Background()
+
+	// Other domains are answered OK without counting
+	for range testLimit + 1 {
+		response, err := s.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+			Domain:      "internal",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "192.0.2.1")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.OverallCode != rlsv3.RateLimitResponse_OK {
+			t.Fatalf("OverallCode = %s, want OK", response.OverallCode)
+		}
+		if len(response.Statuses) != 1 || response.Statuses[0].CurrentLimit != nil {
+			t.Fatalf("Statuses = %v, want one unmatched status", response.Statuses)
+		}
+	}
+}
+
+func TestShouldRateLimitDescriptors(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor *ratelimitv3.RateLimitDescriptor
+		policy     string
+	}{
+		{"IP only", descriptor("remote_address", "192.0.2.1"), "ip"},
+		{"token only", descriptor("api_key", "abc123"), "token"},
+		{"IP and token", descriptor("remote_address", "192.0.2.2", "api_key", "abc123"), "token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, memoryStorage(t))
+			request := &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*ratelimitv3.RateLimitDescriptor{tt.descriptor}}
+
+			for i := range testLimit {
+				response, err := s.ShouldRateLimit(context.Background(), request)
+				if err != nil {
+					t.Fatal(err)
+				}
+				descriptorStatus := response.Statuses[0]
+				if response.OverallCode != rlsv3.RateLimitResponse_OK || descriptorStatus.Code != rlsv3.RateLimitResponse_OK {
+					t.Fatalf("request %d: OverallCode = %s, want OK", i+1, response.OverallCode)
+				}
+				if want := uint32(testLimit - i - 1); descriptorStatus.LimitRemaining != want {
+					t.Errorf("request %d: LimitRemaining = %d, want %d", i+1, descriptorStatus.LimitRemaining, want)
+				}
+				if limit := descriptorStatus.CurrentLimit; limit == nil || limit.Name != tt.policy || limit.RequestsPerUnit != testLimit || limit.Unit != rlsv3.RateLimitResponse_RateLimit_HOUR {
+					t.Errorf("request %d: CurrentLimit = %v, want %d per hour of policy %s", i+1, limit, testLimit, tt.policy)
+				}
+			}
+
+			response, err := s.ShouldRateLimit(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+				t.Errorf("OverallCode = %s past the limit, want OVER_LIMIT", response.OverallCode)
+			}
+		})
+	}
+}
+
+func TestShouldRateLimitUnmatchedDescriptors(t *testing.T) {
+	s := newTestService(t, memoryStorage(t))
+
+	for _, d := range []*ratelimitv3.RateLimitDescriptor{
+		descriptor("remote_address", "not-an-ip"),
+		descriptor("api_key", "unconfigured"),
+		descriptor("path", "/api"),
+	} {
+		response, err := s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*ratelimitv3.RateLimitDescriptor{d}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.OverallCode != rlsv3.RateLimitResponse_OK || response.Statuses[0].CurrentLimit != nil {
+			t.Errorf("descriptor %v answered %v, want an unmatched OK", d.Entries, response)
+		}
+	}
+}
+
+func TestShouldRateLimitHitsAddend(t *testing.T) {
+	s := newTestService(t, memoryStorage(t))
+
+	// The descriptor's hits_addend overrides the request's
+	response, err := s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:     "edge",
+		HitsAddend: 1,
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			{
+				Entries:    descriptor("remote_address", "192.0.2.1").Entries,
+				HitsAddend: wrapperspb.UInt64(4),
+			},
+			descriptor("remote_address", "192.0.2.2"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Statuses[0].LimitRemaining; got != testLimit-4 {
+		t.Errorf("LimitRemaining with a hits_addend of 4 = %d, want %d", got, testLimit-4)
+	}
+	if got := response.Statuses[1].LimitRemaining; got != testLimit-1 {
+		t.Errorf("LimitRemaining with the request hits_addend = %d, want %d", got, testLimit-1)
+	}
+
+	// A hits_addend beyond the remaining units is over limit
+	response, err = s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		HitsAddend:  testLimit,
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "192.0.2.2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("OverallCode = %s, want OVER_LIMIT", response.OverallCode)
+	}
+}
+
+func TestShouldRateLimitOverallCode(t *testing.T) {
+	s := newTestService(t, memoryStorage(t))
+	ctx := context.Background()
+
+	exhausted := descriptor("remote_address", "192.0.2.1")
+	for range testLimit {
+		if _, err := s.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{Domain: "edge", Descriptors: []*ratelimitv3.RateLimitDescriptor{exhausted}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One descriptor over limit makes the whole request over limit
+	response, err := s.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "192.0.2.2"), exhausted},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.OverallCode != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("OverallCode = %s, want OVER_LIMIT", response.OverallCode)
+	}
+	if got := []rlsv3.RateLimitResponse_Code{response.Statuses[0].Code, response.Statuses[1].Code}; got[0] != rlsv3.RateLimitResponse_OK || got[1] != rlsv3.RateLimitResponse_OVER_LIMIT {
+		t.Errorf("descriptor codes = %v, want [OK OVER_LIMIT]", got)
+	}
+}
+
+func TestShouldRateLimitNoDescriptors(t *testing.T) {
+	s := newTestService(t, memoryStorage(t))
+
+	_, err := s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{Domain: "edge"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("error = %v, want InvalidArgument", err)
+	}
+}
+
+// failingStorage is an in-memory storage whose block checks fail, as Redis
+// does when unreachable
+type failingStorage struct {
+	*strategy.MemoryStrategy
+}
+
+func (failingStorage) IsBlocked(context.Context, string) (bool, time.Time, error) {
+	return false, time.Time{}, errors.New("connection refused")
+}
+
+func TestShouldRateLimitStorageError(t *testing.T) {
+	storage := failingStorage{strategy.NewMemoryStrategy()}
+	defer storage.Close()
+	s := newTestService(t, storage)
+
+	_, err := s.ShouldRateLimit(context.Background(), &rlsv3.RateLimitRequest{
+		Domain:      "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "192.0.2.1")},
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("error = %v, want Unavailable", err)
+	}
+}
@@ -0,0 +1,27 @@
+package chiroutes
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Pattern resolves the chi route pattern a request will be served by, empty
+// outside a chi router or when no route matches. Middleware runs before routing
+// completes, so the pattern is looked up from the root router. It is meant for
+// middleware.WithPatternResolver, keeping chi out of the middleware package
+func Pattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	if rctx.Routes == nil {
+		return rctx.RoutePattern()
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	return rctx.Routes.Find(chi.NewRouteContext(), r.Method, path)
+}
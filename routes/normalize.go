@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// maxLabels bounds the distinct routes reported as metric labels
+const maxLabels = 200
+
+// OtherRoute is the metric label of routes beyond maxLabels
+const OtherRoute = "other"
+
+type routeKey struct{}
+
+// WithRoute stores the normalized route of a request in its context
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the normalized route stored by WithRoute
+func RouteFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeKey{}).(string)
+	return route, ok
+}
+
+// Normalizer maps request paths to route templates, e.g. "/users/123" to
+// "/users/{id}", so that policies, metrics and reports see one route per
+// endpoint instead of one per resource
+type Normalizer struct {
+	templates []compiledRoute
+
+	mu     sync.Mutex
+	labels map[string]bool
+}
+
+// NewNormalizer creates a normalizer trying the templates in order, e.g.
+// "/orders/{order}/items/{item}". Paths matching no template have their
+// ID-like segments (numbers, UUIDs, long hex or alphanumeric tokens) replaced
+// with "{id}"
+func NewNormalizer(templates []string) *Normalizer {
+	limits := make([]config.RouteLimit, 0, len(templates))
+	for _, template := range templates {
+		limits = append(limits, config.RouteLimit{Pattern: template})
+	}
+	return &Normalizer{templates: NewTable(limits).routes, labels: make(map[string]bool)}
+}
+
+// Normalize returns the route of a request: the one stored by WithRoute, the
+// route pattern stored by WithPattern, or the normalized path otherwise
+func (n *Normalizer) Normalize(r *http.Request) string {
+	if route, ok := RouteFromContext(r.Context()); ok {
+		return route
+	}
+	if pattern := Pattern(r); pattern != "" {
+		return pattern
+	}
+	return n.NormalizePath(r.URL.Path)
+}
+
+// NormalizePath returns the template matching a path, or the path with its
+// ID-like segments replaced
+func (n *Normalizer) NormalizePath(path string) string {
+	var segments []string
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		segments = strings.Split(trimmed, "/")
+	}
+
+	if n != nil {
+		for _, template := range n.templates {
+			if template.matches(segments) {
+				return template.limit.Pattern
+			}
+		}
+	}
+
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// Label returns the route as a metric label, OtherRoute once maxLabels
+// distinct routes were seen
+func (n *Normalizer) Label(route string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.labels[route] {
+		return route
+	}
+	if len(n.labels) >= maxLabels {
+		return OtherRoute
+	}
+	n.labels[route] = true
+	return route
+}
+
+// isIdentifier reports whether a path segment looks like a resource ID
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	var digits, letters, hex int
+	for _, c := range segment {
+		switch {
+		case unicode.IsDigit(c):
+			digits++
+			hex++
+		case strings.ContainsRune("abcdefABCDEF", c):
+			letters++
+			hex++
+		case unicode.IsLetter(c):
+			letters++
+		case c != '-' && c != '_':
+			return false
+		}
+	}
+
+	switch {
+	case letters == 0 && digits > 0:
+		// Numeric IDs
+		return true
+	case len(segment) == 36 && strings.Count(segment, "-") == 4 && hex == 32:
+		// UUIDs
+		return true
+	case hex == len(segment) && len(segment) >= 16:
+		// Hashes and object IDs
+		return true
+	default:
+		// Opaque tokens mixing letters and digits
+		return len(segment) >= 16 && digits > 0 && letters > 0
+	}
+}
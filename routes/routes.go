@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// Table matches requests against per-route limits
+type Table struct {
+	routes []compiledRoute
+}
+
+type compiledRoute struct {
+	limit config.RouteLimit
+	// methods are the HTTP methods matched, nil for any
+	methods  map[string]bool
+	segments []string
+	wildcard bool
+}
+
+// NewTable compiles the route limits. When several routes match a request,
+// the first one declared wins
+func NewTable(limits []config.RouteLimit) *Table {
+	table := &Table{}
+	for _, limit := range limits {
+		limit.Method = strings.ToUpper(limit.Method)
+
+		pattern := strings.Trim(limit.Pattern, "/")
+		wildcard := pattern == "*" || strings.HasSuffix(pattern, "/*")
+		pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
+
+		var segments []string
+		if pattern != "" {
+			segments = strings.Split(pattern, "/")
+		}
+
+		var methods map[string]bool
+		for _, method := range limit.Methods() {
+			if methods == nil {
+				methods = make(map[string]bool)
+			}
+			methods[method] = true
+		}
+
+		table.routes = append(table.routes, compiledRoute{
+			limit:    limit,
+			methods:  methods,
+			segments: segments,
+			wildcard: wildcard,
+		})
+	}
+	return table
+}
+
+// Len returns the number of routes in the table
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.routes)
+}
+
+// Match returns the limit of the first route matching the method and path
+func (t *Table) Match(method, path string) (config.RouteLimit, bool) {
+	if t == nil {
+		return config.RouteLimit{}, false
+	}
+
+	var segments []string
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		segments = strings.Split(trimmed, "/")
+	}
+
+	for _, route := range t.routes {
+		if route.methods != nil && !route.methods[method] {
+			continue
+		}
+		if route.matches(segments) {
+			return route.limit, true
+		}
+	}
+	return config.RouteLimit{}, false
+}
+
+// MatchRequest returns the limit of the first route matching a request. When
+// the router provided the request's route pattern (WithPattern) the pattern is
+// matched instead of its path, so a "/api/users/{id}" limit applies to every
+// route the router resolves to that pattern. A per-endpoint limit is returned
+// with the pattern, so that each route it covers is counted apart; without a
+// pattern its routes share the budget
+func (t *Table) MatchRequest(r *http.Request) (config.RouteLimit, bool) {
+	if t.Len() == 0 {
+		return config.RouteLimit{}, false
+	}
+	if pattern := Pattern(r); pattern != "" {
+		limit, ok := t.Match(r.Method, pattern)
+		if ok && limit.PerEndpoint {
+			limit.Pattern = pattern
+		}
+		return limit, ok
+	}
+	return t.Match(r.Method, r.URL.Path)
+}
+
+type patternKey struct{}
+
+// WithPattern stores the route pattern a request will be served by, as
+// resolved by the router, e.g. "/api/users/{id}"
+func WithPattern(ctx context.Context, pattern string) context.Context {
+	return context.WithValue(ctx, patternKey{}, pattern)
+}
+
+// Pattern returns the route pattern stored by WithPattern, empty when the
+// router did not provide one
+func Pattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(patternKey{}).(string)
+	return pattern
+}
+
+// matches compares path segments, treating "{param}" segments as placeholders
+func (r compiledRoute) matches(segments []string) bool {
+	if len(segments) < len(r.segments) || (!r.wildcard && len(segments) != len(r.segments)) {
+		return false
+	}
+
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+)
+
+// FuzzMatch checks that matching never panics, that equivalent spellings of a
+// path match the same route, and that normalized paths stay stable
+func FuzzMatch(f *testing.F) {
+	table := NewTable([]config.RouteLimit{
+		{Pattern: "/api/users/{id}", Method: "GET", Limit: 1},
+		{Pattern: "/api/orders/{order}/items/{item}", Limit: 2},
+		{Pattern: "/static/*", Limit: 3},
+		{Pattern: "/login", Method: "POST|PUT", Limit: 4},
+		{Pattern: "*", Method: "DELETE", Limit: 5},
+	})
+	normalizer := NewNormalizer([]string{"/orders/{order}/items/{item}"})
+
+	for _, seed := range []struct{ method, path string }{
+		{"GET", "/api/users/42"},
+		{"GET", "api/users/42/"},
+		{"POST", "/api/orders/1/items/2"},
+		{"GET", "/static/css/app.css"},
+		{"PUT", "/login"},
+		{"DELETE", "/anything/at/all"},
+		{"GET", "//"},
+		{"", ""},
+		{"GET", "/api/users//"},
+		{"GET", "/{id}/{}/"},
+	} {
+		f.Add(seed.method, seed.path)
+	}
+
+	f.Fuzz(func(t *testing.T, method, path string) {
+		limit, ok := table.Match(method, path)
+		if !ok && limit != (config.RouteLimit{}) {
+			t.Fatalf("Match(%q, %q) returned %+v without a match", method, path, limit)
+		}
+
+		// Leading and trailing slashes do not change the route
+		trimmed := "/" + strings.Trim(path, "/")
+		if again, againOK := table.Match(method, trimmed); againOK != ok || again != limit {
+			t.Fatalf("Match(%q, %q) = %+v, %v, but %q gives %+v, %v", method, path, limit, ok, trimmed, again, againOK)
+		}
+
+		route := normalizer.NormalizePath(path)
+		if !strings.HasPrefix(route, "/") {
+			t.Fatalf("NormalizePath(%q) = %q, want a leading slash", path, route)
+		}
+		if again := normalizer.NormalizePath(route); again != route {
+			t.Fatalf("NormalizePath(%q) = %q, but NormalizePath(%q) = %q", path, route, route, again)
+		}
+	})
+}
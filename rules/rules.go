@@ -0,0 +1,233 @@
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule matches requests with a CEL expression and limits or prices them, e.g.
+//
+//	name: free-exports
+//	match: request.path.startsWith('/export') && token.tier == 'free'
+//	limit: 2
+//	cost: "request.method == 'POST' ? 5 : 1"
+type Rule struct {
+	Name string `yaml:"name"`
+	// Match is a boolean CEL expression over request and token
+	Match string `yaml:"match"`
+	// Limit replaces the IP/token limits for matching requests when positive
+	Limit     int           `yaml:"limit"`
+	BlockTime time.Duration `yaml:"block_time"`
+	// Cost is an integer CEL expression pricing matching requests
+	Cost string `yaml:"cost"`
+}
+
+// defaultNormalizer normalizes the routes of requests the middleware did not normalize
+var defaultNormalizer = routes.NewNormalizer(nil)
+
+// Request describes a request for rule expressions
+type Request struct {
+	Method string `cel:"method"`
+	Path   string `cel:"path"`
+	// Route is the normalized path, e.g. /users/{id}
+	Route string `cel:"route"`
+	Host  string `cel:"host"`
+	IP    string `cel:"ip"`
+	// Headers are keyed by lower-case name, multiple values joined by commas
+	Headers map[string]string `cel:"headers"`
+	// Query holds the first value of each query parameter
+	Query map[string]string `cel:"query"`
+}
+
+// Token describes the credential of a request for rule expressions
+type Token struct {
+	Value   string `cel:"value"`
+	Present bool   `cel:"present"`
+	// Configured reports whether the token has configured limits
+	Configured bool   `cel:"configured"`
+	Tier       string `cel:"tier"`
+	Limit      int    `cel:"limit"`
+}
+
+// Result is the outcome of evaluating the rules for a request
+type Result struct {
+	// Rule is the first matching rule with a limit, nil if none
+	Rule *Rule
+	// Cost is the cost computed by the first matching rule with a cost, 0 if none
+	Cost int
+}
+
+// Set evaluates compiled rules in declaration order
+type Set struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	rule  Rule
+	match cel.Program
+	cost  cel.Program
+}
+
+// Load reads rules from a YAML (or JSON) file with a top-level rules list
+func Load(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	return file.Rules, nil
+}
+
+// Compile type-checks the rule expressions against the request and token variables
+func Compile(rules []Rule) (*Set, error) {
+	env, err := cel.NewEnv(
+		ext.NativeTypes(reflect.TypeOf(Request{}), reflect.TypeOf(Token{}), ext.ParseStructTags(true)),
+		cel.Variable("request", cel.ObjectType("rules.Request")),
+		cel.Variable("token", cel.ObjectType("rules.Token")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &Set{}
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Match == "" {
+			return nil, fmt.Errorf("rule %q: match is required", rule.Name)
+		}
+		if rule.Limit <= 0 && rule.Cost == "" {
+			return nil, fmt.Errorf("rule %q: a limit or a cost is required", rule.Name)
+		}
+
+		compiled := compiledRule{rule: rule}
+		if compiled.match, err = program(env, rule.Match, cel.BoolType); err != nil {
+			return nil, fmt.Errorf("rule %q: match: %w", rule.Name, err)
+		}
+		if rule.Cost != "" {
+			if compiled.cost, err = program(env, rule.Cost, cel.IntType); err != nil {
+				return nil, fmt.Errorf("rule %q: cost: %w", rule.Name, err)
+			}
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, nil
+}
+
+// program compiles an expression returning the wanted type
+func program(env *cel.Env, expression string, want *cel.Type) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if out := ast.OutputType(); !out.IsExactType(want) {
+		return nil, fmt.Errorf("expression returns %s, want %s", out, want)
+	}
+	return env.Program(ast)
+}
+
+// Len returns the number of rules in the set
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Evaluate runs the rules against a request. Expressions failing at runtime,
+// e.g. reading a missing header, do not match
+func (s *Set) Evaluate(r *http.Request, ip string, token Token) Result {
+	var result Result
+	if s.Len() == 0 {
+		return result
+	}
+
+	token.Present = token.Value != ""
+	vars := map[string]interface{}{
+		"request": NewRequest(r, ip),
+		"token":   token,
+	}
+
+	for i := range s.rules {
+		compiled := &s.rules[i]
+		needLimit := result.Rule == nil && compiled.rule.Limit > 0
+		needCost := result.Cost == 0 && compiled.cost != nil
+		if !needLimit && !needCost {
+			continue
+		}
+
+		out, _, err := compiled.match.Eval(vars)
+		if err != nil {
+			continue
+		}
+		if matched, ok := out.Value().(bool); !ok || !matched {
+			continue
+		}
+
+		if needLimit {
+			result.Rule = &compiled.rule
+		}
+		if needCost {
+			if out, _, err := compiled.cost.Eval(vars); err == nil {
+				if cost, ok := out.Value().(int64); ok && cost > 0 {
+					result.Cost = int(cost)
+				}
+			}
+		}
+		if result.Rule != nil && result.Cost > 0 {
+			break
+		}
+	}
+	return result
+}
+
+// NewRequest exposes an HTTP request to rule expressions
+func NewRequest(r *http.Request, ip string) Request {
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	query := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		if len(values) > 0 {
+			query[name] = values[0]
+		}
+	}
+
+	route, ok := routes.RouteFromContext(r.Context())
+	if !ok {
+		route = defaultNormalizer.Normalize(r)
+	}
+
+	return Request{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Route:   route,
+		Host:    r.Host,
+		IP:      ip,
+		Headers: headers,
+		Query:   query,
+	}
+}
@@ -0,0 +1,62 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// FuzzEvaluate checks that rules evaluated against arbitrary requests never
+// panic, and that the result is one of the declared rules with a positive cost
+func FuzzEvaluate(f *testing.F) {
+	set, err := Compile([]Rule{
+		{Name: "export", Match: "request.path.startsWith('/export') && token.tier == 'free'", Limit: 2},
+		{Name: "writes", Match: "request.method == 'POST'", Cost: "request.query['size'] == 'large' ? 10 : 2"},
+		{Name: "agent", Match: "request.headers['user-agent'].contains('bot')", Limit: 1},
+		{Name: "internal", Match: "request.ip.startsWith('10.') && !token.present", Limit: 100, Cost: "size(request.route)"},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, seed := range []struct{ method, path, query, agent, ip, token, tier string }{
+		{"GET", "/export/all", "", "", "203.0.113.1", "abc", "free"},
+		{"POST", "/orders", "size=large", "curl", "203.0.113.1", "", ""},
+		{"GET", "/", "", "googlebot", "10.0.0.1", "", ""},
+		{"POST", "/users/42", "size=%zz", "", "10.1.2.3", "", ""},
+		{"", "", "", "", "", "", ""},
+	} {
+		f.Add(seed.method, seed.path, seed.query, seed.agent, seed.ip, seed.token, seed.tier)
+	}
+
+	f.Fuzz(func(t *testing.T, method, path, query, agent, ip, token, tier string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Method = method
+		r.URL = &url.URL{Path: path, RawQuery: query}
+		if agent != "" {
+			r.Header.Set("User-Agent", agent)
+		}
+
+		result := set.Evaluate(r, ip, Token{Value: token, Tier: tier})
+		if result.Cost < 0 {
+			t.Fatalf("Evaluate returned cost %d", result.Cost)
+		}
+		if result.Rule != nil {
+			found := false
+			for i := range set.rules {
+				if result.Rule == &set.rules[i].rule {
+					found = result.Rule.Limit > 0
+				}
+			}
+			if !found {
+				t.Fatalf("Evaluate returned rule %+v, not a declared rule with a limit", result.Rule)
+			}
+		}
+
+		// Evaluation has no side effects, so it is deterministic
+		again := set.Evaluate(r, ip, Token{Value: token, Tier: tier})
+		if again.Rule != result.Rule || again.Cost != result.Cost {
+			t.Fatalf("Evaluate = %+v, then %+v", result, again)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package sampling
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source draws random samples and jitter from a seeded generator. It is safe
+// for concurrent use; with a fixed seed a single goroutine sees the same
+// sequence on every run
+type Source struct {
+	seed int64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// New creates a source seeded with seed
+func New(seed int64) *Source {
+	return &Source{seed: seed, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed of the source
+func (s *Source) Seed() int64 {
+	return s.seed
+}
+
+// Float64 returns a number in [0, 1)
+func (s *Source) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64()
+}
+
+// Int63n returns a number in [0, n), n must be positive
+func (s *Source) Int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Int63n(n)
+}
+
+// Sample reports whether an event is picked at the given rate, always for
+// rates of 1 or more and never for rates of 0 or less
+func (s *Source) Sample(rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	return s.Float64() < rate
+}
+
+// Jitter returns a full-jitter delay in (0, max]
+func (s *Source) Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(s.Int63n(int64(max)) + 1)
+}
+
+// SampleKey reports whether a key is picked at the given rate. The decision
+// only depends on the key and the seed, so every request of a key and every
+// instance sharing the seed agree, e.g. for mirroring or partially blocking a
+// stable subset of clients
+func (s *Source) SampleKey(key string, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(s.seed, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(mix(h.Sum64()))/math.MaxUint64 < rate
+}
+
+// mix spreads the bits of an FNV hash, which are poorly distributed for
+// short, similar keys (the murmur3 finalizer)
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// current is the process-wide source, randomly seeded until SetSeed
+var current atomic.Pointer[Source]
+
+func init() {
+	current.Store(New(time.Now().UnixNano()))
+}
+
+// Default returns the process-wide source
+func Default() *Source {
+	return current.Load()
+}
+
+// SetSeed replaces the process-wide source with one seeded with seed, making
+// samples reproducible for tests and debugging
+func SetSeed(seed int64) {
+	current.Store(New(seed))
+}
+
+// Sample reports whether an event is picked at rate by the process-wide source
+func Sample(rate float64) bool {
+	return Default().Sample(rate)
+}
+
+// SampleKey reports whether a key is picked at rate by the process-wide source
+func SampleKey(key string, rate float64) bool {
+	return Default().SampleKey(key, rate)
+}
+
+// Jitter returns a full-jitter delay in (0, max] from the process-wide source
+func Jitter(max time.Duration) time.Duration {
+	return Default().Jitter(max)
+}
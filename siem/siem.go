@@ -0,0 +1,238 @@
+package siem
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+)
+
+const (
+	vendor  = "marcelobritu"
+	product = "go-expert-rate-limiter"
+	appName = "ratelimiter"
+
+	// priority is facility security/authorization (4) with severity warning (4)
+	priority = 4*8 + 4
+)
+
+// severities are the CEF severities (0-10) per event type
+var severities = map[string]int{
+	events.TypeBlocked:   7,
+	events.TypeHoneypot:  8,
+	events.TypeLimited:   3,
+	events.TypeRelaxed:   5,
+	events.TypeUnblocked: 5,
+}
+
+// names are the human-readable CEF names per event type
+var names = map[string]string{
+	events.TypeBlocked:   "Client blocked",
+	events.TypeHoneypot:  "Honeypot visited",
+	events.TypeLimited:   "Request rate limited",
+	events.TypeRelaxed:   "Limits adjusted by error budget",
+	events.TypeUnblocked: "Client unblocked by operator",
+}
+
+// Sink streams events as CEF messages in RFC 5424 syslog frames over UDP or
+// TCP, ready to be ingested by Splunk, QRadar and other SIEMs. Events are
+// queued and dropped when the collector falls behind, so the request path
+// never waits on the network
+type Sink struct {
+	network  string
+	address  string
+	version  string
+	hostname string
+	types    map[string]bool
+
+	// mu guards queue against sends after Close
+	mu     sync.RWMutex
+	closed bool
+	queue  chan events.Event
+	conn   net.Conn
+	wg     sync.WaitGroup
+}
+
+// NewSink creates a sink sending the given event types to address, e.g.
+// "udp://siem.internal:514" or "tcp://siem.internal:601". version is the
+// device version reported in the CEF header
+func NewSink(address, version string, types []string) (*Sink, error) {
+	network, hostPort, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &Sink{
+		network:  network,
+		address:  hostPort,
+		version:  version,
+		hostname: hostname,
+		types:    make(map[string]bool, len(types)),
+		queue:    make(chan events.Event, 1024),
+	}
+	for _, eventType := range types {
+		s.types[eventType] = true
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// ParseAddress splits a udp:// or tcp:// collector address
+func ParseAddress(address string) (network, hostPort string, err error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid SIEM address %q: %w", address, err)
+	}
+	if parsed.Scheme != "udp" && parsed.Scheme != "tcp" {
+		return "", "", fmt.Errorf("invalid SIEM address %q: scheme must be udp or tcp", address)
+	}
+	if _, _, err := net.SplitHostPort(parsed.Host); err != nil {
+		return "", "", fmt.Errorf("invalid SIEM address %q: %w", address, err)
+	}
+	return parsed.Scheme, parsed.Host, nil
+}
+
+// Handle queues the selected event types
+func (s *Sink) Handle(event events.Event) {
+	if !s.types[event.Type] {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+	}
+}
+
+// Close sends the queued events and closes the connection
+func (s *Sink) Close() {
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// run writes queued events, reconnecting after write errors
+func (s *Sink) run() {
+	defer s.wg.Done()
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for event := range s.queue {
+		message := s.Format(event)
+		if err := s.write(message); err != nil {
+			slog.Error("Failed to send event to SIEM", "type", event.Type, "error", err)
+		}
+	}
+}
+
+func (s *Sink) write(message string) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	// TCP uses newline framing (RFC 6587), UDP one message per datagram
+	if s.network == "tcp" {
+		message += "\n"
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write([]byte(message)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Format renders an event as a CEF message in an RFC 5424 syslog frame
+func (s *Sink) Format(event events.Event) string {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s",
+		priority, timestamp.UTC().Format(time.RFC3339Nano), s.hostname, appName, event.Type, CEF(event, s.version))
+}
+
+// CEF renders an event in the ArcSight Common Event Format
+func CEF(event events.Event, version string) string {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	name, ok := names[event.Type]
+	if !ok {
+		name = event.Type
+	}
+
+	extensions := []string{
+		"rt=" + strconv.FormatInt(timestamp.UnixMilli(), 10),
+		"act=" + escapeExtension(event.Type),
+		"cs1Label=key",
+		"cs1=" + escapeExtension(event.Key),
+	}
+	if kind, identifier, found := strings.Cut(event.Key, ":"); found && kind == "ip" && net.ParseIP(identifier) != nil {
+		extensions = append(extensions, "src="+identifier)
+	}
+	if event.Policy != "" {
+		extensions = append(extensions, "cat="+escapeExtension(event.Policy))
+	}
+	if event.Reason != "" {
+		extensions = append(extensions, "reason="+escapeExtension(event.Reason))
+	}
+	if event.Path != "" {
+		extensions = append(extensions, "request="+escapeExtension(event.Path))
+	}
+	if event.RequestID != "" {
+		extensions = append(extensions, "cs2Label=requestId", "cs2="+escapeExtension(event.RequestID))
+	}
+	if event.BlockTime > 0 {
+		extensions = append(extensions, "cn1Label=blockSeconds", "cn1="+strconv.Itoa(int(event.BlockTime.Seconds())))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		escapeHeader(vendor), escapeHeader(product), escapeHeader(version),
+		escapeHeader(event.Type), escapeHeader(name), severities[event.Type],
+		strings.Join(extensions, " "))
+}
+
+// escapeHeader escapes backslashes and pipes in CEF header fields
+func escapeHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// escapeExtension escapes backslashes, equal signs and newlines in CEF extension values
+func escapeExtension(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
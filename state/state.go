@@ -0,0 +1,302 @@
+package state
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+// Format identifies state dumps in the manifest
+const Format = "ratelimiter-state"
+
+// SchemaVersion is the version of the dump layout written by Write. Read
+// accepts dumps up to this version
+const SchemaVersion = 1
+
+// Files of a dump, after the manifest. Record files hold one JSON
+// strategy.Record per line
+const (
+	ManifestFile  = "manifest.json"
+	CountersFile  = "counters.jsonl"
+	BlocksFile    = "blocks.jsonl"
+	UsageFile     = "usage.jsonl"
+	OverridesFile = "overrides.json"
+)
+
+// Key prefixes of the usage rollups and granted units
+const (
+	usagePrefix = "quota:"
+	grantPrefix = "grant:"
+)
+
+// internalPrefixes start keys of the limiter's own bookkeeping, which are
+// integers too but are not client state
+var internalPrefixes = []string{"policy:"}
+
+// File describes one file of a dump
+type File struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+}
+
+// Manifest describes a dump and is its first file
+type Manifest struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Files         []File    `json:"files"`
+}
+
+// Overrides are the limits changed at runtime and the units granted to keys
+type Overrides struct {
+	// Policy is the enforced policy, nil when the dump leaves it unchanged
+	Policy *config.Policy    `json:"policy,omitempty"`
+	Grants []strategy.Record `json:"grants"`
+}
+
+// Dump is the operational state of the limiter, independent of the storage
+type Dump struct {
+	Manifest  Manifest
+	Counters  []strategy.Record
+	Blocks    []strategy.Record
+	Usage     []strategy.Record
+	Overrides Overrides
+}
+
+// Collect reads every counter, block, usage rollup and grant of the storage
+// into a dump enforcing policy
+func Collect(ctx context.Context, storage strategy.MigratableStorage, policy config.Policy) (*Dump, error) {
+	dump := &Dump{Overrides: Overrides{Policy: &policy}}
+	err := storage.Export(ctx, func(record strategy.Record) error {
+		switch {
+		case record.Blocked:
+			dump.Blocks = append(dump.Blocks, record)
+		case internal(record.Key):
+		case strings.HasPrefix(record.Key, usagePrefix):
+			dump.Usage = append(dump.Usage, record)
+		case strings.HasPrefix(record.Key, grantPrefix):
+			dump.Overrides.Grants = append(dump.Overrides.Grants, record)
+		default:
+			dump.Counters = append(dump.Counters, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export state: %w", err)
+	}
+	return dump, nil
+}
+
+// internal reports whether a key belongs to the limiter's own bookkeeping
+func internal(key string) bool {
+	for _, prefix := range internalPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the dump as a gzipped tar archive, the manifest first
+func (d *Dump) Write(w io.Writer) error {
+	files := []struct {
+		name    string
+		records []strategy.Record
+	}{
+		{CountersFile, d.Counters},
+		{BlocksFile, d.Blocks},
+		{UsageFile, d.Usage},
+	}
+
+	contents := make(map[string][]byte, len(files)+1)
+	manifest := Manifest{Format: Format, SchemaVersion: SchemaVersion, CreatedAt: time.Now().UTC()}
+	for _, file := range files {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, record := range file.records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		contents[file.name] = buf.Bytes()
+		manifest.Files = append(manifest.Files, File{Name: file.name, Records: len(file.records)})
+	}
+
+	overrides, err := json.Marshal(d.Overrides)
+	if err != nil {
+		return err
+	}
+	contents[OverridesFile] = overrides
+	manifest.Files = append(manifest.Files, File{Name: OverridesFile, Records: len(d.Overrides.Grants)})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	d.Manifest = manifest
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	add := func(name string, content []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(content)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(content)
+		return err
+	}
+
+	if err := add(ManifestFile, data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", ManifestFile, err)
+	}
+	for _, file := range manifest.Files {
+		if err := add(file.Name, contents[file.Name]); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read parses a dump written by Write, checking it against its manifest
+func Read(r io.Reader) (*Dump, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("state dump is not gzipped: %w", err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state dump: %w", err)
+	}
+	if header.Name != ManifestFile {
+		return nil, fmt.Errorf("state dump must start with %s, found %s", ManifestFile, header.Name)
+	}
+
+	dump := &Dump{}
+	if err := json.NewDecoder(archive).Decode(&dump.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestFile, err)
+	}
+	if dump.Manifest.Format != Format {
+		return nil, fmt.Errorf("unknown state dump format %q", dump.Manifest.Format)
+	}
+	if dump.Manifest.SchemaVersion < 1 || dump.Manifest.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("unsupported state dump schema version %d, this version reads up to %d", dump.Manifest.SchemaVersion, SchemaVersion)
+	}
+
+	expected := make(map[string]int, len(dump.Manifest.Files))
+	for _, file := range dump.Manifest.Files {
+		expected[file.Name] = file.Records
+	}
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state dump: %w", err)
+		}
+
+		records, listed := expected[header.Name]
+		if !listed {
+			return nil, fmt.Errorf("%s is not listed in the manifest", header.Name)
+		}
+		delete(expected, header.Name)
+
+		var read int
+		switch header.Name {
+		case CountersFile:
+			dump.Counters, err = readRecords(archive)
+			read = len(dump.Counters)
+		case BlocksFile:
+			dump.Blocks, err = readRecords(archive)
+			read = len(dump.Blocks)
+		case UsageFile:
+			dump.Usage, err = readRecords(archive)
+			read = len(dump.Usage)
+		case OverridesFile:
+			err = json.NewDecoder(archive).Decode(&dump.Overrides)
+			read = len(dump.Overrides.Grants)
+		default:
+			// Files unknown to this version are listed but ignored
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", header.Name, err)
+		}
+		if read != records {
+			return nil, fmt.Errorf("%s has %d records, the manifest lists %d", header.Name, read, records)
+		}
+	}
+
+	for _, file := range dump.Manifest.Files {
+		if _, missing := expected[file.Name]; missing {
+			return nil, fmt.Errorf("%s is listed in the manifest but missing", file.Name)
+		}
+	}
+	return dump, nil
+}
+
+// readRecords decodes one record per line
+func readRecords(r io.Reader) ([]strategy.Record, error) {
+	var records []strategy.Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record strategy.Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		if record.Key == "" {
+			return nil, fmt.Errorf("record without key")
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Load stores the counters, blocks, usage rollups and grants of the dump,
+// replacing the existing ones. Records that expired since the dump was taken
+// are skipped. It returns the number of records stored; the policy is left to
+// the caller, which records it as a new version
+func (d *Dump) Load(ctx context.Context, storage strategy.MigratableStorage) (int, error) {
+	now := time.Now()
+	loaded := 0
+	for _, records := range [][]strategy.Record{d.Counters, d.Usage, d.Overrides.Grants, d.Blocks} {
+		for _, record := range records {
+			if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(now) {
+				continue
+			}
+			if err := storage.Import(ctx, record); err != nil {
+				return loaded, fmt.Errorf("failed to load %s: %w", record.Key, err)
+			}
+			loaded++
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,363 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestKeyFormat(t *testing.T) {
+	tests := []struct {
+		key         string
+		block       string
+		legacyBlock string
+		grant       string
+		legacyGrant string
+	}{
+		{"ip:203.0.113.7", "{ip:203.0.113.7}:blocked", "blocked:ip:203.0.113.7", "{ip:203.0.113.7}:grant", "grant:ip:203.0.113.7"},
+		{"ip:2001:db8::1", "{ip:2001:db8::1}:blocked", "blocked:ip:2001:db8::1", "{ip:2001:db8::1}:grant", "grant:ip:2001:db8::1"},
+		{"token:abc123", "{token:abc123}:blocked", "blocked:token:abc123", "{token:abc123}:grant", "grant:token:abc123"},
+		{"route:GET:/api/users/%7Bid%7D:ip:10.0.0.1", "{route:GET:/api/users/%7Bid%7D:ip:10.0.0.1}:blocked", "blocked:route:GET:/api/users/%7Bid%7D:ip:10.0.0.1", "{route:GET:/api/users/%7Bid%7D:ip:10.0.0.1}:grant", "grant:route:GET:/api/users/%7Bid%7D:ip:10.0.0.1"},
+		{"", "{}:blocked", "blocked:", "{}:grant", "grant:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := BlockKeyFor(tt.key); got != tt.block {
+				t.Errorf("BlockKeyFor = %q, want %q", got, tt.block)
+			}
+			if got := LegacyBlockKeyFor(tt.key); got != tt.legacyBlock {
+				t.Errorf("LegacyBlockKeyFor = %q, want %q", got, tt.legacyBlock)
+			}
+			if got := GrantKeyFor(tt.key); got != tt.grant {
+				t.Errorf("GrantKeyFor = %q, want %q", got, tt.grant)
+			}
+			if got := LegacyGrantKeyFor(tt.key); got != tt.legacyGrant {
+				t.Errorf("LegacyGrantKeyFor = %q, want %q", got, tt.legacyGrant)
+			}
+
+			// Block keys of both layouts lead back to the counter key
+			for _, blockKey := range []string{tt.block, tt.legacyBlock} {
+				if got, ok := CounterKeyOf(blockKey); !ok || got != tt.key {
+					t.Errorf("CounterKeyOf(%q) = %q, %t, want %q", blockKey, got, ok, tt.key)
+				}
+			}
+		})
+	}
+}
+
+func TestGetKeyWithPrefix(t *testing.T) {
+	tests := []struct {
+		prefix     string
+		identifier string
+		want       string
+	}{
+		{"ip", "203.0.113.7", "ip:203.0.113.7"},
+		{"route:GET:/api/users/{id}", "ip:10.0.0.1", "route:GET:/api/users/%7Bid%7D:ip:10.0.0.1"},
+		{"token", "ab{c}", "token:ab%7Bc%7D"},
+		// Keys already built are left as they are
+		{"rule:export", "token:ab%7Bc%7D", "rule:export:token:ab%7Bc%7D"},
+	}
+	for _, tt := range tests {
+		if got := GetKeyWithPrefix(tt.prefix, tt.identifier); got != tt.want {
+			t.Errorf("GetKeyWithPrefix(%q, %q) = %q, want %q", tt.prefix, tt.identifier, got, tt.want)
+		}
+	}
+}
+
+func TestCounterKeyOf(t *testing.T) {
+	tests := []struct {
+		blockKey string
+		want     string
+		ok       bool
+	}{
+		{"{ip:1.2.3.4}:blocked", "ip:1.2.3.4", true},
+		{"blocked:ip:1.2.3.4", "ip:1.2.3.4", true},
+		{"{ip:1.2.3.4}:grant", "", false},
+		{"grant:ip:1.2.3.4", "", false},
+		{"ip:1.2.3.4", "", false},
+		{"{ip:1.2.3.4}:dedup:42", "", false},
+		{"ip:1.2.3.4:blocked", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := CounterKeyOf(tt.blockKey)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("CounterKeyOf(%q) = %q, %t, want %q, %t", tt.blockKey, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBlockKeyPatterns(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"ip:", []string{"{ip:*}:blocked", "blocked:ip:*"}},
+		{"", []string{"{*}:blocked", "blocked:*"}},
+		// Glob characters of the prefix match literally
+		{"a*b?[c]:", []string{`{a\*b\?\[c\]:*}:blocked`, `blocked:a\*b\?\[c\]:*`}},
+	}
+	for _, tt := range tests {
+		if patterns := BlockKeyPatterns(tt.prefix); strings.Join(patterns, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("BlockKeyPatterns(%q) = %q, want %q", tt.prefix, patterns, tt.want)
+		}
+	}
+}
+
+// TestHashTagSlots checks that the keys of one counter, used together by the
+// check script, fall in the same Redis Cluster slot
+func TestHashTagSlots(t *testing.T) {
+	for _, key := range []string{
+		"ip:203.0.113.7",
+		"ip:2001:db8::1",
+		"token:abc123",
+		"method:ip:10.0.0.1:POST",
+		"rule:export:token:abc123",
+		GetKeyWithPrefix("route:GET:/api/users/{id}", "ip:10.0.0.1"),
+		GetKeyWithPrefix("route:GET:/api/users/{id}/orders/{order}", "token:abc123"),
+		GetKeyWithPrefix("rule:export", GetKeyWithPrefix("token", "ab{c}")),
+	} {
+		// A hash tag in the counter key would put every client of a route in one slot
+		if strings.ContainsAny(key, "{}") {
+			t.Errorf("counter key %q has braces", key)
+		}
+		slot := clusterSlot(key)
+		related := map[string]string{
+			"block": BlockKeyFor(key),
+			"grant": GrantKeyFor(key),
+			"dedup": dedupKeyFor(key, "0123456789abcdef"),
+		}
+		for name, related := range related {
+			if got := clusterSlot(related); got != slot {
+				t.Errorf("%s key %q is in slot %d, counter %q in slot %d", name, related, got, key, slot)
+			}
+		}
+	}
+}
+
+func TestClusterSlot(t *testing.T) {
+	// Reference values from the Redis Cluster specification and CLUSTER KEYSLOT
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		// Only the hash tag is hashed
+		{"{foo}.bar", 12182},
+		{"x{foo}{baz}", 12182},
+	}
+	for _, tt := range tests {
+		if got := clusterSlot(tt.key); got != tt.slot {
+			t.Errorf("clusterSlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+}
+
+// clusterSlot returns the Redis Cluster slot of a key: the CRC16 of its hash
+// tag, the part between the first "{" and the next "}" when not empty
+func clusterSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+// redisForTest connects to the Redis at REDIS_ADDR, skipping the test otherwise
+func redisForTest(t *testing.T) (*RedisStrategy, string) {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+
+	r := NewRedisStrategyWithOptions(&redis.Options{Addr: addr})
+	t.Cleanup(func() { r.Close() })
+	if err := r.Ping(context.Background()); err != nil {
+		t.Fatalf("failed to connect to Redis at %s: %v", addr, err)
+	}
+	// A fresh key per test, so earlier runs do not interfere
+	return r, fmt.Sprintf("ip:keys-test-%d", time.Now().UnixNano())
+}
+
+func TestRedisLegacyBlockFallback(t *testing.T) {
+	r, key := redisForTest(t)
+	ctx := context.Background()
+	t.Cleanup(func() { r.Delete(ctx, key) })
+
+	if err := r.client.Set(ctx, LegacyBlockKeyFor(key), "1", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads honor blocks of the legacy layout
+	blocked, until, err := r.IsBlocked(ctx, key)
+	if err != nil || !blocked {
+		t.Fatalf("IsBlocked = %t, %v, want blocked by the legacy key", blocked, err)
+	}
+	if ttl := time.Until(until); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("legacy block lasts %s, want at most 1m", ttl)
+	}
+	if blocks, err := r.ListBlocked(ctx, "ip"); err != nil || !containsKey(blocks, strings.TrimPrefix(key, "ip:")) {
+		t.Errorf("ListBlocked = %q, %v, want %q listed", blocks, err, key)
+	}
+
+	// A check moves the legacy block under the hash tag before counting
+	outcome, err := r.CheckAndIncrement(ctx, key, GrantKeyFor(key), 1, 10, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outcome.Blocked || outcome.Allowed {
+		t.Errorf("CheckAndIncrement = %+v, want blocked", outcome)
+	}
+	assertMoved(t, r, LegacyBlockKeyFor(key), BlockKeyFor(key))
+}
+
+func TestRedisMigrateLegacyKeys(t *testing.T) {
+	r, key := redisForTest(t)
+	ctx := context.Background()
+	blockedKey := key + "-blocked"
+	t.Cleanup(func() {
+		r.Delete(ctx, key)
+		r.Delete(ctx, blockedKey)
+		r.client.Del(ctx, GrantKeyFor(key), LegacyGrantKeyFor(key))
+	})
+
+	pipe := r.client.Pipeline()
+	pipe.Set(ctx, LegacyBlockKeyFor(blockedKey), "1", time.Minute)
+	pipe.Set(ctx, LegacyGrantKeyFor(key), "5", time.Hour)
+	// Units granted in the current layout since are kept
+	pipe.Set(ctx, GrantKeyFor(key), "3", time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := r.MigrateLegacyKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved < 2 {
+		t.Errorf("MigrateLegacyKeys moved %d keys, want at least 2", moved)
+	}
+	assertMoved(t, r, LegacyBlockKeyFor(blockedKey), BlockKeyFor(blockedKey))
+	assertMoved(t, r, LegacyGrantKeyFor(key), GrantKeyFor(key))
+
+	if units, err := r.client.Get(ctx, GrantKeyFor(key)).Int(); err != nil || units != 8 {
+		t.Errorf("granted %d units, %v, want the 8 units of both layouts", units, err)
+	}
+	// The balance lasts until the latest expiry
+	if ttl := r.client.PTTL(ctx, GrantKeyFor(key)).Val(); ttl <= time.Minute {
+		t.Errorf("grant expires in %s, want the hour of the legacy grant", ttl)
+	}
+
+	// Checks count the migrated grant
+	outcome, err := r.CheckAndIncrement(ctx, key, GrantKeyFor(key), 1, 1, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !outcome.Allowed || outcome.Limit != 9 {
+		t.Errorf("CheckAndIncrement = %+v, want allowed with limit 9", outcome)
+	}
+
+	// Once migrated, checks no longer look for legacy keys
+	if !r.legacyMigrated.Load() {
+		t.Error("legacy keys not marked as migrated")
+	}
+}
+
+func TestRedisScanBlockedByKind(t *testing.T) {
+	r, key := redisForTest(t)
+	ctx := context.Background()
+	tokenKey := "token:" + strings.TrimPrefix(key, "ip:")
+	legacyKey := key + "-legacy"
+	t.Cleanup(func() {
+		r.Delete(ctx, key)
+		r.Delete(ctx, tokenKey)
+		r.Delete(ctx, legacyKey)
+	})
+
+	pipe := r.client.Pipeline()
+	pipe.Set(ctx, BlockKeyFor(key), "1", time.Minute)
+	pipe.Set(ctx, BlockKeyFor(tokenKey), "1", time.Minute)
+	pipe.Set(ctx, LegacyBlockKeyFor(legacyKey), "1", time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(kind string) map[string]string {
+		found := make(map[string]string)
+		var cursor uint64
+		for {
+			blocks, next, err := r.ScanBlocked(ctx, kind, cursor, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, block := range blocks {
+				found[block.Key] = block.Kind
+			}
+			if cursor = next; cursor == 0 {
+				return found
+			}
+		}
+	}
+
+	// Both layouts of the kind are listed, and only them
+	ipBlocks := scan("ip")
+	for _, want := range []string{key, legacyKey} {
+		if ipBlocks[want] != "ip" {
+			t.Errorf("ScanBlocked(ip) did not list %q", want)
+		}
+	}
+	for blocked, kind := range ipBlocks {
+		if kind != "ip" {
+			t.Errorf("ScanBlocked(ip) listed %q of kind %q", blocked, kind)
+		}
+	}
+
+	all := scan("")
+	for _, want := range []string{key, tokenKey, legacyKey} {
+		if _, ok := all[want]; !ok {
+			t.Errorf("ScanBlocked() did not list %q", want)
+		}
+	}
+}
+
+// assertMoved checks that a legacy key was moved to its current name with its TTL
+func assertMoved(t *testing.T, r *RedisStrategy, legacyKey, key string) {
+	t.Helper()
+	ctx := context.Background()
+	if n := r.client.Exists(ctx, legacyKey).Val(); n != 0 {
+		t.Errorf("legacy key %q still exists", legacyKey)
+	}
+	if ttl := r.client.PTTL(ctx, key).Val(); ttl <= 0 {
+		t.Errorf("key %q has TTL %s, want the legacy TTL carried over", key, ttl)
+	}
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
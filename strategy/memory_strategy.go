@@ -0,0 +1,301 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepEvery is the number of writes between removals of expired entries
+const sweepEvery = 1024
+
+// memoryEntry is a value with its expiry, zero meaning no expiry
+type memoryEntry struct {
+	count     int
+	info      *RateLimitInfo
+	value     string
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryStrategy keeps counters and blocks in process memory. Limits are
+// enforced per instance only, which makes it suitable as a local fallback when
+// the distributed storage is unavailable, for tests and for single instances
+type MemoryStrategy struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	writes  int
+}
+
+// NewMemoryStrategy creates an empty in-memory storage. It is kept for
+// compatibility, new code should use strategy.NewMemory of the v2 API
+func NewMemoryStrategy() *MemoryStrategy {
+	return &MemoryStrategy{entries: make(map[string]*memoryEntry)}
+}
+
+// entry returns the live entry of a key, dropping it when expired
+func (m *MemoryStrategy) entry(key string, now time.Time) *memoryEntry {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(now) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+// store saves an entry and periodically sweeps expired ones
+func (m *MemoryStrategy) store(key string, e *memoryEntry, now time.Time) {
+	m.entries[key] = e
+
+	m.writes++
+	if m.writes%sweepEvery == 0 {
+		for k, existing := range m.entries {
+			if existing.expired(now) {
+				delete(m.entries, k)
+			}
+		}
+	}
+}
+
+// expiry returns the expiry time of a TTL, zero for none
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// Get retrieves rate limit information for a given key
+func (m *MemoryStrategy) Get(ctx context.Context, key string) (*RateLimitInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e := m.entry(key, now)
+	switch {
+	case e == nil:
+		return &RateLimitInfo{Count: 0, ResetTime: now.Add(time.Second)}, nil
+	case e.info != nil:
+		info := *e.info
+		return &info, nil
+	}
+
+	count := e.count
+	if e.value != "" {
+		count, _ = strconv.Atoi(e.value)
+	}
+	resetTime := e.expiresAt
+	if resetTime.IsZero() {
+		resetTime = now
+	}
+	return &RateLimitInfo{Count: count, ResetTime: resetTime}, nil
+}
+
+// Set stores rate limit information for a given key with expiration
+func (m *MemoryStrategy) Set(ctx context.Context, key string, info *RateLimitInfo, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	stored := *info
+	m.store(key, &memoryEntry{info: &stored, expiresAt: expiry(now, expiration)}, now)
+	return nil
+}
+
+// Increment increments the count for a given key by delta. The expiration
+// starts with the first increment, so windows are fixed
+func (m *MemoryStrategy) Increment(ctx context.Context, key string, delta int, expiration time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	e := m.entry(key, now)
+	if e == nil || e.info != nil {
+		e = &memoryEntry{expiresAt: expiry(now, expiration)}
+	}
+	e.count += delta
+	m.store(key, e, now)
+	return e.count, nil
+}
+
+// SetNX stores a value only if the key does not exist, reporting whether it was stored
+func (m *MemoryStrategy) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.entry(key, now) != nil {
+		return false, nil
+	}
+	m.store(key, &memoryEntry{value: value, expiresAt: expiry(now, expiration)}, now)
+	return true, nil
+}
+
+// SetBlocked sets a key as blocked until a specific time
+func (m *MemoryStrategy) SetBlocked(ctx context.Context, key string, blockUntil time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if !blockUntil.After(now) {
+		return nil
+	}
+	m.store(BlockKeyFor(key), &memoryEntry{value: "1", expiresAt: blockUntil}, now)
+	return nil
+}
+
+// IsBlocked checks if a key is currently blocked
+func (m *MemoryStrategy) IsBlocked(ctx context.Context, key string) (bool, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(BlockKeyFor(key), time.Now())
+	if e == nil {
+		return false, time.Time{}, nil
+	}
+	return true, e.expiresAt, nil
+}
+
+// Unblock removes the block of a key, keeping its counter
+func (m *MemoryStrategy) Unblock(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blocked := m.entry(BlockKeyFor(key), time.Now()) != nil
+	delete(m.entries, BlockKeyFor(key))
+	return blocked, nil
+}
+
+// Delete removes a key and its block from storage
+func (m *MemoryStrategy) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	delete(m.entries, BlockKeyFor(key))
+	return nil
+}
+
+// Close releases the stored entries
+func (m *MemoryStrategy) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = make(map[string]*memoryEntry)
+	return nil
+}
+
+// Export calls fn with every live counter and block
+func (m *MemoryStrategy) Export(ctx context.Context, fn func(Record) error) error {
+	m.mu.Lock()
+	now := time.Now()
+	var records []Record
+	for key, e := range m.entries {
+		if e.expired(now) || e.info != nil {
+			continue
+		}
+
+		record := Record{Key: key, Count: e.count, ExpiresAt: e.expiresAt}
+		if counterKey, ok := CounterKeyOf(key); ok {
+			record.Key, record.Count, record.Blocked = counterKey, 0, true
+		} else if e.value != "" {
+			count, err := strconv.Atoi(e.value)
+			if err != nil {
+				continue
+			}
+			record.Count = count
+		}
+		records = append(records, record)
+	}
+	m.mu.Unlock()
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import stores a counter or block, replacing the existing one
+func (m *MemoryStrategy) Import(ctx context.Context, record Record) error {
+	if record.Blocked {
+		return m.SetBlocked(ctx, record.Key, record.ExpiresAt)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.store(record.Key, &memoryEntry{count: record.Count, expiresAt: record.ExpiresAt}, time.Now())
+	return nil
+}
+
+// memorySnapshot is the file format of SaveSnapshot
+type memorySnapshot struct {
+	TakenAt time.Time `json:"taken_at"`
+	Records []Record  `json:"records"`
+}
+
+// SaveSnapshot writes the counters and blocks to a file, replacing it atomically
+func (m *MemoryStrategy) SaveSnapshot(path string) error {
+	snapshot := memorySnapshot{TakenAt: time.Now(), Records: []Record{}}
+	m.Export(context.Background(), func(record Record) error {
+		snapshot.Records = append(snapshot.Records, record)
+		return nil
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot imports the counters and blocks saved by SaveSnapshot that have
+// not expired since
+func (m *MemoryStrategy) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+
+	loaded := 0
+	for _, record := range snapshot.Records {
+		if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		m.Import(context.Background(), record)
+		loaded++
+	}
+	return loaded, nil
+}
@@ -0,0 +1,75 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Record is a counter or block copied between storages
+type Record struct {
+	// Key is the counter key, also for blocks
+	Key string `json:"key"`
+	// Count is the counter value, unused for blocks
+	Count int `json:"count,omitempty"`
+	// Blocked marks the record as the block of Key rather than its counter
+	Blocked bool `json:"blocked,omitempty"`
+	// ExpiresAt is when the counter window or block ends, zero if it never expires
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// MigratableStorage is implemented by storages whose counters and blocks can
+// be copied to another storage
+type MigratableStorage interface {
+	// Export calls fn with every live counter and block
+	Export(ctx context.Context, fn func(Record) error) error
+	// Import stores a counter or block, replacing the existing one
+	Import(ctx context.Context, record Record) error
+}
+
+// Copy copies every counter and block of from into to, at most perSecond
+// records per second (0 for no limit) so that a live storage is not
+// overwhelmed. Records expiring during the copy are skipped. It returns the
+// number of records copied
+func Copy(ctx context.Context, from, to MigratableStorage, perSecond int) (int, error) {
+	var interval time.Duration
+	if perSecond > 0 {
+		interval = time.Second / time.Duration(perSecond)
+	}
+
+	copied := 0
+	next := time.Now()
+	err := from.Export(ctx, func(record Record) error {
+		if interval > 0 {
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			next = next.Add(interval)
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+		}
+
+		if !record.ExpiresAt.IsZero() && !record.ExpiresAt.After(time.Now()) {
+			return nil
+		}
+		if err := to.Import(ctx, record); err != nil {
+			return fmt.Errorf("failed to import %s: %w", record.Key, err)
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
+
+// ttlUntil returns the expiration of a record, 0 for none
+func ttlUntil(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(expiresAt), time.Millisecond)
+}
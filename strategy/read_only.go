@@ -0,0 +1,67 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrReadOnly is returned by the writes of a ReadOnlyStrategy
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ReadOnlyStrategy serves the reads of a storage and rejects every write, for
+// instances pointed at a replica in disaster recovery regions or consumed by
+// analytics, which must never change the shared state
+type ReadOnlyStrategy struct {
+	storage StorageStrategy
+}
+
+// NewReadOnlyStrategy wraps a storage so it can only be read
+func NewReadOnlyStrategy(storage StorageStrategy) *ReadOnlyStrategy {
+	return &ReadOnlyStrategy{storage: storage}
+}
+
+// Get retrieves rate limit information for a given key
+func (s *ReadOnlyStrategy) Get(ctx context.Context, key string) (*RateLimitInfo, error) {
+	return s.storage.Get(ctx, key)
+}
+
+// Set fails with ErrReadOnly
+func (s *ReadOnlyStrategy) Set(ctx context.Context, key string, info *RateLimitInfo, expiration time.Duration) error {
+	return ErrReadOnly
+}
+
+// Increment fails with ErrReadOnly
+func (s *ReadOnlyStrategy) Increment(ctx context.Context, key string, delta int, expiration time.Duration) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// SetNX fails with ErrReadOnly
+func (s *ReadOnlyStrategy) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// SetBlocked fails with ErrReadOnly
+func (s *ReadOnlyStrategy) SetBlocked(ctx context.Context, key string, blockUntil time.Time) error {
+	return ErrReadOnly
+}
+
+// IsBlocked checks if a key is currently blocked
+func (s *ReadOnlyStrategy) IsBlocked(ctx context.Context, key string) (bool, time.Time, error) {
+	return s.storage.IsBlocked(ctx, key)
+}
+
+// Unblock fails with ErrReadOnly
+func (s *ReadOnlyStrategy) Unblock(ctx context.Context, key string) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Delete fails with ErrReadOnly
+func (s *ReadOnlyStrategy) Delete(ctx context.Context, key string) error {
+	return ErrReadOnly
+}
+
+// Close closes the wrapped storage
+func (s *ReadOnlyStrategy) Close() error {
+	return s.storage.Close()
+}
@@ -0,0 +1,249 @@
+package strategy
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// batchedCounter is the local state of a fixed window counter in write-behind
+// mode: the count read from Redis at the last flush, other instances
+// included, plus the increments not flushed yet
+type batchedCounter struct {
+	grantKey     string
+	window       time.Duration
+	remote       int
+	pending      int
+	grant        int
+	resetTime    time.Time
+	blockedUntil time.Time
+}
+
+// batcher accumulates fixed window increments in memory and writes them to
+// Redis in one pipeline every interval
+type batcher struct {
+	interval time.Duration
+
+	mu       sync.Mutex
+	counters map[string]*batchedCounter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// EnableBatching switches fixed window checks to write-behind: requests are
+// decided against the local view of each counter, and increments are flushed
+// every interval, e.g. 10ms. Instances may admit up to one interval of
+// requests over the limit between them; pending increments are flushed on Close
+func (r *RedisStrategy) EnableBatching(interval time.Duration) {
+	r.batch = &batcher{
+		interval: interval,
+		counters: make(map[string]*batchedCounter),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go r.runBatch()
+}
+
+// checkBatched decides a fixed window check against the local view of the
+// counter, counting delta for the next flush. Only new blocks reach Redis
+// right away, so that other instances see them at their next flush
+func (r *RedisStrategy) checkBatched(ctx context.Context, key, grantKey string, delta, limit int, window, blockFor time.Duration) (*CheckOutcome, error) {
+	b := r.batch
+	now := time.Now()
+
+	b.mu.Lock()
+	c := b.counters[key]
+	if c == nil {
+		c = &batchedCounter{grantKey: grantKey, window: window, resetTime: now.Add(window)}
+		b.counters[key] = c
+	} else if !now.Before(c.resetTime) {
+		// Increments of an ended window would count against the next one
+		c.remote, c.pending, c.resetTime = 0, 0, now.Add(window)
+	}
+
+	if now.Before(c.blockedUntil) {
+		outcome := &CheckOutcome{Limit: limit + c.grant, Blocked: true, ResetTime: c.blockedUntil}
+		b.mu.Unlock()
+		return outcome, nil
+	}
+
+	c.pending += delta
+	outcome := &CheckOutcome{
+		Count:     c.remote + c.pending,
+		Limit:     limit + c.grant,
+		ResetTime: c.resetTime,
+	}
+	switch {
+	case outcome.Count <= outcome.Limit:
+		outcome.Allowed = true
+	case blockFor > 0:
+		c.blockedUntil = now.Add(blockFor)
+		outcome.Blocked, outcome.ResetTime = true, c.blockedUntil
+	}
+	b.mu.Unlock()
+
+	if outcome.Blocked {
+		if err := r.SetBlocked(ctx, key, outcome.ResetTime); err != nil {
+			return nil, err
+		}
+	}
+	return outcome, nil
+}
+
+// batchRead holds the replies read for a counter during a flush. Counters
+// with no pending increments, only blocked, are not written
+type batchRead struct {
+	key, grantKey string
+	window        time.Duration
+	delta         int
+	// blockedUntil is the local block when the flush started
+	blockedUntil time.Time
+
+	count     *redis.IntCmd
+	ttl       *redis.DurationCmd
+	grant     *redis.StringCmd
+	blockTTL  *redis.DurationCmd
+	legacyTTL *redis.DurationCmd
+}
+
+// runBatch flushes the pending increments every interval until the storage is closed
+func (r *RedisStrategy) runBatch() {
+	defer close(r.batch.done)
+
+	ticker := time.NewTicker(r.batch.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.batch.stop:
+			r.flushBatch()
+			return
+		}
+		r.flushBatch()
+	}
+}
+
+// flushBatch writes the pending increments in one pipeline and refreshes the
+// local view of the counters written or blocked: their count, window, grants
+// and blocks. Increments of a failed flush are kept for the next one
+func (r *RedisStrategy) flushBatch() {
+	b := r.batch
+	now := time.Now()
+
+	var reads []*batchRead
+	b.mu.Lock()
+	for key, c := range b.counters {
+		blocked := now.Before(c.blockedUntil)
+		if c.pending == 0 && !blocked {
+			// Idle counters are forgotten once their window ends
+			if !now.Before(c.resetTime) {
+				delete(b.counters, key)
+			}
+			continue
+		}
+		reads = append(reads, &batchRead{key: key, grantKey: c.grantKey, window: c.window, delta: c.pending, blockedUntil: c.blockedUntil})
+		c.pending = 0
+	}
+	b.mu.Unlock()
+
+	if len(reads) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := r.client.Pipeline()
+	for _, read := range reads {
+		if read.delta > 0 {
+			read.count = pipe.IncrBy(ctx, read.key, int64(read.delta))
+			pipe.ExpireNX(ctx, read.key, read.window)
+			read.ttl = pipe.PTTL(ctx, read.key)
+		}
+		read.grant = pipe.Get(ctx, read.grantKey)
+		read.blockTTL = pipe.PTTL(ctx, BlockKeyFor(read.key))
+		read.legacyTTL = pipe.PTTL(ctx, LegacyBlockKeyFor(read.key))
+	}
+	// Missing grants fail individually, the other replies are checked below
+	pipe.Exec(ctx)
+
+	flushed, failed := 0, 0
+	var flushErr error
+	now = time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, read := range reads {
+		// Counters reset through this instance meanwhile are dropped
+		c := b.counters[read.key]
+		if c == nil {
+			continue
+		}
+
+		if read.count != nil {
+			if err := read.count.Err(); err != nil {
+				c.pending += read.delta
+				failed, flushErr = failed+1, err
+				continue
+			}
+			flushed += read.delta
+			c.remote = int(read.count.Val())
+			if ttl := read.ttl.Val(); ttl > 0 {
+				c.resetTime = now.Add(ttl)
+			}
+		}
+		if read.blockTTL.Err() != nil {
+			continue
+		}
+
+		c.grant, _ = strconv.Atoi(read.grant.Val())
+		c.grant = max(c.grant, 0)
+		// Blocks lifted elsewhere are lifted here too, unless set meanwhile
+		if ttl := max(read.blockTTL.Val(), read.legacyTTL.Val()); ttl > 0 {
+			c.blockedUntil = now.Add(ttl)
+		} else if c.blockedUntil.Equal(read.blockedUntil) {
+			c.blockedUntil = time.Time{}
+		}
+	}
+
+	metrics.BatchedIncrements.Add(float64(flushed))
+	if failed > 0 {
+		metrics.BatchFlushes.WithLabelValues("error").Inc()
+		slog.Warn("Failed to flush batched increments", "keys", failed, "error", flushErr)
+		return
+	}
+	metrics.BatchFlushes.WithLabelValues("ok").Inc()
+}
+
+// forgetBatched drops the local view of a counter reset through this instance,
+// or only its block when unblocked
+func (r *RedisStrategy) forgetBatched(key string, blockOnly bool) {
+	if r.batch == nil {
+		return
+	}
+
+	r.batch.mu.Lock()
+	defer r.batch.mu.Unlock()
+	if c, ok := r.batch.counters[key]; ok && blockOnly {
+		c.blockedUntil = time.Time{}
+	} else {
+		delete(r.batch.counters, key)
+	}
+}
+
+// closeBatch stops the flushes, writing the pending increments
+func (r *RedisStrategy) closeBatch() {
+	if r.batch == nil {
+		return
+	}
+	close(r.batch.stop)
+	<-r.batch.done
+}
@@ -0,0 +1,157 @@
+package strategy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// cachedBlock is the block status of a key as last read from Redis
+type cachedBlock struct {
+	blocked   bool
+	until     time.Time
+	expiresAt time.Time
+}
+
+// cachedCounter is a fixed window counter found at its limit
+type cachedCounter struct {
+	outcome   CheckOutcome
+	limit     int
+	expiresAt time.Time
+}
+
+// localCache keeps the block status of keys and the counters at their limit
+// in process memory for a short TTL, answering hot keys without a round trip.
+// Other instances' blocks and unblocks are seen once the entries expire
+type localCache struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	blocks   map[string]cachedBlock
+	counters map[string]cachedCounter
+	writes   int
+}
+
+// EnableLocalCache caches block status and counters at their limit for ttl,
+// e.g. 50ms to 100ms. Decisions may lag the other instances by up to ttl
+func (r *RedisStrategy) EnableLocalCache(ttl time.Duration) {
+	r.cache = &localCache{
+		ttl:      ttl,
+		blocks:   make(map[string]cachedBlock),
+		counters: make(map[string]cachedCounter),
+	}
+}
+
+// block returns the cached block status of a key
+func (c *localCache) block(key string) (bool, time.Time, bool) {
+	if c == nil {
+		return false, time.Time{}, false
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.blocks[key]
+	c.mu.Unlock()
+	ok = ok && now.Before(entry.expiresAt) && (!entry.blocked || now.Before(entry.until))
+	c.record("block", ok)
+	return entry.blocked, entry.until, ok
+}
+
+// storeBlock caches the block status of a key, no longer than the block lasts
+func (c *localCache) storeBlock(key string, blocked bool, until time.Time) {
+	if c == nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(c.ttl)
+	if blocked && until.Before(expiresAt) {
+		expiresAt = until
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks[key] = cachedBlock{blocked: blocked, until: until, expiresAt: expiresAt}
+	c.sweep()
+}
+
+// counter returns the cached outcome of a key at its limit, for the same limit
+func (c *localCache) counter(key string, limit int) (*CheckOutcome, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.counters[key]
+	c.mu.Unlock()
+	ok = ok && entry.limit == limit && time.Now().Before(entry.expiresAt)
+	c.record("counter", ok)
+	if !ok {
+		return nil, false
+	}
+	outcome := entry.outcome
+	return &outcome, true
+}
+
+// storeOutcome caches a fixed window outcome: blocks as the block status of the
+// key, and denials without a block as a counter at its limit until the window ends
+func (c *localCache) storeOutcome(key string, limit int, outcome *CheckOutcome) {
+	if c == nil || outcome.Allowed {
+		return
+	}
+	if outcome.Blocked {
+		c.storeBlock(key, true, outcome.ResetTime)
+		return
+	}
+
+	expiresAt := time.Now().Add(c.ttl)
+	if outcome.ResetTime.Before(expiresAt) {
+		expiresAt = outcome.ResetTime
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters[key] = cachedCounter{outcome: *outcome, limit: limit, expiresAt: expiresAt}
+	c.sweep()
+}
+
+// forget drops the cached state of a key changed through this instance
+func (c *localCache) forget(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.blocks, key)
+	delete(c.counters, key)
+}
+
+// sweep periodically removes expired entries. The caller holds c.mu
+func (c *localCache) sweep() {
+	c.writes++
+	if c.writes%sweepEvery != 0 {
+		return
+	}
+
+	now := time.Now()
+	for key, entry := range c.blocks {
+		if !now.Before(entry.expiresAt) {
+			delete(c.blocks, key)
+		}
+	}
+	for key, entry := range c.counters {
+		if !now.Before(entry.expiresAt) {
+			delete(c.counters, key)
+		}
+	}
+}
+
+// record counts a cache lookup
+func (c *localCache) record(kind string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.LocalCacheLookups.WithLabelValues(kind, result).Inc()
+}
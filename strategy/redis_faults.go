@@ -0,0 +1,117 @@
+package strategy
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/sampling"
+)
+
+// injectedError fails the commands of a simulated outage. It is a temporary
+// network error, so retries and failure modes handle it like a real one
+type injectedError struct{}
+
+func (injectedError) Error() string   { return "injected fault: redis unavailable" }
+func (injectedError) Timeout() bool   { return false }
+func (injectedError) Temporary() bool { return true }
+
+// ErrInjectedFault is returned by the Redis commands failed by a FaultInjector
+var ErrInjectedFault error = injectedError{}
+
+// Fault is a simulated Redis degradation
+type Fault struct {
+	// Latency delays every command and pipeline
+	Latency time.Duration `json:"latency"`
+	// ErrorRate is the fraction of commands failed with ErrInjectedFault, 1
+	// for a full outage
+	ErrorRate float64 `json:"error_rate"`
+	// Until ends the fault, so a forgotten game day heals by itself
+	Until time.Time `json:"until"`
+}
+
+// active reports whether the fault is in effect at now
+func (f *Fault) active(now time.Time) bool {
+	return f != nil && now.Before(f.Until)
+}
+
+// FaultInjector degrades the Redis commands of a strategy at runtime, for game
+// days verifying the failure modes and alerting without touching the real
+// infrastructure
+type FaultInjector struct {
+	fault atomic.Pointer[Fault]
+}
+
+// EnableFaultInjection hooks a fault injector into the Redis client. It must
+// be called before the strategy is used; no fault is active until Inject
+func (r *RedisStrategy) EnableFaultInjection() *FaultInjector {
+	r.faults = &FaultInjector{}
+	r.client.AddHook(r.faults)
+	return r.faults
+}
+
+// Faults returns the fault injector, nil when fault injection is disabled
+func (r *RedisStrategy) Faults() *FaultInjector {
+	return r.faults
+}
+
+// Inject replaces the active fault
+func (f *FaultInjector) Inject(fault Fault) {
+	f.fault.Store(&fault)
+}
+
+// Clear ends the active fault, reporting whether one was in effect
+func (f *FaultInjector) Clear() bool {
+	return f.fault.Swap(nil).active(time.Now())
+}
+
+// Active returns the fault in effect, if any
+func (f *FaultInjector) Active() (Fault, bool) {
+	fault := f.fault.Load()
+	if !fault.active(time.Now()) {
+		return Fault{}, false
+	}
+	return *fault, true
+}
+
+// apply delays and possibly fails a command under the active fault
+func (f *FaultInjector) apply(ctx context.Context) error {
+	fault, ok := f.Active()
+	if !ok {
+		return nil
+	}
+
+	if fault.Latency > 0 {
+		metrics.InjectedFaults.WithLabelValues("latency").Inc()
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if sampling.Sample(fault.ErrorRate) {
+		metrics.InjectedFaults.WithLabelValues("error").Inc()
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+func (f *FaultInjector) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, f.apply(ctx)
+}
+
+func (f *FaultInjector) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (f *FaultInjector) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, f.apply(ctx)
+}
+
+func (f *FaultInjector) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
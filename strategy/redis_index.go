@@ -0,0 +1,239 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// indexPrefix prefixes the sorted set indexing the active keys of a kind
+const indexPrefix = "index:"
+
+// keyIndex buffers the keys touched since the last flush. Each indexed key is
+// a member of the sorted set of its kind, scored by the time it stays listed
+type keyIndex struct {
+	kinds    map[string]bool
+	ttl      time.Duration
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// ActiveKey is a key listed in the index of its kind
+type ActiveKey struct {
+	// Key is the counter key, e.g. "ip:203.0.113.7"
+	Key string `json:"key"`
+	// Count is the fixed window counter, 0 for other algorithms or expired windows
+	Count int `json:"count"`
+	// BlockTTL is how long the key remains blocked, 0 when not blocked
+	BlockTTL time.Duration `json:"block_ttl,omitempty"`
+	// ListedUntil is when the key leaves the index without further activity
+	ListedUntil time.Time `json:"listed_until"`
+}
+
+// EnableKeyIndex keeps an index of the keys of the given kinds, e.g. "ip" and
+// "token", touched by the rate limiter. Touches are buffered and written every
+// interval in one pipeline; keys without activity for ttl are pruned
+func (r *RedisStrategy) EnableKeyIndex(kinds []string, ttl, interval time.Duration) {
+	index := &keyIndex{
+		kinds:    make(map[string]bool, len(kinds)),
+		ttl:      ttl,
+		interval: interval,
+		pending:  make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, kind := range kinds {
+		index.kinds[kind] = true
+	}
+	r.index = index
+
+	go r.runIndex()
+}
+
+// Indexed reports whether the keys of a kind are indexed
+func (r *RedisStrategy) Indexed(kind string) bool {
+	return r.index != nil && r.index.kinds[kind]
+}
+
+// Touch implements KeyIndexStorage. Keys of kinds that are not indexed are ignored
+func (r *RedisStrategy) Touch(key string, until time.Time) {
+	kind, _, _ := strings.Cut(key, ":")
+	if !r.Indexed(kind) {
+		return
+	}
+
+	index := r.index
+	if listed := time.Now().Add(index.ttl); listed.After(until) {
+		until = listed
+	}
+
+	index.mu.Lock()
+	if until.After(index.pending[key]) {
+		index.pending[key] = until
+	}
+	index.mu.Unlock()
+}
+
+// runIndex flushes the buffered touches every interval until the storage is closed
+func (r *RedisStrategy) runIndex() {
+	defer close(r.index.done)
+
+	ticker := time.NewTicker(r.index.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.index.stop:
+			r.flushIndex()
+			return
+		}
+		r.flushIndex()
+	}
+}
+
+// indexScript adds touched keys to an index, never lowering their score, prunes
+// the keys no longer listed and expires the index with its last key.
+// KEYS[1] is the index, ARGV[1] the current time in ms, followed by score and
+// key pairs
+var indexScript = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	redis.call('ZADD', KEYS[1], 'GT', ARGV[i], ARGV[i + 1])
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if last[2] then
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+end
+return 1
+`)
+
+// flushIndex writes the buffered touches and prunes the keys no longer listed.
+// Nothing is written without touches, as on read-only instances
+func (r *RedisStrategy) flushIndex() {
+	index := r.index
+	index.mu.Lock()
+	pending := index.pending
+	index.pending = make(map[string]time.Time)
+	index.mu.Unlock()
+
+	// Listings skip keys no longer listed, so pruning waits for the next touches
+	if len(pending) == 0 {
+		return
+	}
+
+	args := make(map[string][]interface{})
+	now := time.Now().UnixMilli()
+	for key, until := range pending {
+		kind, _, _ := strings.Cut(key, ":")
+		if args[kind] == nil {
+			args[kind] = []interface{}{now}
+		}
+		args[kind] = append(args[kind], until.UnixMilli(), key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for kind, kindArgs := range args {
+		if err := indexScript.Run(ctx, r.client, []string{indexPrefix + kind}, kindArgs...).Err(); err != nil {
+			slog.Warn("Failed to update the key index", "kind", kind, "keys", len(kindArgs)/2, "error", err)
+		}
+	}
+}
+
+// closeIndex stops the index, writing the buffered touches
+func (r *RedisStrategy) closeIndex() {
+	if r.index == nil {
+		return
+	}
+	close(r.index.stop)
+	<-r.index.done
+}
+
+// ActiveKeys returns one page of the indexed keys of a kind, those listed the
+// longest first, with their counters and blocks
+func (r *RedisStrategy) ActiveKeys(ctx context.Context, kind string, offset, count int) ([]ActiveKey, error) {
+	if !r.Indexed(kind) {
+		return nil, fmt.Errorf("keys of kind %q are not indexed", kind)
+	}
+
+	listed, err := r.client.ZRevRangeByScoreWithScores(ctx, indexPrefix+kind, &redis.ZRangeBy{
+		Min:    strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max:    "+inf",
+		Offset: int64(offset),
+		Count:  int64(count),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s key index: %w", kind, err)
+	}
+
+	pipe := r.client.Pipeline()
+	counts := make([]*redis.StringCmd, len(listed))
+	blockTTLs := make([]*redis.DurationCmd, len(listed))
+	legacyTTLs := make([]*redis.DurationCmd, len(listed))
+	for i, z := range listed {
+		key, _ := z.Member.(string)
+		counts[i] = pipe.Get(ctx, key)
+		blockTTLs[i] = pipe.PTTL(ctx, BlockKeyFor(key))
+		legacyTTLs[i] = pipe.PTTL(ctx, LegacyBlockKeyFor(key))
+	}
+	if len(listed) > 0 {
+		// Missing counters and keys of other types fail individually
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil && !isWrongType(err) {
+			return nil, fmt.Errorf("failed to read indexed keys: %w", err)
+		}
+	}
+
+	active := make([]ActiveKey, len(listed))
+	for i, z := range listed {
+		key, _ := z.Member.(string)
+		count, _ := strconv.Atoi(counts[i].Val())
+		active[i] = ActiveKey{
+			Key:         key,
+			Count:       count,
+			BlockTTL:    max(blockTTLs[i].Val(), legacyTTLs[i].Val(), 0),
+			ListedUntil: time.UnixMilli(int64(z.Score)),
+		}
+	}
+	return active, nil
+}
+
+// IndexedBlocks returns the blocked keys of an indexed kind found in one page
+// of its index, starting at offset. The listing is complete when the returned
+// offset is 0
+func (r *RedisStrategy) IndexedBlocks(ctx context.Context, kind string, offset, count int) ([]Block, int, error) {
+	active, err := r.ActiveKeys(ctx, kind, offset, count)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var blocks []Block
+	for _, key := range active {
+		if key.BlockTTL > 0 {
+			blocks = append(blocks, Block{Key: key.Key, Kind: kind, TTL: key.BlockTTL})
+		}
+	}
+
+	next := 0
+	if len(active) == count {
+		next = offset + count
+	}
+	return blocks, next, nil
+}
+
+// isWrongType reports a command run against a key of another type, such as a
+// GET of a sliding log
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
@@ -0,0 +1,132 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// isDedupKey reports whether a key remembers the result of a retried operation
+func isDedupKey(key string) bool {
+	return strings.HasPrefix(key, "dedup:") || strings.Contains(key, "}:dedup:")
+}
+
+// migrateLegacy moves the legacy block and grant keys of a counter key under
+// its hash tag, until MigrateLegacyKeys found none left
+func (r *RedisStrategy) migrateLegacy(ctx context.Context, key string) error {
+	if r.legacyMigrated.Load() {
+		return nil
+	}
+	if _, err := r.moveLegacy(ctx, key); err != nil {
+		return fmt.Errorf("failed to migrate legacy keys of %s: %w", key, err)
+	}
+	return nil
+}
+
+// moveLegacy moves the "blocked:<key>" and "grant:<key>" keys of a counter key
+// to BlockKeyFor and GrantKeyFor, keeping their TTL. A block already stored in
+// the current layout wins, while grants in both layouts add up. It returns the
+// number of keys moved
+func (r *RedisStrategy) moveLegacy(ctx context.Context, key string) (int, error) {
+	legacyKeys := []string{LegacyBlockKeyFor(key), LegacyGrantKeyFor(key)}
+
+	pipe := r.client.Pipeline()
+	values := make([]*redis.StringCmd, len(legacyKeys))
+	ttls := make([]*redis.DurationCmd, len(legacyKeys))
+	for i, legacyKey := range legacyKeys {
+		values[i] = pipe.Get(ctx, legacyKey)
+		ttls[i] = pipe.PTTL(ctx, legacyKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	moved := 0
+	for i, legacyKey := range legacyKeys {
+		value, err := values[i].Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		// PTTL replies -2 for a key expired since the read and -1 for a key
+		// without expiry, which is kept without one
+		ttl := ttls[i].Val()
+		if ttl == -2 {
+			continue
+		}
+		ttl = max(ttl, 0)
+
+		if i == 0 {
+			if err := r.client.SetNX(ctx, BlockKeyFor(key), value, ttl).Err(); err != nil {
+				return moved, err
+			}
+		} else if err := r.moveGrant(ctx, GrantKeyFor(key), value, ttl); err != nil {
+			return moved, err
+		}
+		if err := r.client.Del(ctx, legacyKey).Err(); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// moveGrant stores legacy granted units under the current grant key, adding
+// them to the units granted there since. As with Grant, the balance lasts until
+// the latest expiry
+func (r *RedisStrategy) moveGrant(ctx context.Context, grantKey, value string, ttl time.Duration) error {
+	units, err := strconv.Atoi(value)
+	if err != nil || units <= 0 {
+		// The check script counts malformed grants as 0 units too
+		return nil
+	}
+
+	stored, err := r.client.SetNX(ctx, grantKey, units, ttl).Result()
+	if err != nil || stored {
+		return err
+	}
+	if err := r.client.IncrBy(ctx, grantKey, int64(units)).Err(); err != nil {
+		return err
+	}
+
+	current, err := r.client.PTTL(ctx, grantKey).Result()
+	switch {
+	case err != nil || current <= 0:
+		return err
+	case ttl == 0:
+		return r.client.Persist(ctx, grantKey).Err()
+	case ttl > current:
+		return r.client.PExpire(ctx, grantKey, ttl).Err()
+	}
+	return nil
+}
+
+// MigrateLegacyKeys moves every block and grant key of the legacy layouts
+// ("blocked:<key>", "grant:<key>") under the hash tag of its counter key, see
+// BlockKeyFor and GrantKeyFor. Until it completes, each check first moves the
+// legacy keys of the key it counts. It returns the number of keys moved
+func (r *RedisStrategy) MigrateLegacyKeys(ctx context.Context) (int, error) {
+	moved := 0
+	for _, prefix := range []string{legacyBlockPrefix, legacyGrantPrefix} {
+		iter := r.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			n, err := r.moveLegacy(ctx, strings.TrimPrefix(iter.Val(), prefix))
+			moved += n
+			if err != nil {
+				return moved, fmt.Errorf("failed to migrate %s: %w", iter.Val(), err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return moved, fmt.Errorf("failed to scan legacy keys: %w", err)
+		}
+	}
+
+	r.legacyMigrated.Store(true)
+	return moved, nil
+}
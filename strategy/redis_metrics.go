@@ -0,0 +1,218 @@
+package strategy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type commandStartKey struct{}
+
+// metricsHook observes the latency of every Redis command and pipeline
+type metricsHook struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, commandStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeCommand(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, commandStartKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+			break
+		}
+	}
+	observeCommand(ctx, "pipeline", err)
+	return nil
+}
+
+// observeCommand records the latency of a command started in BeforeProcess.
+// Missing keys are not errors
+func observeCommand(ctx context.Context, command string, err error) {
+	start, ok := ctx.Value(commandStartKey{}).(time.Time)
+	if !ok {
+		return
+	}
+
+	result := "ok"
+	if err != nil && err != redis.Nil {
+		result = "error"
+	}
+	metrics.StorageLatency.WithLabelValues(command, result).Observe(time.Since(start).Seconds())
+}
+
+// activeBlocksDesc describes the blocked keys gauge reported by BlockCollector
+var activeBlocksDesc = prometheus.NewDesc(
+	"ratelimiter_active_blocks",
+	"Number of keys currently blocked by key type.",
+	[]string{"kind"}, nil,
+)
+
+// blockTTLDesc describes the remaining block time histogram reported by BlockCollector
+var blockTTLDesc = prometheus.NewDesc(
+	"ratelimiter_block_remaining_seconds",
+	"Remaining time of the keys currently blocked by key type.",
+	[]string{"kind"}, nil,
+)
+
+// blockTTLBuckets are the upper bounds in seconds of the remaining block time histogram
+var blockTTLBuckets = []float64{1, 10, 30, 60, 300, 600, 1800, 3600, 21600, 86400}
+
+// pendingBlock is a block set by this instance that has not ended yet
+type pendingBlock struct {
+	kind, reason string
+	until        time.Time
+}
+
+// BlockCollector reports the number of keys currently blocked per key type and
+// the distribution of their remaining block time, counted with SCAN or from
+// the key index when metrics are scraped. As an events sink it also counts the blocks this
+// instance sets and, at each scrape, those of them that expired
+type BlockCollector struct {
+	storage *RedisStrategy
+	kinds   []string
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]pendingBlock
+}
+
+// NewBlockCollector creates a collector counting the blocked keys of each kind, e.g. "ip" and "token"
+func NewBlockCollector(storage *RedisStrategy, kinds ...string) *BlockCollector {
+	return &BlockCollector{
+		storage: storage,
+		kinds:   kinds,
+		timeout: 5 * time.Second,
+		pending: make(map[string]pendingBlock),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *BlockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeBlocksDesc
+	ch <- blockTTLDesc
+}
+
+// Collect implements prometheus.Collector. When the scan fails the block
+// gauges are left out of the scrape
+func (c *BlockCollector) Collect(ch chan<- prometheus.Metric) {
+	c.sweep(time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	ttls, err := c.blocks(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, kind := range c.kinds {
+		var count uint64
+		var sum float64
+		buckets := make(map[float64]uint64, len(blockTTLBuckets))
+		for key, ttl := range ttls {
+			if !strings.HasPrefix(key, kind+":") {
+				continue
+			}
+			count++
+			sum += ttl.Seconds()
+			for _, bound := range blockTTLBuckets {
+				if ttl.Seconds() <= bound {
+					buckets[bound]++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(activeBlocksDesc, prometheus.GaugeValue, float64(count), kind)
+		ch <- prometheus.MustNewConstHistogram(blockTTLDesc, count, sum, buckets, kind)
+	}
+}
+
+// blocks returns the remaining time of every blocked key, read from the key
+// index when all the kinds are indexed and with SCAN otherwise
+func (c *BlockCollector) blocks(ctx context.Context) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+
+	indexed := len(c.kinds) > 0
+	for _, kind := range c.kinds {
+		indexed = indexed && c.storage.Indexed(kind)
+	}
+	if indexed {
+		for _, kind := range c.kinds {
+			for offset := 0; ; {
+				blocks, next, err := c.storage.IndexedBlocks(ctx, kind, offset, 500)
+				if err != nil {
+					return nil, err
+				}
+				for _, block := range blocks {
+					ttls[block.Key] = block.TTL
+				}
+				if offset = next; offset == 0 {
+					break
+				}
+			}
+		}
+		return ttls, nil
+	}
+
+	// Blocks may be listed twice, in both layouts or across SCAN pages
+	var cursor uint64
+	for {
+		blocks, next, err := c.storage.ScanBlocked(ctx, "", cursor, 500)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range blocks {
+			ttls[block.Key] = max(ttls[block.Key], block.TTL)
+		}
+		if cursor = next; cursor == 0 {
+			return ttls, nil
+		}
+	}
+}
+
+// Handle implements events.Sink, tracking the blocks set and lifted through
+// the rate limiter of this instance
+func (c *BlockCollector) Handle(event events.Event) {
+	kind, _, _ := strings.Cut(event.Key, ":")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch event.Type {
+	case events.TypeBlocked:
+		if event.BlockTime <= 0 {
+			return
+		}
+		metrics.BlocksCreated.WithLabelValues(kind, event.Reason).Inc()
+		c.pending[event.Key] = pendingBlock{kind: kind, reason: event.Reason, until: event.Timestamp.Add(event.BlockTime)}
+	case events.TypeUnblocked:
+		delete(c.pending, event.Key)
+	}
+}
+
+// sweep counts the tracked blocks that expired by now
+func (c *BlockCollector) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, block := range c.pending {
+		if !now.Before(block.until) {
+			metrics.BlocksExpired.WithLabelValues(block.kind, block.reason).Inc()
+			delete(c.pending, key)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package strategy
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,24 +15,156 @@ import (
 // RedisStrategy implements StorageStrategy using Redis
 type RedisStrategy struct {
 	client *redis.Client
+	// serverClock stores block expiry as an absolute Redis TIME timestamp
+	serverClock bool
+
+	retryPolicy RetryPolicy
+	budget      *retryBudget
+	// logRetention keeps sliding log entries past their window
+	logRetention time.Duration
+	// index lists the recently active keys, nil when disabled
+	index *keyIndex
+	// cache answers hot keys from process memory, nil when disabled
+	cache *localCache
+	// batch accumulates fixed window increments, nil when disabled
+	batch *batcher
+	// faults degrades commands for game days, nil when disabled
+	faults *FaultInjector
+	// legacyMigrated is set once MigrateLegacyKeys found no key of the legacy
+	// layouts left, so checks stop looking for them
+	legacyMigrated atomic.Bool
 }
 
-// NewRedisStrategy creates a new Redis strategy instance
+// incrementOnceScript applies an increment once per operation ID: a retry after
+// a lost reply returns the stored result instead of counting again
+var incrementOnceScript = redis.NewScript(`
+local done = redis.call('GET', KEYS[2])
+if done then
+	return tonumber(done)
+end
+local count = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+redis.call('SET', KEYS[2], count, 'PX', ARGV[3])
+return count
+`)
+
+// setBlockedScript stores the block expiry in milliseconds of the Redis clock
+var setBlockedScript = redis.NewScript(`
+local now = redis.call('TIME')
+local until = now[1] * 1000 + math.floor(now[2] / 1000) + tonumber(ARGV[1])
+redis.call('SET', KEYS[1], until, 'PX', ARGV[1])
+return until
+`)
+
+// isBlockedScript returns the stored block value and the current Redis time in milliseconds
+var isBlockedScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return false
+end
+local now = redis.call('TIME')
+return {value, now[1] * 1000 + math.floor(now[2] / 1000), redis.call('PTTL', KEYS[1])}
+`)
+
+// checkScript runs a fixed window check in one round trip: block lookup,
+// increment, grant lookup and blocking on exceed. Its keys share the hash tag
+// of the counter key, so it runs on a Redis Cluster too. The result is remembered under the optional dedup key so that a
+// retried check is counted once.
+// It returns {status, count, limit, resetIn ms}, status being 0 allowed,
+// 1 exceeded and 2 blocked
+var checkScript = redis.NewScript(`
+if KEYS[4] then
+	local done = redis.call('GET', KEYS[4])
+	if done then
+		return cjson.decode(done)
+	end
+end
+
+local delta = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local blockFor = tonumber(ARGV[4])
+local serverClock = ARGV[5] == '1'
+
+local now = redis.call('TIME')
+local nowMs = now[1] * 1000 + math.floor(now[2] / 1000)
+
+local result
+local blocked = redis.call('GET', KEYS[2])
+if blocked then
+	local remaining = redis.call('PTTL', KEYS[2])
+	local untilMs = tonumber(blocked)
+	if untilMs and untilMs > 1 then
+		remaining = untilMs - nowMs
+	end
+	result = {2, 0, limit, remaining}
+else
+	local count = redis.call('INCRBY', KEYS[1], delta)
+	local ttl = redis.call('PTTL', KEYS[1])
+	if ttl < 0 then
+		redis.call('PEXPIRE', KEYS[1], window)
+		ttl = window
+	end
+	limit = limit + math.max(tonumber(redis.call('GET', KEYS[3]) or '0') or 0, 0)
+
+	if count <= limit then
+		result = {0, count, limit, ttl}
+	elseif blockFor > 0 then
+		if serverClock then
+			redis.call('SET', KEYS[2], nowMs + blockFor, 'PX', blockFor)
+		else
+			redis.call('SET', KEYS[2], '1', 'PX', blockFor)
+		end
+		result = {2, count, limit, blockFor}
+	else
+		result = {1, count, limit, ttl}
+	end
+end
+
+if KEYS[4] then
+	redis.call('SET', KEYS[4], cjson.encode(result), 'PX', ARGV[6])
+end
+return result
+`)
+
+// NewRedisStrategy creates a new Redis strategy instance. It is kept for
+// compatibility, new code should use strategy.NewRedis of the v2 API
 func NewRedisStrategy(host, port, password string, db int) *RedisStrategy {
-	rdb := redis.NewClient(&redis.Options{
+	return NewRedisStrategyWithOptions(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", host, port),
 		Password: password,
 		DB:       db,
 	})
+}
+
+// NewRedisStrategyWithOptions creates a Redis strategy from the client
+// options, for settings such as TLS that NewRedisStrategy does not take
+func NewRedisStrategyWithOptions(opts *redis.Options) *RedisStrategy {
+	rdb := redis.NewClient(opts)
+	rdb.AddHook(metricsHook{})
 
 	return &RedisStrategy{
-		client: rdb,
+		client:      rdb,
+		retryPolicy: RetryPolicy{MaxAttempts: 1},
+		budget:      newRetryBudget(0),
 	}
 }
 
+// SetRetryPolicy enables bounded retries of transient errors. Only idempotent
+// operations are retried; increments become idempotent through a dedup key per
+// operation, so a retry never double-charges a client
+func (r *RedisStrategy) SetRetryPolicy(policy RetryPolicy) {
+	r.retryPolicy = policy
+	r.budget = newRetryBudget(policy.Budget)
+}
+
 // Get retrieves rate limit information for a given key
 func (r *RedisStrategy) Get(ctx context.Context, key string) (*RateLimitInfo, error) {
-	data, err := r.client.Get(ctx, key).Result()
+	var data string
+	err := r.retry(ctx, func() (err error) {
+		data, err = r.client.Get(ctx, key).Result()
+		return err
+	})
 	if err != nil {
 		if err == redis.Nil {
 			return &RateLimitInfo{
@@ -41,31 +176,57 @@ func (r *RedisStrategy) Get(ctx context.Context, key string) (*RateLimitInfo, er
 		return nil, err
 	}
 
-	var info RateLimitInfo
-	if err := json.Unmarshal([]byte(data), &info); err != nil {
-		return nil, err
+	// Counters written by Increment are stored as plain integers
+	if count, err := strconv.Atoi(data); err == nil {
+		var ttl time.Duration
+		err := r.retry(ctx, func() (err error) {
+			ttl, err = r.client.PTTL(ctx, key).Result()
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &RateLimitInfo{
+			Count:     count,
+			ResetTime: time.Now().Add(max(ttl, 0)),
+		}, nil
 	}
 
-	return &info, nil
+	info, version, err := decodeInfo([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	if version < SchemaVersion {
+		r.upgradeInfo(ctx, key, data, info, version)
+	}
+
+	return info, nil
 }
 
 // Set stores rate limit information for a given key with expiration
 func (r *RedisStrategy) Set(ctx context.Context, key string, info *RateLimitInfo, expiration time.Duration) error {
-	data, err := json.Marshal(info)
+	data, err := encodeInfo(info)
 	if err != nil {
 		return err
 	}
 
-	return r.client.Set(ctx, key, data, expiration).Err()
+	return r.retry(ctx, func() error {
+		return r.client.Set(ctx, key, data, expiration).Err()
+	})
 }
 
-// Increment increments the count for a given key
-func (r *RedisStrategy) Increment(ctx context.Context, key string, expiration time.Duration) (int, error) {
+// Increment increments the count for a given key by delta
+func (r *RedisStrategy) Increment(ctx context.Context, key string, delta int, expiration time.Duration) (int, error) {
+	if r.retryPolicy.MaxAttempts > 1 {
+		return r.incrementOnce(ctx, key, delta, expiration)
+	}
+
 	// Use Redis pipeline for atomic operations
 	pipe := r.client.Pipeline()
 
 	// Increment counter
-	incrCmd := pipe.Incr(ctx, key)
+	incrCmd := pipe.IncrBy(ctx, key, int64(delta))
 
 	// Set expiration if this is the first increment
 	pipe.Expire(ctx, key, expiration)
@@ -79,23 +240,351 @@ func (r *RedisStrategy) Increment(ctx context.Context, key string, expiration ti
 	return int(incrCmd.Val()), nil
 }
 
+// incrementOnce increments through a dedup key so that it can be retried safely
+func (r *RedisStrategy) incrementOnce(ctx context.Context, key string, delta int, expiration time.Duration) (int, error) {
+	dedupKey := dedupKeyFor(key, idempotencyKey(ctx))
+	// Remember the result long enough to cover every retry
+	dedupTTL := time.Duration(r.retryPolicy.MaxAttempts)*max(r.retryPolicy.MaxDelay, time.Second) + time.Second
+
+	var count int64
+	err := r.retry(ctx, func() (err error) {
+		count, err = incrementOnceScript.Run(ctx, r.client, []string{key, dedupKey}, delta, expiration.Milliseconds(), dedupTTL.Milliseconds()).Int64()
+		return err
+	})
+	return int(count), err
+}
+
+// CheckAndIncrement runs a fixed window check atomically with EVALSHA. With the
+// local cache, blocked keys and counters at their limit are denied without it
+func (r *RedisStrategy) CheckAndIncrement(ctx context.Context, key, grantKey string, delta, limit int, window, blockFor time.Duration) (*CheckOutcome, error) {
+	if blocked, until, ok := r.cache.block(key); ok && blocked {
+		return &CheckOutcome{Limit: limit, Blocked: true, ResetTime: until}, nil
+	}
+	if r.batch != nil {
+		return r.checkBatched(ctx, key, grantKey, delta, limit, window, blockFor)
+	}
+	if outcome, ok := r.cache.counter(key, limit); ok {
+		return outcome, nil
+	}
+
+	outcome, err := r.checkAndIncrement(ctx, key, grantKey, delta, limit, window, blockFor)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.storeOutcome(key, limit, outcome)
+	return outcome, nil
+}
+
+// checkAndIncrement runs the fixed window check script
+func (r *RedisStrategy) checkAndIncrement(ctx context.Context, key, grantKey string, delta, limit int, window, blockFor time.Duration) (*CheckOutcome, error) {
+	// Every key of the script shares the hash tag of the counter key, so legacy
+	// keys are moved under it first
+	if err := r.migrateLegacy(ctx, key); err != nil {
+		return nil, err
+	}
+
+	keys := []string{key, BlockKeyFor(key), grantKey}
+	dedupTTL := time.Duration(0)
+	if r.retryPolicy.MaxAttempts > 1 {
+		keys = append(keys, dedupKeyFor(key, idempotencyKey(ctx)))
+		dedupTTL = time.Duration(r.retryPolicy.MaxAttempts)*max(r.retryPolicy.MaxDelay, time.Second) + time.Second
+	}
+	serverClock := 0
+	if r.serverClock {
+		serverClock = 1
+	}
+
+	var result []interface{}
+	err := r.retry(ctx, func() (err error) {
+		result, err = checkScript.Run(ctx, r.client, keys, delta, limit, window.Milliseconds(), blockFor.Milliseconds(), serverClock, dedupTTL.Milliseconds()).Slice()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 4 {
+		return nil, fmt.Errorf("unexpected check result for %s", key)
+	}
+
+	status, _ := result[0].(int64)
+	count, _ := result[1].(int64)
+	total, _ := result[2].(int64)
+	resetIn, _ := result[3].(int64)
+
+	return &CheckOutcome{
+		Count:     int(count),
+		Limit:     int(total),
+		Allowed:   status == 0,
+		Blocked:   status == 2,
+		ResetTime: time.Now().Add(time.Duration(resetIn) * time.Millisecond),
+	}, nil
+}
+
+// SetNX stores a value only if the key does not exist, reporting whether it was stored
+func (r *RedisStrategy) SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, key, value, expiration).Result()
+}
+
+// slidingLogScript trims a sorted-set log to the retention period, which is at
+// least the trailing window, and logs the request only if it fits the window,
+// timed with the Redis clock so instances agree.
+// Members are unique per request: ARGV[4] is a random request ID
+var slidingLogScript = redis.NewScript(`
+local now = redis.call('TIME')
+local nowMs = now[1] * 1000 + math.floor(now[2] / 1000)
+local window = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local keep = math.max(window, tonumber(ARGV[5]))
+local windowStart = '(' .. (nowMs - window)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', nowMs - keep)
+local count = redis.call('ZCOUNT', KEYS[1], windowStart, '+inf')
+
+local allowed = 0
+if count + cost <= limit then
+	for i = 1, cost do
+		redis.call('ZADD', KEYS[1], nowMs, ARGV[4] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], keep)
+	count = count + cost
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGEBYSCORE', KEYS[1], windowStart, '+inf', 'WITHSCORES', 'LIMIT', 0, 1)
+local resetIn = window
+if oldest[2] then
+	resetIn = tonumber(oldest[2]) + window - nowMs
+end
+return {count, allowed, resetIn}
+`)
+
+// AddToLog implements SlidingLogStorage with a sorted set scored by request time
+func (r *RedisStrategy) AddToLog(ctx context.Context, key string, cost, limit int, window time.Duration) (int, bool, time.Time, error) {
+	requestID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+
+	result, err := slidingLogScript.Run(ctx, r.client, []string{key}, window.Milliseconds(), cost, limit, requestID, r.logRetention.Milliseconds()).Slice()
+	if err != nil {
+		return 0, false, time.Time{}, err
+	}
+	if len(result) != 3 {
+		return 0, false, time.Time{}, fmt.Errorf("unexpected sliding log result for %s", key)
+	}
+
+	count, _ := result[0].(int64)
+	allowed, _ := result[1].(int64)
+	resetIn, _ := result[2].(int64)
+
+	return int(count), allowed == 1, time.Now().Add(time.Duration(resetIn) * time.Millisecond), nil
+}
+
+// SetLogRetention keeps sliding log entries for at least retention, past the
+// window they are counted in, so they can be read back with ReadLog
+func (r *RedisStrategy) SetLogRetention(retention time.Duration) {
+	r.logRetention = retention
+}
+
+// LogEntry is a request unit recorded in a sliding log. A request costing
+// several units has one entry per unit, all with the same request ID
+type LogEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+}
+
+// ReadLog returns up to count entries of the sliding log of a key recorded
+// between from and to, inclusive, oldest first and skipping the first offset
+func (r *RedisStrategy) ReadLog(ctx context.Context, key string, from, to time.Time, offset, count int) ([]LogEntry, error) {
+	var members []redis.Z
+	err := r.retry(ctx, func() (err error) {
+		members, err = r.client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:    strconv.FormatInt(from.UnixMilli(), 10),
+			Max:    strconv.FormatInt(to.UnixMilli(), 10),
+			Offset: int64(offset),
+			Count:  int64(count),
+		}).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LogEntry, 0, len(members))
+	for _, member := range members {
+		name, _ := member.Member.(string)
+		requestID, _, _ := strings.Cut(name, ":")
+		entries = append(entries, LogEntry{
+			Time:      time.UnixMilli(int64(member.Score)).UTC(),
+			RequestID: requestID,
+		})
+	}
+	return entries, nil
+}
+
+// tokenBucketScript refills and takes from a bucket stored as a hash of the
+// token count and the last refill time, using the Redis clock
+var tokenBucketScript = redis.NewScript(`
+local now = redis.call('TIME')
+local nowMs = now[1] * 1000 + math.floor(now[2] / 1000)
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or nowMs
+tokens = math.min(capacity, tokens + math.max(nowMs - ts, 0) * rate / 1000)
+
+local allowed = 0
+local waitMs
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+	waitMs = math.ceil((capacity - tokens) * 1000 / rate)
+else
+	waitMs = math.ceil((cost - tokens) * 1000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', nowMs)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * 1000 / rate) + 1000)
+return {math.floor(tokens), allowed, waitMs}
+`)
+
+// TakeTokens implements TokenBucketStorage with a hash per key
+func (r *RedisStrategy) TakeTokens(ctx context.Context, key string, cost, capacity int, refillRate float64) (int, bool, time.Time, error) {
+	if refillRate <= 0 {
+		return 0, false, time.Time{}, fmt.Errorf("refill rate must be positive")
+	}
+
+	result, err := tokenBucketScript.Run(ctx, r.client, []string{key}, capacity, refillRate, cost).Slice()
+	if err != nil {
+		return 0, false, time.Time{}, err
+	}
+	if len(result) != 3 {
+		return 0, false, time.Time{}, fmt.Errorf("unexpected token bucket result for %s", key)
+	}
+
+	tokens, _ := result[0].(int64)
+	allowed, _ := result[1].(int64)
+	waitMs, _ := result[2].(int64)
+
+	return int(tokens), allowed == 1, time.Now().Add(time.Duration(waitMs) * time.Millisecond), nil
+}
+
+// leakyBucketScript keeps the theoretical time at which a key's queue drains,
+// in microseconds of the Redis clock, and schedules requests after it
+var leakyBucketScript = redis.NewScript(`
+local now = redis.call('TIME')
+local nowUs = now[1] * 1000000 + now[2]
+local cost = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local maxDelay = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or nowUs
+if tat < nowUs then
+	tat = nowUs
+end
+
+local delay = tat - nowUs
+if delay > maxDelay then
+	return {0, delay, 0, delay}
+end
+
+local newTat = tat + cost * interval
+redis.call('SET', KEYS[1], string.format('%.0f', newTat), 'PX', math.ceil((newTat - nowUs) / 1000) + 1000)
+local remaining = math.max(math.floor((maxDelay - (newTat - nowUs)) / interval) + 1, 0)
+return {1, delay, remaining, newTat - nowUs}
+`)
+
+// Schedule implements LeakyBucketStorage with the queue drain time stored per key
+func (r *RedisStrategy) Schedule(ctx context.Context, key string, cost int, interval, maxDelay time.Duration) (time.Duration, bool, int, time.Time, error) {
+	if interval <= 0 {
+		return 0, false, 0, time.Time{}, fmt.Errorf("drain interval must be positive")
+	}
+
+	result, err := leakyBucketScript.Run(ctx, r.client, []string{key}, cost, interval.Microseconds(), maxDelay.Microseconds()).Slice()
+	if err != nil {
+		return 0, false, 0, time.Time{}, err
+	}
+	if len(result) != 4 {
+		return 0, false, 0, time.Time{}, fmt.Errorf("unexpected leaky bucket result for %s", key)
+	}
+
+	allowed, _ := result[0].(int64)
+	delayUs, _ := result[1].(int64)
+	remaining, _ := result[2].(int64)
+	drainUs, _ := result[3].(int64)
+
+	delay := time.Duration(delayUs) * time.Microsecond
+	return delay, allowed == 1, int(remaining), time.Now().Add(time.Duration(drainUs) * time.Microsecond), nil
+}
+
+// SetServerClock makes block expiry absolute timestamps taken from the Redis
+// clock, so instances whose clocks disagree with Redis or with each other
+// still agree on when a block ends and report the same remaining block time
+func (r *RedisStrategy) SetServerClock(enabled bool) {
+	r.serverClock = enabled
+}
+
 // SetBlocked sets a key as blocked until a specific time
 func (r *RedisStrategy) SetBlocked(ctx context.Context, key string, blockUntil time.Time) error {
-	blockKey := fmt.Sprintf("blocked:%s", key)
+	blockKey := BlockKeyFor(key)
 	blockDuration := time.Until(blockUntil)
 
 	if blockDuration <= 0 {
 		return nil
 	}
 
-	return r.client.Set(ctx, blockKey, "1", blockDuration).Err()
+	// Blocked keys stay listed for as long as the block lasts
+	r.Touch(key, blockUntil)
+	r.cache.storeBlock(key, true, blockUntil)
+
+	if r.serverClock {
+		// Only the duration is taken from the local clock
+		return r.retry(ctx, func() error {
+			return setBlockedScript.Run(ctx, r.client, []string{blockKey}, blockDuration.Milliseconds()).Err()
+		})
+	}
+
+	return r.retry(ctx, func() error {
+		return r.client.Set(ctx, blockKey, "1", blockDuration).Err()
+	})
 }
 
-// IsBlocked checks if a key is currently blocked
+// IsBlocked checks if a key is currently blocked, in the current or legacy
+// block key. With the local cache, the last status read is reused
 func (r *RedisStrategy) IsBlocked(ctx context.Context, key string) (bool, time.Time, error) {
-	blockKey := fmt.Sprintf("blocked:%s", key)
+	if blocked, until, ok := r.cache.block(key); ok {
+		return blocked, until, nil
+	}
 
-	ttl, err := r.client.TTL(ctx, blockKey).Result()
+	blocked, until, err := r.isBlocked(ctx, key)
+	if err == nil {
+		r.cache.storeBlock(key, blocked, until)
+	}
+	return blocked, until, err
+}
+
+// isBlocked reads the block status of a key from Redis
+func (r *RedisStrategy) isBlocked(ctx context.Context, key string) (bool, time.Time, error) {
+	if r.serverClock {
+		blocked, blockUntil, err := r.isBlockedServerClock(ctx, BlockKeyFor(key))
+		if err != nil || blocked {
+			return blocked, blockUntil, err
+		}
+		return r.isBlockedServerClock(ctx, LegacyBlockKeyFor(key))
+	}
+
+	var ttl time.Duration
+	err := r.retry(ctx, func() error {
+		pipe := r.client.Pipeline()
+		current := pipe.PTTL(ctx, BlockKeyFor(key))
+		legacy := pipe.PTTL(ctx, LegacyBlockKeyFor(key))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		ttl = max(current.Val(), legacy.Val())
+		return nil
+	})
 	if err != nil {
 		return false, time.Time{}, err
 	}
@@ -108,39 +597,290 @@ func (r *RedisStrategy) IsBlocked(ctx context.Context, key string) (bool, time.T
 	return true, blockUntil, nil
 }
 
-// Delete removes a key from storage
-func (r *RedisStrategy) Delete(ctx context.Context, key string) error {
-	blockKey := fmt.Sprintf("blocked:%s", key)
+// isBlockedServerClock computes the remaining block time against the Redis
+// clock. Blocks written without an absolute expiry fall back to their TTL
+func (r *RedisStrategy) isBlockedServerClock(ctx context.Context, blockKey string) (bool, time.Time, error) {
+	var result []interface{}
+	err := r.retry(ctx, func() (err error) {
+		result, err = isBlockedScript.Run(ctx, r.client, []string{blockKey}).Slice()
+		return err
+	})
+	if err == redis.Nil {
+		return false, time.Time{}, nil
+	}
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	if len(result) != 3 {
+		return false, time.Time{}, fmt.Errorf("unexpected block state for %s", blockKey)
+	}
+
+	value, _ := result[0].(string)
+	now, _ := result[1].(int64)
+	ttl, _ := result[2].(int64)
+
+	remaining := time.Duration(ttl) * time.Millisecond
+	if until, err := strconv.ParseInt(value, 10, 64); err == nil && until > 1 {
+		remaining = time.Duration(until-now) * time.Millisecond
+	}
+
+	if remaining <= 0 {
+		return false, time.Time{}, nil
+	}
+
+	return true, time.Now().Add(remaining), nil
+}
+
+// ListBlocked returns the identifiers currently blocked for a key kind (e.g.
+// "ip"), from the key index when the kind is indexed and with SCAN otherwise
+func (r *RedisStrategy) ListBlocked(ctx context.Context, kind string) ([]string, error) {
+	prefix := kind + ":"
+	if r.Indexed(kind) {
+		var identifiers []string
+		for offset := 0; ; {
+			blocks, next, err := r.IndexedBlocks(ctx, kind, offset, 500)
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range blocks {
+				identifiers = append(identifiers, strings.TrimPrefix(block.Key, prefix))
+			}
+			if offset = next; offset == 0 {
+				return identifiers, nil
+			}
+		}
+	}
+
+	var identifiers []string
+	seen := make(map[string]bool)
+	for _, pattern := range BlockKeyPatterns(prefix) {
+		iter := r.client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			key, ok := CounterKeyOf(iter.Val())
+			identifier := strings.TrimPrefix(key, prefix)
+			if ok && !seen[identifier] {
+				seen[identifier] = true
+				identifiers = append(identifiers, identifier)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return identifiers, nil
+}
+
+// Unblock removes the block of a key in both layouts, keeping its counter
+func (r *RedisStrategy) Unblock(ctx context.Context, key string) (bool, error) {
+	r.cache.forget(key)
+	r.forgetBatched(key, true)
+	var removed int64
+	err := r.retry(ctx, func() error {
+		var err error
+		removed, err = r.client.Del(ctx, BlockKeyFor(key), LegacyBlockKeyFor(key)).Result()
+		return err
+	})
+	return removed > 0, err
+}
+
+// Block is a key currently blocked, as listed by ScanBlocked
+type Block struct {
+	// Key is the counter key, e.g. "ip:203.0.113.7"
+	Key string
+	// Kind is the key kind, e.g. "ip" or "token"
+	Kind string
+	// TTL is how long the block remains
+	TTL time.Duration
+}
+
+// legacyScanPhase marks the ScanBlocked cursors of the legacy block key
+// layout. Redis SCAN cursors index the key table and never reach it
+const legacyScanPhase uint64 = 1 << 63
+
+// ScanBlocked returns one page of the blocked keys of a kind, every kind when
+// empty, starting at a cursor. The kind is part of the SCAN MATCH pattern; the
+// current layout is scanned first, then the legacy one until MigrateLegacyKeys
+// completed. count is a hint, pages may hold fewer keys or none; the listing
+// is complete when the returned cursor is 0
+func (r *RedisStrategy) ScanBlocked(ctx context.Context, kind string, cursor uint64, count int64) ([]Block, uint64, error) {
+	prefix := ""
+	if kind != "" {
+		prefix = kind + ":"
+	}
+	patterns := BlockKeyPatterns(prefix)
+	phase := 0
+	if cursor&legacyScanPhase != 0 {
+		phase, cursor = 1, cursor&^legacyScanPhase
+	}
+
+	keys, next, err := r.client.Scan(ctx, cursor, patterns[phase], count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan blocked keys: %w", err)
+	}
+	switch {
+	case phase == 1 && next != 0:
+		next |= legacyScanPhase
+	case phase == 0 && next == 0 && !r.legacyMigrated.Load():
+		next = legacyScanPhase
+	}
+
+	var blockKeys []string
+	for _, key := range keys {
+		if _, ok := CounterKeyOf(key); ok {
+			blockKeys = append(blockKeys, key)
+		}
+	}
 
 	pipe := r.client.Pipeline()
-	pipe.Del(ctx, key)
-	pipe.Del(ctx, blockKey)
+	ttls := make([]*redis.DurationCmd, len(blockKeys))
+	for i, key := range blockKeys {
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if len(blockKeys) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, 0, fmt.Errorf("failed to read block TTLs: %w", err)
+		}
+	}
 
-	_, err := pipe.Exec(ctx)
-	return err
+	blocks := make([]Block, 0, len(blockKeys))
+	for i, blockKey := range blockKeys {
+		// Expired since the scan, or stored without expiry by another writer
+		ttl := ttls[i].Val()
+		if ttl <= 0 {
+			continue
+		}
+		key, _ := CounterKeyOf(blockKey)
+		kind, _, _ := strings.Cut(key, ":")
+		blocks = append(blocks, Block{Key: key, Kind: kind, TTL: ttl})
+	}
+	return blocks, next, nil
 }
 
-// Close closes the Redis connection
+// Delete removes a key from storage
+func (r *RedisStrategy) Delete(ctx context.Context, key string) error {
+	r.cache.forget(key)
+	r.forgetBatched(key, false)
+	return r.retry(ctx, func() error {
+		pipe := r.client.Pipeline()
+		pipe.Del(ctx, key)
+		pipe.Del(ctx, BlockKeyFor(key))
+		pipe.Del(ctx, LegacyBlockKeyFor(key))
+
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
+
+// Close writes the pending increments and index updates and closes the Redis connection
 func (r *RedisStrategy) Close() error {
+	r.closeBatch()
+	r.closeIndex()
 	return r.client.Close()
 }
 
+// Client returns the underlying Redis client for components that need raw access
+func (r *RedisStrategy) Client() *redis.Client {
+	return r.client
+}
+
 // Ping tests the Redis connection
 func (r *RedisStrategy) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
 
-// GetKeyWithPrefix creates a key with a prefix for different types of rate limiting
+// GetKeyWithPrefix creates a key with a prefix for different types of rate
+// limiting. Braces, e.g. of route patterns such as /users/{id}, are escaped:
+// Redis Cluster would hash the key on them instead of the hash tag BlockKeyFor
+// wraps it in
 func GetKeyWithPrefix(prefix, identifier string) string {
-	return fmt.Sprintf("%s:%s", prefix, identifier)
+	return hashTagEscaper.Replace(prefix + ":" + identifier)
 }
 
-// ParseTokenFromHeader extracts token from API_KEY header
+// hashTagEscaper percent-encodes the braces delimiting Redis Cluster hash tags
+var hashTagEscaper = strings.NewReplacer("{", "%7B", "}", "%7D")
+
+// maxTokenLength bounds the tokens accepted from requests, which become storage keys
+const maxTokenLength = 256
+
+// ParseTokenFromHeader extracts token from API_KEY header. Tokens that are too
+// long or contain spaces or control characters are rejected, as clients control them
 func ParseTokenFromHeader(headerValue string) (string, error) {
-	if headerValue == "" {
+	token := strings.TrimSpace(headerValue)
+	if token == "" {
 		return "", fmt.Errorf("empty header value")
 	}
+	if len(token) > maxTokenLength {
+		return "", fmt.Errorf("token longer than %d bytes", maxTokenLength)
+	}
+	for _, c := range token {
+		if c <= ' ' || c == 0x7f {
+			return "", fmt.Errorf("token contains invalid character %q", c)
+		}
+	}
 
 	// The header value is just the token itself
-	return headerValue, nil
+	return token, nil
+}
+
+// Export scans every counter and block, skipping other data such as sliding
+// logs, buckets, dedup keys and JSON values
+func (r *RedisStrategy) Export(ctx context.Context, fn func(Record) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, "*", 100).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+
+		pipe := r.client.Pipeline()
+		values := make([]*redis.StringCmd, len(keys))
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			values[i] = pipe.Get(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		// Keys of other types fail individually and are skipped below
+		pipe.Exec(ctx)
+
+		now := time.Now()
+		for i, key := range keys {
+			if values[i].Err() != nil || ttls[i].Err() != nil || isDedupKey(key) {
+				continue
+			}
+
+			record := Record{Key: key}
+			if ttl := ttls[i].Val(); ttl > 0 {
+				record.ExpiresAt = now.Add(ttl)
+			}
+			if counterKey, ok := CounterKeyOf(key); ok {
+				if record.ExpiresAt.IsZero() {
+					continue
+				}
+				record.Key, record.Blocked = counterKey, true
+			} else if record.Count, err = strconv.Atoi(values[i].Val()); err != nil {
+				continue
+			}
+
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Import stores a counter or block, blocks in the current layout
+func (r *RedisStrategy) Import(ctx context.Context, record Record) error {
+	if record.Blocked {
+		return r.SetBlocked(ctx, record.Key, record.ExpiresAt)
+	}
+
+	return r.retry(ctx, func() error {
+		return r.client.Set(ctx, record.Key, record.Count, ttlUntil(record.ExpiresAt)).Err()
+	})
 }
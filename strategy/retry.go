@@ -0,0 +1,158 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/sampling"
+)
+
+// RetryPolicy bounds the retries of transient storage errors
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per operation, 1 disables retries
+	MaxAttempts int
+	// BaseDelay and MaxDelay bound the jittered exponential backoff between attempts
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Budget is the fraction of operations that may be retried, so retries
+	// cannot multiply the load on a struggling Redis
+	Budget float64
+}
+
+// retryBudget is a token bucket refilled by Budget tokens per operation and
+// drained by one token per retry
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	// Allow a small burst of retries before the ratio applies
+	return &retryBudget{tokens: 10, max: 10, ratio: ratio}
+}
+
+// deposit credits one operation
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
+
+// withdraw reports whether a retry is allowed, consuming a token if so
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey scopes counter increments to an operation ID, e.g. the
+// request ID, so retried increments for the same operation are applied once
+func WithIdempotencyKey(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, id)
+}
+
+// idempotencyKey returns the operation ID from the context, or a random one
+func idempotencyKey(ctx context.Context) string {
+	if id, ok := ctx.Value(idempotencyKeyContextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return randomID()
+}
+
+func randomID() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	id := make([]byte, 16)
+	for i := range id {
+		id[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(id)
+}
+
+// retry runs op until it succeeds, fails with a permanent error, runs out of
+// attempts or the retry budget is exhausted. op must be idempotent
+func (r *RedisStrategy) retry(ctx context.Context, op func() error) error {
+	if r.retryPolicy.MaxAttempts <= 1 {
+		return op()
+	}
+
+	r.budget.deposit()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || !isTransient(err) {
+			return err
+		}
+		if attempt >= r.retryPolicy.MaxAttempts {
+			metrics.StorageRetries.WithLabelValues("exhausted").Inc()
+			return err
+		}
+		if !r.budget.withdraw() {
+			metrics.StorageRetries.WithLabelValues("budget_exceeded").Inc()
+			return err
+		}
+		metrics.StorageRetries.WithLabelValues("retried").Inc()
+
+		timer := time.NewTimer(r.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt
+func (r *RedisStrategy) backoff(attempt int) time.Duration {
+	base := r.retryPolicy.BaseDelay
+	if base <= 0 {
+		base = 10 * time.Millisecond
+	}
+	ceiling := r.retryPolicy.MaxDelay
+	if ceiling <= 0 {
+		ceiling = 200 * time.Millisecond
+	}
+
+	delay := base << min(attempt-1, 16)
+	if delay <= 0 || delay > ceiling {
+		delay = ceiling
+	}
+	return sampling.Jitter(delay)
+}
+
+// isTransient reports whether an error is worth retrying: network failures
+// and Redis replies signaling a temporary condition
+func isTransient(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	for _, prefix := range []string{"LOADING", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "READONLY", "BUSY "} {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return true
+		}
+	}
+	return strings.Contains(err.Error(), "connection pool timeout") || strings.Contains(err.Error(), "use of closed network connection")
+}
@@ -0,0 +1,102 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+)
+
+// SchemaVersion is the layout version of the RateLimitInfo values written to
+// Redis. Values of older versions are migrated when read, so layout changes
+// do not require flushing the storage
+const SchemaVersion = 1
+
+// ErrUnsupportedSchema is returned for values written by a newer version, e.g.
+// while rolling back a deployment
+var ErrUnsupportedSchema = errors.New("unsupported schema version")
+
+// infoMigrations upgrade the decoded fields of a value from the version they
+// are keyed by to the next one
+var infoMigrations = map[int]func(fields map[string]json.RawMessage) error{
+	// Version 0 values are the unversioned JSON of earlier releases, whose
+	// fields are unchanged in version 1
+	0: func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// storedInfo is the stored layout of a RateLimitInfo
+type storedInfo struct {
+	Version int `json:"v"`
+	RateLimitInfo
+}
+
+// encodeInfo serializes a value in the current schema version
+func encodeInfo(info *RateLimitInfo) ([]byte, error) {
+	return json.Marshal(storedInfo{Version: SchemaVersion, RateLimitInfo: *info})
+}
+
+// decodeInfo deserializes a value of any supported schema version, returning
+// the version it was stored with
+func decodeInfo(data []byte) (*RateLimitInfo, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if raw, ok := fields["v"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid schema version: %w", err)
+		}
+	}
+	if version > SchemaVersion {
+		return nil, version, fmt.Errorf("%w %d, at most %d is supported", ErrUnsupportedSchema, version, SchemaVersion)
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		migrate, ok := infoMigrations[v]
+		if !ok {
+			return nil, version, fmt.Errorf("%w %d, no migration to %d", ErrUnsupportedSchema, v, v+1)
+		}
+		if err := migrate(fields); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate schema version %d: %w", v, err)
+		}
+	}
+
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return nil, version, err
+	}
+	var info RateLimitInfo
+	if err := json.Unmarshal(migrated, &info); err != nil {
+		return nil, version, err
+	}
+	return &info, version, nil
+}
+
+// rewriteInfoScript replaces a value keeping its TTL, unless it changed since it was read
+var rewriteInfoScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+// upgradeInfo rewrites a value read in an older schema version in the current
+// one. Failures only leave the value to be migrated on a later read
+func (r *RedisStrategy) upgradeInfo(ctx context.Context, key, data string, info *RateLimitInfo, version int) {
+	result := "rewritten"
+	encoded, err := encodeInfo(info)
+	if err == nil {
+		err = rewriteInfoScript.Run(ctx, r.client, []string{key}, data, encoded).Err()
+	}
+	if err != nil {
+		result = "error"
+	}
+	metrics.SchemaMigrations.WithLabelValues(strconv.Itoa(version), result).Inc()
+}
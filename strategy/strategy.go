@@ -2,6 +2,7 @@ package strategy
 
 import (
 	"context"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,68 @@ type RateLimitInfo struct {
 	BlockUntil time.Time `json:"block_until,omitempty"`
 }
 
+// blockSuffix ends the block key of a counter key
+const blockSuffix = ":blocked"
+
+// legacyBlockPrefix starts block keys written before BlockKeyFor
+const legacyBlockPrefix = "blocked:"
+
+// BlockKeyFor returns the key holding the block state of a counter key. The
+// counter key is wrapped in a Redis Cluster hash tag, so both keys hash to
+// the same slot and can be used in one script. Counter keys built by
+// GetKeyWithPrefix never contain braces, which would move the tag
+func BlockKeyFor(key string) string {
+	return "{" + key + "}" + blockSuffix
+}
+
+// LegacyBlockKeyFor returns the "blocked:<key>" block key of earlier versions,
+// still read until the blocks stored under it expire
+func LegacyBlockKeyFor(key string) string {
+	return legacyBlockPrefix + key
+}
+
+// grantSuffix ends the grant key of a counter key
+const grantSuffix = ":grant"
+
+// legacyGrantPrefix starts grant keys written before GrantKeyFor
+const legacyGrantPrefix = "grant:"
+
+// GrantKeyFor returns the key holding the units granted to a counter key,
+// under the same hash tag as BlockKeyFor
+func GrantKeyFor(key string) string {
+	return "{" + key + "}" + grantSuffix
+}
+
+// LegacyGrantKeyFor returns the "grant:<key>" grant key of earlier versions,
+// moved to GrantKeyFor by the Redis strategy
+func LegacyGrantKeyFor(key string) string {
+	return legacyGrantPrefix + key
+}
+
+// dedupKeyFor returns the key remembering the result of the operation id on a
+// counter key, under the same hash tag as BlockKeyFor
+func dedupKeyFor(key, id string) string {
+	return "{" + key + "}:dedup:" + id
+}
+
+// BlockKeyPatterns returns the glob patterns matching the block keys, in the
+// current and legacy layouts, of the counter keys starting with prefix
+func BlockKeyPatterns(prefix string) []string {
+	prefix = globEscaper.Replace(prefix)
+	return []string{"{" + prefix + "*}" + blockSuffix, legacyBlockPrefix + prefix + "*"}
+}
+
+// globEscaper escapes the characters special to Redis glob patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// CounterKeyOf returns the counter key of a block key in either layout
+func CounterKeyOf(blockKey string) (string, bool) {
+	if strings.HasPrefix(blockKey, "{") && strings.HasSuffix(blockKey, "}"+blockSuffix) {
+		return blockKey[1 : len(blockKey)-len(blockSuffix)-1], true
+	}
+	return strings.CutPrefix(blockKey, legacyBlockPrefix)
+}
+
 // StorageStrategy defines the interface for different storage mechanisms
 type StorageStrategy interface {
 	// Get retrieves rate limit information for a given key
@@ -21,8 +84,11 @@ type StorageStrategy interface {
 	// Set stores rate limit information for a given key with expiration
 	Set(ctx context.Context, key string, info *RateLimitInfo, expiration time.Duration) error
 
-	// Increment increments the count for a given key
-	Increment(ctx context.Context, key string, expiration time.Duration) (int, error)
+	// Increment increments the count for a given key by delta
+	Increment(ctx context.Context, key string, delta int, expiration time.Duration) (int, error)
+
+	// SetNX stores a value only if the key does not exist, reporting whether it was stored
+	SetNX(ctx context.Context, key string, value string, expiration time.Duration) (bool, error)
 
 	// SetBlocked sets a key as blocked until a specific time
 	SetBlocked(ctx context.Context, key string, blockUntil time.Time) error
@@ -30,9 +96,72 @@ type StorageStrategy interface {
 	// IsBlocked checks if a key is currently blocked
 	IsBlocked(ctx context.Context, key string) (bool, time.Time, error)
 
+	// Unblock lifts the block of a key, keeping its counter, and reports
+	// whether it was blocked
+	Unblock(ctx context.Context, key string) (bool, error)
+
 	// Delete removes a key from storage
 	Delete(ctx context.Context, key string) error
 
 	// Close closes the storage connection
 	Close() error
 }
+
+// SlidingLogStorage is implemented by storages that support the sliding-window log algorithm
+type SlidingLogStorage interface {
+	// AddToLog drops the entries of a key older than window and then logs cost
+	// new entries if they fit within limit. It returns the number of entries in
+	// the window, whether the new entries were logged, and when the oldest entry
+	// leaves the window
+	AddToLog(ctx context.Context, key string, cost, limit int, window time.Duration) (int, bool, time.Time, error)
+}
+
+// TokenBucketStorage is implemented by storages that support the token bucket algorithm
+type TokenBucketStorage interface {
+	// TakeTokens refills the bucket of a key at refillRate tokens per second up to
+	// capacity and then takes cost tokens if available. It returns the tokens
+	// left, whether they were taken, and when the bucket will be full again (or,
+	// when denied, when enough tokens will be available)
+	TakeTokens(ctx context.Context, key string, cost, capacity int, refillRate float64) (int, bool, time.Time, error)
+}
+
+// LeakyBucketStorage is implemented by storages that support the leaky bucket algorithm
+type LeakyBucketStorage interface {
+	// Schedule queues cost requests on a key's bucket draining one request every
+	// interval. It returns how long the caller must wait before its turn, whether
+	// the requests fit (the wait does not exceed maxDelay), the queue slots left
+	// and when the queue will be empty
+	Schedule(ctx context.Context, key string, cost int, interval, maxDelay time.Duration) (time.Duration, bool, int, time.Time, error)
+}
+
+// CheckOutcome is the result of an atomic fixed window check
+type CheckOutcome struct {
+	// Count is the number of requests in the window, including this one when counted
+	Count int
+	// Limit is the configured limit plus the granted units
+	Limit   int
+	Allowed bool
+	// Blocked reports that the key is blocked, either from an earlier request or
+	// because this request exceeded the limit
+	Blocked bool
+	// ResetTime is the end of the window, or of the block when blocked
+	ResetTime time.Time
+}
+
+// AtomicCheckStorage is implemented by storages that run a whole fixed window
+// check in one atomic step, so concurrent requests cannot exceed the limit
+type AtomicCheckStorage interface {
+	// CheckAndIncrement rejects the key while it is blocked, otherwise counts
+	// delta against it within window and compares the count with limit plus the
+	// units stored at grantKey. When the limit is exceeded and blockFor is
+	// positive, the key is blocked for blockFor
+	CheckAndIncrement(ctx context.Context, key, grantKey string, delta, limit int, window, blockFor time.Duration) (*CheckOutcome, error)
+}
+
+// KeyIndexStorage is implemented by storages that keep an index of the
+// recently active keys, so they can be listed without scanning the storage
+type KeyIndexStorage interface {
+	// Touch records activity of a counter key. It keeps the key listed for the
+	// index TTL, or until `until` when later, e.g. the end of a block
+	Touch(key string, until time.Time)
+}
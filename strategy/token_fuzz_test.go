@@ -0,0 +1,56 @@
+package strategy
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzParseTokenFromHeader checks that accepted tokens are trimmed, bounded and
+// free of spaces and control characters, and that parsing is idempotent
+func FuzzParseTokenFromHeader(f *testing.F) {
+	for _, seed := range []string{
+		"abc123",
+		"  abc123  ",
+		"",
+		" ",
+		"token with space",
+		"tok\x00en",
+		"tok\x7fen",
+		"\tabc\n",
+		strings.Repeat("a", maxTokenLength),
+		strings.Repeat("a", maxTokenLength+1),
+		"ção-ümlaut",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, header string) {
+		token, err := ParseTokenFromHeader(header)
+		if err != nil {
+			if token != "" {
+				t.Fatalf("ParseTokenFromHeader(%q) = %q with error %v", header, token, err)
+			}
+			return
+		}
+
+		if token == "" {
+			t.Fatalf("ParseTokenFromHeader(%q) accepted an empty token", header)
+		}
+		if len(token) > maxTokenLength {
+			t.Fatalf("ParseTokenFromHeader(%q) accepted a %d byte token", header, len(token))
+		}
+		if token != strings.TrimSpace(header) {
+			t.Fatalf("ParseTokenFromHeader(%q) = %q, want the trimmed header", header, token)
+		}
+		for i := 0; i < len(token); i++ {
+			if c := token[i]; c <= ' ' || c == 0x7f {
+				t.Fatalf("ParseTokenFromHeader(%q) = %q, contains byte %#x", header, token, c)
+			}
+		}
+
+		again, err := ParseTokenFromHeader(token)
+		if err != nil || again != token {
+			t.Fatalf("ParseTokenFromHeader(%q) = %q, %v, want %q", token, again, err, token)
+		}
+	})
+}
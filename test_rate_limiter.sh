@@ -45,13 +45,16 @@ sleep 2
 echo "=== Testando Limitação por Token ==="
 for i in {1..12}; do
     echo "Requisição $i:"
-    make_request "http://localhost:8080/api/test" "API_KEY: abc123" "API Test (Token)"
+    make_request "http://localhost:8080/api/test" "API_KEY: ABC123" "API Test (Token)"
     sleep 0.1
 done
 
 # Teste 5: Reset de rate limit
 echo "=== Testando Reset de Rate Limit ==="
-make_request "http://localhost:8080/admin/reset/ip:127.0.0.1" "" "Reset IP Rate Limit"
+# Os endpoints /admin exigem o token de ADMIN_WRITE_TOKENS
+echo "--- Reset IP Rate Limit ---"
+curl -s -X POST -H "Authorization: Bearer ${ADMIN_TOKEN}" -w "\nHTTP_CODE:%{http_code}\n" "http://localhost:8080/admin/reset/ip:127.0.0.1"
+echo ""
 
 # Teste 6: Verificar se reset funcionou
 make_request "http://localhost:8080/api/test" "" "API Test (After Reset)"
@@ -0,0 +1,270 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/events"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+)
+
+// Entry types recorded in a key's timeline
+const (
+	TypeWindow = "window"
+	// TypeAction is an operation of an operator on the key
+	TypeAction = "action"
+)
+
+// resolution is the length of the windows counts are aggregated in
+const resolution = time.Second
+
+// Entry is something that happened to a key
+type Entry struct {
+	// Type is TypeWindow, TypeAction or the type of a rate limiter event
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Window counts, merged across instances
+	Count   int `json:"count,omitempty"`
+	Limit   int `json:"limit,omitempty"`
+	Allowed int `json:"allowed,omitempty"`
+	Denied  int `json:"denied,omitempty"`
+	// Events and actions
+	Reason    string        `json:"reason,omitempty"`
+	BlockTime time.Duration `json:"block_time,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Action    string        `json:"action,omitempty"`
+	Actor     string        `json:"actor,omitempty"`
+}
+
+// window aggregates the decisions of a key within one resolution window
+type window struct {
+	start time.Time
+	entry Entry
+}
+
+// record is an entry waiting to be written
+type record struct {
+	key   string
+	entry Entry
+}
+
+// Recorder keeps a ring buffer of the last entries of each key in Redis, so
+// support can see what happened to a client without searching the logs.
+// Decisions are aggregated per window in memory and written asynchronously,
+// dropping entries when the writer falls behind
+type Recorder struct {
+	client *redis.Client
+	size   int
+	ttl    time.Duration
+	queue  chan record
+
+	mu      sync.Mutex
+	windows map[string]*window
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRecorder creates a recorder keeping size entries per key for up to ttl
+// after the last one, and starts its writer
+func NewRecorder(client *redis.Client, size int, ttl time.Duration) *Recorder {
+	t := &Recorder{
+		client:  client,
+		size:    size,
+		ttl:     ttl,
+		queue:   make(chan record, 4096),
+		windows: make(map[string]*window),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Observe counts a decision in the current window of its key
+func (t *Recorder) Observe(result *limiter.CheckResult) {
+	if result.Key == "" {
+		return
+	}
+	now := time.Now()
+	start := now.Truncate(resolution)
+
+	t.mu.Lock()
+	w, ok := t.windows[result.Key]
+	if ok && !w.start.Equal(start) {
+		t.enqueue(result.Key, w.entry)
+		ok = false
+	}
+	if !ok {
+		w = &window{start: start, entry: Entry{Type: TypeWindow, Time: start}}
+		t.windows[result.Key] = w
+	}
+	if result.Allowed {
+		w.entry.Allowed++
+	} else {
+		w.entry.Denied++
+	}
+	// Requests rejected by a block are not counted against a limit
+	if result.Limit > 0 {
+		w.entry.Limit = result.Limit
+		w.entry.Count = max(w.entry.Count, result.Limit-result.Remaining)
+	}
+	t.mu.Unlock()
+}
+
+// Handle records blocks, unblocks and honeypot hits
+func (t *Recorder) Handle(event events.Event) {
+	switch event.Type {
+	case events.TypeBlocked, events.TypeUnblocked, events.TypeHoneypot:
+	default:
+		return
+	}
+
+	t.enqueue(event.Key, Entry{
+		Type:      event.Type,
+		Time:      event.Timestamp,
+		Reason:    event.Reason,
+		BlockTime: event.BlockTime,
+		Path:      event.Path,
+		RequestID: event.RequestID,
+	})
+}
+
+// RecordAction records an operation of an operator on a key, with an optional
+// human-readable detail
+func (t *Recorder) RecordAction(key, action, actor, detail string) {
+	t.enqueue(key, Entry{Type: TypeAction, Time: time.Now(), Action: action, Actor: actor, Reason: detail})
+}
+
+// enqueue hands an entry to the writer without waiting on Redis
+func (t *Recorder) enqueue(key string, entry Entry) {
+	select {
+	case t.queue <- record{key: key, entry: entry}:
+	default:
+	}
+}
+
+// run writes queued entries in batches and flushes finished windows
+func (t *Recorder) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(resolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case r := <-t.queue:
+			t.write(append([]record{r}, t.drain()...))
+		case <-ticker.C:
+			t.flush(time.Now().Truncate(resolution))
+		case <-t.stop:
+			t.flush(time.Now().Add(resolution))
+			t.write(t.drain())
+			return
+		}
+	}
+}
+
+// flush queues the windows started before now
+func (t *Recorder) flush(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, w := range t.windows {
+		if w.start.Before(now) {
+			t.enqueue(key, w.entry)
+			delete(t.windows, key)
+		}
+	}
+}
+
+// drain returns the queued entries without blocking
+func (t *Recorder) drain() []record {
+	var records []record
+	for {
+		select {
+		case r := <-t.queue:
+			records = append(records, r)
+		default:
+			return records
+		}
+	}
+}
+
+// write pushes entries to the ring buffers of their keys
+func (t *Recorder) write(records []record) {
+	if len(records) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	pipe := t.client.Pipeline()
+	for _, r := range records {
+		data, err := json.Marshal(r.entry)
+		if err != nil {
+			continue
+		}
+		key := ringKey(r.key)
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, int64(t.size)-1)
+		pipe.Expire(ctx, key, t.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Failed to record key history", "entries", len(records), "error", err)
+	}
+}
+
+// History returns up to limit entries of a key, newest first. Windows counted
+// by several instances are merged into one entry
+func (t *Recorder) History(ctx context.Context, key string, limit int) ([]Entry, error) {
+	values, err := t.client.LRange(ctx, ringKey(key), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(values))
+	windows := make(map[int64]int)
+	for _, value := range values {
+		var entry Entry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			continue
+		}
+		if entry.Type == TypeWindow {
+			if i, ok := windows[entry.Time.UnixNano()]; ok {
+				merged := &entries[i]
+				merged.Allowed += entry.Allowed
+				merged.Denied += entry.Denied
+				merged.Count = max(merged.Count, entry.Count)
+				continue
+			}
+			windows[entry.Time.UnixNano()] = len(entries)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Instances push at different times, so order by when things happened
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Close flushes the current windows and stops the writer
+func (t *Recorder) Close() {
+	close(t.stop)
+	<-t.done
+}
+
+// ringKey returns the list holding the timeline of a key
+func ringKey(key string) string {
+	return fmt.Sprintf("timeline:%s", key)
+}
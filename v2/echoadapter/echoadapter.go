@@ -0,0 +1,68 @@
+// Package echoadapter runs the rate limiting middleware in Echo services. The
+// checks, headers, 429 JSON body and token extraction are those of
+// middleware.RateLimit, so every middleware option applies
+package echoadapter
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	v1 "github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/middleware"
+)
+
+// echoContextKey is the request context key of the Echo context, read by
+// request ID resolvers
+type echoContextKey struct{}
+
+// WithRequestIDResolver sets how the ID a request is known by is resolved
+// from the Echo context, e.g. the X-Request-ID set by Echo's RequestID
+// middleware. As with the net/http option, the ID is stored with
+// limiter.WithRequestID and reported in the logs and the 429 body
+func WithRequestIDResolver(resolve func(c echo.Context) string) middleware.Option {
+	return v1.WithRequestIDResolver(func(r *http.Request) string {
+		if c, ok := r.Context().Value(echoContextKey{}).(echo.Context); ok {
+			return resolve(c)
+		}
+		return ""
+	})
+}
+
+// RateLimit enforces the limits of the rate limiter on each request. Added
+// with e.Use it runs after routing, so the Echo route (e.g. /users/:id) names
+// the request in route metrics, rules and decision logs
+func RateLimit(rateLimiter *limiter.RateLimiter, opts ...middleware.Option) echo.MiddlewareFunc {
+	return withRoute(echo.WrapMiddleware(middleware.RateLimit(rateLimiter, opts...)))
+}
+
+// Info exposes the rate limit headers of each request without counting it
+func Info(rateLimiter *limiter.RateLimiter, opts ...middleware.Option) echo.MiddlewareFunc {
+	return withRoute(echo.WrapMiddleware(middleware.Info(rateLimiter, opts...)))
+}
+
+// withRoute stores the Echo context and the matched Echo route in the request
+// context before the wrapped middleware runs, the route as the route and as
+// the pattern matched by route limits
+func withRoute(wrapped echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handler := wrapped(next)
+		return func(c echo.Context) error {
+			r := c.Request()
+			ctx := context.WithValue(r.Context(), echoContextKey{}, c)
+			if path := c.Path(); path != "" {
+				if _, ok := routes.RouteFromContext(ctx); !ok {
+					ctx = routes.WithRoute(ctx, path)
+				}
+				if routes.Pattern(r) == "" {
+					ctx = routes.WithPattern(ctx, path)
+				}
+			}
+			c.SetRequest(r.WithContext(ctx))
+			return handler(c)
+		}
+	}
+}
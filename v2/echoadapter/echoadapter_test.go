@@ -0,0 +1,66 @@
+package echoadapter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/strategy"
+)
+
+func TestRateLimitRequestID(t *testing.T) {
+	storage := strategy.NewMemory()
+	defer storage.Close()
+	rateLimiter, err := limiter.New(storage, &config.Config{RateLimit: config.RateLimitConfig{
+		IPLimit:     1,
+		IPBlockTime: time.Minute,
+		Window:      time.Hour,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	var handled string
+	e.Use(RateLimit(rateLimiter, WithRequestIDResolver(func(c echo.Context) string {
+		return c.Request().Header.Get(echo.HeaderXRequestID)
+	})))
+	e.GET("/users/:id", func(c echo.Context) error {
+		handled = limiter.RequestIDFromContext(c.Request().Context())
+		return c.String(http.StatusOK, "ok")
+	})
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set(echo.HeaderXRequestID, id)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("req-1"); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", w.Code)
+	}
+	if handled != "req-1" {
+		t.Errorf("handler saw request ID %q, want req-1", handled)
+	}
+
+	w := request("req-2")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", w.Code)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["request_id"] != "req-2" {
+		t.Errorf("429 body request_id = %v, want req-2", body["request_id"])
+	}
+}
@@ -0,0 +1,182 @@
+// Package fiberadapter runs the rate limiter in Fiber services. Fiber serves
+// requests with fasthttp instead of net/http, so the client IP and token are
+// read from the Fiber context; the checks, headers and 429 JSON body are
+// those of middleware.RateLimit with the IP and token limits
+package fiberadapter
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/metrics"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/routes"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/limiter"
+)
+
+// Option configures RateLimit
+type Option func(*options)
+
+type options struct {
+	tokenHeaders     []string
+	failureMode      string
+	normalizer       *routes.Normalizer
+	resolveRequestID func(*fiber.Ctx) string
+}
+
+// WithTokenHeaders sets the headers carrying the API token, the first valid
+// one wins. API_KEY is used by default
+func WithTokenHeaders(headers ...string) Option {
+	return func(o *options) {
+		if len(headers) > 0 {
+			o.tokenHeaders = headers
+		}
+	}
+}
+
+// WithFailureMode sets how requests are decided when the storage fails:
+// config.FailureOpen (the default) lets them through and config.FailureClosed
+// rejects them with 503
+func WithFailureMode(mode string) (Option, error) {
+	if mode != config.FailureOpen && mode != config.FailureClosed {
+		return nil, fmt.Errorf("unknown failure mode %q, expected open or closed", mode)
+	}
+	return func(o *options) {
+		o.failureMode = mode
+	}, nil
+}
+
+// WithRequestIDResolver sets how the ID a request is known by is resolved,
+// e.g. the X-Request-ID set by Fiber's requestid middleware. The ID is stored
+// in the user context (limiter.WithRequestID) and reported in the logs and
+// the 429 body, as with the net/http middleware
+func WithRequestIDResolver(resolve func(c *fiber.Ctx) string) Option {
+	return func(o *options) {
+		o.resolveRequestID = resolve
+	}
+}
+
+// RateLimit enforces the IP and token limits of the rate limiter on each
+// request. The client IP is c.IP(), so behind a proxy set ProxyHeader and
+// TrustedProxies in fiber.Config
+func RateLimit(rateLimiter *limiter.RateLimiter, opts ...Option) fiber.Handler {
+	o := &options{tokenHeaders: []string{"API_KEY"}, failureMode: config.FailureOpen, normalizer: routes.NewNormalizer(nil)}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(c *fiber.Ctx) error {
+		o.withRequestID(c)
+		result, err := rateLimiter.CheckRateLimit(c.UserContext(), c.IP(), o.token(c))
+		if err != nil {
+			return o.handleFailure(c, err)
+		}
+
+		outcome := "allowed"
+		if !result.Allowed {
+			outcome = "denied"
+		}
+		// Middleware runs before the route is known, so the path is normalized without a route pattern
+		route := o.normalizer.NormalizePath(c.Path())
+		metrics.RouteDecisions.WithLabelValues(c.Method(), o.normalizer.Label(route), outcome).Inc()
+
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("X-RateLimit-Reset", result.ResetTime.Format(time.RFC3339))
+		if !result.Allowed {
+			return writeLimited(c, result)
+		}
+
+		// Smoothed algorithms release the request at its turn
+		if result.Delay > 0 {
+			timer := time.NewTimer(result.Delay)
+			select {
+			case <-timer.C:
+			case <-c.UserContext().Done():
+				timer.Stop()
+				return c.UserContext().Err()
+			}
+		}
+		return c.Next()
+	}
+}
+
+// withRequestID stores the resolved request ID in the user context
+func (o *options) withRequestID(c *fiber.Ctx) {
+	ctx := c.UserContext()
+	if o.resolveRequestID == nil || limiter.RequestIDFromContext(ctx) != "" {
+		return
+	}
+	if id := o.resolveRequestID(c); id != "" {
+		c.SetUserContext(limiter.WithRequestID(ctx, id))
+	}
+}
+
+// logger returns the default logger, tagged with the request ID if any
+func logger(c *fiber.Ctx) *slog.Logger {
+	if id := limiter.RequestIDFromContext(c.UserContext()); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// token returns the first valid token of the configured headers, empty for
+// IP-only rate limiting
+func (o *options) token(c *fiber.Ctx) string {
+	for _, header := range o.tokenHeaders {
+		if value := c.Get(header); value != "" {
+			if token, err := strategy.ParseTokenFromHeader(value); err == nil {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// handleFailure decides a request whose limit check failed
+func (o *options) handleFailure(c *fiber.Ctx, checkErr error) error {
+	if o.failureMode == config.FailureClosed {
+		metrics.DegradedDecisions.WithLabelValues(o.failureMode, "denied").Inc()
+		logger(c).Warn("Rate limit check failed, rejecting request", "method", c.Method(), "path", c.Path(), "error", checkErr)
+		c.Set("Retry-After", "1")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Rate limit check failed"})
+	}
+
+	metrics.DegradedDecisions.WithLabelValues(o.failureMode, "allowed").Inc()
+	logger(c).Warn("Rate limit check failed, allowing request", "method", c.Method(), "path", c.Path(), "error", checkErr)
+	c.Set("X-RateLimit-Error", "Rate limit check failed")
+	return c.Next()
+}
+
+// writeLimited writes the 429 response of a denied request, with the body of
+// the net/http middleware
+func writeLimited(c *fiber.Ctx, result *limiter.CheckResult) error {
+	if result.BlockTime > 0 {
+		c.Set("X-RateLimit-Block-Time", result.BlockTime.String())
+	}
+	retryAt := result.ResetTime
+	if blockEnd := time.Now().Add(result.BlockTime); blockEnd.After(retryAt) {
+		retryAt = blockEnd
+	}
+	c.Set("Retry-After", strconv.Itoa(max(int(math.Ceil(time.Until(retryAt).Seconds())), 1)))
+
+	response := fiber.Map{
+		"error":   "Rate limit exceeded",
+		"message": "you have reached the maximum number of requests or actions allowed within a certain time frame",
+		"details": fiber.Map{
+			"reason":     result.Reason,
+			"reset_time": result.ResetTime,
+			"block_time": result.BlockTime,
+		},
+	}
+	// The request ID lets support find the decision records of a reported 429
+	if id := limiter.RequestIDFromContext(c.UserContext()); id != "" {
+		response["request_id"] = id
+	}
+	return c.Status(fiber.StatusTooManyRequests).JSON(response)
+}
@@ -0,0 +1,70 @@
+package fiberadapter
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/strategy"
+)
+
+func TestRateLimitRequestID(t *testing.T) {
+	storage := strategy.NewMemory()
+	defer storage.Close()
+	rateLimiter, err := limiter.New(storage, &config.Config{RateLimit: config.RateLimitConfig{
+		IPLimit:     1,
+		IPBlockTime: time.Minute,
+		Window:      time.Hour,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	var handled string
+	app.Use(RateLimit(rateLimiter, WithRequestIDResolver(func(c *fiber.Ctx) string {
+		return c.Get("X-Request-ID")
+	})))
+	app.Get("/", func(c *fiber.Ctx) error {
+		handled = limiter.RequestIDFromContext(c.UserContext())
+		return c.SendString("ok")
+	})
+
+	request := func(id string) map[string]any {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Request-ID", id)
+		resp, err := app.Test(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != fiber.StatusTooManyRequests {
+			return nil
+		}
+		var body map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	if body := request("req-1"); body != nil {
+		t.Fatalf("first request rejected: %v", body)
+	}
+	if handled != "req-1" {
+		t.Errorf("handler saw request ID %q, want req-1", handled)
+	}
+
+	body := request("req-2")
+	if body == nil {
+		t.Fatal("second request admitted, want 429")
+	}
+	if body["request_id"] != "req-2" {
+		t.Errorf("429 body request_id = %v, want req-2", body["request_id"])
+	}
+}
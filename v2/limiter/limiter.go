@@ -0,0 +1,110 @@
+// Package limiter is the v2 entry point of the rate limiter core. Its types
+// are aliases of the v1 package, so limiters built either way are
+// interchangeable while the v1 constructors keep working
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/keys"
+	v1 "github.com/marcelobritu/go-expert-desafio-rate-limiter/limiter"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/strategy"
+)
+
+type (
+	RateLimiter = v1.RateLimiter
+	CheckResult = v1.CheckResult
+	Algorithm   = v1.Algorithm
+	Request     = v1.Request
+	Decision    = v1.Decision
+	Fallback    = v1.Fallback
+)
+
+// Option configures a rate limiter built by New
+type Option func(*RateLimiter) error
+
+// WithAlgorithm selects the counting algorithm of a policy ("ip" or "token")
+// by its configuration name
+func WithAlgorithm(policy, name string) Option {
+	return func(rl *RateLimiter) error {
+		if err := rl.SetAlgorithm(policy, name); err != nil {
+			return fmt.Errorf("%s algorithm: %w", policy, err)
+		}
+		return nil
+	}
+}
+
+// WithShadowAlgorithm evaluates a second algorithm of a policy on the same
+// traffic without enforcing it, reporting divergence in the shadow metrics
+func WithShadowAlgorithm(policy, name string) Option {
+	return func(rl *RateLimiter) error {
+		if err := rl.SetShadowAlgorithm(policy, name); err != nil {
+			return fmt.Errorf("%s shadow algorithm: %w", policy, err)
+		}
+		return nil
+	}
+}
+
+// WithCustomAlgorithm uses a custom counting algorithm for a policy
+func WithCustomAlgorithm(policy string, algorithm Algorithm) Option {
+	return func(rl *RateLimiter) error {
+		rl.UseAlgorithm(policy, algorithm)
+		return nil
+	}
+}
+
+// WithBucket sizes the bucket algorithms of a policy
+func WithBucket(policy string, bucket config.BucketConfig) Option {
+	return func(rl *RateLimiter) error {
+		rl.SetBucket(policy, bucket)
+		return nil
+	}
+}
+
+// WithKeyPipeline canonicalizes the identifiers of a key kind ("ip" or "token")
+func WithKeyPipeline(kind string, pipeline keys.Pipeline) Option {
+	return func(rl *RateLimiter) error {
+		rl.SetKeyPipeline(kind, pipeline)
+		return nil
+	}
+}
+
+// New creates a rate limiter enforcing the limits of the configuration over
+// the storage. Unlike v1.NewRateLimiter, invalid options fail the construction
+// instead of being left to setters called afterwards
+func New(storage strategy.Storage, cfg *config.Config, opts ...Option) (*RateLimiter, error) {
+	if storage == nil {
+		return nil, errors.New("storage is required")
+	}
+	if cfg == nil {
+		return nil, errors.New("configuration is required")
+	}
+
+	rl := v1.NewRateLimiter(storage, cfg)
+	for _, opt := range opts {
+		if err := opt(rl); err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// NewFallback creates a local fallback for the limiter, with its limits
+// divided among the given number of instances
+func NewFallback(primary *RateLimiter, cfg config.FallbackConfig, instances int) *Fallback {
+	return v1.NewFallback(primary, cfg, instances)
+}
+
+// WithRequestID returns a context carrying the ID of the request being
+// checked, reported in the events and logs of the check
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return v1.WithRequestID(ctx, id)
+}
+
+// RequestIDFromContext returns the ID stored by WithRequestID, empty without one
+func RequestIDFromContext(ctx context.Context) string {
+	return v1.RequestIDFromContext(ctx)
+}
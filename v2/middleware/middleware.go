@@ -0,0 +1,38 @@
+// Package middleware is the v2 entry point of the net/http middleware
+// adapters. Option is an alias of the v1 option, so every v1 With* option
+// can be passed to these constructors
+package middleware
+
+import (
+	"net/http"
+
+	v1 "github.com/marcelobritu/go-expert-desafio-rate-limiter/middleware"
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/v2/limiter"
+)
+
+type (
+	Option      = v1.Option
+	TokenSource = v1.TokenSource
+)
+
+// RateLimit enforces the limits of the rate limiter on each request
+func RateLimit(rateLimiter *limiter.RateLimiter, opts ...Option) func(http.Handler) http.Handler {
+	return v1.RateLimitMiddleware(rateLimiter, opts...)
+}
+
+// Info exposes the rate limit headers of each request without counting it
+func Info(rateLimiter *limiter.RateLimiter, opts ...Option) func(http.Handler) http.Handler {
+	return v1.RateLimitInfoMiddleware(rateLimiter, opts...)
+}
+
+// TrustBoundary strips forwarded headers and internal markers from peers
+// outside the trusted proxies. It must run before any middleware rewriting
+// r.RemoteAddr
+func TrustBoundary(trustedProxies, headers, cookies []string) (func(http.Handler) http.Handler, error) {
+	return v1.TrustBoundaryMiddleware(trustedProxies, headers, cookies)
+}
+
+// DeniedResult returns the check that rejected a request, for denied handlers
+func DeniedResult(r *http.Request) (*limiter.CheckResult, bool) {
+	return v1.DeniedResult(r)
+}
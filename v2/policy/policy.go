@@ -0,0 +1,40 @@
+// Package policy is the v2 entry point of the policy engine: runtime updates,
+// versioned history and rollback. Its types are aliases of the v1 package
+package policy
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/config"
+	v1 "github.com/marcelobritu/go-expert-desafio-rate-limiter/policy"
+)
+
+type (
+	Policy      = config.Policy
+	Update      = v1.Update
+	TokenUpdate = v1.TokenUpdate
+	Version     = v1.Version
+	Change      = v1.Change
+	Applier     = v1.Applier
+	History     = v1.History
+)
+
+// ErrVersionNotFound is returned when rolling back to an unknown version
+var ErrVersionNotFound = v1.ErrVersionNotFound
+
+// ErrOutsideCaps is returned when rolling back to a version the caps set with
+// History.SetCaps reject
+var ErrOutsideCaps = v1.ErrOutsideCaps
+
+// NewHistory creates a history keeping the last size versions, syncing the
+// applier with the active version every interval
+func NewHistory(client *redis.Client, applier Applier, size int, interval time.Duration) *History {
+	return v1.NewHistory(client, applier, size, interval)
+}
+
+// Diff lists the fields that changed from old to new
+func Diff(old, new Policy) []Change {
+	return v1.Diff(old, new)
+}
@@ -0,0 +1,87 @@
+// Package strategy is the v2 entry point of the storage strategies. Its types
+// are aliases of the v1 package, so storages built either way are
+// interchangeable while the v1 constructors keep working
+package strategy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	v1 "github.com/marcelobritu/go-expert-desafio-rate-limiter/strategy"
+)
+
+type (
+	Storage            = v1.StorageStrategy
+	SlidingLogStorage  = v1.SlidingLogStorage
+	TokenBucketStorage = v1.TokenBucketStorage
+	LeakyBucketStorage = v1.LeakyBucketStorage
+	AtomicCheckStorage = v1.AtomicCheckStorage
+	RateLimitInfo      = v1.RateLimitInfo
+	Memory             = v1.MemoryStrategy
+	Redis              = v1.RedisStrategy
+	ReadOnly           = v1.ReadOnlyStrategy
+	RetryPolicy        = v1.RetryPolicy
+)
+
+// RedisOptions locates the Redis server of NewRedis
+type RedisOptions struct {
+	Host     string
+	Port     string
+	Password string
+	DB       int
+	// Retry retries failed commands, a single attempt when zero
+	Retry RetryPolicy
+	// TLS encrypts the connection, plain TCP when nil
+	TLS *tls.Config
+	// PoolSize, MinIdleConns, ReadTimeout, WriteTimeout and MaxRetries tune
+	// the client, zero values keep the go-redis defaults
+	PoolSize     int
+	MinIdleConns int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	MaxRetries   int
+	// LocalCacheTTL caches block status and counters at their limit in
+	// process memory, disabled when zero
+	LocalCacheTTL time.Duration
+	// BatchInterval flushes fixed window increments in batches, each one
+	// written right away when zero
+	BatchInterval time.Duration
+}
+
+// NewMemory creates an empty in-memory storage
+func NewMemory() *Memory {
+	return v1.NewMemoryStrategy()
+}
+
+// NewRedis creates a Redis storage. The connection is made lazily, Ping checks it
+func NewRedis(opts RedisOptions) *Redis {
+	storage := v1.NewRedisStrategyWithOptions(&redis.Options{
+		Addr:         fmt.Sprintf("%s:%s", opts.Host, opts.Port),
+		Password:     opts.Password,
+		DB:           opts.DB,
+		TLSConfig:    opts.TLS,
+		PoolSize:     opts.PoolSize,
+		MinIdleConns: opts.MinIdleConns,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		MaxRetries:   opts.MaxRetries,
+	})
+	if opts.Retry.MaxAttempts > 0 {
+		storage.SetRetryPolicy(opts.Retry)
+	}
+	if opts.LocalCacheTTL > 0 {
+		storage.EnableLocalCache(opts.LocalCacheTTL)
+	}
+	if opts.BatchInterval > 0 {
+		storage.EnableBatching(opts.BatchInterval)
+	}
+	return storage
+}
+
+// NewReadOnly wraps a storage so that checks never write to it
+func NewReadOnly(storage Storage) *ReadOnly {
+	return v1.NewReadOnlyStrategy(storage)
+}
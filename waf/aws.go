@@ -0,0 +1,124 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/marcelobritu/go-expert-desafio-rate-limiter/awssig"
+)
+
+// AWSExporter replaces the addresses of an AWS WAF IPSet through the WAFv2 JSON API.
+// The IPSet should be dedicated to the rate limiter since its contents are overwritten
+type AWSExporter struct {
+	name   string
+	id     string
+	scope  string
+	client *http.Client
+	signer *awssig.Signer
+}
+
+// NewAWSExporter creates an exporter for an AWS WAF IPSet using the standard AWS_* credentials
+func NewAWSExporter(region, name, id, scope string) (*AWSExporter, error) {
+	if scope == "" {
+		scope = "REGIONAL"
+	}
+	// CloudFront IPSets are managed through us-east-1
+	if scope == "CLOUDFRONT" {
+		region = "us-east-1"
+	}
+
+	signer, err := awssig.FromEnv(region, "wafv2")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AWSExporter{
+		name:   name,
+		id:     id,
+		scope:  scope,
+		client: &http.Client{Timeout: 30 * time.Second},
+		signer: signer,
+	}, nil
+}
+
+// Name identifies the exporter in logs
+func (a *AWSExporter) Name() string {
+	return "aws-waf"
+}
+
+// Sync replaces the IPSet addresses with the IPs matching its address version
+func (a *AWSExporter) Sync(ctx context.Context, ips []string) error {
+	var current struct {
+		IPSet struct {
+			IPAddressVersion string `json:"IPAddressVersion"`
+		} `json:"IPSet"`
+		LockToken string `json:"LockToken"`
+	}
+
+	err := a.call(ctx, "GetIPSet", map[string]interface{}{
+		"Name":  a.name,
+		"Id":    a.id,
+		"Scope": a.scope,
+	}, &current)
+	if err != nil {
+		return err
+	}
+
+	ipv6 := current.IPSet.IPAddressVersion == "IPV6"
+
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if isIPv6(ip) == ipv6 {
+			addresses = append(addresses, toCIDR(ip))
+		}
+	}
+
+	return a.call(ctx, "UpdateIPSet", map[string]interface{}{
+		"Name":      a.name,
+		"Id":        a.id,
+		"Scope":     a.scope,
+		"Addresses": addresses,
+		"LockToken": current.LockToken,
+	}, nil)
+}
+
+// call invokes a WAFv2 API action with a SigV4-signed request
+func (a *AWSExporter) call(ctx context.Context, action string, input interface{}, out interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("wafv2.%s.amazonaws.com", a.signer.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSWAF_20190729."+action)
+	a.signer.Sign(req, body, time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s failed with status %d: %s %s", action, resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,73 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// CloudflareExporter replaces the items of a Cloudflare IP list
+type CloudflareExporter struct {
+	apiToken  string
+	accountID string
+	listID    string
+	client    *http.Client
+}
+
+// NewCloudflareExporter creates an exporter for a Cloudflare account IP list
+func NewCloudflareExporter(apiToken, accountID, listID string) *CloudflareExporter {
+	return &CloudflareExporter{
+		apiToken:  apiToken,
+		accountID: accountID,
+		listID:    listID,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name identifies the exporter in logs
+func (c *CloudflareExporter) Name() string {
+	return "cloudflare"
+}
+
+// Sync replaces all list items with the given IPs
+func (c *CloudflareExporter) Sync(ctx context.Context, ips []string) error {
+	type item struct {
+		IP      string `json:"ip"`
+		Comment string `json:"comment"`
+	}
+
+	items := make([]item, 0, len(ips))
+	for _, ip := range ips {
+		items = append(items, item{IP: ip, Comment: "blocked by rate limiter"})
+	}
+
+	body, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/accounts/%s/rules/lists/%s/items", cloudflareAPI, c.accountID, c.listID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
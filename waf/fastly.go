@@ -0,0 +1,148 @@
+package waf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const fastlyAPI = "https://api.fastly.com"
+
+// fastlyBatchSize is the maximum number of operations per batch request
+const fastlyBatchSize = 1000
+
+// FastlyExporter keeps a Fastly ACL in sync with the blocked IPs
+type FastlyExporter struct {
+	apiToken  string
+	serviceID string
+	aclID     string
+	client    *http.Client
+}
+
+// NewFastlyExporter creates an exporter for a Fastly service ACL
+func NewFastlyExporter(apiToken, serviceID, aclID string) *FastlyExporter {
+	return &FastlyExporter{
+		apiToken:  apiToken,
+		serviceID: serviceID,
+		aclID:     aclID,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name identifies the exporter in logs
+func (f *FastlyExporter) Name() string {
+	return "fastly"
+}
+
+type fastlyEntry struct {
+	ID     string `json:"id,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Subnet int    `json:"subnet,omitempty"`
+	Op     string `json:"op,omitempty"`
+}
+
+// Sync creates entries for new IPs and deletes entries that are no longer blocked
+func (f *FastlyExporter) Sync(ctx context.Context, ips []string) error {
+	current, err := f.listEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		wanted[toCIDR(ip)] = true
+	}
+
+	var ops []fastlyEntry
+	existing := make(map[string]bool, len(current))
+	for _, entry := range current {
+		cidr := fmt.Sprintf("%s/%d", entry.IP, entry.Subnet)
+		if entry.Subnet == 0 {
+			cidr = toCIDR(entry.IP)
+		}
+
+		existing[cidr] = true
+		if !wanted[cidr] {
+			ops = append(ops, fastlyEntry{Op: "delete", ID: entry.ID})
+		}
+	}
+	for cidr := range wanted {
+		if !existing[cidr] {
+			ip, subnet := splitCIDR(cidr)
+			ops = append(ops, fastlyEntry{Op: "create", IP: ip, Subnet: subnet})
+		}
+	}
+
+	for start := 0; start < len(ops); start += fastlyBatchSize {
+		end := min(start+fastlyBatchSize, len(ops))
+		if err := f.patchEntries(ctx, ops[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listEntries returns all entries currently in the ACL
+func (f *FastlyExporter) listEntries(ctx context.Context) ([]fastlyEntry, error) {
+	var entries []fastlyEntry
+
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/service/%s/acl/%s/entries?per_page=100&page=%d", fastlyAPI, f.serviceID, f.aclID, page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []fastlyEntry
+		if err := f.do(req, &batch); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, batch...)
+		if len(batch) < 100 {
+			return entries, nil
+		}
+	}
+}
+
+// patchEntries applies a batch of create/delete operations
+func (f *FastlyExporter) patchEntries(ctx context.Context, ops []fastlyEntry) error {
+	body, err := json.Marshal(map[string]interface{}{"entries": ops})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/service/%s/acl/%s/entries", fastlyAPI, f.serviceID, f.aclID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return f.do(req, nil)
+}
+
+// do sends an authenticated request and decodes the response into out
+func (f *FastlyExporter) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Fastly-Key", f.apiToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
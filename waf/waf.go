@@ -0,0 +1,107 @@
+package waf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exporter pushes the set of currently blocked IPs to an edge WAF
+type Exporter interface {
+	// Name identifies the exporter in logs
+	Name() string
+
+	// Sync replaces the exported list with the given IPs and CIDR prefixes
+	Sync(ctx context.Context, ips []string) error
+}
+
+// BlockedLister lists the identifiers currently blocked for a key kind
+type BlockedLister interface {
+	ListBlocked(ctx context.Context, kind string) ([]string, error)
+}
+
+// Syncer periodically exports blocked IPs to every configured exporter
+type Syncer struct {
+	lister    BlockedLister
+	exporters []Exporter
+}
+
+// NewSyncer creates a syncer for the given exporters
+func NewSyncer(lister BlockedLister, exporters ...Exporter) *Syncer {
+	return &Syncer{
+		lister:    lister,
+		exporters: exporters,
+	}
+}
+
+// Enabled reports whether any exporter is configured
+func (s *Syncer) Enabled() bool {
+	return len(s.exporters) > 0
+}
+
+// Sync pushes the currently blocked IPs to all exporters, continuing past failures
+func (s *Syncer) Sync(ctx context.Context) error {
+	blocked, err := s.lister.ListBlocked(ctx, "ip")
+	if err != nil {
+		return fmt.Errorf("failed to list blocked IPs: %w", err)
+	}
+
+	ips := validIPs(blocked)
+
+	var errs []error
+	for _, exporter := range s.exporters {
+		if err := exporter.Sync(ctx, ips); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", exporter.Name(), err))
+			continue
+		}
+		slog.Info("Exported blocked IPs", "ips", len(ips), "exporter", exporter.Name())
+	}
+
+	return errors.Join(errs...)
+}
+
+// validIPs keeps identifiers that are IP addresses or CIDR prefixes and sorts the result
+func validIPs(identifiers []string) []string {
+	ips := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		if ip := net.ParseIP(identifier); ip != nil {
+			ips = append(ips, ip.String())
+		} else if _, network, err := net.ParseCIDR(identifier); err == nil {
+			ips = append(ips, network.String())
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// toCIDR converts an IP address to a single-host CIDR, leaving prefixes untouched
+func toCIDR(ip string) string {
+	if strings.Contains(ip, "/") {
+		return ip
+	}
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
+
+// splitCIDR returns the address and prefix length of an IP or CIDR
+func splitCIDR(ip string) (string, int) {
+	addr, bits, found := strings.Cut(toCIDR(ip), "/")
+	if !found {
+		return addr, 32
+	}
+	prefix, _ := strconv.Atoi(bits)
+	return addr, prefix
+}
+
+// isIPv6 reports whether an IP or CIDR is IPv6
+func isIPv6(ip string) bool {
+	addr, _ := splitCIDR(ip)
+	return net.ParseIP(addr).To4() == nil
+}